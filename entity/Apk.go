@@ -0,0 +1,15 @@
+package entity
+
+import "io"
+
+// Apk 不解压直接在内存中解析的 APK
+type Apk struct {
+	Name      string
+	Reader    io.ReaderAt // APK 的原始数据
+	Size      int64
+	Zip       *ZipArchive
+	Manifest  *ManifestData
+	Dexes     []*DexFile     // 按 classes.dex、classes2.dex ... 的顺序
+	DexErrors []error        // 读取或解析失败的 dex，错误信息以文件名开头
+	Resources *ResourceTable // 没有 resources.arsc 时为 nil
+}
@@ -0,0 +1,76 @@
+package entity
+
+import (
+	"io"
+	"os"
+)
+
+// 压缩包中的可疑特征
+const (
+	ARCHIVE_PATH_TRAVERSAL = "path-traversal"    // 文件名指向解压目录之外
+	ARCHIVE_SYMLINK        = "symlink"           // 符号链接
+	ARCHIVE_DUPLICATE      = "duplicate"         // 同名文件
+	ARCHIVE_CASE_COLLISION = "case-collision"    // 文件名只差大小写，文件系统不区分大小写时跳过
+	ARCHIVE_ENTRY_COUNT    = "entry-count"       // 文件数超出限制
+	ARCHIVE_ENTRY_SIZE     = "entry-size"        // 单个文件解压后超出限制
+	ARCHIVE_TOTAL_SIZE     = "total-size"        // 解压的总大小超出限制
+	ARCHIVE_RATIO          = "compression-ratio" // 压缩比超出限制
+	ARCHIVE_SIZE_MISMATCH  = "size-mismatch"     // 解压后的大小与目录中记录的不一致
+	ARCHIVE_CORRUPT        = "corrupt"           // 数据无法解压或校验和不对
+
+	// 以下为解析时按 Android 的规则容忍的异常
+	ARCHIVE_END_RECORD     = "end-record"      // 中央目录结束记录与实际不符
+	ARCHIVE_ENCRYPTED      = "encryption-flag" // 设置了加密标志，忽略
+	ARCHIVE_UNKNOWN_METHOD = "unknown-method"  // 未知的压缩方式，按不压缩读取
+	ARCHIVE_LOCAL_HEADER   = "local-header"    // 本地文件头损坏
+	ARCHIVE_NAME_MISMATCH  = "name-mismatch"   // 本地文件头与中央目录的文件名不同，以中央目录为准
+	ARCHIVE_OVERLAP        = "overlap"         // 文件数据与其他文件或中央目录重叠
+)
+
+// ArchiveLimits 解压的限制，0 表示不限制
+type ArchiveLimits struct {
+	MaxEntries   int
+	MaxEntrySize int64 // 单个文件解压后的字节数
+	MaxTotalSize int64 // 全部文件解压后的字节数
+	MaxRatio     int64 // 解压后与压缩后大小之比，只检查解压后超过 1MB 的文件
+}
+
+// ArchiveIssue 解压时发现的一个可疑特征
+type ArchiveIssue struct {
+	Kind   string // ARCHIVE_*
+	Entry  string // 压缩包中的文件名
+	Detail string
+}
+
+// ArchiveReport 解压的结果，可疑的文件跳过并记录在 Issues 中
+type ArchiveReport struct {
+	Extracted int
+	Skipped   int
+	Issues    []ArchiveIssue
+}
+
+// ZipEntry 中央目录中的一个文件
+type ZipEntry struct {
+	Name             string
+	Flags            uint16
+	Method           uint16 // 中央目录中记录的压缩方式，除 8 (deflate) 外都按不压缩读取
+	CRC32            uint32
+	CompressedSize   uint64
+	UncompressedSize uint64
+	Mode             os.FileMode
+	Time             uint32 // DOS 格式的修改时间，高 16 位为日期
+	Creator          uint16 // 创建系统和版本
+	External         uint32 // 外部属性
+	HeaderOffset     int64  // 本地文件头的位置
+	DataOffset       int64  // 数据的位置
+}
+
+// ZipArchive 按 Android 的规则解析的压缩包，以中央目录为准
+type ZipArchive struct {
+	Reader    io.ReaderAt
+	Size      int64
+	DirOffset int64          // 中央目录的位置
+	EndOffset int64          // 中央目录结束记录的位置
+	Entries   []*ZipEntry    // 中央目录中的顺序
+	Issues    []ArchiveIssue // 解析时容忍的异常
+}
@@ -0,0 +1,102 @@
+package entity
+
+import "math"
+
+// 虚拟机中值的类型
+const (
+	VM_VOID   = iota // 没有值，return-void 的返回值
+	VM_INT           // boolean、byte、short、char 和 int
+	VM_LONG          // 占用两个寄存器
+	VM_FLOAT         // float
+	VM_DOUBLE        // 占用两个寄存器
+	VM_REF           // 对象引用，Ref 为 nil 表示 null
+)
+
+// VMValue 虚拟机中的值，基本类型保存在 Raw 中，引用保存在 Ref 中
+type VMValue struct {
+	Kind byte        // VM_*
+	Raw  uint64      // 基本类型的位模式，int 和 float 只使用低 32 位
+	Ref  interface{} // VM_REF 时为 *VMObject、*VMArray 或 nil
+}
+
+func (v VMValue) Int() int32 {
+	return int32(v.Raw)
+}
+
+func (v VMValue) Long() int64 {
+	return int64(v.Raw)
+}
+
+func (v VMValue) Float() float32 {
+	return math.Float32frombits(uint32(v.Raw))
+}
+
+func (v VMValue) Double() float64 {
+	return math.Float64frombits(v.Raw)
+}
+
+// Wide 是否占用两个寄存器
+func (v VMValue) Wide() bool {
+	return v.Kind == VM_LONG || v.Kind == VM_DOUBLE
+}
+
+// VMObject 虚拟机中的对象
+type VMObject struct {
+	Class  string             // 类型描述符
+	Fields map[string]VMValue // 实例字段，按字段签名保存
+	Native interface{}        // 框架类在宿主中的数据，例如 String 的内容
+}
+
+// VMArray 虚拟机中的数组
+type VMArray struct {
+	Type string    // 数组类型描述符，例如 [I
+	Data []VMValue // 数组元素
+}
+
+// 调用类路径和桩函数都找不到的方法时的处理方式
+const (
+	UNKNOWN_CALL_DEFAULT  = iota // 返回类型的默认值
+	UNKNOWN_CALL_SYMBOLIC        // 返回符号值，基本类型仍为默认值
+	UNKNOWN_CALL_ABORT           // 停止执行并返回错误
+)
+
+// 模拟执行的资源限制
+const (
+	LIMIT_INSTRUCTIONS = "instructions"
+	LIMIT_TIME         = "time"
+	LIMIT_CALL_DEPTH   = "call depth"
+	LIMIT_HEAP         = "heap"
+	LIMIT_ARRAY_LENGTH = "array length"
+)
+
+// VMLimits 模拟执行的资源限制，0 表示不限制。
+// 指令数和堆大小从外部调用方法时重新计数，堆大小为累计分配的近似字节数
+type VMLimits struct {
+	MaxInstructions int64
+	MaxCallDepth    int
+	MaxHeapBytes    int64
+	MaxArrayLength  int32
+}
+
+// VMSymbol 未知方法调用得到的符号值，作为 VMObject 的 Native 保存
+type VMSymbol struct {
+	Method string    // 方法签名
+	Args   []VMValue // 调用参数
+}
+
+// VMStackFrame 模拟执行的调用栈中的一帧
+type VMStackFrame struct {
+	Class  string // 类型描述符，直接执行字节码时为空
+	Method string // 方法名
+	Proto  string // 方法原型描述符
+	Pc     uint32 // 指令偏移，以 2 字节为单位
+	Source string // 源文件名，没有时为空
+	Line   int    // 源代码行号，没有调试信息时为 0
+}
+
+// VMThrowable Throwable 对象的 Native 数据
+type VMThrowable struct {
+	Message VMValue // String 或 null
+	Cause   VMValue // Throwable 或 null
+	Trace   []VMStackFrame
+}
@@ -0,0 +1,43 @@
+package entity
+
+// 控制流边的类型
+const (
+	EDGE_FALLTHROUGH = "fallthrough" // 顺序执行到下一个基本块
+	EDGE_BRANCH      = "branch"      // goto 或条件成立的 if
+	EDGE_SWITCH      = "switch"      // switch 的某个 case
+	EDGE_EXCEPTION   = "exception"   // 抛出异常后跳到 catch 块
+)
+
+// CFGEdge 基本块的一条出边
+type CFGEdge struct {
+	To        int
+	Kind      string // EDGE_*
+	Key       int32  // EDGE_SWITCH 时为 case 的值
+	CatchType uint32 // EDGE_EXCEPTION 时为异常类型的 type_ids 索引，catch-all 为 NO_INDEX
+}
+
+// BasicBlock 基本块，地址以 2 字节为单位
+type BasicBlock struct {
+	Id      int
+	Start   uint32 // 第一条指令的偏移
+	End     uint32 // 最后一条指令之后的偏移
+	Insns   []*Instruction
+	Succs   []CFGEdge
+	Preds   []int
+	Exit    bool // 以 return 或 throw 结束
+	Handler bool // catch 块的入口
+}
+
+// Loop 自然循环
+type Loop struct {
+	Header  int
+	Latches []int // 回边的起点
+	Blocks  []int // 循环体内的全部基本块，包括 Header
+}
+
+// MethodCFG 方法的控制流图，Blocks[0] 为入口
+type MethodCFG struct {
+	Blocks []*BasicBlock
+	Idom   []int // 每个基本块的直接支配者，入口和不可达的块为 -1
+	Loops  []Loop
+}
@@ -0,0 +1,24 @@
+package entity
+
+// CallGraphNode 调用图中的一个方法
+type CallGraphNode struct {
+	Id       int    `json:"id"`
+	Method   string `json:"method"`             // 方法签名
+	External bool   `json:"external,omitempty"` // 不在 APK 中定义或无法解析，例如框架方法
+	Entry    bool   `json:"entry,omitempty"`    // 清单中组件的入口方法
+}
+
+// CallGraphEdge 一个调用点到一个目标方法，虚方法和接口方法的调用点可能有多条边
+type CallGraphEdge struct {
+	From     int    `json:"from"`
+	To       int    `json:"to"`
+	Kind     string `json:"kind"`               // invoke-virtual、invoke-static 等，不含 /range 后缀
+	Offset   uint32 `json:"offset"`             // 调用指令在方法中的偏移，以 2 字节为单位
+	Override bool   `json:"override,omitempty"` // 按类层次加入的子类或实现类中的方法
+}
+
+// CallGraph 静态调用图
+type CallGraph struct {
+	Nodes []CallGraphNode `json:"nodes"`
+	Edges []CallGraphEdge `json:"edges"`
+}
@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+// CertInfo 签名证书的信息
+type CertInfo struct {
+	Schemes      []int // 使用该证书签名的方案，SIG_SCHEME_*
+	Subject      string
+	Issuer       string
+	NotBefore    time.Time
+	NotAfter     time.Time
+	Serial       string // 十六进制
+	KeyAlgorithm string // RSA、EC、DSA、Ed25519
+	KeySize      int    // 密钥位数
+	MD5          []byte
+	SHA1         []byte
+	SHA256       []byte
+	Debug        bool     // Android SDK 生成的调试证书
+	WeakKey      bool     // 密钥长度不足
+	Warnings     []string // 调试证书、弱密钥、弱签名算法、过期等
+}
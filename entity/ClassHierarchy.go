@@ -0,0 +1,26 @@
+package entity
+
+// Android 四大组件和 Application 的框架基类
+const (
+	FRAMEWORK_ACTIVITY          = "Activity"
+	FRAMEWORK_SERVICE           = "Service"
+	FRAMEWORK_RECEIVER          = "BroadcastReceiver"
+	FRAMEWORK_PROVIDER          = "ContentProvider"
+	FRAMEWORK_APPLICATION       = "Application"
+	FRAMEWORK_ACTIVITY_CLASS    = "Landroid/app/Activity;"
+	FRAMEWORK_SERVICE_CLASS     = "Landroid/app/Service;"
+	FRAMEWORK_RECEIVER_CLASS    = "Landroid/content/BroadcastReceiver;"
+	FRAMEWORK_PROVIDER_CLASS    = "Landroid/content/ContentProvider;"
+	FRAMEWORK_APPLICATION_CLASS = "Landroid/app/Application;"
+)
+
+// HierarchyNode 继承关系图中的一个类，导出为 JSON 时使用
+type HierarchyNode struct {
+	Name       string   `json:"name"`
+	SuperClass string   `json:"super,omitempty"`
+	Interfaces []string `json:"interfaces,omitempty"`
+	Interface  bool     `json:"interface,omitempty"`
+	External   bool     `json:"external,omitempty"`  // 不在 APK 中定义，例如框架类
+	Framework  string   `json:"framework,omitempty"` // 最终继承的框架组件，FRAMEWORK_*
+	Dex        string   `json:"dex,omitempty"`
+}
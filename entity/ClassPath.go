@@ -0,0 +1,36 @@
+package entity
+
+// DexClass 类路径中的一个类定义
+type DexClass struct {
+	Name       string   // 类型描述符，例如 Lcom/a/B;
+	SuperClass string   // 空串表示没有父类
+	Interfaces []string // 直接实现的接口
+	Dex        *DexFile // 定义所在的 dex
+	DexIndex   int      // dex 在类路径中的序号
+	Def        ClassDef
+	Data       ClassDataItem
+}
+
+// ClassMethod 类中定义的方法
+type ClassMethod struct {
+	Class       *DexClass
+	Ref         DexMethodRef
+	MethodIdx   uint32 // Class.Dex 中的 method_ids 索引
+	AccessFlags uint32
+	CodeOff     uint32 // 0 表示没有代码
+	Direct      bool   // direct_methods 中的方法
+}
+
+// ClassField 类中定义的字段
+type ClassField struct {
+	Class       *DexClass
+	Ref         DexFieldRef
+	FieldIdx    uint32 // Class.Dex 中的 field_ids 索引
+	AccessFlags uint32
+}
+
+// DuplicateClass 在多个 dex 中重复定义的类，按加载顺序第一个生效
+type DuplicateClass struct {
+	Name  string
+	Dexes []string // 定义所在的 dex 文件，第一个为生效的定义
+}
@@ -0,0 +1,25 @@
+package entity
+
+// DEF_ENTRY 到达定义中表示方法入口处的值，即参数寄存器
+const DEF_ENTRY = 0xffffffff
+
+// 常量传播中寄存器值的状态
+const (
+	CONST_UNDEF   = iota // 还没有赋值
+	CONST_NUMBER         // 数值，long/double 按一对寄存器分别保存
+	CONST_STRING         // const-string 得到的字符串
+	CONST_VARYING        // 不是常量
+)
+
+// ConstValue 常量传播中一个寄存器的值
+type ConstValue struct {
+	Kind  int    // CONST_*
+	Value uint32 // CONST_NUMBER 时为寄存器中的 32 位值
+	Str   string // CONST_STRING 时为字符串
+}
+
+// MethodDataFlow 方法内的数据流分析结果，按指令偏移索引该指令执行之前的状态
+type MethodDataFlow struct {
+	Reaching  map[uint32]map[uint32][]uint32   // 寄存器 -> 到达的定义所在的指令偏移，升序，参数为 DEF_ENTRY
+	Constants map[uint32]map[uint32]ConstValue // 值为常量的寄存器
+}
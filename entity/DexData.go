@@ -11,9 +11,16 @@ var (
 	VERSION_037 = []byte{'0', '3', '7', 0}
 	VERSION_038 = []byte{'0', '3', '8', 0}
 	VERSION_039 = []byte{'0', '3', '9', 0}
+	VERSION_040 = []byte{'0', '4', '0', 0}
+	VERSION_041 = []byte{'0', '4', '1', 0} // 容器格式，一个文件内可包含多个 dex
 	VERSION_001 = []byte{'0', '0', '1', 0}
 )
 
+const (
+	KDexHeaderSize          = 0x70 // 035~040 的头部大小
+	KDexContainerHeaderSize = 0x78 // 041 头部追加了 container_size 和 header_offset
+)
+
 type MapItemType uint16
 
 const (
@@ -49,8 +56,18 @@ const (
 	ACC_SYNCHRONIZED = 0x0020
 	ACC_VOLATILE     = 0x0040
 	ACC_TRANSIENT    = 0x0080
-	ACC_INTERFACE    = 0x1000
-	ACC_ABSTRACT     = 0x2000
+	ACC_BRIDGE       = 0x0040 // 方法上与 ACC_VOLATILE 同一位
+	ACC_VARARGS      = 0x0080 // 方法上与 ACC_TRANSIENT 同一位
+	ACC_NATIVE       = 0x0100
+	ACC_INTERFACE    = 0x0200
+	ACC_ABSTRACT     = 0x0400
+	ACC_STRICT       = 0x0800
+	ACC_SYNTHETIC    = 0x1000
+	ACC_ANNOTATION   = 0x2000
+	ACC_ENUM         = 0x4000
+
+	ACC_CONSTRUCTOR           = 0x10000
+	ACC_DECLARED_SYNCHRONIZED = 0x20000
 )
 
 // MapItem 对应于 C++ 中的结构体
@@ -91,6 +108,8 @@ type MethodCodeItem struct {
 	DebbugInfoOff uint32   // 指向调试信息的偏移
 	InsnsSize     uint32   // 指令集个数，以 2 字节为单位
 	Insns         []uint16 // 指令集
+	CodeOff       uint32   // code item 在文件中的偏移
+	Tries         []TryItem
 }
 
 type MethodIdDef struct {
@@ -99,6 +118,32 @@ type MethodIdDef struct {
 	Name_idx_  uint32 // index into string_ids_ array for method name
 	MethodName string
 }
+type ProtoIdDef struct {
+	Shorty_idx_      uint32 // index into string_ids_ for shorty descriptor
+	Return_type_idx_ uint16 // index into type_ids_ array for return type
+	Pad_             uint16 // padding = 0
+	Parameters_off_  uint32 // file offset to type_list for parameter types
+}
+
+type FieldIdDef struct {
+	Class_idx_ uint16 // index into type_ids_ array for defining class
+	Type_idx_  uint16 // index into type_ids_ for field type
+	Name_idx_  uint32 // index into string_ids_ for field name
+}
+
+// TryItem code item 中的 try 块，类型使用 type_ids 的索引
+type TryItem struct {
+	StartAddr    uint32
+	InsnCount    uint16
+	Handlers     []CatchHandler
+	CatchAllAddr int64 // 没有 catch-all 时为 -1
+}
+
+type CatchHandler struct {
+	TypeIdx uint32
+	Addr    uint32
+}
+
 type MethodDef struct {
 	MethodIdx   uint32 // 指向 DexMethodId 的索引
 	AccessFlags uint32 // 访问标志
@@ -148,8 +193,15 @@ type DexHeader struct {
 	DataSize      uint32   /* 数据段的大小 */
 	DataOff       uint32   /* 数据段的偏移 */
 }
+
+// DexContainerHeader 041 版本在标准头部之后追加的字段
+type DexContainerHeader struct {
+	ContainerSize uint32 // 整个容器文件的大小
+	HeaderOffset  uint32 // 当前 dex 头部在容器中的偏移
+}
 type DexFile struct {
 	Header    DexHeader
+	Container DexContainerHeader // 仅 041 容器格式有效
 	Oridata   []byte
 	FileName  string
 	ValidDex  bool
@@ -159,4 +211,6 @@ type DexFile struct {
 	Strings   map[uint32]string
 	Typeids   []uint32
 	MethodIds []MethodIdDef
+	ProtoIds  []ProtoIdDef
+	FieldIds  []FieldIdDef
 }
@@ -0,0 +1,87 @@
+package entity
+
+// InsnFormat dalvik 指令格式，命名与官方文档一致
+type InsnFormat uint8
+
+const (
+	Format10x InsnFormat = iota
+	Format12x
+	Format11n
+	Format11x
+	Format10t
+	Format20t
+	Format22x
+	Format21t
+	Format21s
+	Format21h
+	Format21c
+	Format23x
+	Format22b
+	Format22t
+	Format22s
+	Format22c
+	Format30t
+	Format32x
+	Format31i
+	Format31t
+	Format31c
+	Format35c
+	Format3rc
+	Format45cc
+	Format4rcc
+	Format51l
+	FormatPayload // packed-switch / sparse-switch / fill-array-data 数据
+)
+
+// InsnIndexType 指令引用的常量池类型
+type InsnIndexType uint8
+
+const (
+	IndexNone InsnIndexType = iota
+	IndexString
+	IndexType
+	IndexField
+	IndexMethod
+	IndexProto
+	IndexCallSite
+	IndexMethodHandle
+	IndexMethodAndProto // invoke-polymorphic，Index 为方法，Index2 为 proto
+)
+
+// payload 的标识，位于 nop 指令的高字节
+const (
+	PackedSwitchPayload  uint16 = 0x0100
+	SparseSwitchPayload  uint16 = 0x0200
+	FillArrayDataPayload uint16 = 0x0300
+)
+
+type OpcodeInfo struct {
+	Name   string
+	Format InsnFormat
+	Index  InsnIndexType
+}
+
+// Instruction 解码后的一条指令
+type Instruction struct {
+	Opcode  uint8
+	Offset  uint32      // 在 insns 中的位置，以 2 字节为单位
+	Size    uint32      // 指令长度，以 2 字节为单位
+	Regs    []uint32    // 寄存器，按 vA vB vC 的顺序；35c 为参数列表，3rc 为展开后的连续寄存器
+	Literal int64       // 立即数，21h 已经左移到实际值
+	Index   uint32      // 常量池索引
+	Index2  uint32      // 45cc/4rcc 的 proto 索引
+	Branch  int32       // 分支偏移，相对于当前指令
+	Ref     interface{} // 模型中代替 Index 的符号引用
+	Ref2    interface{} // 模型中代替 Index2 的符号引用
+	Payload *InsnPayload
+}
+
+// InsnPayload switch 和 fill-array-data 的数据
+type InsnPayload struct {
+	Ident        uint16
+	FirstKey     int32   // packed-switch
+	Keys         []int32 // sparse-switch
+	Targets      []int32 // 相对于 switch 指令的偏移
+	ElementWidth uint16  // fill-array-data
+	Data         []byte  // fill-array-data
+}
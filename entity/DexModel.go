@@ -0,0 +1,190 @@
+package entity
+
+// encoded_value 的类型
+const (
+	VALUE_BYTE          = 0x00
+	VALUE_SHORT         = 0x02
+	VALUE_CHAR          = 0x03
+	VALUE_INT           = 0x04
+	VALUE_LONG          = 0x06
+	VALUE_FLOAT         = 0x10
+	VALUE_DOUBLE        = 0x11
+	VALUE_METHOD_TYPE   = 0x15
+	VALUE_METHOD_HANDLE = 0x16
+	VALUE_STRING        = 0x17
+	VALUE_TYPE          = 0x18
+	VALUE_FIELD         = 0x19
+	VALUE_METHOD        = 0x1a
+	VALUE_ENUM          = 0x1b
+	VALUE_ARRAY         = 0x1c
+	VALUE_ANNOTATION    = 0x1d
+	VALUE_NULL          = 0x1e
+	VALUE_BOOLEAN       = 0x1f
+)
+
+// 注解的可见性
+const (
+	VISIBILITY_BUILD   = 0x00
+	VISIBILITY_RUNTIME = 0x01
+	VISIBILITY_SYSTEM  = 0x02
+)
+
+// debug_info_item 中的操作码
+const (
+	DBG_END_SEQUENCE         = 0x00
+	DBG_ADVANCE_PC           = 0x01
+	DBG_ADVANCE_LINE         = 0x02
+	DBG_START_LOCAL          = 0x03
+	DBG_START_LOCAL_EXTENDED = 0x04
+	DBG_END_LOCAL            = 0x05
+	DBG_RESTART_LOCAL        = 0x06
+	DBG_SET_PROLOGUE_END     = 0x07
+	DBG_SET_EPILOGUE_BEGIN   = 0x08
+	DBG_SET_FILE             = 0x09
+	DBG_FIRST_SPECIAL        = 0x0a
+	DBG_LINE_BASE            = -4
+	DBG_LINE_RANGE           = 15
+)
+
+// method_handle_item 的类型
+const (
+	METHOD_HANDLE_STATIC_PUT         = 0x00
+	METHOD_HANDLE_STATIC_GET         = 0x01
+	METHOD_HANDLE_INSTANCE_PUT       = 0x02
+	METHOD_HANDLE_INSTANCE_GET       = 0x03
+	METHOD_HANDLE_INVOKE_STATIC      = 0x04
+	METHOD_HANDLE_INVOKE_INSTANCE    = 0x05
+	METHOD_HANDLE_INVOKE_CONSTRUCTOR = 0x06
+	METHOD_HANDLE_INVOKE_DIRECT      = 0x07
+	METHOD_HANDLE_INVOKE_INTERFACE   = 0x08
+)
+
+// NO_INDEX 表示没有对应的索引
+const NO_INDEX = 0xffffffff
+
+// 以下为内存中的 dex 模型，所有引用都使用描述符，写出时再统一分配索引
+
+// DexProtoRef 方法原型，类型均为描述符，例如 I、Ljava/lang/String;
+type DexProtoRef struct {
+	ReturnType string
+	Params     []string
+}
+
+type DexFieldRef struct {
+	Class string
+	Name  string
+	Type  string
+}
+
+type DexMethodRef struct {
+	Class string
+	Name  string
+	Proto DexProtoRef
+}
+
+type DexMethodHandle struct {
+	Type   uint16        // METHOD_HANDLE_*
+	Field  *DexFieldRef  // 字段类型的 handle
+	Method *DexMethodRef // 方法类型的 handle
+}
+
+// DexCallSite 调用点，Values 依次为 bootstrap method handle、方法名、method type 和额外参数
+type DexCallSite struct {
+	Values []EncodedValue
+}
+
+// EncodedValue Value 的实际类型：
+// BYTE/SHORT/CHAR/INT/LONG 为 int64，FLOAT 为 float32，DOUBLE 为 float64，BOOLEAN 为 bool，
+// STRING/TYPE 为 string，FIELD/ENUM 为 *DexFieldRef，METHOD 为 *DexMethodRef，
+// METHOD_TYPE 为 *DexProtoRef，METHOD_HANDLE 为 *DexMethodHandle，
+// ARRAY 为 []EncodedValue，ANNOTATION 为 *EncodedAnnotation，NULL 为 nil
+type EncodedValue struct {
+	Type  byte
+	Value interface{}
+}
+
+type EncodedAnnotation struct {
+	Type     string
+	Elements []AnnotationElement
+}
+
+type AnnotationElement struct {
+	Name  string
+	Value EncodedValue
+}
+
+type DexAnnotation struct {
+	Visibility byte
+	Annotation EncodedAnnotation
+}
+
+type DexDebugEvent struct {
+	Op       byte   // DBG_*，大于等于 DBG_FIRST_SPECIAL 的为特殊操作码
+	AddrDiff uint32 // DBG_ADVANCE_PC
+	LineDiff int32  // DBG_ADVANCE_LINE
+	Reg      uint32 // DBG_START_LOCAL/DBG_END_LOCAL/DBG_RESTART_LOCAL
+	Name     string // DBG_START_LOCAL/DBG_SET_FILE，空串表示没有
+	Type     string
+	Sig      string
+}
+
+type DexDebugInfo struct {
+	LineStart  uint32
+	ParamNames []string // 空串表示没有名字
+	Events     []DexDebugEvent
+}
+
+type DexCatchModel struct {
+	Type string
+	Addr uint32
+}
+
+type DexTryModel struct {
+	StartAddr    uint32
+	InsnCount    uint16
+	Handlers     []DexCatchModel
+	CatchAllAddr int64 // 没有 catch-all 时为 -1
+}
+
+type DexCodeModel struct {
+	RegistersSize uint16
+	InsSize       uint16
+	OutsSize      uint16
+	Insns         []*Instruction // Ref/Ref2 使用符号引用
+	Tries         []DexTryModel
+	Debug         *DexDebugInfo
+}
+
+type DexFieldModel struct {
+	Ref         DexFieldRef
+	AccessFlags uint32
+	StaticValue *EncodedValue // 静态字段的初始值，nil 使用默认值
+	Annotations []DexAnnotation
+}
+
+type DexMethodModel struct {
+	Ref              DexMethodRef
+	AccessFlags      uint32
+	Code             *DexCodeModel // abstract/native 方法为 nil
+	Annotations      []DexAnnotation
+	ParamAnnotations [][]DexAnnotation
+}
+
+type DexClassModel struct {
+	Name           string // 描述符，例如 Lcom/example/Main;
+	AccessFlags    uint32
+	SuperClass     string // 空串表示没有父类
+	Interfaces     []string
+	SourceFile     string
+	Annotations    []DexAnnotation
+	StaticFields   []*DexFieldModel
+	InstanceFields []*DexFieldModel
+	DirectMethods  []*DexMethodModel
+	VirtualMethods []*DexMethodModel
+}
+
+// DexModel 可写出的 dex 模型
+type DexModel struct {
+	Version string // 例如 "035"，为空时根据使用到的指令自动选择
+	Classes []*DexClassModel
+}
@@ -0,0 +1,29 @@
+package entity
+
+// FrameworkCall 模拟执行时应用代码对框架方法的一次调用
+type FrameworkCall struct {
+	Caller string   `json:"caller"` // 调用所在的方法签名
+	Offset uint32   `json:"offset"` // invoke 指令的偏移，以 2 字节为单位
+	Method string   `json:"method"` // 被调用的框架方法签名
+	Args   []string `json:"args"`
+	Result string   `json:"result,omitempty"`
+	Error  string   `json:"error,omitempty"`
+}
+
+// LifecycleStep 模拟执行的一个生命周期方法
+type LifecycleStep struct {
+	Component string `json:"component"` // 组件类名
+	Method    string `json:"method"`    // 实际执行的方法签名
+	Framework bool   `json:"framework"` // 应用没有重写，由框架桩函数处理
+	Error     string `json:"error,omitempty"`
+}
+
+// LifecycleReport 按清单模拟应用启动的结果
+type LifecycleReport struct {
+	Application  string          `json:"application"`
+	Activity     string          `json:"activity,omitempty"` // 启动 Activity
+	Steps        []LifecycleStep `json:"steps"`
+	Calls        []FrameworkCall `json:"calls"`
+	UnknownCalls []string        `json:"unknown_calls,omitempty"`
+	Logs         []string        `json:"logs,omitempty"`
+}
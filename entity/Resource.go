@@ -0,0 +1,49 @@
+package entity
+
+// resources.arsc 中的块类型
+const (
+	RES_STRING_POOL_TYPE     = 0x0001
+	RES_TABLE_TYPE           = 0x0002
+	RES_TABLE_PACKAGE_TYPE   = 0x0200
+	RES_TABLE_TYPE_TYPE      = 0x0201
+	RES_TABLE_TYPE_SPEC_TYPE = 0x0202
+)
+
+// Res_value 的数据类型
+const (
+	RES_VALUE_NULL      = 0x00
+	RES_VALUE_REFERENCE = 0x01
+	RES_VALUE_ATTRIBUTE = 0x02
+	RES_VALUE_STRING    = 0x03
+	RES_VALUE_FLOAT     = 0x04
+	RES_VALUE_DIMENSION = 0x05
+	RES_VALUE_FRACTION  = 0x06
+	RES_VALUE_INT_DEC   = 0x10
+	RES_VALUE_INT_HEX   = 0x11
+	RES_VALUE_BOOLEAN   = 0x12
+	RES_VALUE_COLOR_MIN = 0x1c
+	RES_VALUE_COLOR_MAX = 0x1f
+)
+
+// ResourceValue 资源在一个配置下的值
+type ResourceValue struct {
+	Config string // 配置限定符，例如 zh-rCN、xhdpi、v21，默认配置为空
+	Type   uint8  // RES_VALUE_*，style、array 等复杂资源为 RES_VALUE_NULL
+	Data   uint32
+	Text   string // 字符串资源为字符串内容，其他类型为值的文本形式
+}
+
+// ResourceEntry 一个资源 ID 对应的资源
+type ResourceEntry struct {
+	Id     uint32
+	Type   string // string、drawable、layout 等
+	Name   string
+	Values []ResourceValue
+}
+
+// ResourceTable resources.arsc 中的资源表
+type ResourceTable struct {
+	Strings  []string          // 全局字符串池
+	Packages map[uint32]string // 包 ID -> 包名
+	Entries  map[uint32]*ResourceEntry
+}
@@ -0,0 +1,124 @@
+package entity
+
+import (
+	"crypto"
+	"crypto/x509"
+)
+
+// 签名方案
+const (
+	SIG_SCHEME_V1  = 1
+	SIG_SCHEME_V2  = 2
+	SIG_SCHEME_V3  = 3
+	SIG_SCHEME_V31 = 31
+)
+
+// APK 签名区块中的 ID
+const (
+	SIG_BLOCK_V2      = 0x7109871a
+	SIG_BLOCK_V3      = 0xf05368c0
+	SIG_BLOCK_V31     = 0x1b93ad61
+	SIG_BLOCK_PADDING = 0x42726577 // verity 对齐的填充
+	SIG_BLOCK_STAMP   = 0x6dff800d // source stamp
+)
+
+// v2/v3 签名数据中的附加属性
+const (
+	SIG_ATTR_STRIPPING_PROTECTION = 0xbeeff00d // v2：值为同时存在的更高版本的方案
+	SIG_ATTR_PROOF_OF_ROTATION    = 0x3ba06f8c // v3：证书轮换记录
+	SIG_ATTR_ROTATION_MIN_SDK     = 0x559f8b02 // v3：值为 v3.1 签名生效的 SDK 版本
+	SIG_ATTR_ROTATION_ON_DEV      = 0xc2a6b3ba // v3：轮换在开发版系统上生效
+)
+
+// v2/v3 签名算法
+const (
+	SIG_RSA_PSS_SHA256          = 0x0101
+	SIG_RSA_PSS_SHA512          = 0x0102
+	SIG_RSA_PKCS1_SHA256        = 0x0103
+	SIG_RSA_PKCS1_SHA512        = 0x0104
+	SIG_ECDSA_SHA256            = 0x0201
+	SIG_ECDSA_SHA512            = 0x0202
+	SIG_DSA_SHA256              = 0x0301
+	SIG_VERITY_RSA_PKCS1_SHA256 = 0x0421
+	SIG_VERITY_ECDSA_SHA256     = 0x0423
+	SIG_VERITY_DSA_SHA256       = 0x0425
+)
+
+// 证书轮换记录中证书的权限
+const (
+	ROTATION_INSTALLED_DATA = 1
+	ROTATION_SHARED_USER_ID = 2
+	ROTATION_PERMISSION     = 4
+	ROTATION_ROLLBACK       = 8
+	ROTATION_AUTH           = 16
+)
+
+// ApkSigningBlock 中央目录之前的 APK 签名区块
+type ApkSigningBlock struct {
+	Offset int64 // 在文件中的位置
+	Size   int64 // 包括头尾的大小
+	Pairs  []SigningBlockPair
+}
+
+// SigningBlockPair 签名区块中的一项
+type SigningBlockPair struct {
+	Id    uint32
+	Value []byte
+}
+
+// SignatureDigest 签名的摘要，v1 为 .SF 中清单的摘要，v2/v3 为 APK 内容的摘要
+type SignatureDigest struct {
+	Algorithm uint32 // v2/v3 的 SIG_*，v1 为 0
+	Name      string // 摘要算法
+	Value     []byte
+}
+
+// RotationNode 证书轮换记录中的一个证书
+type RotationNode struct {
+	Certificate *x509.Certificate
+	Flags       uint32 // ROTATION_*
+	Algorithm   uint32 // 签署下一个证书的算法
+}
+
+// ApkSigner 一个签名者的验证结果
+type ApkSigner struct {
+	Name         string              // v1 为签名文件名，v2/v3 为序号
+	Certificates []*x509.Certificate // 第一个为签名证书
+	Digests      []SignatureDigest
+	MinSdk       uint32            // v3 适用的最低 SDK 版本
+	MaxSdk       uint32            // v3 适用的最高 SDK 版本
+	Attributes   map[uint32][]byte // v2/v3 签名数据中的附加属性，SIG_ATTR_*
+	Lineage      []RotationNode    // v3 证书轮换记录，从最早的证书开始
+	Errors       []string
+}
+
+// SignatureScheme 一种签名方案的验证结果
+type SignatureScheme struct {
+	Version  int // SIG_SCHEME_*
+	Signers  []*ApkSigner
+	Errors   []string // 不属于某个签名者的错误
+	Verified bool     // 没有任何错误
+}
+
+// SignatureReport APK 签名的验证结果
+type SignatureReport struct {
+	Block    *ApkSigningBlock   // 没有签名区块时为 nil
+	Schemes  []*SignatureScheme // 只包含 APK 中存在的方案
+	Issues   []string           // 方案之间的不一致和被删除的签名
+	Verified bool               // 至少有一种方案，所有方案都通过验证并且没有不一致
+}
+
+// SigningKey 签名用的私钥和证书
+type SigningKey struct {
+	Key          crypto.Signer
+	Certificates []*x509.Certificate // 第一个为签名证书，其余为证书链
+}
+
+// SignOptions APK 签名的选项
+type SignOptions struct {
+	V1     bool
+	V2     bool
+	V3     bool
+	MinSdk uint32 // APK 支持的最低 SDK 版本，低于 18 时 v1 签名使用 SHA-1
+	Name   string // v1 签名文件名，为空时使用 CERT
+}
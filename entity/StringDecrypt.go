@@ -0,0 +1,12 @@
+package entity
+
+// DecryptedString 一处字符串解密调用和模拟执行的结果
+type DecryptedString struct {
+	Caller string   `json:"caller"` // 调用所在的方法签名
+	Dex    string   `json:"dex"`
+	Offset uint32   `json:"offset"` // invoke 指令的偏移，以 2 字节为单位
+	Method string   `json:"method"` // 解密方法签名
+	Args   []string `json:"args"`   // 常量参数的文本形式
+	Value  string   `json:"value"`  // 解密结果
+	Error  string   `json:"error,omitempty"`
+}
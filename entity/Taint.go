@@ -0,0 +1,29 @@
+package entity
+
+// TaintStep 污点传播经过的一条指令
+type TaintStep struct {
+	Method string `json:"method"`
+	Offset uint32 `json:"offset"` // 以 2 字节为单位
+	Insn   string `json:"insn"`   // 指令文本
+}
+
+// TaintFinding 来自数据源的值到达 sink 调用
+type TaintFinding struct {
+	Source string      `json:"source"` // 数据源方法签名
+	Sink   string      `json:"sink"`   // sink 方法签名
+	Caller string      `json:"caller"` // sink 调用所在的方法
+	Offset uint32      `json:"offset"` // sink 调用的偏移
+	Path   []TaintStep `json:"path"`   // 从数据源调用到 sink 调用
+}
+
+// TaintRule 静态污点分析的一条数据源或 sink 规则
+type TaintRule struct {
+	Method string             // 方法签名，不带参数列表时匹配所有同名方法
+	Args   map[int]ConstValue // 要求参数为常量，按参数序号，实例方法的 this 为 0
+}
+
+// TaintRules 静态污点分析的数据源和 sink
+type TaintRules struct {
+	Sources []TaintRule
+	Sinks   []TaintRule
+}
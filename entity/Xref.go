@@ -0,0 +1,20 @@
+package entity
+
+// 交叉引用的类型
+const (
+	XREF_INVOKE      = "invoke" // invoke-* 调用方法
+	XREF_FIELD_READ  = "read"   // iget/sget 读取字段
+	XREF_FIELD_WRITE = "write"  // iput/sput 写入字段
+	XREF_STRING      = "string" // const-string 使用字符串
+	XREF_TYPE        = "type"   // const-class/new-instance/check-cast 等使用类型
+)
+
+// XrefEntry 一条交叉引用记录
+type XrefEntry struct {
+	Kind   string // XREF_*
+	Target string // 方法签名、字段签名、字符串内容或类型描述符
+	Caller string // 引用所在方法的签名
+	Dex    string // 引用所在的 dex 文件
+	Offset uint32 // 指令在方法中的偏移，以 2 字节为单位
+	Opcode string // 指令助记符
+}
@@ -9,25 +9,80 @@ import (
 
 type CmdConfig struct {
 	ApkPath      string
+	Extract      bool   // 分析 APK 之前先解压到 OutputDir
+	Signatures   bool   // 验证 APK 的 v1/v2/v3 签名
+	Certs        bool   // 输出签名证书的信息和指纹
+	SignOut      string // 不为空时签名 ApkPath 并写到该文件
+	RepackOut    string // 不为空时把 OutputDir 重新打包到该文件，有私钥时同时签名
+	KeyPath      string // 签名用的私钥
+	CertPath     string // 签名用的证书
+	Keystore     string // 签名用的 PKCS#12 密钥库，代替 KeyPath 和 CertPath
+	StorePass    string // 加密私钥或密钥库的密码
+	KeyAlias     string // 密钥库中私钥的别名
+	MinSdk       int    // APK 支持的最低 SDK 版本，决定 v1 签名的摘要算法
 	OutputDir    string
 	ManifestPath string
 	DexPath      []string
+	SmaliDir     string // 不为空时把该目录下的 smali 汇编为 dex
+	DexOut       string
+	HierarchyOut string   // 继承关系导出文件，.dot 后缀导出 DOT，否则导出 JSON
+	XrefQuery    string   // 查询交叉引用
+	CallGraphOut string   // 调用图导出文件，按后缀 .dot/.graphml 选择格式，否则导出 JSON
+	EntryOnly    bool     // 调用图只保留从清单入口可达的部分
+	Decrypt      bool     // 模拟执行字符串解密方法并输出结果
+	DecryptOut   string   // 不为空时把解密后的 dex 写到该目录
+	Trace        bool     // 模拟执行时输出每条指令
+	Step         bool     // 模拟执行时从第一条指令开始进入调试控制台
+	Breakpoints  []string // 模拟执行的断点，格式为 方法签名@偏移
+	Taint        bool     // 模拟启动时跟踪敏感数据到 sink 的传播
+	Leaks        bool     // 静态分析敏感数据到 sink 的数据流
+	TaintRules   string   // 静态污点分析的规则文件，为空时使用默认规则
 }
 
 // ParseArgs 解析控制台传递的参数
 func ParseArgs() (CmdConfig, error) {
 	apkPath := flag.String("apk", "", "Path to the APK file to be unpacked")
 	outputDir := flag.String("out", "./testdata", "Directory to output the unpacked APK")
+	extract := flag.Bool("unzip", false, "Also extract the APK to -out; the APK is otherwise read in memory")
+	signatures := flag.Bool("verifysig", false, "Verify the v1, v2, v3 and v3.1 signatures of -apk")
+	certs := flag.Bool("cert", false, "Print the signer certificates of -apk with their fingerprints")
+	signOut := flag.String("sign", "", "Sign -apk with v1, v2 and v3 signatures and write it to this file")
+	repackOut := flag.String("repack", "", "Rebuild the directory -out into this APK, keeping the entry order and compression of -apk if given; signed when -key or -keystore is set")
+	keyPath := flag.String("key", "", "PKCS#8, PKCS#1 or SEC1 private key (PEM or DER) for -sign")
+	certPath := flag.String("certfile", "", "X.509 certificate (PEM or DER) for -sign")
+	keystore := flag.String("keystore", "", "PKCS#12 keystore for -sign instead of -key and -certfile")
+	storePass := flag.String("storepass", "", "Password of the encrypted private key or -keystore")
+	keyAlias := flag.String("alias", "", "Alias of the private key in -keystore, the first key by default")
+	minSdk := flag.Int("minsdk", 0, "Minimum SDK of the APK for -sign and -repack; below 18 the v1 signature uses SHA-1")
+	smaliDir := flag.String("smali", "", "Directory of smali files to assemble into a dex")
+	dexOut := flag.String("dexout", "classes.dex", "Output dex file for -smali")
+	hierarchyOut := flag.String("hierarchy", "", "Export the class hierarchy to a .json or .dot file")
+	callGraphOut := flag.String("callgraph", "", "Export the call graph to a .json, .dot or .graphml file")
+	entryOnly := flag.Bool("entry", false, "Only keep methods reachable from manifest entry points in -callgraph")
+	decrypt := flag.Bool("decrypt", false, "Emulate static string decryptors called with constant arguments and print the results")
+	decryptOut := flag.String("decryptout", "", "Directory to write dex files with decrypted strings inlined (implies -decrypt)")
+	trace := flag.Bool("trace", false, "Print every instruction executed by the emulator")
+	step := flag.Bool("step", false, "Start the interactive debugger at the first emulated instruction")
+	breakpoints := flag.String("break", "", "Comma separated emulator breakpoints, e.g. Lcom/a/B;->foo@0x12")
+	taint := flag.Bool("taint", false, "Track sensitive data from sources to sinks while emulating the app startup")
+	leaks := flag.Bool("leaks", false, "Statically find data flows from sources to sinks without running the app")
+	taintRules := flag.String("rules", "", "Source and sink rules for -leaks, one \"source|sink <method> [index=constant ...]\" per line")
+	xrefQuery := flag.String("xref", "", "Find references to a method (Lx;->m()V), field (Lx;->f:I), type (Lx;) or string")
 
 	flag.Parse()
 
+	if *smaliDir != "" {
+		return CmdConfig{SmaliDir: *smaliDir, DexOut: *dexOut}, nil
+	}
+
 	if *apkPath == "" && *outputDir == "" {
 		fmt.Println("Error: APK or out path is required.")
 		flag.Usage()
 		return CmdConfig{}, fmt.Errorf("APK or out path is required")
 	}
 	var dexFiles []string
-	if *outputDir != "" {
+	// 指定 APK 时直接从压缩包读取 dex
+	if *apkPath == "" && *outputDir != "" {
 		d, err := GetDexFilesInDir(*outputDir)
 		if err != nil {
 			return CmdConfig{}, err
@@ -37,12 +92,46 @@ func ParseArgs() (CmdConfig, error) {
 
 	return CmdConfig{
 		ApkPath:      *apkPath,
+		Extract:      *extract,
+		Signatures:   *signatures,
+		Certs:        *certs,
+		SignOut:      *signOut,
+		RepackOut:    *repackOut,
+		KeyPath:      *keyPath,
+		CertPath:     *certPath,
+		Keystore:     *keystore,
+		StorePass:    *storePass,
+		KeyAlias:     *keyAlias,
+		MinSdk:       *minSdk,
 		OutputDir:    *outputDir,
 		ManifestPath: *outputDir + "/AndroidManifest.xml",
 		DexPath:      dexFiles,
+		HierarchyOut: *hierarchyOut,
+		XrefQuery:    *xrefQuery,
+		CallGraphOut: *callGraphOut,
+		EntryOnly:    *entryOnly,
+		Decrypt:      *decrypt || *decryptOut != "",
+		DecryptOut:   *decryptOut,
+		Trace:        *trace,
+		Step:         *step,
+		Breakpoints:  splitList(*breakpoints),
+		Taint:        *taint,
+		Leaks:        *leaks || *taintRules != "",
+		TaintRules:   *taintRules,
 	}, nil
 }
 
+// 按逗号分割参数，忽略空项
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
 func GetDexFilesInDir(dir string) ([]string, error) {
 	// 读取目录中的所有文件和子目录
 	entries, err := os.ReadDir(dir)
@@ -3,8 +3,14 @@ package main
 import (
 	"apkgo/entity"
 	"apkgo/tools"
-	"container/list"
+	"bytes"
+	"crypto/sha256"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -14,25 +20,117 @@ func main() {
 	if err != nil {
 		return
 	}
-	if config.ApkPath != "" {
-		// 解压APK
-		err = tools.Unzip(config.ApkPath, config.OutputDir)
+	if config.SmaliDir != "" {
+		// 汇编 smali
+		model, err := tools.AssembleSmaliDir(config.SmaliDir)
 		if err != nil {
-			fmt.Println("Error during unzipping:", err)
+			fmt.Println("Error during assembling:", err)
+			return
+		}
+		err = tools.WriteDexFile(config.DexOut, model)
+		if err != nil {
+			fmt.Println("Error during writing dex:", err)
 			return
-		} else {
-			fmt.Println("APK successfully unpacked to", config.OutputDir)
-			config.DexPath, _ = entity.GetDexFilesInDir(config.OutputDir)
 		}
+		fmt.Printf("%d classes assembled to %s\n", len(model.Classes), config.DexOut)
+		return
 	}
-
 	tools.DebugFlag = false
 
-	manifestData, err := tools.ReadManifest(config.ManifestPath)
-	if err != nil {
-		fmt.Println(err)
+	if config.RepackOut != "" {
+		// 把解压的目录重新打包
+		if err := repackApk(config); err != nil {
+			fmt.Println("Error during repacking:", err)
+			return
+		}
+		fmt.Printf("%s repacked to %s\n", config.OutputDir, config.RepackOut)
+		return
+	}
+	if config.SignOut != "" {
+		// 签名 APK
+		if config.ApkPath == "" {
+			fmt.Println("Error: -sign requires -apk")
+			return
+		}
+		key, err := loadSigningKey(config)
+		if err != nil {
+			fmt.Println("Error during loading signing key:", err)
+			return
+		}
+		if err := tools.SignApkFile(config.ApkPath, config.SignOut, key, signOptions(config)); err != nil {
+			fmt.Println("Error during signing:", err)
+			return
+		}
+		fmt.Printf("APK signed with %s to %s\n", key.Certificates[0].Subject, config.SignOut)
 		return
 	}
+
+	var manifestData *entity.ManifestData
+	var loaded []*entity.DexFile
+	if config.ApkPath != "" {
+		if config.Extract {
+			// 解压APK
+			report, err := tools.UnzipWithLimits(config.ApkPath, config.OutputDir, tools.DefaultArchiveLimits())
+			if err != nil {
+				fmt.Println("Error during unzipping:", err)
+				return
+			}
+			for _, issue := range report.Issues {
+				fmt.Printf("suspicious %s %s: %s\n", issue.Kind, issue.Entry, issue.Detail)
+			}
+			fmt.Printf("APK unpacked to %s: %d files, %d skipped\n", config.OutputDir, report.Extracted, report.Skipped)
+		}
+		// 直接从压缩包中解析
+		apk, err := tools.OpenApk(config.ApkPath)
+		if err != nil {
+			fmt.Println("Error during reading APK:", err)
+			return
+		}
+		if !config.Extract {
+			// 解压时已经输出
+			for _, issue := range apk.Zip.Issues {
+				fmt.Printf("suspicious %s %s: %s\n", issue.Kind, issue.Entry, issue.Detail)
+			}
+		}
+		if config.Signatures || config.Certs {
+			report, err := tools.VerifySignatures(apk.Zip)
+			if err != nil {
+				fmt.Println("Error during verifying signatures:", err)
+				return
+			}
+			if config.Signatures {
+				printSignatureReport(report)
+			}
+			if config.Certs {
+				printCertificates(tools.SignerCertificates(report))
+			}
+		}
+		manifestData = apk.Manifest
+		loaded = apk.Dexes
+		for _, err := range apk.DexErrors {
+			fmt.Println("dex decode error:", err)
+		}
+		if apk.Resources != nil {
+			fmt.Println("resources", len(apk.Resources.Entries))
+		}
+	} else {
+		manifestData, err = tools.ReadManifest(config.ManifestPath)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		// 按 classes.dex、classes2.dex ... 的顺序加载
+		tools.SortDexPaths(config.DexPath)
+		for strIndex := range config.DexPath {
+			dexs, err := tools.LoadDexContainer(config.DexPath[strIndex])
+			if err != nil {
+				fmt.Println(config.DexPath[strIndex], "dex decode error:", err)
+				continue
+			}
+			// 041 容器格式的文件中可能包含多个 dex
+			loaded = append(loaded, dexs...)
+		}
+	}
 	fmt.Println("package " + manifestData.PackageName)
 	fmt.Println("Application " + manifestData.Application)
 	for e := manifestData.UsesPermission.Front(); e != nil; e = e.Next() {
@@ -43,47 +141,335 @@ func main() {
 		fmt.Printf("Activity:%s isMain: %t\n", key, value)
 	}
 	// tools.WriteManifest("./newFile.xml", manifestData)
-	var dexData = list.New()
-	for strIndex := range config.DexPath {
-		fmt.Print(config.DexPath[strIndex])
-		data, err := tools.LoadDex(config.DexPath[strIndex])
-		if err != nil {
-			fmt.Println("dex decode error:", err)
+	var dexData []*entity.DexFile
+	for _, data := range loaded {
+		fmt.Print(data.FileName)
+		if tools.Verify(data) {
+			dexData = append(dexData, data)
+			fmt.Println(" valid dex")
 		} else {
-			if tools.Verify(data) {
-				dexData.PushBack(data)
-				fmt.Println(" valid dex")
+			fmt.Println(" not a valid dex")
+		}
+	}
+	classPath, err := tools.NewClassPath(dexData)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, dup := range classPath.Duplicates {
+		fmt.Println("duplicate class", dup.Name, dup.Dexes)
+	}
+	if config.HierarchyOut != "" {
+		err = exportHierarchy(classPath, config.HierarchyOut)
+		if err != nil {
+			fmt.Println("Error during exporting hierarchy:", err)
+		}
+	}
+	if config.CallGraphOut != "" {
+		err = exportCallGraph(classPath, manifestData, config)
+		if err != nil {
+			fmt.Println("Error during exporting call graph:", err)
+		}
+	}
+	if config.XrefQuery != "" {
+		xrefs, err := tools.BuildXrefIndex(classPath)
+		if err != nil {
+			fmt.Println("Error during building xref:", err)
+			return
+		}
+		for _, entry := range xrefs.Query(config.XrefQuery) {
+			fmt.Printf("%s %s+0x%x %s %s\n", entry.Kind, entry.Caller, entry.Offset, entry.Opcode, entry.Target)
+		}
+		return
+	}
+	if config.Leaks {
+		rules := tools.DefaultTaintRules()
+		if config.TaintRules != "" {
+			if rules, err = tools.LoadTaintRules(config.TaintRules); err != nil {
+				fmt.Println("Error during loading taint rules:", err)
+				return
+			}
+		}
+		findings, err := tools.AnalyzeTaintFlows(classPath, rules)
+		if err != nil {
+			fmt.Println("Error during analyzing data flow:", err)
+			return
+		}
+		printTaintFindings(findings)
+		return
+	}
+	if config.Decrypt {
+		results, err := tools.DecryptStrings(classPath)
+		if err != nil {
+			fmt.Println("Error during decrypting strings:", err)
+			return
+		}
+		for _, result := range results {
+			if result.Error != "" {
+				fmt.Printf("%s+0x%x %s(%s) failed: %s\n", result.Caller, result.Offset, result.Method, strings.Join(result.Args, ", "), result.Error)
 			} else {
-				fmt.Println("not a valid dex")
+				fmt.Printf("%s+0x%x %s(%s) = %q\n", result.Caller, result.Offset, result.Method, strings.Join(result.Args, ", "), result.Value)
+			}
+		}
+		if config.DecryptOut != "" {
+			if err := writeDecryptedDexes(dexData, results, config.DecryptOut); err != nil {
+				fmt.Println("Error during writing decrypted dex:", err)
 			}
 		}
+		return
+	}
+	// 按清单模拟应用启动
+	vm := tools.NewVM(classPath)
+	vm.UnknownPolicy = entity.UNKNOWN_CALL_SYMBOLIC
+	if err := attachDebugHooks(vm, config); err != nil {
+		fmt.Println("Error during setting breakpoints:", err)
+		return
+	}
+	var tracker *tools.TaintTracker
+	if config.Taint {
+		tracker = tools.NewTaintTracker()
+		vm.Hooks = append(vm.Hooks, tracker)
 	}
-	var class entity.ClassDef
-	var classdex *entity.DexFile
+	report, err := tools.RunLifecycle(vm, manifestData)
+	if report != nil {
+		for _, step := range report.Steps {
+			if step.Framework {
+				fmt.Println("run", step.Method, "(framework)")
+			} else {
+				fmt.Println("run", step.Method)
+			}
+		}
+		for _, call := range report.Calls {
+			result := call.Result
+			if call.Error != "" {
+				result = "throws " + call.Error
+			}
+			fmt.Printf("%s+0x%x -> %s(%s) %s\n", call.Caller, call.Offset, call.Method, strings.Join(call.Args, ", "), result)
+		}
+		for _, line := range report.Logs {
+			fmt.Println(line)
+		}
+	}
+	if err != nil {
+		fmt.Println("Error during execution:", err)
+	}
+	if tracker != nil {
+		printTaintFindings(tracker.Findings)
+	}
+}
+
+// 读取 -keystore 或 -key 和 -certfile 指定的签名密钥
+func loadSigningKey(config entity.CmdConfig) (*entity.SigningKey, error) {
+	if config.Keystore != "" {
+		return tools.LoadPkcs12(config.Keystore, config.StorePass, config.KeyAlias)
+	}
+	return tools.LoadSigningKey(config.KeyPath, config.CertPath, config.StorePass)
+}
+
+// 命令行签名同时写入 v1、v2、v3 签名
+func signOptions(config entity.CmdConfig) entity.SignOptions {
+	return entity.SignOptions{V1: true, V2: true, V3: true, MinSdk: uint32(config.MinSdk)}
+}
 
-	for e := dexData.Front(); e != nil; e = e.Next() {
-		dex := e.Value.(*entity.DexFile)
-		classDef, err := tools.GetClassDef(manifestData.Application, dex)
+// 把 -out 目录打包为 APK，指定了私钥时签名后再写入
+func repackApk(config entity.CmdConfig) error {
+	if config.KeyPath == "" && config.Keystore == "" {
+		return tools.BuildApkFile(config.OutputDir, config.ApkPath, config.RepackOut)
+	}
+	key, err := loadSigningKey(config)
+	if err != nil {
+		return err
+	}
+	var original *entity.ZipArchive
+	if config.ApkPath != "" {
+		f, err := os.Open(config.ApkPath)
 		if err != nil {
-			fmt.Printf("%s not in %s %s\n", manifestData.Application, dex.FileName, err)
-		} else {
-			fmt.Printf("%s in %s\n", manifestData.Application, dex.FileName)
-			fmt.Printf("assess flag %s\n", tools.GetClassAccessString(classDef))
-			class = classDef
-			classdex = dex
-			break
+			return err
+		}
+		defer f.Close()
+		if original, err = tools.OpenZip(f); err != nil {
+			return err
 		}
 	}
-	if class.ClassName != "" {
-		methodid, _ := tools.GetMethodId("Test", class.Class_idx_, classdex)
-		fmt.Println("run methond ", methodid)
-		codeItem, _ := tools.ReadMethodCode(classdex, methodid, class)
+	var buf bytes.Buffer
+	if err := tools.BuildApk(config.OutputDir, original, &buf); err != nil {
+		return err
+	}
+	z, err := tools.ReadZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		return err
+	}
+	signed, err := tools.SignApk(z, key, signOptions(config))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(config.RepackOut, signed, 0644)
+}
 
-		vm := tools.VM{
-			Registers: make([]int, 16),
-			PC:        0,
-			Stack:     []int{},
+// 输出签名验证的结果
+func printSignatureReport(report *entity.SignatureReport) {
+	if report.Block != nil {
+		for _, pair := range report.Block.Pairs {
+			fmt.Printf("signing block 0x%08x %d bytes\n", pair.Id, len(pair.Value))
+		}
+	}
+	for _, scheme := range report.Schemes {
+		fmt.Printf("%s verified: %v\n", tools.SignatureSchemeName(scheme.Version), scheme.Verified)
+		for _, signer := range scheme.Signers {
+			fmt.Printf("  signer %s", signer.Name)
+			if scheme.Version >= entity.SIG_SCHEME_V3 {
+				fmt.Printf(" sdk %d-%d", signer.MinSdk, signer.MaxSdk)
+			}
+			fmt.Println()
+			for _, cert := range signer.Certificates {
+				fmt.Printf("    cert %s sha256 %x\n", cert.Subject, sha256.Sum256(cert.Raw))
+			}
+			for _, digest := range signer.Digests {
+				fmt.Printf("    digest %s %x\n", digest.Name, digest.Value)
+			}
+			for _, node := range signer.Lineage {
+				fmt.Printf("    rotation %s flags %d\n", node.Certificate.Subject, node.Flags)
+			}
+			for _, e := range signer.Errors {
+				fmt.Println("    error:", e)
+			}
 		}
-		vm.ExecuteBytecode(codeItem.Insns)
+		for _, e := range scheme.Errors {
+			fmt.Println("  error:", e)
+		}
+	}
+	for _, issue := range report.Issues {
+		fmt.Println("signature issue:", issue)
+	}
+	fmt.Println("signatures verified:", report.Verified)
+}
+
+// 输出签名证书的信息
+func printCertificates(certs []entity.CertInfo) {
+	for _, cert := range certs {
+		var schemes []string
+		for _, v := range cert.Schemes {
+			schemes = append(schemes, tools.SignatureSchemeName(v))
+		}
+		fmt.Printf("Signer certificate (%s)\n", strings.Join(schemes, ", "))
+		fmt.Println("  Subject:", cert.Subject)
+		fmt.Println("  Issuer:", cert.Issuer)
+		fmt.Printf("  Valid: %s to %s\n", cert.NotBefore.Format(time.RFC3339), cert.NotAfter.Format(time.RFC3339))
+		fmt.Println("  Serial:", cert.Serial)
+		fmt.Printf("  Key: %s %d bits\n", cert.KeyAlgorithm, cert.KeySize)
+		fmt.Println("  MD5:", tools.FormatFingerprint(cert.MD5))
+		fmt.Println("  SHA-1:", tools.FormatFingerprint(cert.SHA1))
+		fmt.Println("  SHA-256:", tools.FormatFingerprint(cert.SHA256))
+		for _, w := range cert.Warnings {
+			fmt.Println("  WARNING:", w)
+		}
+	}
+}
+
+// 输出每条泄漏路径上的方法和指令偏移
+func printTaintFindings(findings []entity.TaintFinding) {
+	for _, finding := range findings {
+		fmt.Printf("leak %s -> %s at %s+0x%x\n", finding.Source, finding.Sink, finding.Caller, finding.Offset)
+		for _, step := range finding.Path {
+			fmt.Printf("\t%s+0x%x %s\n", step.Method, step.Offset, step.Insn)
+		}
+	}
+}
+
+// 按命令行参数给虚拟机加上指令跟踪和调试控制台
+func attachDebugHooks(vm *tools.VM, config entity.CmdConfig) error {
+	if config.Trace {
+		vm.Hooks = append(vm.Hooks, tools.NewTraceHook(os.Stdout))
+	}
+	if !config.Step && len(config.Breakpoints) == 0 {
+		return nil
+	}
+	debugger := tools.NewDebugger(os.Stdin, os.Stdout)
+	if !config.Step {
+		debugger.Continue()
+	}
+	for _, bp := range config.Breakpoints {
+		i := strings.LastIndex(bp, "@")
+		if i < 0 {
+			return fmt.Errorf("invalid breakpoint %s", bp)
+		}
+		pc, err := strconv.ParseUint(bp[i+1:], 0, 32)
+		if err != nil {
+			return fmt.Errorf("invalid breakpoint %s: %v", bp, err)
+		}
+		debugger.AddBreakpoint(bp[:i], uint32(pc))
+	}
+	vm.Hooks = append(vm.Hooks, debugger)
+	return nil
+}
+
+// 把解密结果写回各个 dex，输出到 dir 下的同名文件，容器中的多个 dex 按序号区分
+func writeDecryptedDexes(dexData []*entity.DexFile, results []entity.DecryptedString, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	names := make(map[string]int)
+	for _, dex := range dexData {
+		var own []entity.DecryptedString
+		for _, result := range results {
+			if result.Dex == dex.FileName {
+				own = append(own, result)
+			}
+		}
+		name := filepath.Base(dex.FileName)
+		names[name]++
+		if names[name] > 1 {
+			name = fmt.Sprintf("%s.%d", name, names[name]-1)
+		}
+		if len(own) == 0 {
+			continue
+		}
+		model, err := tools.ReadDexModel(dex)
+		if err != nil {
+			return fmt.Errorf("%s: %v", dex.FileName, err)
+		}
+		count := tools.RewriteDecryptedStrings(model, own)
+		if err := tools.WriteDexFile(filepath.Join(dir, name), model); err != nil {
+			return fmt.Errorf("%s: %v", dex.FileName, err)
+		}
+		fmt.Printf("%d strings inlined into %s\n", count, filepath.Join(dir, name))
+	}
+	return nil
+}
+
+// 导出继承关系，根据文件后缀选择格式
+func exportHierarchy(classPath *tools.ClassPath, path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hierarchy := tools.BuildClassHierarchy(classPath)
+	if strings.HasSuffix(path, ".dot") {
+		return tools.ExportHierarchyDOT(hierarchy, file)
+	}
+	return tools.ExportHierarchyJSON(hierarchy, file)
+}
+
+// 导出调用图，根据文件后缀选择格式
+func exportCallGraph(classPath *tools.ClassPath, manifestData *entity.ManifestData, config entity.CmdConfig) error {
+	graph, err := tools.BuildCallGraph(classPath)
+	if err != nil {
+		return err
+	}
+	if config.EntryOnly {
+		graph = tools.ReachableCallGraph(graph, tools.ManifestEntryPoints(classPath, manifestData))
+	}
+	file, err := os.Create(config.CallGraphOut)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	switch {
+	case strings.HasSuffix(config.CallGraphOut, ".dot"):
+		return tools.ExportCallGraphDOT(graph, file)
+	case strings.HasSuffix(config.CallGraphOut, ".graphml"):
+		return tools.ExportCallGraphGraphML(graph, file)
 	}
+	return tools.ExportCallGraphJSON(graph, file)
 }
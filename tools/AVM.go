@@ -1,37 +1,946 @@
 package tools
 
-import "fmt"
+import (
+	"apkgo/entity"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+)
 
+// VM dalvik 字节码解释器
 type VM struct {
-	Registers []int // 模拟寄存器
-	PC        int   // 程序计数器
-	Stack     []int // 方法调用栈
-}
-
-// 解析字节码
-func (vm *VM) ExecuteBytecode(bytecode []uint16) {
-	vRet := 0
-	vInput := 1
-	vInput2 := 2
-	for vm.PC < len(bytecode) {
-		opcode := bytecode[vm.PC]
-		fmt.Printf("PC: %d, Opcode: 0x%x\n", vm.PC, opcode)
-		vm.PC += 1
-		switch opcode {
-		case 0x90:
-			vA := (bytecode[vm.PC] >> 8) & 0x0F // 操作数A
-			vB := bytecode[vm.PC] & 0xFF        // 操作数B
-			vm.Registers[vB] = vInput
-
-			vm.PC += 1
-			vC := bytecode[vm.PC] & 0xFF // 操作数C
-			vm.Registers[vC] = vInput2
-			vm.Registers[vA] = vm.Registers[vB] + vm.Registers[vC]
-			vRet = int(vA)
-		case 0xf:
-			fmt.Printf("return %d\n", vm.Registers[vRet])
+	ClassPath     *ClassPath                // 用于解析方法调用和类型，可以为 nil
+	Statics       map[string]entity.VMValue // 静态字段，按字段签名保存
+	Stubs         map[string]NativeMethod   // 框架方法的 Go 实现，按方法签名保存
+	UnknownPolicy int                       // entity.UNKNOWN_CALL_*
+	UnknownCalls  []string                  // 按 UnknownPolicy 处理过的方法调用
+	Logs          []string                  // android.util.Log 输出的日志
+	Hooks         []VMHook                  // 按顺序在每条指令前后调用
+	Limits        entity.VMLimits           // 资源限制，超出时返回 *LimitError
+	Context       context.Context           // 不为 nil 时取消或超时后停止执行
+	PackageName   string                    // Context.getPackageName 的返回值
+
+	frames     []*VMFrame
+	classState map[string]int              // 类的初始化状态
+	strings    map[string]*entity.VMObject // 字符串常量池
+	classes    map[string]*entity.VMObject // const-class 得到的 Class 对象
+	decoded    map[codeKey]map[uint32]*entity.Instruction
+	debugInfo  map[codeKey]*entity.DexDebugInfo
+	executed   int64 // 本次调用执行的指令数
+	heapBytes  int64 // 本次调用分配的近似字节数
+}
+
+type codeKey struct {
+	dex *entity.DexFile
+	off uint32
+}
+
+// 一次方法调用的栈帧
+type VMFrame struct {
+	dex        *entity.DexFile
+	method     *entity.ClassMethod // 直接执行字节码时为 nil
+	returnType string              // 返回值类型，未知时为空
+	code       entity.MethodCodeItem
+	insns      map[uint32]*entity.Instruction
+	pc         uint32
+	regs       []uint32      // 寄存器中的基本类型值
+	refs       []interface{} // 寄存器中的引用，基本类型时为 nil
+	result     entity.VMValue
+	exception  *entity.VMObject // 正在处理的异常，由 move-exception 读取
+}
+
+// NewVM 创建虚拟机并注册默认的框架桩函数，cp 用于解析方法调用，可以为 nil
+func NewVM(cp *ClassPath) *VM {
+	vm := &VM{
+		ClassPath:  cp,
+		Statics:    make(map[string]entity.VMValue),
+		Stubs:      make(map[string]NativeMethod),
+		Limits:     DefaultVMLimits(),
+		classState: make(map[string]int),
+		strings:    make(map[string]*entity.VMObject),
+		classes:    make(map[string]*entity.VMObject),
+		decoded:    make(map[codeKey]map[uint32]*entity.Instruction),
+		debugInfo:  make(map[codeKey]*entity.DexDebugInfo),
+	}
+	for signature, fn := range DefaultStubs() {
+		vm.Stubs[signature] = fn
+	}
+	return vm
+}
+
+// ExecuteBytecode 执行一段方法代码，args 按参数顺序传入（实例方法第一个为 this），
+// 参数占用的寄存器数必须等于 InsSize
+func (vm *VM) ExecuteBytecode(dex *entity.DexFile, code entity.MethodCodeItem, args []entity.VMValue) (entity.VMValue, error) {
+	if err := vm.enter(); err != nil {
+		return entity.VMValue{}, err
+	}
+	return vm.execute(dex, nil, code, args)
+}
+
+// InvokeMethod 执行类路径中的方法，静态方法会先初始化所在的类。
+// 类初始化和方法本身共用一次调用的资源限制
+func (vm *VM) InvokeMethod(method *entity.ClassMethod, args []entity.VMValue) (entity.VMValue, error) {
+	if err := vm.enter(); err != nil {
+		return entity.VMValue{}, err
+	}
+	return vm.invokeMethod(method, args)
+}
+
+func (vm *VM) invokeMethod(method *entity.ClassMethod, args []entity.VMValue) (entity.VMValue, error) {
+	if method.AccessFlags&entity.ACC_STATIC != 0 {
+		if err := vm.initClass(method.Class); err != nil {
+			return entity.VMValue{}, err
+		}
+	}
+	if method.CodeOff == 0 {
+		return entity.VMValue{}, fmt.Errorf("method %s has no code", GetMethodSignature(&method.Ref))
+	}
+	code, err := ReadCodeItem(method.Class.Dex, method.CodeOff)
+	if err != nil {
+		return entity.VMValue{}, err
+	}
+	return vm.execute(method.Class.Dex, method, code, args)
+}
+
+func (vm *VM) decode(dex *entity.DexFile, code entity.MethodCodeItem) (map[uint32]*entity.Instruction, error) {
+	key := codeKey{dex, code.CodeOff}
+	if insns, ok := vm.decoded[key]; ok && code.CodeOff != 0 {
+		return insns, nil
+	}
+	list, err := DecodeInstructions(code.Insns)
+	if err != nil {
+		return nil, err
+	}
+	insns := make(map[uint32]*entity.Instruction, len(list))
+	for _, insn := range list {
+		insns[insn.Offset] = insn
+	}
+	if code.CodeOff != 0 {
+		vm.decoded[key] = insns
+	}
+	return insns, nil
+}
+
+func (vm *VM) execute(dex *entity.DexFile, method *entity.ClassMethod, code entity.MethodCodeItem, args []entity.VMValue) (entity.VMValue, error) {
+	if err := vm.checkCallDepth(); err != nil {
+		return entity.VMValue{}, err
+	}
+	insns, err := vm.decode(dex, code)
+	if err != nil {
+		return entity.VMValue{}, err
+	}
+	if code.InsSize > code.RegistersSize {
+		return entity.VMValue{}, fmt.Errorf("ins size %d larger than registers size %d", code.InsSize, code.RegistersSize)
+	}
+	frame := &VMFrame{
+		dex:    dex,
+		method: method,
+		code:   code,
+		insns:  insns,
+		regs:   make([]uint32, code.RegistersSize),
+		refs:   make([]interface{}, code.RegistersSize),
+	}
+	if method != nil {
+		frame.returnType = method.Ref.Proto.ReturnType
+	}
+	width := 0
+	for _, arg := range args {
+		width++
+		if arg.Wide() {
+			width++
+		}
+	}
+	if width != int(code.InsSize) {
+		return entity.VMValue{}, fmt.Errorf("%s: arguments use %d registers, expected %d", frame.name(), width, code.InsSize)
+	}
+	reg := uint32(code.RegistersSize - code.InsSize)
+	for _, arg := range args {
+		frame.setValue(reg, arg)
+		reg++
+		if arg.Wide() {
+			reg++
+		}
+	}
+	vm.frames = append(vm.frames, frame)
+	defer func() {
+		vm.frames = vm.frames[:len(vm.frames)-1]
+	}()
+	return vm.run(frame)
+}
+
+func (f *VMFrame) name() string {
+	if f.method == nil {
+		return "<bytecode>"
+	}
+	return GetMethodSignature(&f.method.Ref)
+}
+
+// Name 返回栈帧所属方法的签名，直接执行字节码时为 <bytecode>
+func (f *VMFrame) Name() string {
+	return f.name()
+}
+
+// Method 返回栈帧所属的方法，直接执行字节码时为 nil
+func (f *VMFrame) Method() *entity.ClassMethod {
+	return f.method
+}
+
+// Dex 返回代码所在的 dex
+func (f *VMFrame) Dex() *entity.DexFile {
+	return f.dex
+}
+
+// Pc 返回当前指令的偏移，以 2 字节为单位
+func (f *VMFrame) Pc() uint32 {
+	return f.pc
+}
+
+// Code 返回方法的代码
+func (f *VMFrame) Code() entity.MethodCodeItem {
+	return f.code
+}
+
+// Register 读取寄存器，保存引用时返回引用，否则按 int 返回
+func (f *VMFrame) Register(r uint32) entity.VMValue {
+	if f.refs[r] != nil {
+		return RefValue(f.refs[r])
+	}
+	return IntValue(f.int(r))
+}
+
+// Frames 返回当前的调用栈，最外层在前
+func (vm *VM) Frames() []*VMFrame {
+	return append([]*VMFrame(nil), vm.frames...)
+}
+
+// Depth 返回当前调用栈的深度
+func (vm *VM) Depth() int {
+	return len(vm.frames)
+}
+
+func (f *VMFrame) int(r uint32) int32 {
+	return int32(f.regs[r])
+}
+
+func (f *VMFrame) setInt(r uint32, v int32) {
+	f.regs[r] = uint32(v)
+	f.refs[r] = nil
+}
+
+func (f *VMFrame) long(r uint32) int64 {
+	return int64(uint64(f.regs[r]) | uint64(f.regs[r+1])<<32)
+}
+
+func (f *VMFrame) setLong(r uint32, v int64) {
+	f.regs[r] = uint32(v)
+	f.regs[r+1] = uint32(uint64(v) >> 32)
+	f.refs[r] = nil
+	f.refs[r+1] = nil
+}
+
+func (f *VMFrame) float(r uint32) float32 {
+	return math.Float32frombits(f.regs[r])
+}
+
+func (f *VMFrame) double(r uint32) float64 {
+	return math.Float64frombits(uint64(f.long(r)))
+}
+
+func (f *VMFrame) ref(r uint32) interface{} {
+	return f.refs[r]
+}
+
+func (f *VMFrame) setRef(r uint32, ref interface{}) {
+	f.regs[r] = 0
+	f.refs[r] = normalizeRef(ref)
+}
+
+// 寄存器中的值是否为 0 或 null
+func (f *VMFrame) isZero(r uint32) bool {
+	return f.refs[r] == nil && f.regs[r] == 0
+}
+
+// 按类型读取寄存器
+func (f *VMFrame) value(r uint32, kind byte) entity.VMValue {
+	switch kind {
+	case entity.VM_REF:
+		return RefValue(f.refs[r])
+	case entity.VM_LONG, entity.VM_DOUBLE:
+		return entity.VMValue{Kind: kind, Raw: uint64(f.long(r))}
+	}
+	return entity.VMValue{Kind: kind, Raw: uint64(f.regs[r])}
+}
+
+// 按值的类型写入寄存器，宽类型写入两个寄存器
+func (f *VMFrame) setValue(r uint32, v entity.VMValue) {
+	switch v.Kind {
+	case entity.VM_REF:
+		f.setRef(r, v.Ref)
+	case entity.VM_LONG, entity.VM_DOUBLE:
+		f.setLong(r, int64(v.Raw))
+	default:
+		f.setInt(r, int32(v.Raw))
+	}
+}
+
+func (vm *VM) run(f *VMFrame) (ret entity.VMValue, err error) {
+	// 寄存器越界等错误转换为 error
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s pc %d: %v", f.name(), f.pc, r)
+		}
+	}()
+	for {
+		insn := f.insns[f.pc]
+		if insn == nil || insn.Payload != nil {
+			return entity.VMValue{}, fmt.Errorf("%s: invalid pc %d", f.name(), f.pc)
+		}
+		if err := vm.countInstruction(); err != nil {
+			return entity.VMValue{}, vm.limitError(err)
+		}
+		for _, hook := range vm.Hooks {
+			if err := hook.BeforeInstruction(vm, f, insn); err != nil {
+				return entity.VMValue{}, err
+			}
+		}
+		done, err := vm.step(f, insn)
+		if exc, ok := err.(*JavaException); ok {
+			t := exc.Throwable()
+			if t.Trace == nil {
+				t.Trace = vm.stackTrace()
+			}
+			handler, found, err := vm.findHandler(f, insn.Offset, exc.Object)
+			if err != nil {
+				return entity.VMValue{}, fmt.Errorf("%s pc %d: %w", f.name(), insn.Offset, err)
+			}
+			if !found {
+				return entity.VMValue{}, exc
+			}
+			f.exception = exc.Object
+			f.pc = handler
+			continue
+		}
+		if limit, ok := err.(*LimitError); ok {
+			return entity.VMValue{}, vm.limitError(limit)
+		}
+		if err != nil {
+			return entity.VMValue{}, fmt.Errorf("%s pc %d: %w", f.name(), insn.Offset, err)
+		}
+		for _, hook := range vm.Hooks {
+			if err := hook.AfterInstruction(vm, f, insn); err != nil {
+				return entity.VMValue{}, err
+			}
+		}
+		if done {
+			return f.result, nil
+		}
+	}
+}
+
+// 执行一条指令，返回方法是否已经返回，返回值保存在 f.result
+func (vm *VM) step(f *VMFrame, insn *entity.Instruction) (bool, error) {
+	op := insn.Opcode
+	regs := insn.Regs
+	next := insn.Offset + insn.Size
+	jump := func(offset int32) {
+		next = uint32(int64(insn.Offset) + int64(offset))
+	}
+	switch {
+	case op == 0x00: // nop
+	case op >= 0x01 && op <= 0x03: // move
+		f.setInt(regs[0], f.int(regs[1]))
+	case op >= 0x04 && op <= 0x06: // move-wide
+		f.setLong(regs[0], f.long(regs[1]))
+	case op >= 0x07 && op <= 0x09: // move-object
+		f.setRef(regs[0], f.ref(regs[1]))
+	case op >= 0x0a && op <= 0x0c: // move-result
+		f.setValue(regs[0], f.result)
+	case op == 0x0d: // move-exception
+		f.setRef(regs[0], f.exception)
+	case op == 0x0e: // return-void
+		f.result = entity.VMValue{}
+		return true, nil
+	case op == 0x0f:
+		kind := byte(entity.VM_INT)
+		if f.returnType == "F" {
+			kind = entity.VM_FLOAT
+		}
+		f.result = f.value(regs[0], kind)
+		return true, nil
+	case op == 0x10:
+		kind := byte(entity.VM_LONG)
+		if f.returnType == "D" {
+			kind = entity.VM_DOUBLE
+		}
+		f.result = f.value(regs[0], kind)
+		return true, nil
+	case op == 0x11:
+		f.result = RefValue(f.ref(regs[0]))
+		return true, nil
+	case op >= 0x12 && op <= 0x15: // const
+		f.setInt(regs[0], int32(insn.Literal))
+	case op >= 0x16 && op <= 0x19: // const-wide
+		f.setLong(regs[0], insn.Literal)
+	case op == 0x1a || op == 0x1b:
+		str, err := GetStringById(f.dex, insn.Index)
+		if err != nil {
+			return false, err
+		}
+		f.setRef(regs[0], vm.internString(str))
+	case op == 0x1c:
+		typ, err := GetTypeName(f.dex, insn.Index)
+		if err != nil {
+			return false, err
+		}
+		f.setRef(regs[0], vm.classObject(typ))
+	case op == 0x1d || op == 0x1e: // monitor-enter/exit
+		if f.ref(regs[0]) == nil {
+			return false, javaException("java.lang.NullPointerException", "monitor on null")
+		}
+	case op == 0x1f: // check-cast
+		typ, err := GetTypeName(f.dex, insn.Index)
+		if err != nil {
+			return false, err
+		}
+		if ref := f.ref(regs[0]); ref != nil && !vm.isInstance(ref, typ) {
+			return false, javaException("java.lang.ClassCastException", "%s cannot be cast to %s", convertToClassName(runtimeType(ref)), convertToClassName(typ))
+		}
+	case op == 0x20: // instance-of
+		typ, err := GetTypeName(f.dex, insn.Index)
+		if err != nil {
+			return false, err
+		}
+		f.setInt(regs[0], BoolValue(vm.isInstance(f.ref(regs[1]), typ)).Int())
+	case op == 0x21: // array-length
+		arr, err := f.array(regs[1])
+		if err != nil {
+			return false, err
+		}
+		f.setInt(regs[0], int32(len(arr.Data)))
+	case op == 0x22: // new-instance
+		typ, err := GetTypeName(f.dex, insn.Index)
+		if err != nil {
+			return false, err
+		}
+		obj, err := vm.newObject(typ)
+		if err != nil {
+			return false, err
+		}
+		if err := vm.allocate(objectHeaderSize); err != nil {
+			return false, err
+		}
+		f.setRef(regs[0], obj)
+	case op == 0x23: // new-array
+		typ, err := GetTypeName(f.dex, insn.Index)
+		if err != nil {
+			return false, err
+		}
+		arr, err := vm.newArray(typ, f.int(regs[1]))
+		if err != nil {
+			return false, err
+		}
+		f.setRef(regs[0], arr)
+	case op == 0x24 || op == 0x25: // filled-new-array
+		typ, err := GetTypeName(f.dex, insn.Index)
+		if err != nil {
+			return false, err
+		}
+		arr, err := vm.newArray(typ, int32(len(regs)))
+		if err != nil {
+			return false, err
+		}
+		kind := kindOfType(typ[1:])
+		if kind == entity.VM_LONG || kind == entity.VM_DOUBLE {
+			return false, fmt.Errorf("filled-new-array of %s is not allowed", typ)
+		}
+		for i, reg := range regs {
+			arr.Data[i] = f.value(reg, kind)
+		}
+		f.result = RefValue(arr)
+	case op == 0x26: // fill-array-data
+		arr, err := f.array(regs[0])
+		if err != nil {
+			return false, err
+		}
+		payload := f.insns[uint32(int64(insn.Offset)+int64(insn.Branch))]
+		if payload == nil || payload.Payload == nil || payload.Payload.Ident != entity.FillArrayDataPayload {
+			return false, errors.New("invalid array data payload")
+		}
+		if err := fillArray(arr, payload.Payload); err != nil {
+			return false, err
+		}
+	case op == 0x27: // throw
+		switch ref := f.ref(regs[0]).(type) {
+		case nil:
+			return false, javaException("java.lang.NullPointerException", "throw with null exception")
+		case *entity.VMObject:
+			return false, &JavaException{Object: ref}
+		default:
+			return false, fmt.Errorf("cannot throw %s", runtimeType(ref))
+		}
+	case op >= 0x28 && op <= 0x2a: // goto
+		jump(insn.Branch)
+	case op == 0x2b || op == 0x2c: // switch
+		payload := f.insns[uint32(int64(insn.Offset)+int64(insn.Branch))]
+		if payload == nil || payload.Payload == nil {
+			return false, errors.New("invalid switch payload")
+		}
+		if target, ok := switchTarget(payload.Payload, f.int(regs[0])); ok {
+			jump(target)
+		}
+	case op >= 0x2d && op <= 0x31: // cmp
+		var result int32
+		nanResult := int32(1) // cmpg
+		if op == 0x2d || op == 0x2f {
+			nanResult = -1 // cmpl
+		}
+		switch op {
+		case 0x2d, 0x2e:
+			result = compareFloat(float64(f.float(regs[1])), float64(f.float(regs[2])), nanResult)
+		case 0x2f, 0x30:
+			result = compareFloat(f.double(regs[1]), f.double(regs[2]), nanResult)
 		default:
-			fmt.Printf("not support 0x%x\n", opcode)
+			a, b := f.long(regs[1]), f.long(regs[2])
+			if a < b {
+				result = -1
+			} else if a > b {
+				result = 1
+			}
+		}
+		f.setInt(regs[0], result)
+	case op >= 0x32 && op <= 0x37: // if-test
+		var cmp int
+		if f.refs[regs[0]] != nil || f.refs[regs[1]] != nil {
+			// 引用只能比较是否相等
+			if f.refs[regs[0]] != f.refs[regs[1]] {
+				cmp = 1
+			}
+		} else {
+			a, b := f.int(regs[0]), f.int(regs[1])
+			if a < b {
+				cmp = -1
+			} else if a > b {
+				cmp = 1
+			}
+		}
+		if testCompare(op-0x32, cmp) {
+			jump(insn.Branch)
+		}
+	case op >= 0x38 && op <= 0x3d: // if-testz
+		cmp := 0
+		if !f.isZero(regs[0]) {
+			cmp = 1
+			if f.refs[regs[0]] == nil && f.int(regs[0]) < 0 {
+				cmp = -1
+			}
+		}
+		if testCompare(op-0x38, cmp) {
+			jump(insn.Branch)
+		}
+	case op >= 0x44 && op <= 0x4a: // aget
+		arr, err := f.array(regs[1])
+		if err != nil {
+			return false, err
+		}
+		index := f.int(regs[2])
+		if index < 0 || int(index) >= len(arr.Data) {
+			return false, javaException("java.lang.ArrayIndexOutOfBoundsException", "length=%d; index=%d", len(arr.Data), index)
+		}
+		f.setValue(regs[0], arr.Data[index])
+	case op >= 0x4b && op <= 0x51: // aput
+		arr, err := f.array(regs[1])
+		if err != nil {
+			return false, err
+		}
+		index := f.int(regs[2])
+		if index < 0 || int(index) >= len(arr.Data) {
+			return false, javaException("java.lang.ArrayIndexOutOfBoundsException", "length=%d; index=%d", len(arr.Data), index)
+		}
+		elem := arr.Type[1:]
+		value := f.value(regs[0], kindOfType(elem))
+		if value.Kind == entity.VM_REF && value.Ref != nil && !vm.isInstance(value.Ref, elem) {
+			return false, javaException("java.lang.ArrayStoreException", "%s cannot be stored in an array of type %s", convertToClassName(runtimeType(value.Ref)), arr.Type)
+		}
+		arr.Data[index] = truncateValue(elem, value)
+	case op >= 0x52 && op <= 0x6d: // iget/iput/sget/sput
+		if err := vm.accessField(f, insn); err != nil {
+			return false, err
+		}
+	case (op >= 0x6e && op <= 0x72) || (op >= 0x74 && op <= 0x78): // invoke
+		result, err := vm.invoke(f, insn)
+		if err != nil {
+			return false, err
+		}
+		f.result = result
+	case op >= 0x7b && op <= 0x8f:
+		unaryOp(f, op, regs[0], regs[1])
+	case op >= 0x90 && op <= 0xaf:
+		if err := binaryOp(f, int(op-0x90), regs[0], regs[1], regs[2]); err != nil {
+			return false, err
+		}
+	case op >= 0xb0 && op <= 0xcf:
+		if err := binaryOp(f, int(op-0xb0), regs[0], regs[0], regs[1]); err != nil {
+			return false, err
+		}
+	case op >= 0xd0 && op <= 0xe2: // binop/lit16, binop/lit8
+		index := int(op - 0xd0)
+		if op >= 0xd8 {
+			index = int(op - 0xd8)
+		}
+		a, b := f.int(regs[1]), int32(insn.Literal)
+		if index == 1 { // rsub-int
+			a, b = b, a
+		}
+		result, ok := intBinop(index, a, b)
+		if !ok {
+			return false, javaException("java.lang.ArithmeticException", "divide by zero")
+		}
+		f.setInt(regs[0], result)
+	default:
+		return false, fmt.Errorf("unsupported instruction %s", OpcodeName(insn))
+	}
+	f.pc = next
+	return false, nil
+}
+
+// if 指令的比较，cond 为 eq、ne、lt、ge、gt、le 的序号，cmp 为比较结果的符号
+func testCompare(cond uint8, cmp int) bool {
+	switch cond {
+	case 0:
+		return cmp == 0
+	case 1:
+		return cmp != 0
+	case 2:
+		return cmp < 0
+	case 3:
+		return cmp >= 0
+	case 4:
+		return cmp > 0
+	}
+	return cmp <= 0
+}
+
+func switchTarget(payload *entity.InsnPayload, value int32) (int32, bool) {
+	if payload.Ident == entity.PackedSwitchPayload {
+		index := int64(value) - int64(payload.FirstKey)
+		if index >= 0 && index < int64(len(payload.Targets)) {
+			return payload.Targets[index], true
+		}
+		return 0, false
+	}
+	for i, key := range payload.Keys {
+		if key == value {
+			return payload.Targets[i], true
+		}
+	}
+	return 0, false
+}
+
+func (f *VMFrame) array(r uint32) (*entity.VMArray, error) {
+	switch ref := f.ref(r).(type) {
+	case *entity.VMArray:
+		return ref, nil
+	case nil:
+		return nil, javaException("java.lang.NullPointerException", "null array")
+	default:
+		return nil, fmt.Errorf("%s is not an array", runtimeType(ref))
+	}
+}
+
+// 用 fill-array-data 的数据填充数组
+func fillArray(arr *entity.VMArray, payload *entity.InsnPayload) error {
+	width := int(payload.ElementWidth)
+	if width == 0 {
+		return errors.New("invalid element width")
+	}
+	count := len(payload.Data) / width
+	if count > len(arr.Data) {
+		return javaException("java.lang.ArrayIndexOutOfBoundsException", "array data of %d elements does not fit length %d", count, len(arr.Data))
+	}
+	elem := arr.Type[1:]
+	for i := 0; i < count; i++ {
+		var raw uint64
+		for j := width - 1; j >= 0; j-- {
+			raw = raw<<8 | uint64(payload.Data[i*width+j])
+		}
+		switch width {
+		case 1:
+			raw = uint64(int64(int8(raw)))
+		case 2:
+			raw = uint64(int64(int16(raw)))
+		}
+		arr.Data[i] = truncateValue(elem, entity.VMValue{Raw: raw})
+	}
+	return nil
+}
+
+func unaryOp(f *VMFrame, op uint8, dst uint32, src uint32) {
+	switch op {
+	case 0x7b:
+		f.setInt(dst, -f.int(src))
+	case 0x7c:
+		f.setInt(dst, ^f.int(src))
+	case 0x7d:
+		f.setLong(dst, -f.long(src))
+	case 0x7e:
+		f.setLong(dst, ^f.long(src))
+	case 0x7f:
+		f.setInt(dst, int32(math.Float32bits(-f.float(src))))
+	case 0x80:
+		f.setLong(dst, int64(math.Float64bits(-f.double(src))))
+	case 0x81:
+		f.setLong(dst, int64(f.int(src)))
+	case 0x82:
+		f.setInt(dst, int32(math.Float32bits(float32(f.int(src)))))
+	case 0x83:
+		f.setLong(dst, int64(math.Float64bits(float64(f.int(src)))))
+	case 0x84:
+		f.setInt(dst, int32(f.long(src)))
+	case 0x85:
+		f.setInt(dst, int32(math.Float32bits(float32(f.long(src)))))
+	case 0x86:
+		f.setLong(dst, int64(math.Float64bits(float64(f.long(src)))))
+	case 0x87:
+		f.setInt(dst, f2i(float64(f.float(src))))
+	case 0x88:
+		f.setLong(dst, f2l(float64(f.float(src))))
+	case 0x89:
+		f.setLong(dst, int64(math.Float64bits(float64(f.float(src)))))
+	case 0x8a:
+		f.setInt(dst, f2i(f.double(src)))
+	case 0x8b:
+		f.setLong(dst, f2l(f.double(src)))
+	case 0x8c:
+		f.setInt(dst, int32(math.Float32bits(float32(f.double(src)))))
+	case 0x8d:
+		f.setInt(dst, int32(int8(f.int(src))))
+	case 0x8e:
+		f.setInt(dst, int32(uint16(f.int(src))))
+	case 0x8f:
+		f.setInt(dst, int32(int16(f.int(src))))
+	}
+}
+
+// 二元运算，index 为相对 add-int 的序号
+func binaryOp(f *VMFrame, index int, dst uint32, a uint32, b uint32) error {
+	switch {
+	case index < 11:
+		result, ok := intBinop(index, f.int(a), f.int(b))
+		if !ok {
+			return javaException("java.lang.ArithmeticException", "divide by zero")
+		}
+		f.setInt(dst, result)
+	case index < 22:
+		var rhs int64
+		if index >= 19 { // 移位的位数为 int
+			rhs = int64(f.int(b))
+		} else {
+			rhs = f.long(b)
+		}
+		result, ok := longBinop(index-11, f.long(a), rhs)
+		if !ok {
+			return javaException("java.lang.ArithmeticException", "divide by zero")
+		}
+		f.setLong(dst, result)
+	case index < 27:
+		result := float32(floatBinop(index-22, float64(f.float(a)), float64(f.float(b))))
+		f.setInt(dst, int32(math.Float32bits(result)))
+	default:
+		result := floatBinop(index-27, f.double(a), f.double(b))
+		f.setLong(dst, int64(math.Float64bits(result)))
+	}
+	return nil
+}
+
+func (vm *VM) internString(s string) *entity.VMObject {
+	obj, ok := vm.strings[s]
+	if !ok {
+		obj = NewStringObject(s)
+		vm.strings[s] = obj
+	}
+	return obj
+}
+
+// 常量池中的字符串和 Class 对象在所有使用处共享
+func (vm *VM) sharedObject(ref interface{}) bool {
+	obj, ok := ref.(*entity.VMObject)
+	if !ok || obj == nil {
+		return false
+	}
+	switch native := obj.Native.(type) {
+	case string:
+		return vm.strings[native] == obj || vm.classes[native] == obj
+	}
+	return false
+}
+
+func (vm *VM) classObject(typ string) *entity.VMObject {
+	obj, ok := vm.classes[typ]
+	if !ok {
+		obj = &entity.VMObject{Class: "Ljava/lang/Class;", Native: typ}
+		vm.classes[typ] = obj
+	}
+	return obj
+}
+
+// 字段读写，静态字段保存在 vm.Statics，实例字段保存在对象中，
+// 都按字段声明位置的签名保存
+func (vm *VM) accessField(f *VMFrame, insn *entity.Instruction) error {
+	ref, err := GetFieldRef(f.dex, insn.Index)
+	if err != nil {
+		return err
+	}
+	ref, class := vm.resolveField(ref)
+	key := GetFieldSignature(ref)
+	op := insn.Opcode
+	if op >= 0x60 && class != nil {
+		if err := vm.initClass(class); err != nil {
+			return err
+		}
+	}
+	kind := kindOfType(ref.Type)
+	switch {
+	case op >= 0x52 && op <= 0x58: // iget
+		obj, err := f.object(insn.Regs[1])
+		if err != nil {
+			return err
+		}
+		value, ok := obj.Fields[key]
+		if !ok {
+			value = zeroValue(ref.Type)
+		}
+		f.setValue(insn.Regs[0], value)
+	case op >= 0x59 && op <= 0x5f: // iput
+		obj, err := f.object(insn.Regs[1])
+		if err != nil {
+			return err
+		}
+		if obj.Fields == nil {
+			obj.Fields = make(map[string]entity.VMValue)
+		}
+		if _, ok := obj.Fields[key]; !ok {
+			if err := vm.allocate(8); err != nil {
+				return err
+			}
+		}
+		obj.Fields[key] = truncateValue(ref.Type, f.value(insn.Regs[0], kind))
+	case op >= 0x60 && op <= 0x66: // sget
+		value, ok := vm.Statics[key]
+		if !ok {
+			value = zeroValue(ref.Type)
+		}
+		f.setValue(insn.Regs[0], value)
+	default: // sput
+		vm.Statics[key] = truncateValue(ref.Type, f.value(insn.Regs[0], kind))
+	}
+	return nil
+}
+
+func (f *VMFrame) object(r uint32) (*entity.VMObject, error) {
+	switch ref := f.ref(r).(type) {
+	case *entity.VMObject:
+		return ref, nil
+	case nil:
+		return nil, javaException("java.lang.NullPointerException", "null object")
+	default:
+		return nil, fmt.Errorf("%s is not an object", runtimeType(ref))
+	}
+}
+
+// 按方法原型从寄存器中取出参数，实例方法第一个参数为 this
+func (f *VMFrame) collectArgs(regs []uint32, proto entity.DexProtoRef, static bool) ([]entity.VMValue, error) {
+	var args []entity.VMValue
+	i := 0
+	if !static {
+		if len(regs) == 0 {
+			return nil, errors.New("missing this argument")
+		}
+		args = append(args, RefValue(f.ref(regs[0])))
+		i++
+	}
+	for _, param := range proto.Params {
+		if i >= len(regs) {
+			return nil, errors.New("too few argument registers")
+		}
+		kind := kindOfType(param)
+		args = append(args, f.value(regs[i], kind))
+		i++
+		if kind == entity.VM_LONG || kind == entity.VM_DOUBLE {
+			i++
+		}
+	}
+	if i != len(regs) {
+		return nil, errors.New("argument registers do not match the prototype")
+	}
+	return args, nil
+}
+
+// 执行 invoke 指令
+func (vm *VM) invoke(f *VMFrame, insn *entity.Instruction) (entity.VMValue, error) {
+	ref, err := GetMethodRef(f.dex, insn.Index)
+	if err != nil {
+		return entity.VMValue{}, err
+	}
+	kind := insn.Opcode
+	if kind >= 0x74 {
+		kind -= 0x74 - 0x6e
+	}
+	args, err := f.collectArgs(insn.Regs, ref.Proto, kind == 0x71)
+	if err != nil {
+		return entity.VMValue{}, err
+	}
+	return vm.callMethod(kind, ref, args)
+}
+
+// 按调用类型解析并执行方法，kind 为不带 /range 的 invoke 操作码。
+// 先查找类路径中有代码的方法，再查找桩函数，都没有时按 UnknownPolicy 处理
+func (vm *VM) callMethod(kind uint8, ref *entity.DexMethodRef, args []entity.VMValue) (entity.VMValue, error) {
+	class := ref.Class
+	var target *entity.ClassMethod
+	var err error
+	// invoke-virtual、invoke-super、invoke-direct、invoke-interface 的第一个参数为接收者
+	if kind >= 0x6e && kind <= 0x72 && kind != 0x71 && args[0].Ref == nil {
+		return entity.VMValue{}, javaException("java.lang.NullPointerException", "null receiver for %s", GetMethodSignature(ref))
+	}
+	if kind == 0x6e || kind == 0x72 { // invoke-virtual, invoke-interface
+		class = runtimeType(args[0].Ref)
+		if vm.ClassPath != nil {
+			target, err = vm.ClassPath.ResolveVirtual(class, ref)
+			if err != nil {
+				target, err = vm.ClassPath.ResolveMethod(ref)
+			}
+		}
+	} else if vm.ClassPath != nil {
+		target, err = vm.ClassPath.ResolveMethod(ref)
+	}
+	if err == nil && target != nil && target.CodeOff != 0 {
+		return vm.invokeMethod(target, args)
+	}
+	var ret entity.VMValue
+	var callErr error
+	if fn := vm.findStub(class, ref); fn != nil {
+		ret, callErr = fn(vm, args)
+		// 桩函数新建的对象计入堆大小
+		if callErr == nil && ret.Kind == entity.VM_REF && ret.Ref != nil && !containsRef(args, ret.Ref) {
+			callErr = vm.allocate(refSize(ret.Ref))
+		}
+	} else {
+		ret, callErr = vm.unknownCall(ref, args)
+	}
+	for _, hook := range vm.Hooks {
+		if h, ok := hook.(VMCallHook); ok {
+			h.AfterFrameworkCall(vm, ref, args, ret, callErr)
+		}
+	}
+	return ret, callErr
+}
+
+func containsRef(values []entity.VMValue, ref interface{}) bool {
+	for _, v := range values {
+		if v.Kind == entity.VM_REF && v.Ref == ref {
+			return true
 		}
 	}
+	return false
 }
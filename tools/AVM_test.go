@@ -0,0 +1,58 @@
+package tools
+
+import "testing"
+
+// 接收者为 null 时 invoke-direct 和 invoke-super 抛出 NullPointerException，不执行方法
+const testNullReceiverSmali = `.class public LNullTest;
+.super Ljava/lang/Object;
+
+.method private value()I
+    .registers 2
+    const/4 v0, 0x1
+    return v0
+.end method
+
+.method public static direct()I
+    .registers 2
+    const/4 v0, 0x0
+    :try_start_0
+    invoke-direct {v0}, LNullTest;->value()I
+    move-result v1
+    :try_end_0
+    .catch Ljava/lang/NullPointerException; {:try_start_0 .. :try_end_0} :catch_0
+    return v1
+
+    :catch_0
+    const/4 v1, -0x1
+    return v1
+.end method
+
+.method public static superCall()I
+    .registers 2
+    const/4 v0, 0x0
+    :try_start_0
+    invoke-super {v0}, Ljava/lang/Object;->hashCode()I
+    move-result v1
+    :try_end_0
+    .catch Ljava/lang/NullPointerException; {:try_start_0 .. :try_end_0} :catch_0
+    return v1
+
+    :catch_0
+    const/4 v1, -0x1
+    return v1
+.end method
+`
+
+func TestNullReceiver(t *testing.T) {
+	vm, cp, _ := assembleTestSmali(t, testNullReceiverSmali)
+	for _, name := range []string{"direct", "superCall"} {
+		method, err := cp.FindMethod(cp.FindClass("LNullTest;"), name, "()I")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret, err := vm.InvokeMethod(method, nil)
+		if err != nil || ret.Int() != -1 {
+			t.Errorf("%s() = %d, %v, want NullPointerException", name, ret.Int(), err)
+		}
+	}
+}
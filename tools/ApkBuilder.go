@@ -0,0 +1,198 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// aapt 默认不压缩的扩展名，这些文件本身已经压缩过
+var apkNoCompressExts = []string{
+	".jpg", ".jpeg", ".png", ".gif", ".webp", ".wav", ".mp2", ".mp3", ".ogg", ".aac",
+	".mpg", ".mpeg", ".mid", ".midi", ".smf", ".jet", ".rtttl", ".imy", ".xmf", ".mp4",
+	".m4a", ".m4v", ".3gp", ".3gpp", ".3g2", ".3gpp2", ".amr", ".awb", ".wma", ".wmv",
+	".webm", ".mkv",
+}
+
+// 必须不压缩的文件：Android 11 起 resources.arsc 要直接映射，
+// extractNativeLibs 为 false 时 .so 直接从 APK 中加载
+func apkMustStore(name string) bool {
+	return name == "resources.arsc" || strings.HasSuffix(name, ".so")
+}
+
+// ApkEntryMethod 新文件的压缩方式：resources.arsc、.so 和已经压缩过的媒体文件不压缩，其余 deflate
+func ApkEntryMethod(name string) uint16 {
+	if apkMustStore(name) {
+		return zipMethodStore
+	}
+	lower := strings.ToLower(name)
+	for _, ext := range apkNoCompressExts {
+		if strings.HasSuffix(lower, ext) {
+			return zipMethodStore
+		}
+	}
+	return zipMethodDeflate
+}
+
+// 写入原压缩包中的文件 e，data 为 nil 时使用原文件的内容。
+// 压缩方式与原文件相同时直接复制压缩后的数据
+func writeApkEntry(zw *ZipWriter, z *entity.ZipArchive, e *entity.ZipEntry, data []byte) error {
+	method := e.Method
+	if apkMustStore(e.Name) {
+		method = zipMethodStore
+	} else if method != zipMethodStore && method != zipMethodDeflate {
+		// 未知的压缩方式按不压缩读取，写入时重新选择
+		method = ApkEntryMethod(e.Name)
+	}
+	if data == nil {
+		if method == e.Method {
+			return zw.CopyEntry(z, e)
+		}
+		var err error
+		if data, err = ReadZipEntry(z, e); err != nil {
+			return fmt.Errorf("%s: %v", e.Name, err)
+		}
+	}
+	return zw.ReplaceEntry(e, data, method)
+}
+
+// RepackApk 重新打包内存中的 APK。files 中的文件替换同名的文件，值为 nil 时删除该文件，
+// 原来没有的文件按文件名顺序加在最后。其余文件按原来的顺序和压缩方式写入，
+// resources.arsc 和 .so 不压缩，不压缩的文件按 ZipAlignment 对齐。
+// 原有的签名区块和 v1 签名文件不会保留，安装前需要重新签名
+func RepackApk(apk *entity.Apk, files map[string][]byte, w io.Writer) error {
+	zw := NewZipWriter(w)
+	written := make(map[string]bool)
+	for _, e := range apk.Zip.Entries {
+		if written[e.Name] {
+			continue
+		}
+		written[e.Name] = true
+		data, ok := files[e.Name]
+		if (ok && data == nil) || (!ok && isJarSignatureFile(e.Name)) {
+			continue
+		}
+		if err := writeApkEntry(zw, apk.Zip, e, data); err != nil {
+			return err
+		}
+	}
+	var added []string
+	for name, data := range files {
+		if !written[name] && data != nil {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		if err := zw.AddEntry(name, files[name], ApkEntryMethod(name)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// BuildApk 把解压的目录重新打包为 APK。original 为解压前的压缩包，可以为 nil，
+// 不为 nil 时按原来的顺序和压缩方式写入，内容没有变化的文件直接复制压缩后的数据，
+// 目录中已删除的文件不再写入，新增的文件按路径顺序加在最后。
+// META-INF 下的 v1 签名文件不写入，内容改变后原来的签名已经无效，安装前需要重新签名
+func BuildApk(dir string, original *entity.ZipArchive, w io.Writer) error {
+	files := make(map[string]string)
+	var names []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if isJarSignatureFile(name) {
+			return nil
+		}
+		files[name] = path
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	zw := NewZipWriter(w)
+	written := make(map[string]bool)
+	if original != nil {
+		for _, e := range original.Entries {
+			if written[e.Name] {
+				continue
+			}
+			if strings.HasSuffix(e.Name, "/") {
+				// 目录仍然存在时保留目录项
+				if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(e.Name))); err == nil && info.IsDir() {
+					written[e.Name] = true
+					if err := zw.CopyEntry(original, e); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			path, ok := files[e.Name]
+			if !ok {
+				continue
+			}
+			written[e.Name] = true
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if uint64(len(data)) == e.UncompressedSize && crc32.ChecksumIEEE(data) == e.CRC32 {
+				data = nil
+			}
+			if err := writeApkEntry(zw, original, e, data); err != nil {
+				return err
+			}
+		}
+	}
+	for _, name := range names {
+		if written[name] {
+			continue
+		}
+		data, err := os.ReadFile(files[name])
+		if err != nil {
+			return err
+		}
+		if err := zw.AddEntry(name, data, ApkEntryMethod(name)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// BuildApkFile 把解压的目录重新打包为 out，original 为解压前的 APK，为空时按默认规则压缩
+func BuildApkFile(dir string, original string, out string) error {
+	var z *entity.ZipArchive
+	if original != "" {
+		f, err := os.Open(original)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if z, err = OpenZip(f); err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	if err := BuildApk(dir, z, &buf); err != nil {
+		return err
+	}
+	return os.WriteFile(out, buf.Bytes(), 0644)
+}
@@ -0,0 +1,79 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// 重新打包已签名的 APK 时不保留原来的 v1 签名文件
+func TestBuildApkDropsSignatureFiles(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := SignApk(testApk(t), testSigningKey(t, key), entity.SignOptions{V1: true, V2: true, MinSdk: 24})
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := ReadZip(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	extracted := map[string]bool{"META-INF/services/test": true}
+	for _, e := range original.Entries {
+		data, err := ReadZipEntry(original, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, filepath.FromSlash(e.Name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		extracted[e.Name] = true
+	}
+	// META-INF 下的其他文件仍然写入
+	services := filepath.Join(dir, "META-INF", "services")
+	if err := os.MkdirAll(services, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(services, "test"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := BuildApk(dir, original, &buf); err != nil {
+		t.Fatalf("BuildApk: %v", err)
+	}
+	z, err := ReadZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(map[string]bool)
+	for _, e := range z.Entries {
+		written[e.Name] = true
+	}
+	signatureFiles := 0
+	for name := range extracted {
+		if isJarSignatureFile(name) {
+			signatureFiles++
+			if written[name] {
+				t.Errorf("%s copied from the signed APK", name)
+			}
+		} else if !written[name] {
+			t.Errorf("%s missing", name)
+		}
+	}
+	if signatureFiles != 3 {
+		t.Errorf("signed APK has %d signature files, want 3", signatureFiles)
+	}
+}
@@ -0,0 +1,719 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	_ "crypto/md5"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+const apkSigBlockMagic = "APK Sig Block 42"
+
+// 内容摘要的种类
+const (
+	digestChunkedSha256 = 1
+	digestChunkedSha512 = 2
+	digestVeritySha256  = 3
+)
+
+// v2/v3 签名算法的参数
+type sigAlgorithm struct {
+	name   string
+	hash   crypto.Hash
+	pss    bool
+	digest int // 内容摘要的种类
+}
+
+var sigAlgorithms = map[uint32]sigAlgorithm{
+	entity.SIG_RSA_PSS_SHA256:          {"RSA-PSS-SHA256", crypto.SHA256, true, digestChunkedSha256},
+	entity.SIG_RSA_PSS_SHA512:          {"RSA-PSS-SHA512", crypto.SHA512, true, digestChunkedSha512},
+	entity.SIG_RSA_PKCS1_SHA256:        {"RSA-PKCS1-SHA256", crypto.SHA256, false, digestChunkedSha256},
+	entity.SIG_RSA_PKCS1_SHA512:        {"RSA-PKCS1-SHA512", crypto.SHA512, false, digestChunkedSha512},
+	entity.SIG_ECDSA_SHA256:            {"ECDSA-SHA256", crypto.SHA256, false, digestChunkedSha256},
+	entity.SIG_ECDSA_SHA512:            {"ECDSA-SHA512", crypto.SHA512, false, digestChunkedSha512},
+	entity.SIG_DSA_SHA256:              {"DSA-SHA256", crypto.SHA256, false, digestChunkedSha256},
+	entity.SIG_VERITY_RSA_PKCS1_SHA256: {"VERITY-RSA-PKCS1-SHA256", crypto.SHA256, false, digestVeritySha256},
+	entity.SIG_VERITY_ECDSA_SHA256:     {"VERITY-ECDSA-SHA256", crypto.SHA256, false, digestVeritySha256},
+	entity.SIG_VERITY_DSA_SHA256:       {"VERITY-DSA-SHA256", crypto.SHA256, false, digestVeritySha256},
+}
+
+// SignatureAlgorithmName 返回 v2/v3 签名算法的名字
+func SignatureAlgorithmName(id uint32) string {
+	if alg, ok := sigAlgorithms[id]; ok {
+		return alg.name
+	}
+	return fmt.Sprintf("0x%04x", id)
+}
+
+var errSignature = errors.New("signature does not verify")
+
+// 验证 data 的签名，pss 只用于 RSA
+func verifySigned(pub crypto.PublicKey, hash crypto.Hash, pss bool, data []byte, sig []byte) error {
+	h := hash.New()
+	h.Write(data)
+	return verifyHashed(pub, hash, pss, h.Sum(nil), sig)
+}
+
+// 验证摘要的签名
+func verifyHashed(pub crypto.PublicKey, hash crypto.Hash, pss bool, hashed []byte, sig []byte) error {
+	switch key := pub.(type) {
+	case *rsa.PublicKey:
+		if pss {
+			return rsa.VerifyPSS(key, hash, hashed, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(key, hash, hashed, sig)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed, sig) {
+			return errSignature
+		}
+	case *dsa.PublicKey:
+		var rs struct{ R, S *big.Int }
+		if rest, err := asn1.Unmarshal(sig, &rs); err != nil || len(rest) > 0 {
+			return errSignature
+		}
+		if n := key.Q.BitLen() / 8; len(hashed) > n {
+			hashed = hashed[:n]
+		}
+		if !dsa.Verify(key, hashed, rs.R, rs.S) {
+			return errSignature
+		}
+	default:
+		return fmt.Errorf("unsupported public key %T", pub)
+	}
+	return nil
+}
+
+// 按长度前缀读取签名区块中的数据
+type sigBuffer struct {
+	b   []byte
+	err error
+}
+
+var errSigTruncated = errors.New("truncated")
+
+func (s *sigBuffer) uint32() uint32 {
+	if s.err != nil || len(s.b) < 4 {
+		s.err = errSigTruncated
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(s.b)
+	s.b = s.b[4:]
+	return v
+}
+
+// 读取 uint32 长度前缀的数据
+func (s *sigBuffer) bytes() []byte {
+	n := s.uint32()
+	if s.err != nil || uint64(n) > uint64(len(s.b)) {
+		s.err = errSigTruncated
+		return nil
+	}
+	v := s.b[:n]
+	s.b = s.b[n:]
+	return v
+}
+
+func (s *sigBuffer) remaining() bool {
+	return s.err == nil && len(s.b) > 0
+}
+
+// ReadSigningBlock 读取中央目录之前的 APK 签名区块，没有签名区块时返回 nil
+func ReadSigningBlock(z *entity.ZipArchive) (*entity.ApkSigningBlock, error) {
+	if z.DirOffset < 32 {
+		return nil, nil
+	}
+	footer := make([]byte, 24)
+	if _, err := z.Reader.ReadAt(footer, z.DirOffset-24); err != nil {
+		return nil, err
+	}
+	if string(footer[8:]) != apkSigBlockMagic {
+		return nil, nil
+	}
+	size := binary.LittleEndian.Uint64(footer)
+	if size < 24 || size > uint64(z.DirOffset-8) {
+		return nil, fmt.Errorf("signing block size %d out of range", size)
+	}
+	offset := z.DirOffset - int64(size) - 8
+	raw := make([]byte, size+8)
+	if _, err := z.Reader.ReadAt(raw, offset); err != nil {
+		return nil, err
+	}
+	if head := binary.LittleEndian.Uint64(raw); head != size {
+		return nil, fmt.Errorf("signing block header size %d, footer size %d", head, size)
+	}
+	block := &entity.ApkSigningBlock{Offset: offset, Size: int64(size) + 8}
+	pairs := raw[8 : len(raw)-24]
+	for len(pairs) > 0 {
+		if len(pairs) < 12 {
+			return nil, fmt.Errorf("signing block pair at %d truncated", offset+int64(len(raw)-24-len(pairs)))
+		}
+		n := binary.LittleEndian.Uint64(pairs)
+		if n < 4 || n > uint64(len(pairs)-8) {
+			return nil, fmt.Errorf("signing block pair length %d out of range", n)
+		}
+		block.Pairs = append(block.Pairs, entity.SigningBlockPair{Id: binary.LittleEndian.Uint32(pairs[8:]), Value: pairs[12 : 8+n]})
+		pairs = pairs[8+n:]
+	}
+	return block, nil
+}
+
+// 计算 v2/v3 签名的内容摘要。摘要的内容依次为签名区块之前的数据、中央目录，
+// 以及中央目录位置改为签名区块位置的中央目录结束记录
+type contentDigester struct {
+	sections []*io.SectionReader
+	cache    map[int][]byte
+}
+
+func newContentDigester(before *io.SectionReader, dir *io.SectionReader, end []byte, blockOffset int64) *contentDigester {
+	end = append([]byte(nil), end...)
+	binary.LittleEndian.PutUint32(end[16:], uint32(blockOffset))
+	return &contentDigester{
+		sections: []*io.SectionReader{before, dir, io.NewSectionReader(bytes.NewReader(end), 0, int64(len(end)))},
+		cache:    make(map[int][]byte),
+	}
+}
+
+// 压缩包中签名区块位于 blockOffset 时的内容摘要
+func archiveDigester(z *entity.ZipArchive, blockOffset int64) (*contentDigester, error) {
+	end := make([]byte, z.Size-z.EndOffset)
+	if _, err := z.Reader.ReadAt(end, z.EndOffset); err != nil {
+		return nil, err
+	}
+	return newContentDigester(
+		io.NewSectionReader(z.Reader, 0, blockOffset),
+		io.NewSectionReader(z.Reader, z.DirOffset, z.EndOffset-z.DirOffset),
+		end, blockOffset), nil
+}
+
+func (d *contentDigester) digest(kind int) ([]byte, error) {
+	if v, ok := d.cache[kind]; ok {
+		return v, nil
+	}
+	var v []byte
+	var err error
+	switch kind {
+	case digestChunkedSha256:
+		v, err = d.chunked(crypto.SHA256)
+	case digestChunkedSha512:
+		v, err = d.chunked(crypto.SHA512)
+	case digestVeritySha256:
+		v, err = d.verity()
+	default:
+		err = fmt.Errorf("unknown content digest %d", kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.cache[kind] = v
+	return v, nil
+}
+
+// 每 1MB 计算一个摘要，再对全部摘要计算摘要
+func (d *contentDigester) chunked(hash crypto.Hash) ([]byte, error) {
+	const chunkSize = 1 << 20
+	h := hash.New()
+	buf := make([]byte, chunkSize)
+	var digests []byte
+	var count uint32
+	var prefix [5]byte
+	for _, s := range d.sections {
+		for off := int64(0); off < s.Size(); off += chunkSize {
+			n := s.Size() - off
+			if n > chunkSize {
+				n = chunkSize
+			}
+			if _, err := s.ReadAt(buf[:n], off); err != nil && err != io.EOF {
+				return nil, err
+			}
+			prefix[0] = 0xa5
+			binary.LittleEndian.PutUint32(prefix[1:], uint32(n))
+			h.Reset()
+			h.Write(prefix[:])
+			h.Write(buf[:n])
+			digests = h.Sum(digests)
+			count++
+		}
+	}
+	prefix[0] = 0x5a
+	binary.LittleEndian.PutUint32(prefix[1:], count)
+	h.Reset()
+	h.Write(prefix[:])
+	h.Write(digests)
+	return h.Sum(nil), nil
+}
+
+// fs-verity 的 Merkle 树根摘要加上数据的长度，每页 4KB，盐为 8 个 0 字节
+func (d *contentDigester) verity() ([]byte, error) {
+	const pageSize = 4096
+	salt := make([]byte, 8)
+	hashPages := func(r io.Reader) ([]byte, error) {
+		page := make([]byte, pageSize)
+		var out []byte
+		for {
+			n, err := io.ReadFull(r, page)
+			if n == 0 {
+				if err == io.EOF {
+					return out, nil
+				}
+				return nil, err
+			}
+			for i := n; i < pageSize; i++ {
+				page[i] = 0
+			}
+			h := sha256.New()
+			h.Write(salt)
+			h.Write(page)
+			out = h.Sum(out)
+			if err == io.ErrUnexpectedEOF {
+				return out, nil
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	var total int64
+	readers := make([]io.Reader, len(d.sections))
+	for i, s := range d.sections {
+		readers[i] = io.NewSectionReader(s, 0, s.Size())
+		total += s.Size()
+	}
+	level, err := hashPages(io.MultiReader(readers...))
+	if err != nil {
+		return nil, err
+	}
+	for len(level) > pageSize {
+		if level, err = hashPages(bytes.NewReader(level)); err != nil {
+			return nil, err
+		}
+	}
+	top := make([]byte, pageSize)
+	copy(top, level)
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(top)
+	result := h.Sum(nil)
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(total))
+	return append(result, size[:]...), nil
+}
+
+// 验证 v2/v3/v3.1 签名区块中的全部签名者
+func verifyApkScheme(value []byte, version int, digester *contentDigester) *entity.SignatureScheme {
+	scheme := &entity.SignatureScheme{Version: version}
+	outer := &sigBuffer{b: value}
+	list := &sigBuffer{b: outer.bytes()}
+	for list.remaining() {
+		raw := list.bytes()
+		if list.err != nil {
+			break
+		}
+		scheme.Signers = append(scheme.Signers, verifyApkSigner(raw, version, len(scheme.Signers)+1, digester))
+	}
+	if outer.err != nil || list.err != nil {
+		scheme.Errors = append(scheme.Errors, "signer list truncated")
+	}
+	if len(scheme.Signers) == 0 {
+		scheme.Errors = append(scheme.Errors, "no signers")
+	}
+	if version != entity.SIG_SCHEME_V2 {
+		// 不同签名者的 SDK 范围不能重叠
+		for i, a := range scheme.Signers {
+			for _, b := range scheme.Signers[i+1:] {
+				if a.MinSdk <= b.MaxSdk && b.MinSdk <= a.MaxSdk {
+					scheme.Errors = append(scheme.Errors, fmt.Sprintf("signers %s and %s have overlapping SDK ranges", a.Name, b.Name))
+				}
+			}
+		}
+	}
+	scheme.Verified = len(scheme.Errors) == 0
+	for _, signer := range scheme.Signers {
+		if len(signer.Errors) > 0 {
+			scheme.Verified = false
+		}
+	}
+	return scheme
+}
+
+// 验证一个 v2/v3 签名者：签名、证书与公钥、SDK 范围、证书轮换记录和内容摘要
+func verifyApkSigner(raw []byte, version int, index int, digester *contentDigester) *entity.ApkSigner {
+	signer := &entity.ApkSigner{Name: fmt.Sprintf("#%d", index), Attributes: make(map[uint32][]byte)}
+	fail := func(format string, args ...interface{}) {
+		signer.Errors = append(signer.Errors, fmt.Sprintf(format, args...))
+	}
+	buf := &sigBuffer{b: raw}
+	signedData := buf.bytes()
+	var minSdk, maxSdk uint32
+	if version != entity.SIG_SCHEME_V2 {
+		minSdk = buf.uint32()
+		maxSdk = buf.uint32()
+	}
+	sigList := buf.bytes()
+	pubRaw := buf.bytes()
+	if buf.err != nil {
+		fail("signer %v", buf.err)
+		return signer
+	}
+	pub, err := x509.ParsePKIXPublicKey(pubRaw)
+	if err != nil {
+		fail("public key: %v", err)
+		return signer
+	}
+
+	var sigAlgs []uint32
+	verified := 0
+	sigs := &sigBuffer{b: sigList}
+	for sigs.remaining() {
+		item := &sigBuffer{b: sigs.bytes()}
+		id := item.uint32()
+		sig := item.bytes()
+		if item.err != nil {
+			sigs.err = item.err
+			break
+		}
+		sigAlgs = append(sigAlgs, id)
+		alg, ok := sigAlgorithms[id]
+		if !ok {
+			continue
+		}
+		if err := verifySigned(pub, alg.hash, alg.pss, signedData, sig); err != nil {
+			fail("%s signature: %v", alg.name, err)
+			continue
+		}
+		verified++
+	}
+	if sigs.err != nil {
+		fail("signatures %v", sigs.err)
+	}
+	if verified == 0 && len(signer.Errors) == 0 {
+		fail("no supported signature")
+	}
+
+	data := &sigBuffer{b: signedData}
+	digestList := data.bytes()
+	certList := data.bytes()
+	if version != entity.SIG_SCHEME_V2 {
+		signer.MinSdk = data.uint32()
+		signer.MaxSdk = data.uint32()
+	}
+	attrList := data.bytes()
+	if data.err != nil {
+		fail("signed data %v", data.err)
+		return signer
+	}
+	if version != entity.SIG_SCHEME_V2 && (signer.MinSdk != minSdk || signer.MaxSdk != maxSdk) {
+		fail("SDK range %d-%d differs from the signed %d-%d", minSdk, maxSdk, signer.MinSdk, signer.MaxSdk)
+	}
+
+	certs := &sigBuffer{b: certList}
+	for certs.remaining() {
+		cert, err := x509.ParseCertificate(certs.bytes())
+		if err != nil {
+			fail("certificate %d: %v", len(signer.Certificates)+1, err)
+			continue
+		}
+		signer.Certificates = append(signer.Certificates, cert)
+	}
+	if len(signer.Certificates) == 0 {
+		fail("no certificates")
+	} else if !bytes.Equal(signer.Certificates[0].RawSubjectPublicKeyInfo, pubRaw) {
+		fail("public key does not match the first certificate")
+	}
+
+	attrs := &sigBuffer{b: attrList}
+	for attrs.remaining() {
+		item := &sigBuffer{b: attrs.bytes()}
+		id := item.uint32()
+		if item.err != nil {
+			fail("attribute %v", item.err)
+			break
+		}
+		signer.Attributes[id] = item.b
+	}
+
+	var digestAlgs []uint32
+	digests := &sigBuffer{b: digestList}
+	for digests.remaining() {
+		item := &sigBuffer{b: digests.bytes()}
+		id := item.uint32()
+		value := item.bytes()
+		if item.err != nil {
+			fail("digest %v", item.err)
+			break
+		}
+		digestAlgs = append(digestAlgs, id)
+		alg, ok := sigAlgorithms[id]
+		if !ok {
+			continue
+		}
+		signer.Digests = append(signer.Digests, entity.SignatureDigest{Algorithm: id, Name: alg.name, Value: value})
+		computed, err := digester.digest(alg.digest)
+		if err != nil {
+			fail("%s digest: %v", alg.name, err)
+		} else if !bytes.Equal(computed, value) {
+			fail("%s digest does not match the APK contents", alg.name)
+		}
+	}
+	if fmt.Sprint(sigAlgs) != fmt.Sprint(digestAlgs) {
+		fail("signature algorithms %x do not match digest algorithms %x", sigAlgs, digestAlgs)
+	}
+
+	if por, ok := signer.Attributes[entity.SIG_ATTR_PROOF_OF_ROTATION]; ok && version != entity.SIG_SCHEME_V2 {
+		lineage, err := ParseSigningLineage(por)
+		if err != nil {
+			fail("proof-of-rotation: %v", err)
+		} else {
+			signer.Lineage = lineage
+			last := lineage[len(lineage)-1].Certificate
+			if len(signer.Certificates) > 0 && !last.Equal(signer.Certificates[0]) {
+				fail("proof-of-rotation does not end with the signing certificate")
+			}
+		}
+	}
+	return signer
+}
+
+// ParseSigningLineage 解析并验证 v3 的证书轮换记录，每个证书由前一个证书签署
+func ParseSigningLineage(raw []byte) ([]entity.RotationNode, error) {
+	buf := &sigBuffer{b: raw}
+	if version := buf.uint32(); buf.err == nil && version != 1 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	nodes := &sigBuffer{b: buf.bytes()}
+	if buf.err != nil {
+		return nil, buf.err
+	}
+	var lineage []entity.RotationNode
+	var lastAlg uint32
+	for nodes.remaining() {
+		node := &sigBuffer{b: nodes.bytes()}
+		signedData := node.bytes()
+		flags := node.uint32()
+		algId := node.uint32()
+		sig := node.bytes()
+		data := &sigBuffer{b: signedData}
+		certRaw := data.bytes()
+		signedAlg := data.uint32()
+		if node.err != nil || data.err != nil {
+			return nil, fmt.Errorf("node %d truncated", len(lineage)+1)
+		}
+		cert, err := x509.ParseCertificate(certRaw)
+		if err != nil {
+			return nil, fmt.Errorf("node %d: %v", len(lineage)+1, err)
+		}
+		if len(lineage) > 0 {
+			// 由前一个证书用它记录的算法签署
+			prev := lineage[len(lineage)-1].Certificate
+			alg, ok := sigAlgorithms[lastAlg]
+			if !ok {
+				return nil, fmt.Errorf("node %d: unsupported algorithm 0x%04x", len(lineage)+1, lastAlg)
+			}
+			if signedAlg != lastAlg {
+				return nil, fmt.Errorf("node %d: signed algorithm 0x%04x, expected 0x%04x", len(lineage)+1, signedAlg, lastAlg)
+			}
+			if err := verifySigned(prev.PublicKey, alg.hash, alg.pss, signedData, sig); err != nil {
+				return nil, fmt.Errorf("node %d: %v", len(lineage)+1, err)
+			}
+		}
+		for _, n := range lineage {
+			if n.Certificate.Equal(cert) {
+				return nil, fmt.Errorf("node %d: certificate repeated", len(lineage)+1)
+			}
+		}
+		lineage = append(lineage, entity.RotationNode{Certificate: cert, Flags: flags, Algorithm: algId})
+		lastAlg = algId
+	}
+	if nodes.err != nil {
+		return nil, nodes.err
+	}
+	if len(lineage) == 0 {
+		return nil, fmt.Errorf("no certificates")
+	}
+	return lineage, nil
+}
+
+// VerifySignatures 验证 APK 的 v1、v2、v3 和 v3.1 签名，检查方案之间签名证书是否一致、
+// 是否有签名被删除。只有读取出错时返回错误
+func VerifySignatures(z *entity.ZipArchive) (*entity.SignatureReport, error) {
+	report := &entity.SignatureReport{}
+	issue := func(format string, args ...interface{}) {
+		report.Issues = append(report.Issues, fmt.Sprintf(format, args...))
+	}
+	v1, claims, err := verifyJarSignature(z)
+	if err != nil {
+		return nil, err
+	}
+	if v1 != nil {
+		report.Schemes = append(report.Schemes, v1)
+	}
+
+	block, err := ReadSigningBlock(z)
+	if err != nil {
+		issue("signing block: %v", err)
+	}
+	report.Block = block
+	schemes := map[int]*entity.SignatureScheme{}
+	if v1 != nil {
+		schemes[entity.SIG_SCHEME_V1] = v1
+	}
+	if block != nil {
+		digester, err := archiveDigester(z, block.Offset)
+		if err != nil {
+			return nil, err
+		}
+		for _, pair := range block.Pairs {
+			version := 0
+			switch pair.Id {
+			case entity.SIG_BLOCK_V2:
+				version = entity.SIG_SCHEME_V2
+			case entity.SIG_BLOCK_V3:
+				version = entity.SIG_SCHEME_V3
+			case entity.SIG_BLOCK_V31:
+				version = entity.SIG_SCHEME_V31
+			default:
+				continue
+			}
+			if schemes[version] != nil {
+				issue("duplicate signature scheme block 0x%08x", pair.Id)
+				continue
+			}
+			schemes[version] = verifyApkScheme(pair.Value, version, digester)
+			report.Schemes = append(report.Schemes, schemes[version])
+		}
+	}
+
+	// 被删除的更高版本的签名
+	for _, v := range claims {
+		if v >= entity.SIG_SCHEME_V2 && schemes[v] == nil {
+			issue("v1 signature claims a v%d signature, which is missing", v)
+		}
+	}
+	if v2 := schemes[entity.SIG_SCHEME_V2]; v2 != nil {
+		for _, signer := range v2.Signers {
+			if attr, ok := signer.Attributes[entity.SIG_ATTR_STRIPPING_PROTECTION]; ok && len(attr) >= 4 {
+				if v := int(binary.LittleEndian.Uint32(attr)); schemes[v] == nil {
+					issue("v2 signer %s claims a v%d signature, which is missing", signer.Name, v)
+				}
+			}
+		}
+	}
+	v3, v31 := schemes[entity.SIG_SCHEME_V3], schemes[entity.SIG_SCHEME_V31]
+	if v3 != nil {
+		for _, signer := range v3.Signers {
+			attr, ok := signer.Attributes[entity.SIG_ATTR_ROTATION_MIN_SDK]
+			if !ok || len(attr) < 4 {
+				continue
+			}
+			sdk := binary.LittleEndian.Uint32(attr)
+			if v31 == nil {
+				issue("v3 signer %s claims a v3.1 signature from SDK %d, which is missing", signer.Name, sdk)
+				continue
+			}
+			found := false
+			for _, s := range v31.Signers {
+				if s.MinSdk == sdk {
+					found = true
+				}
+			}
+			if !found {
+				issue("v3 signer %s claims a v3.1 signature from SDK %d, no v3.1 signer starts there", signer.Name, sdk)
+			}
+		}
+	}
+
+	// 签名证书一致：v1 与 v2 相同，v3 与 v2 (没有 v2 时为 v1) 相同或在轮换记录中，v3.1 轮换自 v3
+	v2 := schemes[entity.SIG_SCHEME_V2]
+	if v1 != nil && v2 != nil && !sameSigners(v1, v2) {
+		issue("v1 and v2 signing certificates differ")
+	}
+	older := v2
+	if older == nil {
+		older = v1
+	}
+	if older != nil && v3 != nil {
+		checkRotation(older, v3, issue)
+	}
+	if v3 != nil && v31 != nil {
+		checkRotation(v3, v31, issue)
+	}
+
+	report.Verified = len(report.Schemes) > 0 && len(report.Issues) == 0
+	for _, scheme := range report.Schemes {
+		if !scheme.Verified {
+			report.Verified = false
+		}
+	}
+	return report, nil
+}
+
+// 两种方案的签名证书集合是否相同
+func sameSigners(a *entity.SignatureScheme, b *entity.SignatureScheme) bool {
+	certs := func(s *entity.SignatureScheme) map[string]bool {
+		set := make(map[string]bool)
+		for _, signer := range s.Signers {
+			if len(signer.Certificates) > 0 {
+				set[string(signer.Certificates[0].Raw)] = true
+			}
+		}
+		return set
+	}
+	ca, cb := certs(a), certs(b)
+	if len(ca) != len(cb) {
+		return false
+	}
+	for c := range ca {
+		if !cb[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// 签名者按集合比较：newer 的每个签名者至少与 older 的一个签名者的证书相同，
+// 或者该证书在它的轮换记录中
+func checkRotation(older *entity.SignatureScheme, newer *entity.SignatureScheme, issue func(string, ...interface{})) {
+	for _, signer := range newer.Signers {
+		if len(signer.Certificates) == 0 {
+			continue
+		}
+		ok, compared := false, false
+		for _, old := range older.Signers {
+			if len(old.Certificates) == 0 {
+				continue
+			}
+			compared = true
+			cert := old.Certificates[0]
+			if cert.Equal(signer.Certificates[0]) {
+				ok = true
+			}
+			for _, node := range signer.Lineage {
+				if node.Certificate.Equal(cert) {
+					ok = true
+				}
+			}
+		}
+		if compared && !ok {
+			issue("%s signer %s does not match or rotate from any %s signer", SignatureSchemeName(newer.Version), signer.Name, SignatureSchemeName(older.Version))
+		}
+	}
+}
+
+// SignatureSchemeName 返回签名方案的名字，如 v2、v3.1
+func SignatureSchemeName(version int) string {
+	if version == entity.SIG_SCHEME_V31 {
+		return "v3.1"
+	}
+	return fmt.Sprintf("v%d", version)
+}
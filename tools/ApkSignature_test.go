@@ -0,0 +1,85 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T, name string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func testScheme(version int, signers ...*entity.ApkSigner) *entity.SignatureScheme {
+	for i, signer := range signers {
+		signer.Name = fmt.Sprint(i + 1)
+	}
+	return &entity.SignatureScheme{Version: version, Signers: signers}
+}
+
+// 多个签名者按集合比较，不要求每对签名者都相同
+func TestCheckRotationSigners(t *testing.T) {
+	a, b, c, rotated := testCertificate(t, "a"), testCertificate(t, "b"), testCertificate(t, "c"), testCertificate(t, "rotated")
+	cases := []struct {
+		name   string
+		older  *entity.SignatureScheme
+		newer  *entity.SignatureScheme
+		issues int
+	}{
+		{
+			"same signers",
+			testScheme(entity.SIG_SCHEME_V2, &entity.ApkSigner{Certificates: []*x509.Certificate{a}}, &entity.ApkSigner{Certificates: []*x509.Certificate{b}}),
+			testScheme(entity.SIG_SCHEME_V3, &entity.ApkSigner{Certificates: []*x509.Certificate{b}}, &entity.ApkSigner{Certificates: []*x509.Certificate{a}}),
+			0,
+		},
+		{
+			"rotated signer",
+			testScheme(entity.SIG_SCHEME_V2, &entity.ApkSigner{Certificates: []*x509.Certificate{a}}, &entity.ApkSigner{Certificates: []*x509.Certificate{b}}),
+			testScheme(entity.SIG_SCHEME_V3,
+				&entity.ApkSigner{Certificates: []*x509.Certificate{a}},
+				&entity.ApkSigner{Certificates: []*x509.Certificate{rotated}, Lineage: []entity.RotationNode{{Certificate: b}, {Certificate: rotated}}}),
+			0,
+		},
+		{
+			"unknown signer",
+			testScheme(entity.SIG_SCHEME_V2, &entity.ApkSigner{Certificates: []*x509.Certificate{a}}, &entity.ApkSigner{Certificates: []*x509.Certificate{b}}),
+			testScheme(entity.SIG_SCHEME_V3, &entity.ApkSigner{Certificates: []*x509.Certificate{a}}, &entity.ApkSigner{Certificates: []*x509.Certificate{c}}),
+			1,
+		},
+	}
+	for _, tc := range cases {
+		var issues []string
+		checkRotation(tc.older, tc.newer, func(format string, args ...interface{}) {
+			issues = append(issues, fmt.Sprintf(format, args...))
+		})
+		if len(issues) != tc.issues {
+			t.Errorf("%s: issues %v, want %d", tc.name, issues, tc.issues)
+		}
+	}
+}
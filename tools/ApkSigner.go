@@ -0,0 +1,366 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	signerCreatedBy = "1.0 (apkgo)"
+	// v3 签名只在 Android 9 (SDK 28) 及以上生效
+	v3MinSdk = 28
+	v3MaxSdk = 0x7fffffff
+)
+
+var (
+	oidDigestSha1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSha256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidRsaKey       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidEcKey        = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+)
+
+// SignApkFile 签名 in 并写到 out
+func SignApkFile(in string, out string, key *entity.SigningKey, opts entity.SignOptions) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	z, err := OpenZip(f)
+	if err != nil {
+		return err
+	}
+	signed, err := SignApk(z, key, opts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, signed, 0644)
+}
+
+// SignApk 删除原有的签名，按 opts 写入 v1、v2、v3 签名，返回签名后的 APK。
+// 原有文件的压缩数据原样复制，重复的文件只保留第一个
+func SignApk(z *entity.ZipArchive, key *entity.SigningKey, opts entity.SignOptions) ([]byte, error) {
+	if !opts.V1 && !opts.V2 && !opts.V3 {
+		return nil, fmt.Errorf("no signature scheme enabled")
+	}
+	if len(key.Certificates) == 0 {
+		return nil, fmt.Errorf("no certificate")
+	}
+	alg, err := signingAlgorithm(key.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*entity.ZipEntry
+	seen := make(map[string]bool)
+	for _, e := range z.Entries {
+		if seen[e.Name] || isJarSignatureFile(e.Name) {
+			continue
+		}
+		seen[e.Name] = true
+		entries = append(entries, e)
+	}
+
+	var buf bytes.Buffer
+	zw := NewZipWriter(&buf)
+	for _, e := range entries {
+		if err := zw.CopyEntry(z, e); err != nil {
+			return nil, err
+		}
+	}
+	if opts.V1 {
+		files, err := signJar(z, entries, key, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if err := zw.AddEntry(f.name, f.data, zipMethodDeflate); err != nil {
+				return nil, err
+			}
+		}
+	}
+	dir, end := zw.Finish()
+	if !opts.V2 && !opts.V3 {
+		buf.Write(dir)
+		buf.Write(end)
+		return buf.Bytes(), nil
+	}
+
+	data := buf.Bytes()
+	digester := newContentDigester(
+		io.NewSectionReader(bytes.NewReader(data), 0, int64(len(data))),
+		io.NewSectionReader(bytes.NewReader(dir), 0, int64(len(dir))),
+		end, int64(len(data)))
+	var pairs []entity.SigningBlockPair
+	if opts.V2 {
+		var attrs []entity.SigningBlockPair
+		if opts.V3 {
+			// 防止删除 v3 签名后降级为 v2
+			attrs = append(attrs, entity.SigningBlockPair{Id: entity.SIG_ATTR_STRIPPING_PROTECTION, Value: sigUint32(entity.SIG_SCHEME_V3)})
+		}
+		signer, err := signApkSigner(key, alg, digester, attrs, 0, 0, false)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, entity.SigningBlockPair{Id: entity.SIG_BLOCK_V2, Value: sigBytes(sigBytes(signer))})
+	}
+	if opts.V3 {
+		signer, err := signApkSigner(key, alg, digester, nil, v3MinSdk, v3MaxSdk, true)
+		if err != nil {
+			return nil, err
+		}
+		pairs = append(pairs, entity.SigningBlockPair{Id: entity.SIG_BLOCK_V3, Value: sigBytes(sigBytes(signer))})
+	}
+	block := signingBlock(pairs)
+
+	out := make([]byte, 0, len(data)+len(block)+len(dir)+len(end))
+	out = append(out, data...)
+	out = append(out, block...)
+	out = append(out, dir...)
+	end = append([]byte(nil), end...)
+	binary.LittleEndian.PutUint32(end[16:], uint32(len(data)+len(block)))
+	return append(out, end...), nil
+}
+
+// 签名时要替换的 META-INF 下的签名文件
+func isJarSignatureFile(name string) bool {
+	return strings.HasPrefix(name, "META-INF/") && !strings.HasSuffix(name, "/") && !jarEntryNeedsDigest(name)
+}
+
+// 按私钥选择 v2/v3 签名算法，与 apksig 相同
+func signingAlgorithm(key crypto.Signer) (uint32, error) {
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() <= 3072 {
+			return entity.SIG_RSA_PKCS1_SHA256, nil
+		}
+		return entity.SIG_RSA_PKCS1_SHA512, nil
+	case *ecdsa.PublicKey:
+		if pub.Curve.Params().BitSize <= 256 {
+			return entity.SIG_ECDSA_SHA256, nil
+		}
+		return entity.SIG_ECDSA_SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported key %T", key.Public())
+}
+
+func signData(key crypto.Signer, hash crypto.Hash, data []byte) ([]byte, error) {
+	return key.Sign(rand.Reader, hashBytes(hash, data), hash)
+}
+
+func sigUint32(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return b
+}
+
+// 加上 4 字节长度前缀
+func sigBytes(parts ...[]byte) []byte {
+	var n int
+	for _, p := range parts {
+		n += len(p)
+	}
+	b := sigUint32(uint32(n))
+	for _, p := range parts {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// 生成 v2 或 v3 (withSdk 为 true) 的一个签名者
+func signApkSigner(key *entity.SigningKey, alg uint32, digester *contentDigester, attrs []entity.SigningBlockPair,
+	minSdk uint32, maxSdk uint32, withSdk bool) ([]byte, error) {
+	params := sigAlgorithms[alg]
+	digest, err := digester.digest(params.digest)
+	if err != nil {
+		return nil, err
+	}
+	var certs, attrList []byte
+	for _, cert := range key.Certificates {
+		certs = append(certs, sigBytes(cert.Raw)...)
+	}
+	for _, attr := range attrs {
+		attrList = append(attrList, sigBytes(sigUint32(attr.Id), attr.Value)...)
+	}
+	digests := sigBytes(sigBytes(sigUint32(alg), sigBytes(digest)))
+	var signed []byte
+	if withSdk {
+		signed = sigBytes(digests, sigBytes(certs), sigUint32(minSdk), sigUint32(maxSdk), sigBytes(attrList))
+	} else {
+		signed = sigBytes(digests, sigBytes(certs), sigBytes(attrList))
+	}
+	sig, err := signData(key.Key, params.hash, signed[4:])
+	if err != nil {
+		return nil, err
+	}
+	signatures := sigBytes(sigBytes(sigUint32(alg), sigBytes(sig)))
+	publicKey := sigBytes(key.Certificates[0].RawSubjectPublicKeyInfo)
+	if withSdk {
+		return bytes.Join([][]byte{signed, sigUint32(minSdk), sigUint32(maxSdk), signatures, publicKey}, nil), nil
+	}
+	return bytes.Join([][]byte{signed, signatures, publicKey}, nil), nil
+}
+
+// 组装 APK 签名区块
+func signingBlock(pairs []entity.SigningBlockPair) []byte {
+	var body []byte
+	for _, p := range pairs {
+		var n [8]byte
+		binary.LittleEndian.PutUint64(n[:], uint64(len(p.Value)+4))
+		body = append(body, n[:]...)
+		body = append(body, sigUint32(p.Id)...)
+		body = append(body, p.Value...)
+	}
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(body)+24))
+	block := append(append([]byte(nil), size...), body...)
+	block = append(block, size...)
+	return append(block, apkSigBlockMagic...)
+}
+
+// v1 签名生成的文件
+type jarFile struct {
+	name string
+	data []byte
+}
+
+// 生成 MANIFEST.MF、.SF 和 PKCS#7 签名块
+func signJar(z *entity.ZipArchive, entries []*entity.ZipEntry, key *entity.SigningKey, opts entity.SignOptions) ([]jarFile, error) {
+	hash, digestName, digestOid := crypto.SHA256, "SHA-256", oidDigestSha256
+	if opts.MinSdk < 18 {
+		// Android 4.3 之前只支持 SHA-1
+		hash, digestName, digestOid = crypto.SHA1, "SHA1", oidDigestSha1
+	}
+	var names []string
+	byName := make(map[string]*entity.ZipEntry)
+	for _, e := range entries {
+		if jarEntryNeedsDigest(e.Name) {
+			names = append(names, e.Name)
+			byName[e.Name] = e
+		}
+	}
+	sort.Strings(names)
+
+	var manifest, sf, sfEntries bytes.Buffer
+	writeJarAttr(&manifest, "Manifest-Version", "1.0")
+	writeJarAttr(&manifest, "Created-By", signerCreatedBy)
+	manifest.WriteString("\r\n")
+	for _, name := range names {
+		digest, err := hashZipEntry(z, byName[name], hash)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		start := manifest.Len()
+		writeJarAttr(&manifest, "Name", name)
+		writeJarAttr(&manifest, digestName+"-Digest", base64.StdEncoding.EncodeToString(digest))
+		manifest.WriteString("\r\n")
+		// .SF 中记录清单中每段的摘要
+		writeJarAttr(&sfEntries, "Name", name)
+		writeJarAttr(&sfEntries, digestName+"-Digest", base64.StdEncoding.EncodeToString(hashBytes(hash, manifest.Bytes()[start:])))
+		sfEntries.WriteString("\r\n")
+	}
+
+	writeJarAttr(&sf, "Signature-Version", "1.0")
+	writeJarAttr(&sf, "Created-By", signerCreatedBy)
+	writeJarAttr(&sf, digestName+"-Digest-Manifest", base64.StdEncoding.EncodeToString(hashBytes(hash, manifest.Bytes())))
+	var schemes []string
+	if opts.V2 {
+		schemes = append(schemes, "2")
+	}
+	if opts.V3 {
+		schemes = append(schemes, "3")
+	}
+	if len(schemes) > 0 {
+		writeJarAttr(&sf, "X-Android-APK-Signed", strings.Join(schemes, ", "))
+	}
+	sf.WriteString("\r\n")
+	sf.Write(sfEntries.Bytes())
+
+	block, err := signPkcs7(key, hash, digestOid, sf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	base := strings.ToUpper(opts.Name)
+	if base == "" {
+		base = "CERT"
+	}
+	ext := ".RSA"
+	if _, ok := key.Key.Public().(*ecdsa.PublicKey); ok {
+		ext = ".EC"
+	}
+	return []jarFile{
+		{"META-INF/MANIFEST.MF", manifest.Bytes()},
+		{"META-INF/" + base + ".SF", sf.Bytes()},
+		{"META-INF/" + base + ext, block},
+	}, nil
+}
+
+// 写入一行属性，超过 72 字节时换行，续行以空格开头
+func writeJarAttr(buf *bytes.Buffer, name string, value string) {
+	line := name + ": " + value
+	limit := 72
+	for len(line) > limit {
+		buf.WriteString(line[:limit])
+		buf.WriteString("\r\n ")
+		line = line[limit:]
+		limit = 71
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+// 生成不含内容和认证属性的 PKCS#7 SignedData
+func signPkcs7(key *entity.SigningKey, hash crypto.Hash, digestOid asn1.ObjectIdentifier, content []byte) ([]byte, error) {
+	sig, err := signData(key.Key, hash, content)
+	if err != nil {
+		return nil, err
+	}
+	signing := key.Certificates[0]
+	ias, err := asn1.Marshal(pkcs7IssuerAndSerial{Issuer: asn1.RawValue{FullBytes: signing.RawIssuer}, Serial: signing.SerialNumber})
+	if err != nil {
+		return nil, err
+	}
+	keyAlg := pkix.AlgorithmIdentifier{Algorithm: oidRsaKey, Parameters: asn1.NullRawValue}
+	if _, ok := key.Key.Public().(*ecdsa.PublicKey); ok {
+		keyAlg = pkix.AlgorithmIdentifier{Algorithm: oidEcKey}
+	}
+	digestAlg := pkix.AlgorithmIdentifier{Algorithm: digestOid, Parameters: asn1.NullRawValue}
+	var certs []byte
+	for _, cert := range key.Certificates {
+		certs = append(certs, cert.Raw...)
+	}
+	sd := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlg},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidPkcs7Data},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version:                   1,
+			IssuerAndSerial:           asn1.RawValue{FullBytes: ias},
+			DigestAlgorithm:           digestAlg,
+			DigestEncryptionAlgorithm: keyAlg,
+			EncryptedDigest:           sig,
+		}},
+	}
+	inner, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidPkcs7SignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: inner},
+	})
+}
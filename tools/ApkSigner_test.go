@@ -0,0 +1,212 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 测试用的未签名 APK，包含压缩和不压缩的文件以及需要 16KB 对齐的 .so
+func testApk(t *testing.T) *entity.ZipArchive {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := NewZipWriter(&buf)
+	files := []struct {
+		name   string
+		data   []byte
+		method uint16
+	}{
+		{"AndroidManifest.xml", bytes.Repeat([]byte("manifest"), 64), zipMethodDeflate},
+		{"classes.dex", bytes.Repeat([]byte("dex\n035\x00"), 128), zipMethodDeflate},
+		{"resources.arsc", []byte("resources"), zipMethodStore},
+		{"lib/arm64-v8a/libtest.so", []byte("\x7fELF"), zipMethodStore},
+		{"res/raw/a.png", []byte("png"), zipMethodStore},
+	}
+	for _, f := range files {
+		if err := zw.AddEntry(f.name, f.data, f.method); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	z, err := ReadZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return z
+}
+
+// 生成自签名证书，把私钥和证书以 PEM 格式写到临时目录，再用 LoadSigningKey 读取
+func testSigningKey(t *testing.T, key crypto.Signer) *entity.SigningKey {
+	t.Helper()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "apkgo test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	certDer, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "key.pem")
+	certPath := filepath.Join(dir, "cert.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDer}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	signingKey, err := LoadSigningKey(keyPath, certPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signingKey
+}
+
+// 签名后重新读取并验证，返回验证结果
+func signAndVerify(t *testing.T, key *entity.SigningKey, opts entity.SignOptions) *entity.SignatureReport {
+	t.Helper()
+	signed, err := SignApk(testApk(t), key, opts)
+	if err != nil {
+		t.Fatalf("SignApk: %v", err)
+	}
+	z, err := ReadZip(bytes.NewReader(signed), int64(len(signed)))
+	if err != nil {
+		t.Fatalf("ReadZip: %v", err)
+	}
+	for _, e := range z.Entries {
+		if e.Method == zipMethodStore && e.DataOffset%ZipAlignment(e.Name) != 0 {
+			t.Errorf("%s at %d is not aligned to %d", e.Name, e.DataOffset, ZipAlignment(e.Name))
+		}
+	}
+	report, err := VerifySignatures(z)
+	if err != nil {
+		t.Fatalf("VerifySignatures: %v", err)
+	}
+	if !report.Verified {
+		t.Errorf("signature not verified: issues %v", report.Issues)
+		for _, scheme := range report.Schemes {
+			t.Logf("%s: %v", SignatureSchemeName(scheme.Version), scheme.Errors)
+			for _, signer := range scheme.Signers {
+				t.Logf("  %s: %v", signer.Name, signer.Errors)
+			}
+		}
+	}
+	return report
+}
+
+func findScheme(report *entity.SignatureReport, version int) *entity.SignatureScheme {
+	for _, scheme := range report.Schemes {
+		if scheme.Version == version {
+			return scheme
+		}
+	}
+	return nil
+}
+
+// 检查 v1、v2、v3 都存在并通过验证，v2 带有防止删除 v3 的属性，v1 使用 digest 摘要
+func checkAllSchemes(t *testing.T, report *entity.SignatureReport, digest string) {
+	t.Helper()
+	for _, version := range []int{entity.SIG_SCHEME_V1, entity.SIG_SCHEME_V2, entity.SIG_SCHEME_V3} {
+		scheme := findScheme(report, version)
+		if scheme == nil {
+			t.Errorf("%s signature missing", SignatureSchemeName(version))
+			continue
+		}
+		if !scheme.Verified || len(scheme.Signers) != 1 {
+			t.Errorf("%s: verified %v, %d signers", SignatureSchemeName(version), scheme.Verified, len(scheme.Signers))
+		}
+	}
+	if v2 := findScheme(report, entity.SIG_SCHEME_V2); v2 != nil && len(v2.Signers) > 0 {
+		value, ok := v2.Signers[0].Attributes[entity.SIG_ATTR_STRIPPING_PROTECTION]
+		if !ok || len(value) != 4 || binary.LittleEndian.Uint32(value) != entity.SIG_SCHEME_V3 {
+			t.Errorf("v2 stripping protection attribute = %x, %v", value, ok)
+		}
+	}
+	if v1 := findScheme(report, entity.SIG_SCHEME_V1); v1 != nil && len(v1.Signers) > 0 {
+		digests := v1.Signers[0].Digests
+		if len(digests) == 0 {
+			t.Errorf("v1 signer has no digests")
+		}
+		for _, d := range digests {
+			if d.Name != digest {
+				t.Errorf("v1 digest %s, want %s", d.Name, digest)
+			}
+		}
+	}
+}
+
+func TestSignApkRsa(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := signAndVerify(t, testSigningKey(t, key), entity.SignOptions{V1: true, V2: true, V3: true, MinSdk: 21})
+	checkAllSchemes(t, report, "SHA-256")
+}
+
+func TestSignApkEc(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := signAndVerify(t, testSigningKey(t, key), entity.SignOptions{V1: true, V2: true, V3: true, MinSdk: 24})
+	checkAllSchemes(t, report, "SHA-256")
+}
+
+func TestSignApkPkcs12(t *testing.T) {
+	// signer.p12 使用 PBES2/AES，signer-legacy.p12 使用 3DES 和 RC2
+	for _, name := range []string{"signer.p12", "signer-legacy.p12"} {
+		t.Run(name, func(t *testing.T) {
+			key, err := LoadPkcs12(filepath.Join("testdata", name), "secret", "signer")
+			if err != nil {
+				t.Fatal(err)
+			}
+			report := signAndVerify(t, key, entity.SignOptions{V1: true, V2: true, V3: true, MinSdk: 21})
+			checkAllSchemes(t, report, "SHA-256")
+		})
+	}
+	if _, err := LoadPkcs12(filepath.Join("testdata", "signer.p12"), "wrong", ""); err == nil {
+		t.Errorf("wrong password accepted")
+	}
+}
+
+func TestSignApkSha1ForOldSdk(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := signAndVerify(t, testSigningKey(t, key), entity.SignOptions{V1: true, V2: true, V3: true, MinSdk: 14})
+	checkAllSchemes(t, report, "SHA1")
+}
+
+func TestSignApkV1Only(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := signAndVerify(t, testSigningKey(t, key), entity.SignOptions{V1: true, MinSdk: 24})
+	if report.Block != nil || len(report.Schemes) != 1 || report.Schemes[0].Version != entity.SIG_SCHEME_V1 {
+		t.Errorf("v1-only APK has %d schemes, block %v", len(report.Schemes), report.Block != nil)
+	}
+}
@@ -0,0 +1,147 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// OpenApk 读取 APK 文件，在内存中解析清单、dex 和资源表，不解压到磁盘。
+// 文件本身和读取的文件都受 DefaultArchiveLimits 的总大小限制
+func OpenApk(path string) (*entity.Apk, error) {
+	limit := DefaultArchiveLimits().MaxTotalSize
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > limit {
+		return nil, fmt.Errorf("%s: size %d exceeds limit %d", path, info.Size(), limit)
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseApk(raw, path)
+}
+
+// ParseApk 解析内存中的 APK，name 用于错误信息
+func ParseApk(raw []byte, name string) (*entity.Apk, error) {
+	return ReadApk(bytes.NewReader(raw), int64(len(raw)), name)
+}
+
+// ReadApk 使用默认限制从 r 中读取 APK，size 为数据的长度
+func ReadApk(r io.ReaderAt, size int64, name string) (*entity.Apk, error) {
+	return ReadApkWithLimits(r, size, name, DefaultArchiveLimits())
+}
+
+// ReadApkWithLimits 从 r 中读取 APK。压缩包按 Android 的规则容忍格式错误，异常记录在 Zip.Issues 中。
+// dex 只取根目录下的 classesN.dex，FileName 为压缩包中的文件名，读取或解析失败的 dex 记录在 DexErrors 中。
+// 读取的文件受 limits 中单个文件和总大小的限制
+func ReadApkWithLimits(r io.ReaderAt, size int64, name string, limits entity.ArchiveLimits) (*entity.Apk, error) {
+	zr, err := ReadZip(r, size)
+	if err != nil {
+		return nil, err
+	}
+	apk := &entity.Apk{Name: name, Reader: r, Size: size, Zip: zr}
+	reader := &apkEntryReader{apk: apk, limits: limits}
+
+	raw, err := reader.read("AndroidManifest.xml")
+	if err != nil {
+		return nil, err
+	}
+	if apk.Manifest, err = ParseManifest(raw); err != nil {
+		return nil, fmt.Errorf("AndroidManifest.xml: %v", err)
+	}
+
+	var dexNames []string
+	for _, f := range zr.Entries {
+		if !strings.Contains(f.Name, "/") && dexLoadIndex(f.Name) > 0 {
+			dexNames = append(dexNames, f.Name)
+		}
+	}
+	SortDexPaths(dexNames)
+	for _, dexName := range dexNames {
+		raw, err := reader.read(dexName)
+		if err != nil {
+			apk.DexErrors = append(apk.DexErrors, err)
+			continue
+		}
+		dexs, err := ParseDex(raw, dexName)
+		if err != nil {
+			apk.DexErrors = append(apk.DexErrors, fmt.Errorf("%s: %v", dexName, err))
+			continue
+		}
+		apk.Dexes = append(apk.Dexes, dexs...)
+	}
+
+	raw, err = reader.read("resources.arsc")
+	if err == nil {
+		if apk.Resources, err = ParseResources(raw); err != nil {
+			return nil, fmt.Errorf("resources.arsc: %v", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return apk, nil
+}
+
+// 读取 APK 中的文件并累计解压后的大小
+type apkEntryReader struct {
+	apk    *entity.Apk
+	limits entity.ArchiveLimits
+	total  int64
+}
+
+func (r *apkEntryReader) read(name string) ([]byte, error) {
+	e := findApkEntry(r.apk, name)
+	if e == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	size := int64(zipEntrySize(e))
+	if r.limits.MaxEntrySize > 0 && size > r.limits.MaxEntrySize {
+		return nil, fmt.Errorf("%s: size %d exceeds limit %d", name, size, r.limits.MaxEntrySize)
+	}
+	if r.limits.MaxTotalSize > 0 && r.total+size > r.limits.MaxTotalSize {
+		return nil, fmt.Errorf("%s: total size limit %d reached", name, r.limits.MaxTotalSize)
+	}
+	// 读取的长度不会超过中央目录中记录的大小
+	r.total += size
+	rc, err := OpenZipEntry(r.apk.Zip, e)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	defer rc.Close()
+	raw, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return raw, nil
+}
+
+// 同名的文件取第一个
+func findApkEntry(apk *entity.Apk, name string) *entity.ZipEntry {
+	for _, f := range apk.Zip.Entries {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// ReadApkEntry 读取 APK 中一个文件的内容，同名的文件取第一个，
+// 文件不存在时返回的错误满足 os.IsNotExist
+func ReadApkEntry(apk *entity.Apk, name string) ([]byte, error) {
+	f := findApkEntry(apk, name)
+	if f == nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	raw, err := ReadZipEntry(apk.Zip, f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return raw, nil
+}
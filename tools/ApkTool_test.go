@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// 只有字符串池和资源 ID 表的空清单
+func testManifest() []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, entity.HEADER{ResType: 3, HeaderSize: 8, Filesize: 44})
+	binary.Write(&buf, binary.LittleEndian, entity.StringChunk{ScType: 1, HeaderSize: 28, ScSize: 28})
+	binary.Write(&buf, binary.LittleEndian, []uint16{0x180, 8})
+	binary.Write(&buf, binary.LittleEndian, uint32(8))
+	return buf.Bytes()
+}
+
+// classes2.dex 无法解析，其余的 dex 正常
+func testApkWithDexes(t *testing.T) []byte {
+	t.Helper()
+	dex, err := WriteDex(testDexModel(t, "035"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := NewZipWriter(&buf)
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"AndroidManifest.xml", testManifest()},
+		{"classes.dex", dex},
+		{"classes2.dex", []byte("dex\n035\x00broken")},
+		{"classes3.dex", dex},
+	}
+	for _, f := range files {
+		if err := zw.AddEntry(f.name, f.data, zipMethodDeflate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadApkKeepsGoodDexes(t *testing.T) {
+	apk, err := ParseApk(testApkWithDexes(t), "test.apk")
+	if err != nil {
+		t.Fatalf("ParseApk: %v", err)
+	}
+	if len(apk.Dexes) != 2 || apk.Dexes[0].FileName != "classes.dex" || apk.Dexes[1].FileName != "classes3.dex" {
+		t.Errorf("%d dexes loaded", len(apk.Dexes))
+	}
+	if len(apk.DexErrors) != 1 || !strings.HasPrefix(apk.DexErrors[0].Error(), "classes2.dex: ") {
+		t.Errorf("dex errors %v", apk.DexErrors)
+	}
+}
+
+func TestReadApkTotalLimit(t *testing.T) {
+	raw := testApkWithDexes(t)
+	dex, err := WriteDex(testDexModel(t, "035"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 只够读取清单和第一个 dex
+	limits := DefaultArchiveLimits()
+	limits.MaxTotalSize = int64(len(testManifest()) + len(dex))
+	apk, err := ReadApkWithLimits(bytes.NewReader(raw), int64(len(raw)), "test.apk", limits)
+	if err != nil {
+		t.Fatalf("ReadApkWithLimits: %v", err)
+	}
+	if len(apk.Dexes) != 1 || len(apk.DexErrors) != 2 {
+		t.Fatalf("%d dexes, errors %v", len(apk.Dexes), apk.DexErrors)
+	}
+	if !strings.Contains(apk.DexErrors[1].Error(), "total size limit") {
+		t.Errorf("classes3.dex error %v", apk.DexErrors[1])
+	}
+	limits.MaxEntrySize = int64(len(testManifest())) - 1
+	if _, err := ReadApkWithLimits(bytes.NewReader(raw), int64(len(raw)), "test.apk", limits); err == nil {
+		t.Errorf("manifest larger than the entry limit accepted")
+	}
+}
@@ -0,0 +1,682 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/rc4"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strings"
+)
+
+const (
+	cipherEncryptMode = 1
+	cipherDecryptMode = 2
+)
+
+// SecretKeySpec 保存在 Native 中的密钥
+type secretKey struct {
+	algorithm string
+	encoded   []byte
+}
+
+// IvParameterSpec 和 GCMParameterSpec 保存在 Native 中的参数，tagBits 只用于 GCM
+type cipherParams struct {
+	iv      []byte
+	tagBits int32
+}
+
+// Cipher 保存在 Native 中的状态
+type cipherState struct {
+	transformation string
+	algorithm      string // AES、DES、DESEDE 或 RC4
+	mode           string // ECB、CBC、CTR、CFB、OFB 或 GCM，RC4 为空
+	padding        bool   // PKCS5Padding/PKCS7Padding
+	opmode         int32  // 0 表示还没有 init
+	key            []byte
+	params         cipherParams
+	buffered       []byte // update 传入、等待 doFinal 处理的数据
+}
+
+// MessageDigest 保存在 Native 中的状态
+type digestState struct {
+	algorithm string
+	hash      hash.Hash
+}
+
+// 解析 "算法/模式/填充"，只有算法时使用 Java 的默认值 ECB/PKCS5Padding
+func parseTransformation(transformation string) (*cipherState, error) {
+	parts := strings.Split(transformation, "/")
+	if len(parts) != 1 && len(parts) != 3 {
+		return nil, javaException("java.security.NoSuchAlgorithmException", "Invalid transformation format: %s", transformation)
+	}
+	state := &cipherState{transformation: transformation, algorithm: strings.ToUpper(parts[0])}
+	mode, padding := "ECB", "PKCS5PADDING"
+	if len(parts) == 3 {
+		mode, padding = strings.ToUpper(parts[1]), strings.ToUpper(parts[2])
+	}
+	switch state.algorithm {
+	case "AES", "DES", "DESEDE", "TRIPLEDES":
+		if state.algorithm == "TRIPLEDES" {
+			state.algorithm = "DESEDE"
+		}
+		switch mode {
+		case "ECB", "CBC", "CTR", "CFB", "OFB", "GCM":
+			state.mode = mode
+		default:
+			return nil, javaException("java.security.NoSuchAlgorithmException", "Cannot find any provider supporting %s", transformation)
+		}
+		if mode == "GCM" && state.algorithm != "AES" {
+			return nil, javaException("java.security.NoSuchAlgorithmException", "Cannot find any provider supporting %s", transformation)
+		}
+	case "RC4", "ARCFOUR":
+		state.algorithm = "RC4"
+		if len(parts) == 3 && mode != "ECB" && mode != "NONE" {
+			return nil, javaException("java.security.NoSuchAlgorithmException", "Cannot find any provider supporting %s", transformation)
+		}
+		padding = "NOPADDING"
+	default:
+		return nil, javaException("java.security.NoSuchAlgorithmException", "Cannot find any provider supporting %s", transformation)
+	}
+	switch padding {
+	case "PKCS5PADDING", "PKCS7PADDING":
+		// 流模式下 Java 同样忽略填充
+		state.padding = state.mode == "ECB" || state.mode == "CBC"
+	case "NOPADDING":
+	default:
+		return nil, javaException("javax.crypto.NoSuchPaddingException", "Unsupported padding %s", parts[2])
+	}
+	return state, nil
+}
+
+// 按算法创建分组密码，DES 和 DESede 与 SunJCE 一样只使用密钥的前 8 或 24 字节
+func (c *cipherState) block() (cipher.Block, error) {
+	var b cipher.Block
+	var err error
+	switch c.algorithm {
+	case "AES":
+		b, err = aes.NewCipher(c.key)
+	case "DES":
+		if len(c.key) < des.BlockSize {
+			return nil, javaException("java.security.InvalidKeyException", "Wrong key size")
+		}
+		b, err = des.NewCipher(c.key[:des.BlockSize])
+	case "DESEDE":
+		if len(c.key) < 3*des.BlockSize {
+			return nil, javaException("java.security.InvalidKeyException", "Wrong key size")
+		}
+		b, err = des.NewTripleDESCipher(c.key[:3*des.BlockSize])
+	}
+	if err != nil {
+		return nil, javaException("java.security.InvalidKeyException", "%v", err)
+	}
+	return b, nil
+}
+
+func (c *cipherState) blockSize() int {
+	switch c.algorithm {
+	case "AES":
+		return aes.BlockSize
+	case "DES", "DESEDE":
+		return des.BlockSize
+	}
+	return 0
+}
+
+// Cipher.init：检查密钥和参数。需要 IV 的模式在加密时没有给出 IV 则使用全 0，
+// Java 会随机生成，模拟执行需要确定的结果
+func (c *cipherState) init(opmode int32, key entity.VMValue, params entity.VMValue) error {
+	if opmode != cipherEncryptMode && opmode != cipherDecryptMode {
+		return javaException("java.lang.UnsupportedOperationException", "Unsupported cipher mode %d", opmode)
+	}
+	obj, ok := key.Ref.(*entity.VMObject)
+	if !ok || obj == nil {
+		return javaException("java.security.InvalidKeyException", "No installed provider supports this key: null")
+	}
+	k, ok := obj.Native.(*secretKey)
+	if !ok {
+		return javaException("java.security.InvalidKeyException", "No installed provider supports this key: %s", convertToClassName(obj.Class))
+	}
+	c.key = append([]byte(nil), k.encoded...)
+	c.params = cipherParams{}
+	if obj, ok := params.Ref.(*entity.VMObject); ok && obj != nil {
+		p, ok := obj.Native.(*cipherParams)
+		if !ok {
+			return javaException("java.security.InvalidAlgorithmParameterException", "Unsupported parameter %s", convertToClassName(obj.Class))
+		}
+		c.params = cipherParams{iv: append([]byte(nil), p.iv...), tagBits: p.tagBits}
+	}
+	if c.algorithm == "RC4" {
+		if len(c.key) == 0 || len(c.key) > 256 {
+			return javaException("java.security.InvalidKeyException", "Key length must be between 1 and 256 bytes")
+		}
+	} else {
+		if _, err := c.block(); err != nil {
+			return err
+		}
+		switch c.mode {
+		case "ECB":
+			if c.params.iv != nil {
+				return javaException("java.security.InvalidAlgorithmParameterException", "ECB mode cannot use IV")
+			}
+		case "GCM":
+			if c.params.iv == nil {
+				if opmode == cipherDecryptMode {
+					return javaException("java.security.InvalidKeyException", "Parameters missing")
+				}
+				c.params = cipherParams{iv: make([]byte, 12), tagBits: 128}
+			}
+		default:
+			if c.params.iv == nil {
+				if opmode == cipherDecryptMode {
+					return javaException("java.security.InvalidKeyException", "Parameters missing")
+				}
+				c.params.iv = make([]byte, c.blockSize())
+			}
+			if len(c.params.iv) != c.blockSize() {
+				return javaException("java.security.InvalidAlgorithmParameterException", "Wrong IV length: must be %d bytes long", c.blockSize())
+			}
+		}
+	}
+	c.opmode = opmode
+	c.buffered = nil
+	return nil
+}
+
+// Cipher.doFinal：处理 update 缓存的数据和 input，之后恢复到 init 之后的状态
+func (c *cipherState) doFinal(input []byte) ([]byte, error) {
+	if c.opmode == 0 {
+		return nil, javaException("java.lang.IllegalStateException", "Cipher not initialized")
+	}
+	data := append(c.buffered, input...)
+	c.buffered = nil
+	if c.algorithm == "RC4" {
+		rc, err := rc4.NewCipher(c.key)
+		if err != nil {
+			return nil, javaException("java.security.InvalidKeyException", "%v", err)
+		}
+		out := make([]byte, len(data))
+		rc.XORKeyStream(out, data)
+		return out, nil
+	}
+	b, err := c.block()
+	if err != nil {
+		return nil, err
+	}
+	encrypt := c.opmode == cipherEncryptMode
+	switch c.mode {
+	case "ECB", "CBC":
+		return c.blockMode(b, encrypt, data)
+	case "GCM":
+		return c.gcm(b, encrypt, data)
+	}
+	var stream cipher.Stream
+	switch c.mode {
+	case "CTR":
+		stream = cipher.NewCTR(b, c.params.iv)
+	case "OFB":
+		stream = cipher.NewOFB(b, c.params.iv)
+	case "CFB":
+		if encrypt {
+			stream = cipher.NewCFBEncrypter(b, c.params.iv)
+		} else {
+			stream = cipher.NewCFBDecrypter(b, c.params.iv)
+		}
+	}
+	out := make([]byte, len(data))
+	stream.XORKeyStream(out, data)
+	return out, nil
+}
+
+// ECB 和 CBC，按需要加上或去掉 PKCS#5 填充
+func (c *cipherState) blockMode(b cipher.Block, encrypt bool, data []byte) ([]byte, error) {
+	size := b.BlockSize()
+	if encrypt && c.padding {
+		n := size - len(data)%size
+		data = append(data, bytes.Repeat([]byte{byte(n)}, n)...)
+	}
+	if len(data)%size != 0 {
+		if encrypt {
+			return nil, javaException("javax.crypto.IllegalBlockSizeException", "Input length not multiple of %d bytes", size)
+		}
+		return nil, javaException("javax.crypto.IllegalBlockSizeException", "Input length must be multiple of %d when decrypting with padded cipher", size)
+	}
+	out := make([]byte, len(data))
+	switch {
+	case c.mode == "CBC" && encrypt:
+		cipher.NewCBCEncrypter(b, c.params.iv).CryptBlocks(out, data)
+	case c.mode == "CBC":
+		cipher.NewCBCDecrypter(b, c.params.iv).CryptBlocks(out, data)
+	default:
+		for i := 0; i < len(data); i += size {
+			if encrypt {
+				b.Encrypt(out[i:i+size], data[i:i+size])
+			} else {
+				b.Decrypt(out[i:i+size], data[i:i+size])
+			}
+		}
+	}
+	if encrypt || !c.padding {
+		return out, nil
+	}
+	if len(out) == 0 {
+		return nil, javaException("javax.crypto.BadPaddingException", "Given final block not properly padded")
+	}
+	n := int(out[len(out)-1])
+	if n == 0 || n > size {
+		return nil, javaException("javax.crypto.BadPaddingException", "Given final block not properly padded")
+	}
+	for _, p := range out[len(out)-n:] {
+		if int(p) != n {
+			return nil, javaException("javax.crypto.BadPaddingException", "Given final block not properly padded")
+		}
+	}
+	return out[:len(out)-n], nil
+}
+
+// AES/GCM，标签附在密文后面。Go 1.18 不能同时指定非标准的 nonce 和标签长度
+func (c *cipherState) gcm(b cipher.Block, encrypt bool, data []byte) ([]byte, error) {
+	tagSize := int(c.params.tagBits / 8)
+	var aead cipher.AEAD
+	var err error
+	switch {
+	case tagSize == 16:
+		aead, err = cipher.NewGCMWithNonceSize(b, len(c.params.iv))
+	case len(c.params.iv) == 12:
+		aead, err = cipher.NewGCMWithTagSize(b, tagSize)
+	default:
+		return nil, javaException("java.security.InvalidAlgorithmParameterException", "Unsupported GCM parameters: %d-byte IV with %d-bit tag", len(c.params.iv), c.params.tagBits)
+	}
+	if err != nil {
+		return nil, javaException("java.security.InvalidAlgorithmParameterException", "%v", err)
+	}
+	if encrypt {
+		return aead.Seal(nil, c.params.iv, data, nil), nil
+	}
+	out, err := aead.Open(nil, c.params.iv, data, nil)
+	if err != nil {
+		return nil, javaException("javax.crypto.AEADBadTagException", "Tag mismatch!")
+	}
+	return out, nil
+}
+
+func newDigest(algorithm string) hash.Hash {
+	switch strings.ToUpper(algorithm) {
+	case "MD5":
+		return md5.New()
+	case "SHA-1", "SHA1", "SHA":
+		return sha1.New()
+	case "SHA-224":
+		return sha256.New224()
+	case "SHA-256":
+		return sha256.New()
+	case "SHA-384":
+		return sha512.New384()
+	case "SHA-512":
+		return sha512.New()
+	}
+	return nil
+}
+
+// 取 this 对象，null 时抛出 NullPointerException
+func nativeThis(v entity.VMValue, class string) (*entity.VMObject, error) {
+	obj, ok := v.Ref.(*entity.VMObject)
+	if !ok || obj == nil {
+		return nil, javaException("java.lang.NullPointerException", "null %s", class)
+	}
+	return obj, nil
+}
+
+func cipherThis(v entity.VMValue) (*cipherState, error) {
+	obj, err := nativeThis(v, "Cipher")
+	if err != nil {
+		return nil, err
+	}
+	c, ok := obj.Native.(*cipherState)
+	if !ok {
+		return nil, javaException("java.lang.IllegalStateException", "Cipher not initialized")
+	}
+	return c, nil
+}
+
+func digestThis(v entity.VMValue) (*digestState, error) {
+	obj, err := nativeThis(v, "MessageDigest")
+	if err != nil {
+		return nil, err
+	}
+	d, ok := obj.Native.(*digestState)
+	if !ok {
+		return nil, javaException("java.lang.IllegalStateException", "MessageDigest not initialized")
+	}
+	return d, nil
+}
+
+// 取 byte[] 参数中 [off, off+length) 的部分
+func byteRangeArg(v entity.VMValue, off int32, length int32) ([]byte, error) {
+	arr, err := arrayArg(v)
+	if err != nil {
+		return nil, err
+	}
+	if off < 0 || length < 0 || int64(off)+int64(length) > int64(len(arr.Data)) {
+		return nil, javaException("java.lang.ArrayIndexOutOfBoundsException", "offset %d, length %d, array length %d", off, length, len(arr.Data))
+	}
+	return ByteArrayData(arr)[off : off+length], nil
+}
+
+// javax.crypto.Cipher、SecretKeySpec、IvParameterSpec、GCMParameterSpec 和 java.security.MessageDigest，
+// 支持 AES、DES、DESede、RC4 以及常用的摘要算法
+func addCryptoStubs(stubs map[string]NativeMethod) {
+	const keySpec = "Ljavax/crypto/spec/SecretKeySpec;->"
+	keyInit := func(args []entity.VMValue, off int32, length int32, algorithm entity.VMValue) (entity.VMValue, error) {
+		data, err := byteRangeArg(args[1], off, length)
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		alg, err := stringArg(algorithm)
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		if len(data) == 0 {
+			return entity.VMValue{}, javaException("java.lang.IllegalArgumentException", "Empty key")
+		}
+		args[0].Ref.(*entity.VMObject).Native = &secretKey{algorithm: alg, encoded: append([]byte(nil), data...)}
+		return entity.VMValue{}, nil
+	}
+	stubs[keySpec+"<init>([BLjava/lang/String;)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		arr, err := arrayArg(args[1])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return keyInit(args, 0, int32(len(arr.Data)), args[2])
+	}
+	stubs[keySpec+"<init>([BIILjava/lang/String;)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return keyInit(args, args[2].Int(), args[3].Int(), args[4])
+	}
+	// 通过 Key 接口调用时从对象的实际类型找到这里
+	keyThis := func(v entity.VMValue) (*secretKey, error) {
+		obj, err := nativeThis(v, "SecretKeySpec")
+		if err != nil {
+			return nil, err
+		}
+		k, _ := obj.Native.(*secretKey)
+		if k == nil {
+			return nil, javaException("java.lang.IllegalStateException", "SecretKeySpec not initialized")
+		}
+		return k, nil
+	}
+	stubs[keySpec+"getEncoded()[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		k, err := keyThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return RefValue(NewByteArray(k.encoded)), nil
+	}
+	stubs[keySpec+"getAlgorithm()Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		k, err := keyThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return stringValue(k.algorithm), nil
+	}
+
+	const ivSpec = "Ljavax/crypto/spec/IvParameterSpec;->"
+	stubs[ivSpec+"<init>([B)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		arr, err := arrayArg(args[1])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		args[0].Ref.(*entity.VMObject).Native = &cipherParams{iv: ByteArrayData(arr)}
+		return entity.VMValue{}, nil
+	}
+	stubs[ivSpec+"<init>([BII)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		iv, err := byteRangeArg(args[1], args[2].Int(), args[3].Int())
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		args[0].Ref.(*entity.VMObject).Native = &cipherParams{iv: append([]byte(nil), iv...)}
+		return entity.VMValue{}, nil
+	}
+	stubs[ivSpec+"getIV()[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		obj, err := nativeThis(args[0], "IvParameterSpec")
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		p, _ := obj.Native.(*cipherParams)
+		if p == nil {
+			return RefValue(nil), nil
+		}
+		return RefValue(NewByteArray(p.iv)), nil
+	}
+	stubs["Ljavax/crypto/spec/GCMParameterSpec;-><init>(I[B)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		arr, err := arrayArg(args[2])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		if args[1].Int() < 0 {
+			return entity.VMValue{}, javaException("java.lang.IllegalArgumentException", "Length argument is negative")
+		}
+		args[0].Ref.(*entity.VMObject).Native = &cipherParams{iv: ByteArrayData(arr), tagBits: args[1].Int()}
+		return entity.VMValue{}, nil
+	}
+
+	const cipherClass = "Ljavax/crypto/Cipher;"
+	getCipher := func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		transformation, err := stringArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		state, err := parseTransformation(transformation)
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return RefValue(&entity.VMObject{Class: cipherClass, Native: state, Fields: make(map[string]entity.VMValue)}), nil
+	}
+	stubs[cipherClass+"->getInstance(Ljava/lang/String;)Ljavax/crypto/Cipher;"] = getCipher
+	// 忽略指定的 provider
+	stubs[cipherClass+"->getInstance(Ljava/lang/String;Ljava/lang/String;)Ljavax/crypto/Cipher;"] = getCipher
+	cipherInit := func(vm *VM, args []entity.VMValue, params entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return entity.VMValue{}, c.init(args[1].Int(), args[2], params)
+	}
+	stubs[cipherClass+"->init(ILjava/security/Key;)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return cipherInit(vm, args, RefValue(nil))
+	}
+	stubs[cipherClass+"->init(ILjava/security/Key;Ljava/security/spec/AlgorithmParameterSpec;)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return cipherInit(vm, args, args[3])
+	}
+	// update 只缓存数据，全部结果由 doFinal 返回，两者拼接的结果与 Java 相同
+	cipherUpdate := func(vm *VM, c *cipherState, data []byte) (entity.VMValue, error) {
+		if c.opmode == 0 {
+			return entity.VMValue{}, javaException("java.lang.IllegalStateException", "Cipher not initialized")
+		}
+		if err := vm.allocate(int64(len(data))); err != nil {
+			return entity.VMValue{}, err
+		}
+		c.buffered = append(c.buffered, data...)
+		return RefValue(NewByteArray(nil)), nil
+	}
+	stubs[cipherClass+"->update([B)[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		arr, err := arrayArg(args[1])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return cipherUpdate(vm, c, ByteArrayData(arr))
+	}
+	stubs[cipherClass+"->update([BII)[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		data, err := byteRangeArg(args[1], args[2].Int(), args[3].Int())
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return cipherUpdate(vm, c, data)
+	}
+	cipherFinal := func(vm *VM, c *cipherState, data []byte) (entity.VMValue, error) {
+		out, err := c.doFinal(data)
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return RefValue(NewByteArray(out)), nil
+	}
+	stubs[cipherClass+"->doFinal()[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return cipherFinal(vm, c, nil)
+	}
+	stubs[cipherClass+"->doFinal([B)[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		arr, err := arrayArg(args[1])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return cipherFinal(vm, c, ByteArrayData(arr))
+	}
+	stubs[cipherClass+"->doFinal([BII)[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		data, err := byteRangeArg(args[1], args[2].Int(), args[3].Int())
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return cipherFinal(vm, c, data)
+	}
+	stubs[cipherClass+"->getIV()[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		if c.params.iv == nil {
+			return RefValue(nil), nil
+		}
+		return RefValue(NewByteArray(c.params.iv)), nil
+	}
+	stubs[cipherClass+"->getBlockSize()I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return IntValue(int32(c.blockSize())), nil
+	}
+	stubs[cipherClass+"->getAlgorithm()Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		c, err := cipherThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return stringValue(c.transformation), nil
+	}
+
+	const digestClass = "Ljava/security/MessageDigest;"
+	getDigest := func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		algorithm, err := stringArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		h := newDigest(algorithm)
+		if h == nil {
+			return entity.VMValue{}, javaException("java.security.NoSuchAlgorithmException", "%s MessageDigest not available", algorithm)
+		}
+		return RefValue(&entity.VMObject{Class: digestClass, Native: &digestState{algorithm: algorithm, hash: h}, Fields: make(map[string]entity.VMValue)}), nil
+	}
+	stubs[digestClass+"->getInstance(Ljava/lang/String;)Ljava/security/MessageDigest;"] = getDigest
+	stubs[digestClass+"->getInstance(Ljava/lang/String;Ljava/lang/String;)Ljava/security/MessageDigest;"] = getDigest
+	stubs[digestClass+"->update(B)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		d, err := digestThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		d.hash.Write([]byte{byte(args[1].Int())})
+		return entity.VMValue{}, nil
+	}
+	stubs[digestClass+"->update([B)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		d, err := digestThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		arr, err := arrayArg(args[1])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		d.hash.Write(ByteArrayData(arr))
+		return entity.VMValue{}, nil
+	}
+	stubs[digestClass+"->update([BII)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		d, err := digestThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		data, err := byteRangeArg(args[1], args[2].Int(), args[3].Int())
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		d.hash.Write(data)
+		return entity.VMValue{}, nil
+	}
+	// digest 之后摘要重置
+	stubs[digestClass+"->digest()[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		d, err := digestThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		sum := d.hash.Sum(nil)
+		d.hash.Reset()
+		return RefValue(NewByteArray(sum)), nil
+	}
+	stubs[digestClass+"->digest([B)[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		d, err := digestThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		arr, err := arrayArg(args[1])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		d.hash.Write(ByteArrayData(arr))
+		sum := d.hash.Sum(nil)
+		d.hash.Reset()
+		return RefValue(NewByteArray(sum)), nil
+	}
+	stubs[digestClass+"->reset()V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		d, err := digestThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		d.hash.Reset()
+		return entity.VMValue{}, nil
+	}
+	stubs[digestClass+"->getDigestLength()I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		d, err := digestThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return IntValue(int32(d.hash.Size())), nil
+	}
+	stubs[digestClass+"->getAlgorithm()Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		d, err := digestThis(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return stringValue(d.algorithm), nil
+	}
+}
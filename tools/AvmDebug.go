@@ -0,0 +1,446 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VMHook 虚拟机执行指令时的回调，返回错误时停止执行并把错误返回给调用者
+type VMHook interface {
+	// BeforeInstruction 在指令执行之前调用
+	BeforeInstruction(vm *VM, frame *VMFrame, insn *entity.Instruction) error
+	// AfterInstruction 在指令正常执行之后调用，抛出异常的指令不会调用
+	AfterInstruction(vm *VM, frame *VMFrame, insn *entity.Instruction) error
+}
+
+// VMCallHook VMHook 可以同时实现的接口，调用没有代码的框架方法（桩函数或未知方法）之后回调
+type VMCallHook interface {
+	AfterFrameworkCall(vm *VM, ref *entity.DexMethodRef, args []entity.VMValue, ret entity.VMValue, err error)
+}
+
+// InstructionHook 把函数适配为只在指令执行之前调用的 VMHook
+type InstructionHook func(vm *VM, frame *VMFrame, insn *entity.Instruction) error
+
+func (h InstructionHook) BeforeInstruction(vm *VM, frame *VMFrame, insn *entity.Instruction) error {
+	return h(vm, frame, insn)
+}
+
+func (h InstructionHook) AfterInstruction(vm *VM, frame *VMFrame, insn *entity.Instruction) error {
+	return nil
+}
+
+// NewTraceHook 返回把每条执行的指令写到 w 的 VMHook，按调用深度缩进
+func NewTraceHook(w io.Writer) VMHook {
+	return InstructionHook(func(vm *VM, frame *VMFrame, insn *entity.Instruction) error {
+		indent := strings.Repeat("  ", vm.Depth()-1)
+		_, err := fmt.Fprintf(w, "%s%s %04x: %s\n", indent, frame.Name(), insn.Offset, FormatInstruction(frame.Dex(), insn))
+		return err
+	})
+}
+
+// FormatInstruction 返回指令的文本形式，常量池索引解析为字符串、类型、字段或方法
+func FormatInstruction(dex *entity.DexFile, insn *entity.Instruction) string {
+	name := OpcodeName(insn)
+	if insn.Payload != nil {
+		return name
+	}
+	var operands []string
+	regs := make([]string, len(insn.Regs))
+	for i, r := range insn.Regs {
+		regs[i] = fmt.Sprintf("v%d", r)
+	}
+	format := InsnFormatOf(insn)
+	switch format {
+	case entity.Format35c, entity.Format3rc, entity.Format45cc, entity.Format4rcc:
+		operands = append(operands, "{"+strings.Join(regs, ", ")+"}")
+	default:
+		operands = append(operands, regs...)
+	}
+	switch format {
+	case entity.Format11n, entity.Format21s, entity.Format21h, entity.Format22b, entity.Format22s, entity.Format31i, entity.Format51l:
+		operands = append(operands, fmt.Sprintf("#%d", insn.Literal))
+	case entity.Format10t, entity.Format20t, entity.Format21t, entity.Format22t, entity.Format30t, entity.Format31t:
+		operands = append(operands, fmt.Sprintf("%04x", int64(insn.Offset)+int64(insn.Branch)))
+	}
+	if dex != nil && Opcodes[insn.Opcode].Index != entity.IndexNone {
+		_, target, err := xrefTarget(dex, insn)
+		switch {
+		case err != nil || target == "":
+			operands = append(operands, fmt.Sprintf("@%d", insn.Index))
+		case Opcodes[insn.Opcode].Index == entity.IndexString:
+			operands = append(operands, strconv.Quote(target))
+		default:
+			operands = append(operands, target)
+		}
+	}
+	if len(operands) == 0 {
+		return name
+	}
+	return name + " " + strings.Join(operands, ", ")
+}
+
+// FormatValue 返回值的文本形式，字符串加引号，对象和数组只显示类型和地址
+func FormatValue(v entity.VMValue) string {
+	switch v.Kind {
+	case entity.VM_VOID:
+		return "void"
+	case entity.VM_LONG:
+		return fmt.Sprintf("%d (long)", v.Long())
+	case entity.VM_FLOAT:
+		return fmt.Sprintf("%g (float)", v.Float())
+	case entity.VM_DOUBLE:
+		return fmt.Sprintf("%g (double)", v.Double())
+	case entity.VM_REF:
+		if s, ok := GoString(v.Ref); ok {
+			return strconv.Quote(s)
+		}
+		if v.Ref == nil {
+			return "null"
+		}
+		if obj, ok := v.Ref.(*entity.VMObject); ok {
+			if symbol, ok := obj.Native.(*entity.VMSymbol); ok {
+				return fmt.Sprintf("<%s>", symbol.Method)
+			}
+		}
+		return fmt.Sprintf("%s@%p", runtimeType(v.Ref), v.Ref)
+	}
+	return fmt.Sprintf("%d (0x%x)", v.Int(), uint32(v.Raw))
+}
+
+// FormatObject 返回堆上对象的内容：对象的字段或数组的元素
+func FormatObject(ref interface{}) string {
+	var b strings.Builder
+	switch obj := ref.(type) {
+	case *entity.VMObject:
+		fmt.Fprintf(&b, "%s@%p", obj.Class, obj)
+		switch native := obj.Native.(type) {
+		case nil:
+		case *entity.VMThrowable:
+			fmt.Fprintf(&b, " message=%s", FormatValue(native.Message))
+		case *entity.VMSymbol:
+			fmt.Fprintf(&b, " symbol=%s", native.Method)
+		default:
+			fmt.Fprintf(&b, " native=%s", strconv.Quote(javaToString(RefValue(obj))))
+		}
+		keys := make([]string, 0, len(obj.Fields))
+		for key := range obj.Fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "\n  %s = %s", key, FormatValue(obj.Fields[key]))
+		}
+	case *entity.VMArray:
+		fmt.Fprintf(&b, "%s@%p length=%d", obj.Type, obj, len(obj.Data))
+		for i, v := range obj.Data {
+			if i == 64 {
+				fmt.Fprintf(&b, "\n  ...")
+				break
+			}
+			fmt.Fprintf(&b, "\n  [%d] = %s", i, FormatValue(v))
+		}
+	case nil:
+		b.WriteString("null")
+	default:
+		fmt.Fprintf(&b, "%v", ref)
+	}
+	return b.String()
+}
+
+// ErrDebuggerQuit 在调试控制台输入 quit 时返回，停止模拟执行
+var ErrDebuggerQuit = errors.New("debugger: quit")
+
+// 调试控制台的运行模式
+const (
+	debugContinue = iota // 运行到断点或观察点
+	debugStep            // 停在下一条指令
+	debugNext            // 停在当前方法或调用者的下一条指令，不进入被调用的方法
+	debugFinish          // 停在调用者的下一条指令
+)
+
+type debugBreakpoint struct {
+	method string
+	pc     uint32
+}
+
+type registerWatch struct {
+	method string
+	reg    uint32
+	before map[*VMFrame]entity.VMValue
+}
+
+// Debugger 交互式调试控制台，从 In 读取命令，输出到 Out。
+// 作为 VMHook 加入 VM.Hooks 后，在第一条指令、断点和观察点处停下等待命令
+type Debugger struct {
+	In  *bufio.Reader
+	Out io.Writer
+
+	mode        int
+	depth       int // 输入 next/finish 时的调用深度
+	breakpoints []debugBreakpoint
+	registers   []*registerWatch
+	fields      map[string]bool
+}
+
+// NewDebugger 创建调试控制台，初始为单步模式
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		In:     bufio.NewReader(in),
+		Out:    out,
+		mode:   debugStep,
+		fields: make(map[string]bool),
+	}
+}
+
+// AddBreakpoint 在方法的指定偏移处设置断点，method 为方法签名，
+// 不带参数列表时匹配所有同名方法
+func (d *Debugger) AddBreakpoint(method string, pc uint32) {
+	d.breakpoints = append(d.breakpoints, debugBreakpoint{method, pc})
+}
+
+// WatchRegister 在方法的寄存器被修改时停下，method 的格式同 AddBreakpoint
+func (d *Debugger) WatchRegister(method string, reg uint32) {
+	d.registers = append(d.registers, &registerWatch{method: method, reg: reg, before: make(map[*VMFrame]entity.VMValue)})
+}
+
+// WatchField 在字段被读写时停下，field 为 Lcom/a/B;->x:I 形式的字段签名
+func (d *Debugger) WatchField(field string) {
+	d.fields[field] = true
+}
+
+// Continue 不再单步，只在断点和观察点处停下
+func (d *Debugger) Continue() {
+	d.mode = debugContinue
+}
+
+// 方法签名是否匹配，pattern 不带参数列表时只比较类和方法名
+func matchMethod(pattern string, signature string) bool {
+	if pattern == signature {
+		return true
+	}
+	return !strings.Contains(pattern, "(") && strings.HasPrefix(signature, pattern+"(")
+}
+
+func (d *Debugger) BeforeInstruction(vm *VM, frame *VMFrame, insn *entity.Instruction) error {
+	name := frame.Name()
+	for _, watch := range d.registers {
+		if matchMethod(watch.method, name) && int(watch.reg) < len(frame.regs) {
+			watch.before[frame] = frame.Register(watch.reg)
+		}
+	}
+	var reason string
+	depth := vm.Depth()
+	switch {
+	case d.mode == debugStep:
+		reason = "step"
+	case d.mode == debugNext && depth <= d.depth:
+		reason = "step"
+	case d.mode == debugFinish && depth < d.depth:
+		reason = "finish"
+	}
+	for _, bp := range d.breakpoints {
+		if bp.pc == insn.Offset && matchMethod(bp.method, name) {
+			reason = fmt.Sprintf("breakpoint %s@0x%x", bp.method, bp.pc)
+		}
+	}
+	if field := d.watchedField(frame, insn); field != "" {
+		reason = fmt.Sprintf("watchpoint %s %s", fieldAccess(insn), field)
+	}
+	if reason == "" {
+		return nil
+	}
+	return d.prompt(vm, frame, insn, reason)
+}
+
+func (d *Debugger) AfterInstruction(vm *VM, frame *VMFrame, insn *entity.Instruction) error {
+	var reasons []string
+	for _, watch := range d.registers {
+		old, ok := watch.before[frame]
+		if !ok {
+			continue
+		}
+		delete(watch.before, frame)
+		now := frame.Register(watch.reg)
+		if now != old {
+			reasons = append(reasons, fmt.Sprintf("watchpoint v%d: %s -> %s", watch.reg, FormatValue(old), FormatValue(now)))
+		}
+	}
+	if len(reasons) == 0 {
+		return nil
+	}
+	return d.prompt(vm, frame, insn, strings.Join(reasons, ", "))
+}
+
+// 指令访问的被观察字段，没有时返回空
+func (d *Debugger) watchedField(frame *VMFrame, insn *entity.Instruction) string {
+	if len(d.fields) == 0 || insn.Opcode < 0x52 || insn.Opcode > 0x6d {
+		return ""
+	}
+	ref, err := GetFieldRef(frame.dex, insn.Index)
+	if err != nil {
+		return ""
+	}
+	if key := GetFieldSignature(ref); d.fields[key] {
+		return key
+	}
+	return ""
+}
+
+func fieldAccess(insn *entity.Instruction) string {
+	if (insn.Opcode >= 0x59 && insn.Opcode <= 0x5f) || insn.Opcode >= 0x67 {
+		return "write"
+	}
+	return "read"
+}
+
+const debuggerHelp = `commands:
+  s, step              execute one instruction, entering calls
+  n, next              execute one instruction, stepping over calls
+  f, finish            run until the current method returns
+  c, continue          run until a breakpoint or watchpoint
+  r, regs              print the registers of the current frame
+  p <vN|field>         print a register or static field; objects and arrays are expanded
+  bt                   print the call stack
+  b <method>@<pc>      set a breakpoint, e.g. b Lcom/a/B;->foo@0x12
+  w <vN>               watch a register of the current method
+  wf <field>           watch reads and writes of a field, e.g. wf Lcom/a/B;->x:I
+  q, quit              stop execution
+`
+
+// 停下并执行命令，直到输入恢复执行的命令
+func (d *Debugger) prompt(vm *VM, frame *VMFrame, insn *entity.Instruction, reason string) error {
+	fmt.Fprintf(d.Out, "[%s] %s %04x: %s\n", reason, frame.Name(), insn.Offset, FormatInstruction(frame.dex, insn))
+	for {
+		fmt.Fprint(d.Out, "(avm) ")
+		line, err := d.In.ReadString('\n')
+		if err != nil && line == "" {
+			// 输入结束后一直运行下去
+			fmt.Fprintln(d.Out)
+			d.mode = debugContinue
+			d.breakpoints = nil
+			d.registers = nil
+			d.fields = make(map[string]bool)
+			return nil
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		arg := ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+		switch fields[0] {
+		case "s", "step":
+			d.mode = debugStep
+			return nil
+		case "n", "next":
+			d.mode, d.depth = debugNext, vm.Depth()
+			return nil
+		case "f", "finish":
+			d.mode, d.depth = debugFinish, vm.Depth()
+			return nil
+		case "c", "continue":
+			d.mode = debugContinue
+			return nil
+		case "q", "quit":
+			return ErrDebuggerQuit
+		case "r", "regs":
+			d.printRegisters(frame)
+		case "p", "print":
+			d.print(vm, frame, arg)
+		case "bt":
+			for _, f := range vm.stackTrace() {
+				fmt.Fprintf(d.Out, "  %s\n", FormatStackFrame(f))
+			}
+		case "b", "break":
+			i := strings.LastIndex(arg, "@")
+			if i < 0 {
+				fmt.Fprintln(d.Out, "usage: b <method>@<pc>")
+				continue
+			}
+			pc, err := strconv.ParseUint(arg[i+1:], 0, 32)
+			if err != nil {
+				fmt.Fprintf(d.Out, "invalid pc: %v\n", err)
+				continue
+			}
+			d.AddBreakpoint(arg[:i], uint32(pc))
+		case "w", "watch":
+			reg, ok := d.register(frame, arg)
+			if ok {
+				d.WatchRegister(frame.Name(), reg)
+				// 当前指令的修改也要检查
+				d.registers[len(d.registers)-1].before[frame] = frame.Register(reg)
+			}
+		case "wf":
+			if arg == "" {
+				fmt.Fprintln(d.Out, "usage: wf <field>")
+				continue
+			}
+			d.WatchField(arg)
+		case "h", "help":
+			fmt.Fprint(d.Out, debuggerHelp)
+		default:
+			fmt.Fprintf(d.Out, "unknown command %q, type help for a list\n", fields[0])
+		}
+	}
+}
+
+// 解析 vN 或 pN 形式的寄存器，pN 为参数寄存器
+func (d *Debugger) register(frame *VMFrame, arg string) (uint32, bool) {
+	if len(arg) > 1 && (arg[0] == 'v' || arg[0] == 'p') {
+		if n, err := strconv.ParseUint(arg[1:], 10, 32); err == nil {
+			reg := uint32(n)
+			if arg[0] == 'p' {
+				reg += uint32(frame.code.RegistersSize - frame.code.InsSize)
+			}
+			if int(reg) < len(frame.regs) {
+				return reg, true
+			}
+		}
+	}
+	fmt.Fprintf(d.Out, "invalid register %q\n", arg)
+	return 0, false
+}
+
+func (d *Debugger) printRegisters(frame *VMFrame) {
+	params := uint32(frame.code.RegistersSize - frame.code.InsSize)
+	for r := range frame.regs {
+		reg := uint32(r)
+		name := fmt.Sprintf("v%d", reg)
+		if reg >= params {
+			name += fmt.Sprintf(" (p%d)", reg-params)
+		}
+		fmt.Fprintf(d.Out, "  %-10s %s\n", name, FormatValue(frame.Register(reg)))
+	}
+}
+
+func (d *Debugger) print(vm *VM, frame *VMFrame, arg string) {
+	if strings.Contains(arg, "->") {
+		value, ok := vm.Statics[arg]
+		if !ok {
+			fmt.Fprintf(d.Out, "%s is not set\n", arg)
+			return
+		}
+		d.printValue(value)
+		return
+	}
+	reg, ok := d.register(frame, arg)
+	if ok {
+		d.printValue(frame.Register(reg))
+	}
+}
+
+func (d *Debugger) printValue(value entity.VMValue) {
+	if value.Kind == entity.VM_REF {
+		fmt.Fprintln(d.Out, FormatObject(value.Ref))
+		return
+	}
+	fmt.Fprintln(d.Out, FormatValue(value))
+}
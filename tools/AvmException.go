@@ -0,0 +1,278 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+	"strings"
+)
+
+// JavaException 模拟代码中抛出的 Java 异常，没有被捕获时作为错误返回给调用者
+type JavaException struct {
+	Object *entity.VMObject // 异常对象，Native 为 *entity.VMThrowable
+}
+
+// Throwable 返回异常的消息和调用栈
+func (e *JavaException) Throwable() *entity.VMThrowable {
+	return throwableData(e.Object)
+}
+
+func (e *JavaException) Error() string {
+	t := e.Throwable()
+	var b strings.Builder
+	b.WriteString(convertToClassName(e.Object.Class))
+	if t.Message.Ref != nil {
+		b.WriteString(": ")
+		b.WriteString(javaToString(t.Message))
+	}
+	for _, frame := range t.Trace {
+		b.WriteString("\n\tat ")
+		b.WriteString(FormatStackFrame(frame))
+	}
+	if t.Cause.Ref != nil {
+		if cause, ok := t.Cause.Ref.(*entity.VMObject); ok {
+			b.WriteString("\nCaused by: ")
+			b.WriteString((&JavaException{Object: cause}).Error())
+		}
+	}
+	return b.String()
+}
+
+// FormatStackFrame 按 Java 调用栈的格式输出一帧，例如 com.a.B.foo(B.java:12) pc 0x3
+func FormatStackFrame(frame entity.VMStackFrame) string {
+	location := "Unknown Source"
+	if frame.Source != "" {
+		location = frame.Source
+		if frame.Line > 0 {
+			location = fmt.Sprintf("%s:%d", frame.Source, frame.Line)
+		}
+	} else if frame.Line > 0 {
+		location = fmt.Sprintf("Unknown Source:%d", frame.Line)
+	}
+	name := frame.Method
+	if frame.Class != "" {
+		name = convertToClassName(frame.Class) + "." + frame.Method
+	}
+	return fmt.Sprintf("%s(%s) pc 0x%x", name, location, frame.Pc)
+}
+
+// 取异常对象的 Native 数据，没有时创建
+func throwableData(obj *entity.VMObject) *entity.VMThrowable {
+	t, ok := obj.Native.(*entity.VMThrowable)
+	if !ok {
+		t = &entity.VMThrowable{Message: RefValue(nil), Cause: RefValue(nil)}
+		obj.Native = t
+	}
+	return t
+}
+
+// 模拟代码中抛出的 Java 异常，调用栈在传播时由虚拟机填写
+func javaException(class string, format string, args ...interface{}) error {
+	class = convertToDexClassName(class)
+	obj := &entity.VMObject{Class: class, Fields: make(map[string]entity.VMValue)}
+	throwableData(obj).Message = stringValue(fmt.Sprintf(format, args...))
+	return &JavaException{Object: obj}
+}
+
+// 常用异常类和框架类的父类
+var frameworkSuperclass = map[string]string{
+	"Ljava/lang/Throwable;":                              "Ljava/lang/Object;",
+	"Ljava/lang/Exception;":                              "Ljava/lang/Throwable;",
+	"Ljava/lang/Error;":                                  "Ljava/lang/Throwable;",
+	"Ljava/lang/RuntimeException;":                       "Ljava/lang/Exception;",
+	"Ljava/lang/ArithmeticException;":                    "Ljava/lang/RuntimeException;",
+	"Ljava/lang/NullPointerException;":                   "Ljava/lang/RuntimeException;",
+	"Ljava/lang/ClassCastException;":                     "Ljava/lang/RuntimeException;",
+	"Ljava/lang/IllegalArgumentException;":               "Ljava/lang/RuntimeException;",
+	"Ljava/lang/IllegalStateException;":                  "Ljava/lang/RuntimeException;",
+	"Ljava/lang/UnsupportedOperationException;":          "Ljava/lang/RuntimeException;",
+	"Ljava/lang/IndexOutOfBoundsException;":              "Ljava/lang/RuntimeException;",
+	"Ljava/lang/ArrayStoreException;":                    "Ljava/lang/RuntimeException;",
+	"Ljava/lang/NegativeArraySizeException;":             "Ljava/lang/RuntimeException;",
+	"Ljava/lang/ArrayIndexOutOfBoundsException;":         "Ljava/lang/IndexOutOfBoundsException;",
+	"Ljava/lang/StringIndexOutOfBoundsException;":        "Ljava/lang/IndexOutOfBoundsException;",
+	"Ljava/lang/NumberFormatException;":                  "Ljava/lang/IllegalArgumentException;",
+	"Ljava/io/IOException;":                              "Ljava/lang/Exception;",
+	"Ljava/io/UnsupportedEncodingException;":             "Ljava/io/IOException;",
+	"Ljava/lang/LinkageError;":                           "Ljava/lang/Error;",
+	"Ljava/lang/ExceptionInInitializerError;":            "Ljava/lang/LinkageError;",
+	"Ljava/lang/NoClassDefFoundError;":                   "Ljava/lang/LinkageError;",
+	"Ljava/security/GeneralSecurityException;":           "Ljava/lang/Exception;",
+	"Ljava/security/NoSuchAlgorithmException;":           "Ljava/security/GeneralSecurityException;",
+	"Ljavax/crypto/BadPaddingException;":                 "Ljava/security/GeneralSecurityException;",
+	"Ljavax/crypto/AEADBadTagException;":                 "Ljavax/crypto/BadPaddingException;",
+	"Ljavax/crypto/IllegalBlockSizeException;":           "Ljava/security/GeneralSecurityException;",
+	"Ljavax/crypto/NoSuchPaddingException;":              "Ljava/security/GeneralSecurityException;",
+	"Ljava/security/KeyException;":                       "Ljava/security/GeneralSecurityException;",
+	"Ljava/security/InvalidKeyException;":                "Ljava/security/KeyException;",
+	"Ljava/security/InvalidAlgorithmParameterException;": "Ljava/security/GeneralSecurityException;",
+	"Ljava/lang/ReflectiveOperationException;":           "Ljava/lang/Exception;",
+	"Ljava/lang/ClassNotFoundException;":                 "Ljava/lang/ReflectiveOperationException;",
+	"Ljava/util/NoSuchElementException;":                 "Ljava/lang/RuntimeException;",
+	"Ljava/lang/SecurityException;":                      "Ljava/lang/RuntimeException;",
+	"Ljava/lang/StackOverflowError;":                     "Ljava/lang/Error;",
+	"Ljava/lang/OutOfMemoryError;":                       "Ljava/lang/Error;",
+	"Ljava/lang/AssertionError;":                         "Ljava/lang/Error;",
+	"Ljava/io/FileNotFoundException;":                    "Ljava/io/IOException;",
+	// 组件和 Context
+	"Landroid/content/Context;":          "Ljava/lang/Object;",
+	"Landroid/content/ContextWrapper;":   "Landroid/content/Context;",
+	"Landroid/app/ContextImpl;":          "Landroid/content/Context;",
+	"Landroid/view/ContextThemeWrapper;": "Landroid/content/ContextWrapper;",
+	"Landroid/app/Activity;":             "Landroid/view/ContextThemeWrapper;",
+	"Landroid/app/Application;":          "Landroid/content/ContextWrapper;",
+	"Landroid/app/Service;":              "Landroid/content/ContextWrapper;",
+}
+
+// 类的父类，类路径之外的类只知道常用异常类和框架类的父类
+func (vm *VM) superClass(name string) string {
+	if vm.ClassPath != nil && vm.ClassPath.Classes[name] != nil {
+		return vm.ClassPath.Classes[name].SuperClass
+	}
+	return frameworkSuperclass[name]
+}
+
+// 当前的模拟调用栈，最内层在前
+func (vm *VM) stackTrace() []entity.VMStackFrame {
+	var trace []entity.VMStackFrame
+	for i := len(vm.frames) - 1; i >= 0; i-- {
+		trace = append(trace, vm.stackFrame(vm.frames[i]))
+	}
+	return trace
+}
+
+func (vm *VM) stackFrame(f *VMFrame) entity.VMStackFrame {
+	frame := entity.VMStackFrame{Method: f.name(), Pc: f.pc}
+	if f.method == nil {
+		return frame
+	}
+	class := f.method.Class
+	frame.Class = class.Name
+	frame.Method = f.method.Ref.Name
+	frame.Proto = GetProtoDescriptor(f.method.Ref.Proto)
+	if class.Def.Source_file_idx_ != entity.NO_INDEX {
+		frame.Source, _ = GetStringById(class.Dex, class.Def.Source_file_idx_)
+	}
+	if f.code.DebbugInfoOff != 0 {
+		key := codeKey{f.dex, f.code.DebbugInfoOff}
+		info, ok := vm.debugInfo[key]
+		if !ok {
+			info, _ = ReadDebugInfo(f.dex, f.code.DebbugInfoOff)
+			vm.debugInfo[key] = info
+		}
+		frame.Line = SourceLine(info, f.pc)
+	}
+	return frame
+}
+
+// SourceLine 根据调试信息计算指令对应的源代码行号，没有时返回 0
+func SourceLine(info *entity.DexDebugInfo, pc uint32) int {
+	if info == nil {
+		return 0
+	}
+	addr, line := uint32(0), int(info.LineStart)
+	result := 0
+	for _, event := range info.Events {
+		switch {
+		case event.Op == entity.DBG_ADVANCE_PC:
+			addr += event.AddrDiff
+		case event.Op == entity.DBG_ADVANCE_LINE:
+			line += int(event.LineDiff)
+		case event.Op >= entity.DBG_FIRST_SPECIAL:
+			adjusted := int(event.Op - entity.DBG_FIRST_SPECIAL)
+			addr += uint32(adjusted / entity.DBG_LINE_RANGE)
+			line += entity.DBG_LINE_BASE + adjusted%entity.DBG_LINE_RANGE
+			if addr > pc {
+				return result
+			}
+			result = line
+		}
+	}
+	return result
+}
+
+// 在当前帧的 try 块中查找能处理异常的 handler
+func (vm *VM) findHandler(f *VMFrame, addr uint32, obj *entity.VMObject) (uint32, bool, error) {
+	try := findTry(f.code.Tries, addr)
+	if try == nil {
+		return 0, false, nil
+	}
+	for _, handler := range try.Handlers {
+		typ, err := GetTypeName(f.dex, handler.TypeIdx)
+		if err != nil {
+			return 0, false, err
+		}
+		if vm.isInstance(obj, typ) {
+			return handler.Addr, true, nil
+		}
+	}
+	if try.CatchAllAddr >= 0 {
+		return uint32(try.CatchAllAddr), true, nil
+	}
+	return 0, false, nil
+}
+
+// Throwable 的构造方法保存消息和原因，并记录构造时的调用栈
+func throwableInit(message func(args []entity.VMValue) (entity.VMValue, entity.VMValue)) NativeMethod {
+	return func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		obj, ok := args[0].Ref.(*entity.VMObject)
+		if !ok {
+			return entity.VMValue{}, fmt.Errorf("%s is not a throwable", runtimeType(args[0].Ref))
+		}
+		t := throwableData(obj)
+		t.Message, t.Cause = message(args[1:])
+		// 跳过异常类自身的构造方法
+		trace := vm.stackTrace()
+		for len(trace) > 0 && trace[0].Method == "<init>" && vm.isInstance(obj, trace[0].Class) {
+			trace = trace[1:]
+		}
+		t.Trace = trace
+		return entity.VMValue{}, nil
+	}
+}
+
+func addThrowableStubs(stubs map[string]NativeMethod) {
+	const class = "Ljava/lang/Throwable;->"
+	null := RefValue(nil)
+	stubs[class+"<init>()V"] = throwableInit(func(args []entity.VMValue) (entity.VMValue, entity.VMValue) {
+		return null, null
+	})
+	stubs[class+"<init>(Ljava/lang/String;)V"] = throwableInit(func(args []entity.VMValue) (entity.VMValue, entity.VMValue) {
+		return args[0], null
+	})
+	stubs[class+"<init>(Ljava/lang/String;Ljava/lang/Throwable;)V"] = throwableInit(func(args []entity.VMValue) (entity.VMValue, entity.VMValue) {
+		return args[0], args[1]
+	})
+	stubs[class+"<init>(Ljava/lang/Throwable;)V"] = throwableInit(func(args []entity.VMValue) (entity.VMValue, entity.VMValue) {
+		// 与 Java 一致，消息为原因的 toString()
+		if cause, ok := args[0].Ref.(*entity.VMObject); ok {
+			return stringValue(throwableString(cause)), args[0]
+		}
+		return null, null
+	})
+	getMessage := func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return throwableData(args[0].Ref.(*entity.VMObject)).Message, nil
+	}
+	stubs[class+"getMessage()Ljava/lang/String;"] = getMessage
+	stubs[class+"getLocalizedMessage()Ljava/lang/String;"] = getMessage
+	stubs[class+"getCause()Ljava/lang/Throwable;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return throwableData(args[0].Ref.(*entity.VMObject)).Cause, nil
+	}
+	stubs[class+"toString()Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(throwableString(args[0].Ref.(*entity.VMObject))), nil
+	}
+	stubs[class+"printStackTrace()V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		trace := (&JavaException{Object: args[0].Ref.(*entity.VMObject)}).Error()
+		vm.Logs = append(vm.Logs, "W/System.err: "+trace)
+		debugPrint("%s\n", trace)
+		return entity.VMValue{}, nil
+	}
+}
+
+// Throwable.toString()：类名加消息
+func throwableString(obj *entity.VMObject) string {
+	t := throwableData(obj)
+	if t.Message.Ref == nil {
+		return convertToClassName(obj.Class)
+	}
+	return convertToClassName(obj.Class) + ": " + javaToString(t.Message)
+}
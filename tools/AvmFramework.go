@@ -0,0 +1,864 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// DefaultStubs 返回常用框架方法的默认实现：String、StringBuilder、Integer、Math、
+// Arrays、android.util.Base64、android.util.Log、System.arraycopy、Throwable、
+// javax.crypto 的 Cipher 和密钥参数、MessageDigest，以及 Context 的常用方法
+func DefaultStubs() map[string]NativeMethod {
+	stubs := map[string]NativeMethod{
+		"Ljava/lang/Object;-><init>()V": func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			return entity.VMValue{}, nil
+		},
+		"Ljava/lang/Object;->getClass()Ljava/lang/Class;": func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			return RefValue(vm.classObject(runtimeType(args[0].Ref))), nil
+		},
+		"Ljava/lang/Object;->equals(Ljava/lang/Object;)Z": func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			return BoolValue(args[0].Ref == args[1].Ref), nil
+		},
+		"Ljava/lang/Object;->toString()Ljava/lang/String;": func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			return stringValue(javaToString(args[0])), nil
+		},
+		"Ljava/lang/System;->arraycopy(Ljava/lang/Object;ILjava/lang/Object;II)V": systemArraycopy,
+	}
+	addStringStubs(stubs)
+	addStringBuilderStubs(stubs)
+	addIntegerStubs(stubs)
+	addMathStubs(stubs)
+	addArraysStubs(stubs)
+	addBase64Stubs(stubs)
+	addThrowableStubs(stubs)
+	addCryptoStubs(stubs)
+	addContextStubs(stubs)
+	for _, level := range []string{"v", "d", "i", "w", "e"} {
+		tag := strings.ToUpper(level)
+		stubs["Landroid/util/Log;->"+level+"(Ljava/lang/String;Ljava/lang/String;)I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			line := fmt.Sprintf("%s/%s: %s", tag, javaToString(args[0]), javaToString(args[1]))
+			vm.Logs = append(vm.Logs, line)
+			debugPrint("%s\n", line)
+			return IntValue(int32(len(line))), nil
+		}
+	}
+	return stubs
+}
+
+func stringValue(s string) entity.VMValue {
+	return RefValue(NewStringObject(s))
+}
+
+// 取 String 参数，null 时抛出 NullPointerException
+func stringArg(v entity.VMValue) (string, error) {
+	if v.Ref == nil {
+		return "", javaException("java.lang.NullPointerException", "null string")
+	}
+	s, ok := GoString(v.Ref)
+	if !ok {
+		return "", fmt.Errorf("%s is not a string", runtimeType(v.Ref))
+	}
+	return s, nil
+}
+
+// 取数组参数，null 时抛出 NullPointerException
+func arrayArg(v entity.VMValue) (*entity.VMArray, error) {
+	if v.Ref == nil {
+		return nil, javaException("java.lang.NullPointerException", "null array")
+	}
+	arr, ok := v.Ref.(*entity.VMArray)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an array", runtimeType(v.Ref))
+	}
+	return arr, nil
+}
+
+// ByteArrayData 取 byte[] 的内容
+func ByteArrayData(arr *entity.VMArray) []byte {
+	data := make([]byte, len(arr.Data))
+	for i, v := range arr.Data {
+		data[i] = byte(v.Raw)
+	}
+	return data
+}
+
+// NewByteArray 创建 byte[]
+func NewByteArray(data []byte) *entity.VMArray {
+	arr := &entity.VMArray{Type: "[B", Data: make([]entity.VMValue, len(data))}
+	for i, b := range data {
+		arr.Data[i] = IntValue(int32(int8(b)))
+	}
+	return arr
+}
+
+func charArrayData(arr *entity.VMArray) []uint16 {
+	data := make([]uint16, len(arr.Data))
+	for i, v := range arr.Data {
+		data[i] = uint16(v.Raw)
+	}
+	return data
+}
+
+func newCharArray(units []uint16) *entity.VMArray {
+	arr := &entity.VMArray{Type: "[C", Data: make([]entity.VMValue, len(units))}
+	for i, u := range units {
+		arr.Data[i] = IntValue(int32(u))
+	}
+	return arr
+}
+
+// 按 String.valueOf(Object) 的规则转换为字符串
+func javaToString(v entity.VMValue) string {
+	if v.Ref == nil {
+		return "null"
+	}
+	obj, ok := v.Ref.(*entity.VMObject)
+	if !ok {
+		return fmt.Sprintf("%s@%p", runtimeType(v.Ref), v.Ref)
+	}
+	switch native := obj.Native.(type) {
+	case string:
+		return native
+	case []uint16:
+		return utf16UnitsToString(native)
+	case int32:
+		return strconv.Itoa(int(native))
+	}
+	return fmt.Sprintf("%s@%p", convertToClassName(obj.Class), obj)
+}
+
+// 字符串编码，只支持 UTF-8 和单字节编码
+func encodeString(s string, charset string) ([]byte, error) {
+	switch strings.ToUpper(charset) {
+	case "UTF-8", "UTF8":
+		return []byte(s), nil
+	case "ISO-8859-1", "US-ASCII", "ASCII", "LATIN1":
+		units := stringToUTF16Units(s)
+		data := make([]byte, len(units))
+		for i, u := range units {
+			if u > 0xff {
+				u = '?'
+			}
+			data[i] = byte(u)
+		}
+		return data, nil
+	}
+	return nil, javaException("java.io.UnsupportedEncodingException", "%s", charset)
+}
+
+func decodeString(data []byte, charset string) (string, error) {
+	switch strings.ToUpper(charset) {
+	case "UTF-8", "UTF8":
+		// 无效的字节替换为 U+FFFD
+		return string([]rune(string(data))), nil
+	case "ISO-8859-1", "US-ASCII", "ASCII", "LATIN1":
+		units := make([]uint16, len(data))
+		for i, b := range data {
+			units[i] = uint16(b)
+		}
+		return utf16UnitsToString(units), nil
+	}
+	return "", javaException("java.io.UnsupportedEncodingException", "%s", charset)
+}
+
+// 以 String 为 this 的方法，units 为 UTF-16 编码单元
+func stringMethod(fn func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error)) NativeMethod {
+	return func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		s, err := stringArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return fn(vm, s, stringToUTF16Units(s), args[1:])
+	}
+}
+
+// String 的构造方法，把内容保存到 this
+func stringInit(fn func(args []entity.VMValue) (string, error)) NativeMethod {
+	return func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		s, err := fn(args[1:])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		args[0].Ref.(*entity.VMObject).Native = s
+		return entity.VMValue{}, nil
+	}
+}
+
+func indexOfUnits(units []uint16, sub []uint16, from int) int {
+	if from < 0 {
+		from = 0
+	}
+	for i := from; i+len(sub) <= len(units); i++ {
+		match := true
+		for j := range sub {
+			if units[i+j] != sub[j] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return i
+		}
+	}
+	return -1
+}
+
+func checkRange(begin int32, end int32, length int) error {
+	if begin < 0 || end > int32(length) || begin > end {
+		return javaException("java.lang.StringIndexOutOfBoundsException", "begin %d, end %d, length %d", begin, end, length)
+	}
+	return nil
+}
+
+func addStringStubs(stubs map[string]NativeMethod) {
+	const class = "Ljava/lang/String;->"
+	stubs[class+"<init>()V"] = stringInit(func(args []entity.VMValue) (string, error) {
+		return "", nil
+	})
+	stubs[class+"<init>(Ljava/lang/String;)V"] = stringInit(func(args []entity.VMValue) (string, error) {
+		return stringArg(args[0])
+	})
+	stubs[class+"<init>([B)V"] = stringInit(func(args []entity.VMValue) (string, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return "", err
+		}
+		return decodeString(ByteArrayData(arr), "UTF-8")
+	})
+	stubs[class+"<init>([BLjava/lang/String;)V"] = stringInit(func(args []entity.VMValue) (string, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return "", err
+		}
+		charset, err := stringArg(args[1])
+		if err != nil {
+			return "", err
+		}
+		return decodeString(ByteArrayData(arr), charset)
+	})
+	stubs[class+"<init>([C)V"] = stringInit(func(args []entity.VMValue) (string, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return "", err
+		}
+		return utf16UnitsToString(charArrayData(arr)), nil
+	})
+	stubs[class+"<init>([CII)V"] = stringInit(func(args []entity.VMValue) (string, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return "", err
+		}
+		offset, count := args[1].Int(), args[2].Int()
+		if offset < 0 || count < 0 || int(offset)+int(count) > len(arr.Data) {
+			return "", checkRange(offset, offset+count, len(arr.Data))
+		}
+		return utf16UnitsToString(charArrayData(arr)[offset : offset+count]), nil
+	})
+	stubs[class+"length()I"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		return IntValue(int32(len(units))), nil
+	})
+	stubs[class+"isEmpty()Z"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		return BoolValue(len(units) == 0), nil
+	})
+	stubs[class+"charAt(I)C"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		index := args[0].Int()
+		if index < 0 || int(index) >= len(units) {
+			return entity.VMValue{}, javaException("java.lang.StringIndexOutOfBoundsException", "index %d, length %d", index, len(units))
+		}
+		return IntValue(int32(units[index])), nil
+	})
+	stubs[class+"equals(Ljava/lang/Object;)Z"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		other, ok := GoString(args[0].Ref)
+		return BoolValue(ok && other == s), nil
+	})
+	stubs[class+"hashCode()I"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		var hash int32
+		for _, u := range units {
+			hash = 31*hash + int32(u)
+		}
+		return IntValue(hash), nil
+	})
+	stubs[class+"compareTo(Ljava/lang/String;)I"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		other, err := stringArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		otherUnits := stringToUTF16Units(other)
+		for i := 0; i < len(units) && i < len(otherUnits); i++ {
+			if units[i] != otherUnits[i] {
+				return IntValue(int32(units[i]) - int32(otherUnits[i])), nil
+			}
+		}
+		return IntValue(int32(len(units) - len(otherUnits))), nil
+	})
+	stubs[class+"toString()Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return args[0], nil
+	}
+	stubs[class+"intern()Ljava/lang/String;"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		return RefValue(vm.internString(s)), nil
+	})
+	stubs[class+"toCharArray()[C"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		return RefValue(newCharArray(units)), nil
+	})
+	stubs[class+"getBytes()[B"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		return RefValue(NewByteArray([]byte(s))), nil
+	})
+	stubs[class+"getBytes(Ljava/lang/String;)[B"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		charset, err := stringArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		data, err := encodeString(s, charset)
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return RefValue(NewByteArray(data)), nil
+	})
+	stubs[class+"substring(I)Ljava/lang/String;"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		begin := args[0].Int()
+		if err := checkRange(begin, int32(len(units)), len(units)); err != nil {
+			return entity.VMValue{}, err
+		}
+		return stringValue(utf16UnitsToString(units[begin:])), nil
+	})
+	stubs[class+"substring(II)Ljava/lang/String;"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		begin, end := args[0].Int(), args[1].Int()
+		if err := checkRange(begin, end, len(units)); err != nil {
+			return entity.VMValue{}, err
+		}
+		return stringValue(utf16UnitsToString(units[begin:end])), nil
+	})
+	stubs[class+"indexOf(I)I"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		ch := rune(args[0].Int())
+		sub := []uint16{uint16(ch)}
+		if ch >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(ch)
+			sub = []uint16{uint16(r1), uint16(r2)}
+		}
+		return IntValue(int32(indexOfUnits(units, sub, 0))), nil
+	})
+	stubs[class+"indexOf(Ljava/lang/String;)I"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		sub, err := stringArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return IntValue(int32(indexOfUnits(units, stringToUTF16Units(sub), 0))), nil
+	})
+	stubs[class+"contains(Ljava/lang/CharSequence;)Z"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		if args[0].Ref == nil {
+			return entity.VMValue{}, javaException("java.lang.NullPointerException", "null string")
+		}
+		return BoolValue(strings.Contains(s, javaToString(args[0]))), nil
+	})
+	stubs[class+"startsWith(Ljava/lang/String;)Z"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		prefix, err := stringArg(args[0])
+		return BoolValue(strings.HasPrefix(s, prefix)), err
+	})
+	stubs[class+"endsWith(Ljava/lang/String;)Z"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		suffix, err := stringArg(args[0])
+		return BoolValue(strings.HasSuffix(s, suffix)), err
+	})
+	stubs[class+"concat(Ljava/lang/String;)Ljava/lang/String;"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		other, err := stringArg(args[0])
+		return stringValue(s + other), err
+	})
+	stubs[class+"trim()Ljava/lang/String;"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		// 与 Java 一致，去掉两端小于等于空格的字符
+		begin, end := 0, len(units)
+		for begin < end && units[begin] <= ' ' {
+			begin++
+		}
+		for end > begin && units[end-1] <= ' ' {
+			end--
+		}
+		return stringValue(utf16UnitsToString(units[begin:end])), nil
+	})
+	stubs[class+"replace(CC)Ljava/lang/String;"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		from, to := uint16(args[0].Raw), uint16(args[1].Raw)
+		replaced := make([]uint16, len(units))
+		for i, u := range units {
+			if u == from {
+				u = to
+			}
+			replaced[i] = u
+		}
+		return stringValue(utf16UnitsToString(replaced)), nil
+	})
+	stubs[class+"toUpperCase()Ljava/lang/String;"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(strings.ToUpper(s)), nil
+	})
+	stubs[class+"toLowerCase()Ljava/lang/String;"] = stringMethod(func(vm *VM, s string, units []uint16, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(strings.ToLower(s)), nil
+	})
+	stubs[class+"valueOf(Ljava/lang/Object;)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(javaToString(args[0])), nil
+	}
+	stubs[class+"valueOf(I)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(strconv.Itoa(int(args[0].Int()))), nil
+	}
+	stubs[class+"valueOf(J)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(strconv.FormatInt(args[0].Long(), 10)), nil
+	}
+	stubs[class+"valueOf(C)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(utf16UnitsToString([]uint16{uint16(args[0].Raw)})), nil
+	}
+	stubs[class+"valueOf(Z)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(strconv.FormatBool(args[0].Int() != 0)), nil
+	}
+	stubs[class+"valueOf([C)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return stringValue(utf16UnitsToString(charArrayData(arr))), nil
+	}
+}
+
+// StringBuilder 的内容以 UTF-16 编码单元保存在 Native 中
+func builderUnits(v entity.VMValue) (*entity.VMObject, []uint16, error) {
+	obj, ok := v.Ref.(*entity.VMObject)
+	if !ok || obj == nil {
+		return nil, nil, javaException("java.lang.NullPointerException", "null StringBuilder")
+	}
+	units, _ := obj.Native.([]uint16)
+	return obj, units, nil
+}
+
+// StringBuilder 的内容改为 length 个编码单元之前检查数组长度限制，增长的部分计入堆大小
+func (vm *VM) growBuilder(units []uint16, length int64) error {
+	if length > math.MaxInt32 {
+		return &LimitError{Limit: entity.LIMIT_ARRAY_LENGTH, Max: int64(vm.Limits.MaxArrayLength)}
+	}
+	if err := vm.checkArrayLength(int32(length)); err != nil {
+		return err
+	}
+	if grow := length - int64(len(units)); grow > 0 {
+		return vm.allocate(2 * grow)
+	}
+	return nil
+}
+
+func addStringBuilderStubs(stubs map[string]NativeMethod) {
+	for _, class := range []string{"Ljava/lang/StringBuilder;", "Ljava/lang/StringBuffer;"} {
+		class := class
+		init := func(fn func(args []entity.VMValue) (string, error)) NativeMethod {
+			return func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+				s, err := fn(args[1:])
+				if err != nil {
+					return entity.VMValue{}, err
+				}
+				units := stringToUTF16Units(s)
+				if err := vm.growBuilder(nil, int64(len(units))); err != nil {
+					return entity.VMValue{}, err
+				}
+				args[0].Ref.(*entity.VMObject).Native = units
+				return entity.VMValue{}, nil
+			}
+		}
+		stubs[class+"-><init>()V"] = init(func(args []entity.VMValue) (string, error) {
+			return "", nil
+		})
+		stubs[class+"-><init>(I)V"] = init(func(args []entity.VMValue) (string, error) {
+			if args[0].Int() < 0 {
+				return "", javaException("java.lang.NegativeArraySizeException", "%d", args[0].Int())
+			}
+			return "", nil
+		})
+		stubs[class+"-><init>(Ljava/lang/String;)V"] = init(func(args []entity.VMValue) (string, error) {
+			return stringArg(args[0])
+		})
+		appendStub := func(format func(v entity.VMValue) string) NativeMethod {
+			return func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+				obj, units, err := builderUnits(args[0])
+				if err != nil {
+					return entity.VMValue{}, err
+				}
+				added := stringToUTF16Units(format(args[1]))
+				// 返回值是接收者，callMethod 不会计入增长的部分
+				if err := vm.growBuilder(units, int64(len(units))+int64(len(added))); err != nil {
+					return entity.VMValue{}, err
+				}
+				obj.Native = append(units, added...)
+				return args[0], nil
+			}
+		}
+		formats := map[string]func(v entity.VMValue) string{
+			"Ljava/lang/String;":       javaToString,
+			"Ljava/lang/Object;":       javaToString,
+			"Ljava/lang/CharSequence;": javaToString,
+			"I": func(v entity.VMValue) string {
+				return strconv.Itoa(int(v.Int()))
+			},
+			"J": func(v entity.VMValue) string {
+				return strconv.FormatInt(v.Long(), 10)
+			},
+			"Z": func(v entity.VMValue) string {
+				return strconv.FormatBool(v.Int() != 0)
+			},
+			"C": func(v entity.VMValue) string {
+				return utf16UnitsToString([]uint16{uint16(v.Raw)})
+			},
+		}
+		for param, format := range formats {
+			stubs[class+"->append("+param+")"+class] = appendStub(format)
+		}
+		stubs[class+"->toString()Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			_, units, err := builderUnits(args[0])
+			if err != nil {
+				return entity.VMValue{}, err
+			}
+			return stringValue(utf16UnitsToString(units)), nil
+		}
+		stubs[class+"->length()I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			_, units, err := builderUnits(args[0])
+			return IntValue(int32(len(units))), err
+		}
+		stubs[class+"->charAt(I)C"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			_, units, err := builderUnits(args[0])
+			if err != nil {
+				return entity.VMValue{}, err
+			}
+			index := args[1].Int()
+			if index < 0 || int(index) >= len(units) {
+				return entity.VMValue{}, javaException("java.lang.StringIndexOutOfBoundsException", "index %d, length %d", index, len(units))
+			}
+			return IntValue(int32(units[index])), nil
+		}
+		stubs[class+"->reverse()"+class] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			obj, units, err := builderUnits(args[0])
+			if err != nil {
+				return entity.VMValue{}, err
+			}
+			// 与 Java 一致，代理对保持原来的顺序
+			runes := utf16.Decode(units)
+			for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+				runes[i], runes[j] = runes[j], runes[i]
+			}
+			obj.Native = utf16.Encode(runes)
+			return args[0], nil
+		}
+		stubs[class+"->setLength(I)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			obj, units, err := builderUnits(args[0])
+			if err != nil {
+				return entity.VMValue{}, err
+			}
+			length := args[1].Int()
+			if length < 0 {
+				return entity.VMValue{}, javaException("java.lang.StringIndexOutOfBoundsException", "length %d", length)
+			}
+			if err := vm.growBuilder(units, int64(length)); err != nil {
+				return entity.VMValue{}, err
+			}
+			if int(length) > len(units) {
+				// 一次分配，新增的部分为 0
+				grown := make([]uint16, length)
+				copy(grown, units)
+				units = grown
+			}
+			obj.Native = units[:length]
+			return entity.VMValue{}, nil
+		}
+	}
+}
+
+// Integer 对象的值以 int32 保存在 Native 中
+func boxInteger(v int32) entity.VMValue {
+	return RefValue(&entity.VMObject{Class: "Ljava/lang/Integer;", Native: v})
+}
+
+func parseJavaInt(s string, radix int32) (int32, error) {
+	if radix < 2 || radix > 36 {
+		return 0, javaException("java.lang.NumberFormatException", "radix %d out of range", radix)
+	}
+	v, err := strconv.ParseInt(s, int(radix), 32)
+	if err != nil {
+		return 0, javaException("java.lang.NumberFormatException", "For input string: \"%s\"", s)
+	}
+	return int32(v), nil
+}
+
+func addIntegerStubs(stubs map[string]NativeMethod) {
+	const class = "Ljava/lang/Integer;->"
+	parse := func(args []entity.VMValue) (int32, error) {
+		s, err := stringArg(args[0])
+		if err != nil {
+			return 0, javaException("java.lang.NumberFormatException", "null")
+		}
+		radix := int32(10)
+		if len(args) > 1 {
+			radix = args[1].Int()
+		}
+		return parseJavaInt(s, radix)
+	}
+	stubs[class+"parseInt(Ljava/lang/String;)I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		v, err := parse(args)
+		return IntValue(v), err
+	}
+	stubs[class+"parseInt(Ljava/lang/String;I)I"] = stubs[class+"parseInt(Ljava/lang/String;)I"]
+	stubs[class+"valueOf(Ljava/lang/String;)Ljava/lang/Integer;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		v, err := parse(args)
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return boxInteger(v), nil
+	}
+	stubs[class+"valueOf(I)Ljava/lang/Integer;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return boxInteger(args[0].Int()), nil
+	}
+	stubs[class+"intValue()I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		obj := args[0].Ref.(*entity.VMObject)
+		v, _ := obj.Native.(int32)
+		return IntValue(v), nil
+	}
+	stubs[class+"toString()Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(javaToString(args[0])), nil
+	}
+	stubs[class+"toString(I)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(strconv.Itoa(int(args[0].Int()))), nil
+	}
+	stubs[class+"toHexString(I)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(strconv.FormatUint(uint64(uint32(args[0].Int())), 16)), nil
+	}
+	stubs[class+"toBinaryString(I)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(strconv.FormatUint(uint64(uint32(args[0].Int())), 2)), nil
+	}
+}
+
+func addMathStubs(stubs map[string]NativeMethod) {
+	const class = "Ljava/lang/Math;->"
+	stubs[class+"abs(I)I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		v := args[0].Int()
+		if v < 0 {
+			v = -v
+		}
+		return IntValue(v), nil
+	}
+	stubs[class+"abs(J)J"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		v := args[0].Long()
+		if v < 0 {
+			v = -v
+		}
+		return LongValue(v), nil
+	}
+	stubs[class+"max(II)I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		if args[0].Int() > args[1].Int() {
+			return args[0], nil
+		}
+		return args[1], nil
+	}
+	stubs[class+"min(II)I"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		if args[0].Int() < args[1].Int() {
+			return args[0], nil
+		}
+		return args[1], nil
+	}
+	stubs[class+"max(JJ)J"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		if args[0].Long() > args[1].Long() {
+			return args[0], nil
+		}
+		return args[1], nil
+	}
+	stubs[class+"min(JJ)J"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		if args[0].Long() < args[1].Long() {
+			return args[0], nil
+		}
+		return args[1], nil
+	}
+}
+
+// 复制数组的一段，超出原数组的部分为默认值
+func copyArrayRange(vm *VM, arr *entity.VMArray, from int32, to int32) (*entity.VMArray, error) {
+	if from < 0 || int(from) > len(arr.Data) {
+		return nil, javaException("java.lang.ArrayIndexOutOfBoundsException", "from %d, length %d", from, len(arr.Data))
+	}
+	if from > to {
+		return nil, javaException("java.lang.IllegalArgumentException", "%d > %d", from, to)
+	}
+	if err := vm.checkArrayLength(to - from); err != nil {
+		return nil, err
+	}
+	result, err := NewArray(arr.Type, to-from)
+	if err != nil {
+		return nil, err
+	}
+	end := int(to)
+	if end > len(arr.Data) {
+		end = len(arr.Data)
+	}
+	copy(result.Data, arr.Data[from:end])
+	return result, nil
+}
+
+func addArraysStubs(stubs map[string]NativeMethod) {
+	const class = "Ljava/util/Arrays;->"
+	for _, typ := range []string{"[B", "[C", "[I", "[J", "[Ljava/lang/Object;"} {
+		stubs[class+"copyOf("+typ+"I)"+typ] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			arr, err := arrayArg(args[0])
+			if err != nil {
+				return entity.VMValue{}, err
+			}
+			if args[1].Int() < 0 {
+				return entity.VMValue{}, javaException("java.lang.NegativeArraySizeException", "%d", args[1].Int())
+			}
+			result, err := copyArrayRange(vm, arr, 0, args[1].Int())
+			return RefValue(result), err
+		}
+		stubs[class+"copyOfRange("+typ+"II)"+typ] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			arr, err := arrayArg(args[0])
+			if err != nil {
+				return entity.VMValue{}, err
+			}
+			result, err := copyArrayRange(vm, arr, args[1].Int(), args[2].Int())
+			return RefValue(result), err
+		}
+		stubs[class+"equals("+typ+typ+")Z"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			a, b := args[0].Ref, args[1].Ref
+			if a == nil || b == nil {
+				return BoolValue(a == b), nil
+			}
+			x, y := a.(*entity.VMArray), b.(*entity.VMArray)
+			if len(x.Data) != len(y.Data) {
+				return BoolValue(false), nil
+			}
+			for i := range x.Data {
+				if x.Data[i].Raw != y.Data[i].Raw || x.Data[i].Ref != y.Data[i].Ref {
+					return BoolValue(false), nil
+				}
+			}
+			return BoolValue(true), nil
+		}
+	}
+	stubs[class+"fill([BB)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		for i := range arr.Data {
+			arr.Data[i] = IntValue(int32(int8(args[1].Raw)))
+		}
+		return entity.VMValue{}, nil
+	}
+}
+
+// android.util.Base64 的标志位
+const (
+	base64NoPadding = 1
+	base64NoWrap    = 2
+	base64UrlSafe   = 8
+)
+
+func base64Encode(data []byte, flags int32) string {
+	encoding := base64.StdEncoding
+	if flags&base64UrlSafe != 0 {
+		encoding = base64.URLEncoding
+	}
+	if flags&base64NoPadding != 0 {
+		encoding = encoding.WithPadding(base64.NoPadding)
+	}
+	s := encoding.EncodeToString(data)
+	if flags&base64NoWrap != 0 {
+		return s
+	}
+	// 默认每 76 个字符换行，结尾也有换行
+	var b strings.Builder
+	for len(s) > 76 {
+		b.WriteString(s[:76])
+		b.WriteByte('\n')
+		s = s[76:]
+	}
+	if s != "" {
+		b.WriteString(s)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// 与 android.util.Base64 一致，忽略空白，两种字母表都接受，填充可以省略
+func base64Decode(s string) ([]byte, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\r', '\n':
+		case '-':
+			b.WriteByte('+')
+		case '_':
+			b.WriteByte('/')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(b.String(), "="))
+	if err != nil {
+		return nil, javaException("java.lang.IllegalArgumentException", "bad base-64")
+	}
+	return data, nil
+}
+
+func addBase64Stubs(stubs map[string]NativeMethod) {
+	const class = "Landroid/util/Base64;->"
+	stubs[class+"decode(Ljava/lang/String;I)[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		s, err := stringArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		data, err := base64Decode(s)
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return RefValue(NewByteArray(data)), nil
+	}
+	stubs[class+"decode([BI)[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		data, err := base64Decode(string(ByteArrayData(arr)))
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return RefValue(NewByteArray(data)), nil
+	}
+	stubs[class+"encodeToString([BI)Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return stringValue(base64Encode(ByteArrayData(arr), args[1].Int())), nil
+	}
+	stubs[class+"encode([BI)[B"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		arr, err := arrayArg(args[0])
+		if err != nil {
+			return entity.VMValue{}, err
+		}
+		return RefValue(NewByteArray([]byte(base64Encode(ByteArrayData(arr), args[1].Int())))), nil
+	}
+}
+
+// System.arraycopy，源和目标相同时按先复制到临时数组的语义处理
+func systemArraycopy(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+	src, err := arrayArg(args[0])
+	if err != nil {
+		return entity.VMValue{}, err
+	}
+	dst, err := arrayArg(args[2])
+	if err != nil {
+		return entity.VMValue{}, err
+	}
+	srcPos, dstPos, length := args[1].Int(), args[3].Int(), args[4].Int()
+	if srcPos < 0 || dstPos < 0 || length < 0 ||
+		int64(srcPos)+int64(length) > int64(len(src.Data)) || int64(dstPos)+int64(length) > int64(len(dst.Data)) {
+		return entity.VMValue{}, javaException("java.lang.ArrayIndexOutOfBoundsException",
+			"src.length=%d srcPos=%d dst.length=%d dstPos=%d length=%d", len(src.Data), srcPos, len(dst.Data), dstPos, length)
+	}
+	srcElem, dstElem := src.Type[1:], dst.Type[1:]
+	if kindOfType(srcElem) != kindOfType(dstElem) || (kindOfType(srcElem) != entity.VM_REF && srcElem != dstElem) {
+		return entity.VMValue{}, javaException("java.lang.ArrayStoreException", "%s into %s", src.Type, dst.Type)
+	}
+	copy(dst.Data[dstPos:dstPos+length], src.Data[srcPos:srcPos+length])
+	return entity.VMValue{}, nil
+}
@@ -0,0 +1,176 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"errors"
+	"fmt"
+)
+
+// 类的初始化状态
+const (
+	classInitializing = iota + 1
+	classInitialized
+	classFailed
+)
+
+// LoadClass 从类路径中加载类并执行初始化，框架类等不在类路径中的类返回 nil
+func (vm *VM) LoadClass(name string) (*entity.DexClass, error) {
+	if err := vm.enter(); err != nil {
+		return nil, err
+	}
+	return vm.loadClass(name)
+}
+
+func (vm *VM) loadClass(name string) (*entity.DexClass, error) {
+	if vm.ClassPath == nil {
+		return nil, nil
+	}
+	class := vm.ClassPath.FindClass(name)
+	if class == nil {
+		return nil, nil
+	}
+	return class, vm.initClass(class)
+}
+
+// 初始化类：先初始化父类，再设置静态字段的初始值，最后执行 <clinit>。
+// 初始化过程中再次访问同一个类时直接返回
+func (vm *VM) initClass(class *entity.DexClass) error {
+	switch vm.classState[class.Name] {
+	case classInitializing, classInitialized:
+		return nil
+	case classFailed:
+		return javaException("java.lang.NoClassDefFoundError", "%s", convertToClassName(class.Name))
+	}
+	vm.classState[class.Name] = classInitializing
+	err := vm.runClassInit(class)
+	var limit *LimitError
+	if errors.As(err, &limit) {
+		// 资源限制不是类的错误，之后可以重新初始化
+		delete(vm.classState, class.Name)
+		return err
+	}
+	if err != nil {
+		vm.classState[class.Name] = classFailed
+		// Error 原样抛出，其他异常包装为 ExceptionInInitializerError
+		if exc, ok := err.(*JavaException); ok && !vm.isInstance(exc.Object, "Ljava/lang/Error;") {
+			wrapped := javaException("java.lang.ExceptionInInitializerError", "%s", convertToClassName(class.Name)).(*JavaException)
+			wrapped.Throwable().Cause = RefValue(exc.Object)
+			return wrapped
+		}
+		return err
+	}
+	vm.classState[class.Name] = classInitialized
+	return nil
+}
+
+func (vm *VM) runClassInit(class *entity.DexClass) error {
+	if super := vm.ClassPath.Classes[class.SuperClass]; super != nil {
+		if err := vm.initClass(super); err != nil {
+			return err
+		}
+	}
+	fields, err := vm.ClassPath.Fields(class)
+	if err != nil {
+		return err
+	}
+	var values []entity.EncodedValue
+	if class.Def.Static_values_off_ != 0 {
+		mr := &modelReader{dex: class.Dex}
+		if values, err = mr.readEncodedArrayAt(class.Def.Static_values_off_); err != nil {
+			return err
+		}
+	}
+	// 静态字段在前，static_values 按静态字段的顺序排列，缺少的为默认值
+	for i, field := range fields {
+		if field.AccessFlags&entity.ACC_STATIC == 0 {
+			break
+		}
+		value := zeroValue(field.Ref.Type)
+		if i < len(values) {
+			if value, err = vm.encodedToValue(values[i], field.Ref.Type); err != nil {
+				return err
+			}
+		}
+		vm.Statics[GetFieldSignature(&field.Ref)] = value
+	}
+	clinit, err := vm.ClassPath.FindMethod(class, "<clinit>", "()V")
+	if err != nil || clinit == nil || clinit.CodeOff == 0 {
+		return err
+	}
+	_, err = vm.invokeMethod(clinit, nil)
+	return err
+}
+
+// 把 static_values 中的常量转换为字段类型的值
+func (vm *VM) encodedToValue(value entity.EncodedValue, typ string) (entity.VMValue, error) {
+	switch v := value.Value.(type) {
+	case nil:
+		return zeroValue(typ), nil
+	case int64:
+		switch kindOfType(typ) {
+		case entity.VM_LONG:
+			return LongValue(v), nil
+		case entity.VM_INT:
+			return truncateValue(typ, IntValue(int32(v))), nil
+		}
+	case bool:
+		return BoolValue(v), nil
+	case float32:
+		return FloatValue(v), nil
+	case float64:
+		return DoubleValue(v), nil
+	case string:
+		if value.Type == entity.VALUE_TYPE {
+			return RefValue(vm.classObject(v)), nil
+		}
+		return RefValue(vm.internString(v)), nil
+	}
+	return entity.VMValue{}, fmt.Errorf("unsupported static value type 0x%x for %s", value.Type, typ)
+}
+
+// NewObject 创建 class 的实例，类在类路径中时先初始化
+func (vm *VM) NewObject(class string) (*entity.VMObject, error) {
+	if err := vm.enter(); err != nil {
+		return nil, err
+	}
+	return vm.newObject(class)
+}
+
+func (vm *VM) newObject(class string) (*entity.VMObject, error) {
+	def, err := vm.loadClass(class)
+	if err != nil {
+		return nil, err
+	}
+	if def != nil {
+		class = def.Name
+	}
+	return &entity.VMObject{Class: class, Fields: make(map[string]entity.VMValue)}, nil
+}
+
+// NewArray 创建数组，元素为类型的默认值
+func NewArray(typ string, size int32) (*entity.VMArray, error) {
+	if len(typ) < 2 || typ[0] != '[' {
+		return nil, fmt.Errorf("invalid array type %s", typ)
+	}
+	if size < 0 {
+		return nil, javaException("java.lang.NegativeArraySizeException", "%d", size)
+	}
+	arr := &entity.VMArray{Type: typ, Data: make([]entity.VMValue, size)}
+	zero := zeroValue(typ[1:])
+	for i := range arr.Data {
+		arr.Data[i] = zero
+	}
+	return arr, nil
+}
+
+// 字段在类路径中的声明位置，找不到时使用引用本身
+func (vm *VM) resolveField(ref *entity.DexFieldRef) (*entity.DexFieldRef, *entity.DexClass) {
+	if vm.ClassPath == nil || vm.ClassPath.Classes[ref.Class] == nil {
+		return ref, nil
+	}
+	field, err := vm.ClassPath.ResolveField(ref)
+	if err != nil || field == nil {
+		return ref, nil
+	}
+	return &field.Ref, field.Class
+}
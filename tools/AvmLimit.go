@@ -0,0 +1,145 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+	"unsafe"
+)
+
+// LimitError 模拟执行超出资源限制，模拟代码中的 catch 无法捕获
+type LimitError struct {
+	Limit string                // entity.LIMIT_*
+	Max   int64                 // 限制值，时间限制时为 0
+	Err   error                 // 时间限制时为 Context 的错误
+	Trace []entity.VMStackFrame // 停止时的调用栈，最内层在前
+}
+
+func (e *LimitError) Error() string {
+	msg := fmt.Sprintf("emulation %s limit %d exceeded", e.Limit, e.Max)
+	if e.Err != nil {
+		msg = fmt.Sprintf("emulation %s limit: %v", e.Limit, e.Err)
+	}
+	if len(e.Trace) > 0 {
+		msg += " at " + FormatStackFrame(e.Trace[0])
+	}
+	return msg
+}
+
+func (e *LimitError) Unwrap() error {
+	return e.Err
+}
+
+// 超出限制的错误不按栈帧包装，在最内层记录调用栈
+func (vm *VM) limitError(err error) error {
+	if limit, ok := err.(*LimitError); ok && limit.Trace == nil {
+		limit.Trace = vm.stackTrace()
+	}
+	return err
+}
+
+// DefaultVMLimits 返回 NewVM 使用的默认限制
+func DefaultVMLimits() entity.VMLimits {
+	return entity.VMLimits{
+		MaxInstructions: 10000000,
+		MaxCallDepth:    512,
+		MaxHeapBytes:    256 << 20,
+		MaxArrayLength:  4 << 20, // 每个元素按 vmValueSize 计算，4M 个元素约占 128MB
+	}
+}
+
+// 每执行多少条指令检查一次 Context
+const contextCheckInterval = 1024
+
+// 从外部进入模拟器时重新计数，模拟执行中的嵌套调用沿用当前的计数
+func (vm *VM) enter() error {
+	if len(vm.frames) > 0 {
+		return vm.checkContext()
+	}
+	vm.executed = 0
+	vm.heapBytes = 0
+	return vm.checkContext()
+}
+
+func (vm *VM) checkContext() error {
+	if vm.Context == nil {
+		return nil
+	}
+	if err := vm.Context.Err(); err != nil {
+		return &LimitError{Limit: entity.LIMIT_TIME, Err: err}
+	}
+	return nil
+}
+
+// 执行一条指令之前计数
+func (vm *VM) countInstruction() error {
+	vm.executed++
+	if vm.Limits.MaxInstructions > 0 && vm.executed > vm.Limits.MaxInstructions {
+		return &LimitError{Limit: entity.LIMIT_INSTRUCTIONS, Max: vm.Limits.MaxInstructions}
+	}
+	if vm.executed%contextCheckInterval == 0 {
+		return vm.checkContext()
+	}
+	return nil
+}
+
+// 进入新的栈帧之前检查调用深度
+func (vm *VM) checkCallDepth() error {
+	if vm.Limits.MaxCallDepth > 0 && len(vm.frames) >= vm.Limits.MaxCallDepth {
+		return &LimitError{Limit: entity.LIMIT_CALL_DEPTH, Max: int64(vm.Limits.MaxCallDepth)}
+	}
+	return nil
+}
+
+// 记录分配的字节数
+func (vm *VM) allocate(bytes int64) error {
+	vm.heapBytes += bytes
+	if vm.Limits.MaxHeapBytes > 0 && vm.heapBytes > vm.Limits.MaxHeapBytes {
+		return &LimitError{Limit: entity.LIMIT_HEAP, Max: vm.Limits.MaxHeapBytes}
+	}
+	return nil
+}
+
+func (vm *VM) checkArrayLength(size int32) error {
+	if vm.Limits.MaxArrayLength > 0 && size > vm.Limits.MaxArrayLength {
+		return &LimitError{Limit: entity.LIMIT_ARRAY_LENGTH, Max: int64(vm.Limits.MaxArrayLength)}
+	}
+	return nil
+}
+
+// 按限制创建数组并记录分配
+func (vm *VM) newArray(typ string, size int32) (*entity.VMArray, error) {
+	if err := vm.checkArrayLength(size); err != nil {
+		return nil, err
+	}
+	if size > 0 && len(typ) > 1 {
+		if err := vm.allocate(objectHeaderSize + int64(size)*vmValueSize); err != nil {
+			return nil, err
+		}
+	}
+	return NewArray(typ, size)
+}
+
+// 对象头的近似大小
+const objectHeaderSize = 16
+
+// 数组元素和字段都以 entity.VMValue 保存，按它在宿主上的实际大小计算，
+// 而不是按 Java 中基本类型的大小
+const vmValueSize = int64(unsafe.Sizeof(entity.VMValue{}))
+
+// 对象占用的近似字节数
+func refSize(ref interface{}) int64 {
+	switch v := ref.(type) {
+	case *entity.VMArray:
+		return objectHeaderSize + int64(len(v.Data))*vmValueSize
+	case *entity.VMObject:
+		size := objectHeaderSize + vmValueSize*int64(len(v.Fields))
+		switch native := v.Native.(type) {
+		case string:
+			size += int64(len(native))
+		case []uint16:
+			size += 2 * int64(len(native))
+		}
+		return size
+	}
+	return 0
+}
@@ -0,0 +1,94 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"errors"
+	"testing"
+)
+
+const testLimitSmali = `.class public LLimitTest;
+.super Ljava/lang/Object;
+
+.field static count:I
+
+.method static constructor <clinit>()V
+    .registers 2
+    const/4 v0, 0x0
+    const/16 v1, 0x64
+    :goto_0
+    if-ge v0, v1, :cond_0
+    add-int/lit8 v0, v0, 0x1
+    goto :goto_0
+    :cond_0
+    sput v0, LLimitTest;->count:I
+    return-void
+.end method
+
+.method public static loop(I)I
+    .registers 3
+    const/4 v0, 0x0
+    :goto_0
+    if-ge v0, p0, :cond_0
+    add-int/lit8 v0, v0, 0x1
+    goto :goto_0
+    :cond_0
+    sget v1, LLimitTest;->count:I
+    add-int/2addr v0, v1
+    return v0
+.end method
+
+.method public static alloc(I)I
+    .registers 2
+    new-array v0, p0, [I
+    array-length v0, v0
+    return v0
+.end method
+`
+
+func invokeLimitTest(t *testing.T, vm *VM, cp *ClassPath, name string, args ...entity.VMValue) (entity.VMValue, error) {
+	t.Helper()
+	method, err := cp.FindMethod(cp.FindClass("LLimitTest;"), name, "(I)I")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm.InvokeMethod(method, args)
+}
+
+// <clinit> 和调用的方法共用一次指令数限制
+func TestLimitIncludesClassInit(t *testing.T) {
+	vm, cp, _ := assembleTestSmali(t, testLimitSmali)
+	// <clinit> 约 300 条指令，loop(100) 约 300 条，单独都不超过 500
+	vm.Limits.MaxInstructions = 500
+	_, err := invokeLimitTest(t, vm, cp, "loop", IntValue(100))
+	var limit *LimitError
+	if !errors.As(err, &limit) || limit.Limit != entity.LIMIT_INSTRUCTIONS {
+		t.Fatalf("loop(100) with class init: %v", err)
+	}
+	// 类没有初始化成功，下一次调用重新初始化并重新计数
+	vm.Limits.MaxInstructions = 1000
+	ret, err := invokeLimitTest(t, vm, cp, "loop", IntValue(100))
+	if err != nil || ret.Int() != 200 {
+		t.Errorf("loop(100) = %d, %v", ret.Int(), err)
+	}
+	// 之后的调用不再执行 <clinit>
+	vm.Limits.MaxInstructions = 500
+	if ret, err = invokeLimitTest(t, vm, cp, "loop", IntValue(100)); err != nil || ret.Int() != 200 {
+		t.Errorf("loop(100) after class init = %d, %v", ret.Int(), err)
+	}
+}
+
+// 数组按 VMValue 的实际大小计入堆大小
+func TestLimitArrayHeap(t *testing.T) {
+	vm, cp, _ := assembleTestSmali(t, testLimitSmali)
+	vm.Limits.MaxHeapBytes = 1 << 20
+	size := int32((1<<20)/vmValueSize) + 1
+	_, err := invokeLimitTest(t, vm, cp, "alloc", IntValue(size))
+	var limit *LimitError
+	if !errors.As(err, &limit) || limit.Limit != entity.LIMIT_HEAP {
+		t.Errorf("alloc(%d) with 1MB heap: %v", size, err)
+	}
+	ret, err := invokeLimitTest(t, vm, cp, "alloc", IntValue(1000))
+	if err != nil || ret.Int() != 1000 {
+		t.Errorf("alloc(1000) = %d, %v", ret.Int(), err)
+	}
+}
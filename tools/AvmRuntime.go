@@ -0,0 +1,320 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"math"
+)
+
+// IntValue 创建 int 值，boolean/byte/short/char 也使用 int
+func IntValue(v int32) entity.VMValue {
+	return entity.VMValue{Kind: entity.VM_INT, Raw: uint64(uint32(v))}
+}
+
+// BoolValue 创建 boolean 值
+func BoolValue(v bool) entity.VMValue {
+	if v {
+		return IntValue(1)
+	}
+	return IntValue(0)
+}
+
+// LongValue 创建 long 值
+func LongValue(v int64) entity.VMValue {
+	return entity.VMValue{Kind: entity.VM_LONG, Raw: uint64(v)}
+}
+
+// FloatValue 创建 float 值
+func FloatValue(v float32) entity.VMValue {
+	return entity.VMValue{Kind: entity.VM_FLOAT, Raw: uint64(math.Float32bits(v))}
+}
+
+// DoubleValue 创建 double 值
+func DoubleValue(v float64) entity.VMValue {
+	return entity.VMValue{Kind: entity.VM_DOUBLE, Raw: math.Float64bits(v)}
+}
+
+// RefValue 创建引用值，ref 为 nil 表示 null
+func RefValue(ref interface{}) entity.VMValue {
+	return entity.VMValue{Kind: entity.VM_REF, Ref: normalizeRef(ref)}
+}
+
+// 带类型的空指针转换为 nil，保证 null 判断正确
+func normalizeRef(ref interface{}) interface{} {
+	switch v := ref.(type) {
+	case *entity.VMObject:
+		if v == nil {
+			return nil
+		}
+	case *entity.VMArray:
+		if v == nil {
+			return nil
+		}
+	}
+	return ref
+}
+
+// NewStringObject 创建 java.lang.String 对象
+func NewStringObject(s string) *entity.VMObject {
+	return &entity.VMObject{Class: "Ljava/lang/String;", Native: s}
+}
+
+// GoString 取 String 对象的内容，不是 String 时返回 false
+func GoString(ref interface{}) (string, bool) {
+	obj, ok := ref.(*entity.VMObject)
+	if !ok || obj == nil || obj.Class != "Ljava/lang/String;" {
+		return "", false
+	}
+	s, ok := obj.Native.(string)
+	return s, ok
+}
+
+// 类型描述符对应的值类型
+func kindOfType(desc string) byte {
+	switch desc[0] {
+	case 'V':
+		return entity.VM_VOID
+	case 'J':
+		return entity.VM_LONG
+	case 'D':
+		return entity.VM_DOUBLE
+	case 'F':
+		return entity.VM_FLOAT
+	case 'L', '[':
+		return entity.VM_REF
+	}
+	return entity.VM_INT
+}
+
+// 类型的默认值
+func zeroValue(desc string) entity.VMValue {
+	kind := kindOfType(desc)
+	if kind == entity.VM_REF {
+		return RefValue(nil)
+	}
+	return entity.VMValue{Kind: kind}
+}
+
+// 按类型截断 int 值，用于 byte/char/short/boolean 的字段和数组元素
+func truncateValue(desc string, v entity.VMValue) entity.VMValue {
+	switch desc[0] {
+	case 'B':
+		return IntValue(int32(int8(v.Raw)))
+	case 'C':
+		return IntValue(int32(uint16(v.Raw)))
+	case 'S':
+		return IntValue(int32(int16(v.Raw)))
+	case 'Z':
+		return IntValue(int32(v.Raw & 1))
+	}
+	v.Kind = kindOfType(desc)
+	if v.Kind != entity.VM_LONG && v.Kind != entity.VM_DOUBLE && v.Kind != entity.VM_REF {
+		v.Raw = uint64(uint32(v.Raw))
+	}
+	return v
+}
+
+// 引用的运行时类型
+func runtimeType(ref interface{}) string {
+	switch v := ref.(type) {
+	case *entity.VMObject:
+		return v.Class
+	case *entity.VMArray:
+		return v.Type
+	}
+	return ""
+}
+
+// 类路径之外常用框架类的父类型，用于 instance-of/check-cast
+var frameworkSupertypes = map[string][]string{
+	"Ljava/lang/String;":        {"Ljava/lang/CharSequence;", "Ljava/lang/Comparable;", "Ljava/io/Serializable;"},
+	"Ljava/lang/StringBuilder;": {"Ljava/lang/CharSequence;", "Ljava/lang/Appendable;", "Ljava/io/Serializable;"},
+	"Ljava/lang/Integer;":       {"Ljava/lang/Number;", "Ljava/lang/Comparable;"},
+	"Ljava/lang/Long;":          {"Ljava/lang/Number;", "Ljava/lang/Comparable;"},
+	"Ljava/lang/Number;":        {"Ljava/io/Serializable;"},
+	"Ljava/lang/Class;":         {"Ljava/io/Serializable;"},
+	"Ljava/lang/Throwable;":     {"Ljava/io/Serializable;"},
+}
+
+// 判断 from 类型的值能否赋给 to 类型
+func (vm *VM) isAssignable(from string, to string) bool {
+	if from == to || to == "Ljava/lang/Object;" {
+		return true
+	}
+	if from == "" || to == "" {
+		return false
+	}
+	if from[0] == '[' {
+		if to == "Ljava/lang/Cloneable;" || to == "Ljava/io/Serializable;" {
+			return true
+		}
+		if to[0] != '[' {
+			return false
+		}
+		fromElem, toElem := from[1:], to[1:]
+		if kindOfType(fromElem) == entity.VM_REF && kindOfType(toElem) == entity.VM_REF {
+			return vm.isAssignable(fromElem, toElem)
+		}
+		return false
+	}
+	visited := make(map[string]bool)
+	var walk func(name string) bool
+	walk = func(name string) bool {
+		if name == to {
+			return true
+		}
+		if name == "" || visited[name] {
+			return false
+		}
+		visited[name] = true
+		var supers []string
+		if vm.ClassPath != nil && vm.ClassPath.Classes[name] != nil {
+			class := vm.ClassPath.Classes[name]
+			supers = append([]string{class.SuperClass}, class.Interfaces...)
+		} else {
+			supers = append([]string{frameworkSuperclass[name]}, frameworkSupertypes[name]...)
+		}
+		for _, super := range supers {
+			if walk(super) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(from)
+}
+
+// 判断对象是否是 typ 类型的实例，null 不是任何类型的实例
+func (vm *VM) isInstance(ref interface{}, typ string) bool {
+	if ref == nil {
+		return false
+	}
+	// 符号值的实际类型未知，可以作为任何类的实例
+	if obj, ok := ref.(*entity.VMObject); ok && typ[0] == 'L' {
+		if _, symbolic := obj.Native.(*entity.VMSymbol); symbolic {
+			return true
+		}
+	}
+	return vm.isAssignable(runtimeType(ref), typ)
+}
+
+// 按 Java 语义把浮点数转换为整数：NaN 为 0，超出范围取边界值
+func floatToInt(v float64, min float64, max float64) float64 {
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v <= min:
+		return min
+	case v >= max:
+		return max
+	}
+	return math.Trunc(v)
+}
+
+func f2i(v float64) int32 {
+	return int32(floatToInt(v, math.MinInt32, math.MaxInt32))
+}
+
+func f2l(v float64) int64 {
+	if !math.IsNaN(v) && v >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	return int64(floatToInt(v, math.MinInt64, math.MaxInt64))
+}
+
+// 比较浮点数，有 NaN 时 cmpl 返回 -1，cmpg 返回 1
+func compareFloat(a float64, b float64, nanResult int32) int32 {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		return nanResult
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// 整数运算，op 的顺序与 add-int 开始的操作码一致
+func intBinop(op int, a int32, b int32) (int32, bool) {
+	switch op {
+	case 0:
+		return a + b, true
+	case 1:
+		return a - b, true
+	case 2:
+		return a * b, true
+	case 3:
+		if b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	case 4:
+		if b == 0 {
+			return 0, false
+		}
+		return a % b, true
+	case 5:
+		return a & b, true
+	case 6:
+		return a | b, true
+	case 7:
+		return a ^ b, true
+	case 8:
+		return a << uint(b&31), true
+	case 9:
+		return a >> uint(b&31), true
+	case 10:
+		return int32(uint32(a) >> uint(b&31)), true
+	}
+	return 0, true
+}
+
+// long 运算，移位时 b 为 int
+func longBinop(op int, a int64, b int64) (int64, bool) {
+	switch op {
+	case 0:
+		return a + b, true
+	case 1:
+		return a - b, true
+	case 2:
+		return a * b, true
+	case 3:
+		if b == 0 {
+			return 0, false
+		}
+		return a / b, true
+	case 4:
+		if b == 0 {
+			return 0, false
+		}
+		return a % b, true
+	case 5:
+		return a & b, true
+	case 6:
+		return a | b, true
+	case 7:
+		return a ^ b, true
+	case 8:
+		return a << uint(b&63), true
+	case 9:
+		return a >> uint(b&63), true
+	case 10:
+		return int64(uint64(a) >> uint(b&63)), true
+	}
+	return 0, true
+}
+
+// 浮点运算，op 为 add、sub、mul、div、rem
+func floatBinop(op int, a float64, b float64) float64 {
+	switch op {
+	case 0:
+		return a + b
+	case 1:
+		return a - b
+	case 2:
+		return a * b
+	case 3:
+		return a / b
+	}
+	return math.Mod(a, b)
+}
@@ -0,0 +1,48 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+)
+
+// NativeMethod 用 Go 实现的方法，实例方法的第一个参数为 this
+type NativeMethod func(vm *VM, args []entity.VMValue) (entity.VMValue, error)
+
+// RegisterStub 把方法签名绑定到 Go 实现，例如 Ljava/lang/String;->length()I，
+// 已有的绑定会被替换
+func (vm *VM) RegisterStub(signature string, fn NativeMethod) {
+	vm.Stubs[signature] = fn
+}
+
+// 查找桩函数：从 class 开始沿父类查找，最后查找方法引用的类和 Object
+func (vm *VM) findStub(class string, ref *entity.DexMethodRef) NativeMethod {
+	desc := "->" + ref.Name + GetProtoDescriptor(ref.Proto)
+	visited := make(map[string]bool)
+	for name := class; name != "" && !visited[name]; {
+		visited[name] = true
+		if fn := vm.Stubs[name+desc]; fn != nil {
+			return fn
+		}
+		name = vm.superClass(name)
+	}
+	if fn := vm.Stubs[ref.Class+desc]; fn != nil {
+		return fn
+	}
+	return vm.Stubs["Ljava/lang/Object;"+desc]
+}
+
+// 调用找不到实现的方法，按 UnknownPolicy 处理
+func (vm *VM) unknownCall(ref *entity.DexMethodRef, args []entity.VMValue) (entity.VMValue, error) {
+	signature := GetMethodSignature(ref)
+	vm.UnknownCalls = append(vm.UnknownCalls, signature)
+	switch vm.UnknownPolicy {
+	case entity.UNKNOWN_CALL_ABORT:
+		return entity.VMValue{}, fmt.Errorf("unsupported method %s", signature)
+	case entity.UNKNOWN_CALL_SYMBOLIC:
+		if kindOfType(ref.Proto.ReturnType) == entity.VM_REF {
+			symbol := &entity.VMSymbol{Method: signature, Args: args}
+			return RefValue(&entity.VMObject{Class: ref.Proto.ReturnType, Native: symbol}), nil
+		}
+	}
+	return zeroValue(ref.Proto.ReturnType), nil
+}
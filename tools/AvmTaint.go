@@ -0,0 +1,439 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+)
+
+// DefaultTaintSources 默认的敏感数据源：设备标识、位置、联系人、剪贴板和 Intent 携带的数据。
+// 按 AddBreakpoint 的规则匹配方法签名，不带参数列表时匹配所有同名方法
+var DefaultTaintSources = []string{
+	"Landroid/telephony/TelephonyManager;->getDeviceId",
+	"Landroid/telephony/TelephonyManager;->getImei",
+	"Landroid/telephony/TelephonyManager;->getMeid",
+	"Landroid/telephony/TelephonyManager;->getSubscriberId",
+	"Landroid/telephony/TelephonyManager;->getSimSerialNumber",
+	"Landroid/telephony/TelephonyManager;->getLine1Number",
+	"Landroid/provider/Settings$Secure;->getString",
+	"Landroid/location/LocationManager;->getLastKnownLocation",
+	"Landroid/location/Location;->getLatitude",
+	"Landroid/location/Location;->getLongitude",
+	"Landroid/content/ContentResolver;->query",
+	"Landroid/content/ClipboardManager;->getPrimaryClip",
+	"Landroid/content/ClipboardManager;->getText",
+	"Landroid/content/Intent;->getStringExtra",
+	"Landroid/content/Intent;->getExtras",
+	"Landroid/content/Intent;->getBundleExtra",
+	"Landroid/content/Intent;->getData",
+	"Landroid/content/Intent;->getDataString",
+}
+
+// DefaultTaintSinks 默认的 sink：网络、日志、SharedPreferences、文件写入和短信
+var DefaultTaintSinks = []string{
+	"Ljava/net/URL;-><init>",
+	"Ljava/net/URLConnection;->setRequestProperty",
+	"Ljava/net/HttpURLConnection;->setRequestProperty",
+	"Ljava/io/OutputStream;->write",
+	"Lokhttp3/Request$Builder;->url",
+	"Lokhttp3/Request$Builder;->header",
+	"Lokhttp3/RequestBody;->create",
+	"Landroid/util/Log;->v",
+	"Landroid/util/Log;->d",
+	"Landroid/util/Log;->i",
+	"Landroid/util/Log;->w",
+	"Landroid/util/Log;->e",
+	"Landroid/util/Log;->wtf",
+	"Landroid/content/SharedPreferences$Editor;->putString",
+	"Landroid/content/SharedPreferences$Editor;->putStringSet",
+	"Landroid/content/SharedPreferences$Editor;->putInt",
+	"Landroid/content/SharedPreferences$Editor;->putLong",
+	"Landroid/content/SharedPreferences$Editor;->putFloat",
+	"Ljava/io/FileOutputStream;->write",
+	"Ljava/io/Writer;->write",
+	"Ljava/io/FileWriter;->write",
+	"Ljava/io/OutputStreamWriter;->write",
+	"Ljava/io/BufferedWriter;->write",
+	"Landroid/telephony/SmsManager;->sendTextMessage",
+	"Landroid/telephony/SmsManager;->sendMultipartTextMessage",
+	"Landroid/telephony/SmsManager;->sendDataMessage",
+}
+
+// 污点传播路径上的一个节点，prev 指向上一步，origin 为数据源调用。
+// 静态分析中 origin 也可以是参数的占位节点，占位节点没有 step
+type taintNode struct {
+	source string
+	param  int // 占位节点对应的参数寄存器序号
+	step   entity.TaintStep
+	prev   *taintNode
+	origin *taintNode
+}
+
+// 一个值携带的污点，每个数据源调用最多一个节点
+type taint []*taintNode
+
+func (t taint) union(other taint) taint {
+	if len(other) == 0 {
+		return t
+	}
+	if len(t) == 0 {
+		return other
+	}
+	result := append(taint(nil), t...)
+	for _, n := range other {
+		found := false
+		for _, m := range result {
+			if m.origin == n.origin {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// 在路径上记录一条指令，连续经过同一条指令时只记录一次
+func (t taint) extend(frame *VMFrame, insn *entity.Instruction) taint {
+	if len(t) == 0 {
+		return nil
+	}
+	return t.extendStep(taintStep(frame, insn))
+}
+
+func (t taint) extendStep(step entity.TaintStep) taint {
+	if len(t) == 0 {
+		return nil
+	}
+	result := make(taint, len(t))
+	for i, n := range t {
+		if n.step.Method == step.Method && n.step.Offset == step.Offset {
+			result[i] = n
+			continue
+		}
+		result[i] = &taintNode{source: n.source, param: n.param, step: step, prev: n, origin: n.origin}
+	}
+	return result
+}
+
+// 从数据源到当前节点的路径
+func (n *taintNode) path() []entity.TaintStep {
+	var path []entity.TaintStep
+	for ; n != nil; n = n.prev {
+		if n.step.Method != "" {
+			path = append([]entity.TaintStep{n.step}, path...)
+		}
+	}
+	return path
+}
+
+func taintStep(frame *VMFrame, insn *entity.Instruction) entity.TaintStep {
+	return entity.TaintStep{Method: frame.Name(), Offset: insn.Offset, Insn: FormatInstruction(frame.dex, insn)}
+}
+
+type frameTaint struct {
+	regs    []taint
+	result  taint        // 最近一次调用的返回值，由 move-result 读取
+	pending *pendingCall // 正在执行的 invoke
+	load    pendingLoad  // 正在执行的 aget、iget
+}
+
+// aget、iget 读取的数组或对象，在指令执行前记录，目标寄存器可能与它相同
+type pendingLoad struct {
+	obj   interface{}
+	taint taint // 数组寄存器和数组整体的污点
+}
+
+type fieldTaintKey struct {
+	obj   interface{}
+	field string
+}
+
+// 正在执行的 invoke，被调用方法的参数寄存器按顺序对应 regs
+type pendingCall struct {
+	frame *VMFrame
+	insn  *entity.Instruction
+	ref   *entity.DexMethodRef
+	regs  []taint
+}
+
+// TaintTracker 污点跟踪模式，作为 VMHook 加入 VM.Hooks 后，数据源方法的返回值带有污点，
+// 污点经过寄存器、字段、数组和框架方法传播，到达 sink 方法的参数时记录到 Findings。
+// 框架方法的返回值和可变的 this 对象带有全部参数的污点，String、装箱类型和常量池中的对象除外
+type TaintTracker struct {
+	Sources  []string
+	Sinks    []string
+	Findings []entity.TaintFinding
+
+	frames   map[*VMFrame]*frameTaint
+	heap     map[interface{}]taint // 对象和数组整体的污点
+	fields   map[fieldTaintKey]taint
+	statics  map[string]taint
+	returned taint // 最近一次返回的值
+	found    map[string]bool
+}
+
+// NewTaintTracker 使用默认的数据源和 sink 创建污点跟踪
+func NewTaintTracker() *TaintTracker {
+	return &TaintTracker{
+		Sources: append([]string(nil), DefaultTaintSources...),
+		Sinks:   append([]string(nil), DefaultTaintSinks...),
+		frames:  make(map[*VMFrame]*frameTaint),
+		heap:    make(map[interface{}]taint),
+		fields:  make(map[fieldTaintKey]taint),
+		statics: make(map[string]taint),
+		found:   make(map[string]bool),
+	}
+}
+
+func matchAny(patterns []string, signature string) bool {
+	for _, pattern := range patterns {
+		if matchMethod(pattern, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// 栈帧的污点状态，新的栈帧从正在执行的 invoke 取得参数的污点
+func (t *TaintTracker) frame(vm *VM, f *VMFrame) *frameTaint {
+	if ft, ok := t.frames[f]; ok {
+		return ft
+	}
+	// 因异常退出的栈帧不会执行 return，在这里清理
+	if len(t.frames) >= vm.Depth() {
+		live := make(map[*VMFrame]bool)
+		for _, frame := range vm.frames {
+			live[frame] = true
+		}
+		for frame := range t.frames {
+			if !live[frame] {
+				delete(t.frames, frame)
+			}
+		}
+	}
+	ft := &frameTaint{regs: make([]taint, len(f.regs))}
+	t.frames[f] = ft
+	if len(vm.frames) < 2 {
+		return ft
+	}
+	caller := t.frames[vm.frames[len(vm.frames)-2]]
+	if caller == nil {
+		return ft
+	}
+	// 类初始化等隐式调用的栈帧不对应 invoke
+	if p := caller.pending; p != nil && f.method != nil && f.method.Ref.Name == p.ref.Name &&
+		GetProtoDescriptor(f.method.Ref.Proto) == GetProtoDescriptor(p.ref.Proto) {
+		base := int(f.code.RegistersSize - f.code.InsSize)
+		for i, regTaint := range p.regs {
+			if base+i < len(ft.regs) {
+				ft.regs[base+i] = regTaint.extend(p.frame, p.insn)
+			}
+		}
+		caller.pending = nil
+	}
+	return ft
+}
+
+// 寄存器的污点，引用还包括对象整体的污点
+func (t *TaintTracker) reg(f *VMFrame, ft *frameTaint, r uint32) taint {
+	result := ft.regs[r]
+	if ref := f.refs[r]; ref != nil {
+		result = result.union(t.heap[ref])
+	}
+	return result
+}
+
+func (t *TaintTracker) uses(f *VMFrame, ft *frameTaint, regs []uint32) taint {
+	var result taint
+	for _, r := range regs {
+		result = result.union(t.reg(f, ft, r))
+	}
+	return result
+}
+
+func (t *TaintTracker) setRegs(ft *frameTaint, regs []uint32, value taint) {
+	for _, r := range regs {
+		ft.regs[r] = value
+	}
+}
+
+func (t *TaintTracker) taintObject(ref interface{}, value taint) {
+	if ref != nil && len(value) > 0 {
+		t.heap[ref] = t.heap[ref].union(value)
+	}
+}
+
+func (t *TaintTracker) BeforeInstruction(vm *VM, f *VMFrame, insn *entity.Instruction) error {
+	ft := t.frame(vm, f)
+	op := insn.Opcode
+	switch {
+	case (op >= 0x6e && op <= 0x78) || (op >= 0xfa && op <= 0xfd): // invoke
+		ref, err := GetMethodRef(f.dex, insn.Index)
+		if err != nil {
+			return nil
+		}
+		p := &pendingCall{frame: f, insn: insn, ref: ref, regs: make([]taint, len(insn.Regs))}
+		for i, r := range insn.Regs {
+			p.regs[i] = t.reg(f, ft, r)
+		}
+		ft.pending = p
+		t.returned = nil
+	case op >= 0x44 && op <= 0x4a: // aget
+		ft.load = pendingLoad{obj: f.refs[insn.Regs[1]], taint: t.reg(f, ft, insn.Regs[1])}
+	case op >= 0x52 && op <= 0x58: // iget
+		ft.load = pendingLoad{obj: f.refs[insn.Regs[1]]}
+	case isReturnOp(op):
+		t.returned = t.uses(f, ft, InsnUses(insn)).extend(f, insn)
+	}
+	return nil
+}
+
+func (t *TaintTracker) AfterInstruction(vm *VM, f *VMFrame, insn *entity.Instruction) error {
+	ft := t.frame(vm, f)
+	op := insn.Opcode
+	regs := insn.Regs
+	switch {
+	case (op >= 0x6e && op <= 0x78) || (op >= 0xfa && op <= 0xfd): // invoke
+		ft.result = t.returned
+		ft.pending = nil
+	case op >= 0x0a && op <= 0x0c: // move-result
+		t.setRegs(ft, InsnDefs(insn), ft.result)
+	case op == 0x24 || op == 0x25: // filled-new-array
+		value := t.uses(f, ft, regs).extend(f, insn)
+		ft.result = value
+		t.taintObject(f.result.Ref, value)
+	case op >= 0x44 && op <= 0x4a: // aget
+		t.setRegs(ft, InsnDefs(insn), ft.load.taint.extend(f, insn))
+		ft.load = pendingLoad{}
+	case op >= 0x4b && op <= 0x51: // aput
+		uses := InsnUses(insn)
+		value := t.uses(f, ft, uses[:len(uses)-2]) // 去掉数组和下标
+		t.taintObject(f.refs[regs[1]], value.extend(f, insn))
+	case op >= 0x52 && op <= 0x6d: // iget, iput, sget, sput
+		t.fieldAccess(f, ft, insn)
+	case isReturnOp(op):
+		delete(t.frames, f)
+	default:
+		if defs := InsnDefs(insn); defs != nil {
+			// const、new-instance 等没有读取寄存器，结果不带污点
+			t.setRegs(ft, defs, t.uses(f, ft, InsnUses(insn)))
+		}
+	}
+	return nil
+}
+
+func (t *TaintTracker) fieldAccess(f *VMFrame, ft *frameTaint, insn *entity.Instruction) {
+	ref, err := GetFieldRef(f.dex, insn.Index)
+	if err != nil {
+		return
+	}
+	key := GetFieldSignature(ref)
+	op := insn.Opcode
+	switch {
+	case op <= 0x58: // iget
+		var value taint
+		if ft.load.obj != nil {
+			value = t.fields[fieldTaintKey{ft.load.obj, key}]
+		}
+		t.setRegs(ft, InsnDefs(insn), value.extend(f, insn))
+		ft.load = pendingLoad{}
+	case op <= 0x5f: // iput
+		obj := f.refs[insn.Regs[1]]
+		if obj == nil {
+			return
+		}
+		fieldKey := fieldTaintKey{obj, key}
+		uses := InsnUses(insn)
+		if value := t.uses(f, ft, uses[:len(uses)-1]); len(value) > 0 { // 去掉对象
+			t.fields[fieldKey] = value.extend(f, insn)
+		} else {
+			delete(t.fields, fieldKey)
+		}
+	case op <= 0x66: // sget
+		t.setRegs(ft, InsnDefs(insn), t.statics[key].extend(f, insn))
+	default: // sput
+		if value := t.uses(f, ft, InsnUses(insn)); len(value) > 0 {
+			t.statics[key] = value.extend(f, insn)
+		} else {
+			delete(t.statics, key)
+		}
+	}
+}
+
+func (t *TaintTracker) AfterFrameworkCall(vm *VM, ref *entity.DexMethodRef, args []entity.VMValue, ret entity.VMValue, err error) {
+	if vm.Depth() == 0 {
+		return
+	}
+	ft := t.frames[vm.frames[len(vm.frames)-1]]
+	signature := GetMethodSignature(ref)
+	if ft == nil || ft.pending == nil || GetMethodSignature(ft.pending.ref) != signature {
+		// 不是由 invoke 指令发起的调用
+		return
+	}
+	p := ft.pending
+	var argTaint taint
+	for _, regTaint := range p.regs {
+		argTaint = argTaint.union(regTaint)
+	}
+	if len(argTaint) > 0 && matchAny(t.Sinks, signature) {
+		t.report(signature, p, argTaint)
+	}
+	result := argTaint.extend(p.frame, p.insn)
+	if matchAny(t.Sources, signature) {
+		node := &taintNode{source: signature, step: taintStep(p.frame, p.insn)}
+		node.origin = node
+		result = result.union(taint{node})
+	}
+	static := p.insn.Opcode == 0x71 || p.insn.Opcode == 0x77
+	if !static && len(args) > 0 && mutableReceiver(args[0].Ref) && !vm.sharedObject(args[0].Ref) {
+		t.taintObject(args[0].Ref, result)
+	}
+	if ret.Kind == entity.VM_REF && !vm.sharedObject(ret.Ref) {
+		t.taintObject(ret.Ref, result)
+	}
+	t.returned = result
+}
+
+// 不可变的对象调用框架方法后内容不变，不传入参数的污点
+var immutableClasses = map[string]bool{
+	"Ljava/lang/String;":    true,
+	"Ljava/lang/Integer;":   true,
+	"Ljava/lang/Long;":      true,
+	"Ljava/lang/Short;":     true,
+	"Ljava/lang/Byte;":      true,
+	"Ljava/lang/Character;": true,
+	"Ljava/lang/Boolean;":   true,
+	"Ljava/lang/Float;":     true,
+	"Ljava/lang/Double;":    true,
+	"Ljava/lang/Class;":     true,
+}
+
+// 框架方法可能修改内容的接收者，如 StringBuilder、集合和 Intent
+func mutableReceiver(ref interface{}) bool {
+	obj, ok := ref.(*entity.VMObject)
+	return ok && obj != nil && !immutableClasses[obj.Class]
+}
+
+func (t *TaintTracker) report(sink string, p *pendingCall, value taint) {
+	step := taintStep(p.frame, p.insn)
+	for _, n := range value {
+		key := fmt.Sprintf("%s+%x %s+%x", n.origin.step.Method, n.origin.step.Offset, step.Method, step.Offset)
+		if t.found[key] {
+			continue
+		}
+		t.found[key] = true
+		path := n.path()
+		if last := path[len(path)-1]; last.Method != step.Method || last.Offset != step.Offset {
+			path = append(path, step)
+		}
+		t.Findings = append(t.Findings, entity.TaintFinding{
+			Source: n.source,
+			Sink:   sink,
+			Caller: step.Method,
+			Offset: step.Offset,
+			Path:   path,
+		})
+	}
+}
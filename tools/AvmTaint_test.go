@@ -0,0 +1,86 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"testing"
+)
+
+// aget、iget 的目标寄存器与数组或对象寄存器相同
+const testTaintSmali = `.class public LTaintTest;
+.super Ljava/lang/Object;
+
+.field public n:I
+
+.method public static arrayReuse()V
+    .registers 3
+    const/4 v0, 0x1
+    new-array v0, v0, [I
+    const/4 v1, 0x0
+    invoke-static {}, LSource;->id()I
+    move-result v2
+    aput v2, v0, v1
+    aget v0, v0, v1
+    invoke-static {v0}, LSink;->send(I)V
+    return-void
+.end method
+
+.method public static fieldReuse()V
+    .registers 2
+    new-instance v0, LTaintTest;
+    invoke-static {}, LSource;->id()I
+    move-result v1
+    iput v1, v0, LTaintTest;->n:I
+    iget v0, v0, LTaintTest;->n:I
+    invoke-static {v0}, LSink;->send(I)V
+    return-void
+.end method
+
+.method public static clean()V
+    .registers 2
+    new-instance v0, LTaintTest;
+    const/4 v1, 0x7
+    iput v1, v0, LTaintTest;->n:I
+    iget v0, v0, LTaintTest;->n:I
+    invoke-static {v0}, LSink;->send(I)V
+    return-void
+.end method
+`
+
+func TestTaintRegisterReuse(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		findings int
+	}{
+		{"arrayReuse", 1},
+		{"fieldReuse", 1},
+		{"clean", 0},
+	} {
+		vm, cp, _ := assembleTestSmali(t, testTaintSmali)
+		vm.RegisterStub("LSource;->id()I", func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			return IntValue(42), nil
+		})
+		vm.RegisterStub("LSink;->send(I)V", func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+			return entity.VMValue{}, nil
+		})
+		tracker := NewTaintTracker()
+		tracker.Sources = []string{"LSource;->id"}
+		tracker.Sinks = []string{"LSink;->send"}
+		vm.Hooks = append(vm.Hooks, tracker)
+		method, err := cp.FindMethod(cp.FindClass("LTaintTest;"), c.name, "()V")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := vm.InvokeMethod(method, nil); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if len(tracker.Findings) != c.findings {
+			t.Errorf("%s: %d findings, want %d", c.name, len(tracker.Findings), c.findings)
+			continue
+		}
+		for _, finding := range tracker.Findings {
+			if finding.Source != "LSource;->id()I" || finding.Sink != "LSink;->send(I)V" {
+				t.Errorf("%s: finding %s -> %s", c.name, finding.Source, finding.Sink)
+			}
+		}
+	}
+}
@@ -0,0 +1,493 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// InsnCanThrow 指令是否可能抛出异常
+func InsnCanThrow(op uint8) bool {
+	switch {
+	case op == 0x1a || op == 0x1b || op == 0x1c: // const-string, const-class
+		return true
+	case op >= 0x1d && op <= 0x27: // monitor, check-cast, instance-of, array-length, new-*, fill-array-data, throw
+		return true
+	case op >= 0x44 && op <= 0x6d: // aget/aput/iget/iput/sget/sput
+		return true
+	case op >= 0x6e && op <= 0x72, op >= 0x74 && op <= 0x78: // invoke
+		return true
+	case op == 0x93 || op == 0x94 || op == 0x9e || op == 0x9f: // div/rem int/long
+		return true
+	case op == 0xb3 || op == 0xb4 || op == 0xbe || op == 0xbf: // div/rem 2addr
+		return true
+	case op == 0xd3 || op == 0xd4 || op == 0xdb || op == 0xdc: // div/rem lit
+		return true
+	case op >= 0xfa: // invoke-polymorphic, invoke-custom, const-method-handle, const-method-type
+		return true
+	}
+	return false
+}
+
+// InsnDefs 指令写入的寄存器，long/double 写入一对寄存器。
+// invoke 的返回值由后面的 move-result 写入
+func InsnDefs(insn *entity.Instruction) []uint32 {
+	op := insn.Opcode
+	wide := false
+	switch {
+	case op >= 0x01 && op <= 0x0d: // move, move-result, move-exception
+		wide = (op >= 0x04 && op <= 0x06) || op == 0x0b
+	case op >= 0x12 && op <= 0x1c: // const
+		wide = op >= 0x16 && op <= 0x19
+	case op >= 0x20 && op <= 0x23: // instance-of, array-length, new-instance, new-array
+	case op >= 0x2d && op <= 0x31: // cmp
+	case op >= 0x44 && op <= 0x4a: // aget
+		wide = op == 0x45
+	case op >= 0x52 && op <= 0x58: // iget
+		wide = op == 0x53
+	case op >= 0x60 && op <= 0x66: // sget
+		wide = op == 0x61
+	case op >= 0x7b && op <= 0x8f: // 一元运算和类型转换
+		switch op {
+		case 0x7d, 0x7e, 0x80, 0x81, 0x83, 0x86, 0x88, 0x89, 0x8b:
+			wide = true
+		}
+	case op >= 0x90 && op <= 0xe2: // 二元运算
+		index := op - 0x90
+		if op >= 0xb0 && op <= 0xcf {
+			index = op - 0xb0
+		}
+		wide = op <= 0xcf && ((index >= 11 && index <= 21) || index >= 27)
+	case op == 0xfe || op == 0xff: // const-method-handle, const-method-type
+	default:
+		return nil
+	}
+	if wide {
+		return []uint32{insn.Regs[0], insn.Regs[0] + 1}
+	}
+	return []uint32{insn.Regs[0]}
+}
+
+// InsnUses 指令读取的寄存器，long/double 读取一对寄存器
+func InsnUses(insn *entity.Instruction) []uint32 {
+	op := insn.Opcode
+	regs := insn.Regs
+	pair := func(r uint32, wide bool) []uint32 {
+		if wide {
+			return []uint32{r, r + 1}
+		}
+		return []uint32{r}
+	}
+	switch {
+	case op >= 0x01 && op <= 0x09: // move
+		return pair(regs[1], op >= 0x04 && op <= 0x06)
+	case op >= 0x0f && op <= 0x11: // return
+		return pair(regs[0], op == 0x10)
+	case op == 0x1d || op == 0x1e || op == 0x1f || op == 0x26 || op == 0x27 || isSwitchOp(op):
+		// monitor, check-cast, fill-array-data, throw, switch
+		return []uint32{regs[0]}
+	case op >= 0x20 && op <= 0x23 && op != 0x22: // instance-of, array-length, new-array
+		return []uint32{regs[1]}
+	case op == 0x24 || op == 0x25 || (op >= 0x6e && op <= 0x78) || (op >= 0xfa && op <= 0xfd):
+		// filled-new-array, invoke
+		return append([]uint32(nil), regs...)
+	case op >= 0x2d && op <= 0x31: // cmp
+		wide := op >= 0x2f
+		return append(pair(regs[1], wide), pair(regs[2], wide)...)
+	case op >= 0x32 && op <= 0x37: // if-test
+		return []uint32{regs[0], regs[1]}
+	case op >= 0x38 && op <= 0x3d: // if-testz
+		return []uint32{regs[0]}
+	case op >= 0x44 && op <= 0x4a: // aget
+		return []uint32{regs[1], regs[2]}
+	case op >= 0x4b && op <= 0x51: // aput
+		return append(pair(regs[0], op == 0x4c), regs[1], regs[2])
+	case op >= 0x52 && op <= 0x58: // iget
+		return []uint32{regs[1]}
+	case op >= 0x59 && op <= 0x5f: // iput
+		return append(pair(regs[0], op == 0x5a), regs[1])
+	case op >= 0x67 && op <= 0x6d: // sput
+		return pair(regs[0], op == 0x68)
+	case op >= 0x7b && op <= 0x8f: // 一元运算和类型转换
+		switch op {
+		case 0x7d, 0x7e, 0x80, 0x84, 0x85, 0x86, 0x8a, 0x8b, 0x8c:
+			return pair(regs[1], true)
+		}
+		return []uint32{regs[1]}
+	case op >= 0x90 && op <= 0xcf: // 二元运算
+		index := op - 0x90
+		first := 1
+		if op >= 0xb0 {
+			index = op - 0xb0
+			first = 0
+		}
+		wide := (index >= 11 && index <= 21) || index >= 27
+		// long 移位的位数为 int
+		shift := index >= 19 && index <= 21
+		return append(pair(regs[first], wide), pair(regs[first+1], wide && !shift)...)
+	case op >= 0xd0 && op <= 0xe2: // lit16, lit8
+		return []uint32{regs[1]}
+	}
+	return nil
+}
+
+func isReturnOp(op uint8) bool {
+	return op >= 0x0e && op <= 0x11
+}
+
+func isBranchOp(op uint8) bool {
+	return (op >= 0x28 && op <= 0x2a) || (op >= 0x32 && op <= 0x3d)
+}
+
+func isSwitchOp(op uint8) bool {
+	return op == 0x2b || op == 0x2c
+}
+
+// 指令执行后不会顺序执行下一条
+func endsFlow(op uint8) bool {
+	return isReturnOp(op) || op == 0x27 || (op >= 0x28 && op <= 0x2a)
+}
+
+// switch 指令对应的 payload，目标地址相对于 switch 指令
+func switchPayload(byOffset map[uint32]*entity.Instruction, insn *entity.Instruction) (*entity.InsnPayload, error) {
+	target := byOffset[uint32(int64(insn.Offset)+int64(insn.Branch))]
+	if target == nil || target.Payload == nil {
+		return nil, fmt.Errorf("offset %d: invalid switch payload", insn.Offset)
+	}
+	return target.Payload, nil
+}
+
+// 包含 addr 的 try 块
+func findTry(tries []entity.TryItem, addr uint32) *entity.TryItem {
+	for i := range tries {
+		if addr >= tries[i].StartAddr && addr < tries[i].StartAddr+uint32(tries[i].InsnCount) {
+			return &tries[i]
+		}
+	}
+	return nil
+}
+
+// BuildCFG 为方法代码建立控制流图。try 块中可能抛出异常的指令会结束基本块，
+// 并从该块连出到各个 catch 块的边
+func BuildCFG(code entity.MethodCodeItem) (*entity.MethodCFG, error) {
+	insns, err := DecodeInstructions(code.Insns)
+	if err != nil {
+		return nil, err
+	}
+	byOffset := make(map[uint32]*entity.Instruction)
+	for _, insn := range insns {
+		byOffset[insn.Offset] = insn
+	}
+	inCode := func(addr int64) bool {
+		insn := byOffset[uint32(addr)]
+		return addr >= 0 && insn != nil && insn.Payload == nil
+	}
+
+	// 找出基本块的起点
+	leaders := map[uint32]bool{0: true}
+	addLeader := func(addr int64, from *entity.Instruction) error {
+		if !inCode(addr) {
+			return fmt.Errorf("offset %d: invalid target %d", from.Offset, addr)
+		}
+		leaders[uint32(addr)] = true
+		return nil
+	}
+	for _, try := range code.Tries {
+		leaders[try.StartAddr] = true
+		leaders[try.StartAddr+uint32(try.InsnCount)] = true
+		for _, handler := range try.Handlers {
+			leaders[handler.Addr] = true
+		}
+		if try.CatchAllAddr >= 0 {
+			leaders[uint32(try.CatchAllAddr)] = true
+		}
+	}
+	for _, insn := range insns {
+		if insn.Payload != nil {
+			continue
+		}
+		next := insn.Offset + insn.Size
+		switch {
+		case isBranchOp(insn.Opcode):
+			if err := addLeader(int64(insn.Offset)+int64(insn.Branch), insn); err != nil {
+				return nil, err
+			}
+			leaders[next] = true
+		case isSwitchOp(insn.Opcode):
+			payload, err := switchPayload(byOffset, insn)
+			if err != nil {
+				return nil, err
+			}
+			for _, target := range payload.Targets {
+				if err := addLeader(int64(insn.Offset)+int64(target), insn); err != nil {
+					return nil, err
+				}
+			}
+			leaders[next] = true
+		case endsFlow(insn.Opcode):
+			leaders[next] = true
+		case InsnCanThrow(insn.Opcode) && findTry(code.Tries, insn.Offset) != nil:
+			leaders[next] = true
+		}
+	}
+
+	// 切分基本块，payload 不属于任何基本块
+	cfg := &entity.MethodCFG{}
+	blockAt := make(map[uint32]int)
+	var current *entity.BasicBlock
+	for _, insn := range insns {
+		if insn.Payload != nil {
+			current = nil
+			continue
+		}
+		if current == nil || leaders[insn.Offset] {
+			current = &entity.BasicBlock{Id: len(cfg.Blocks), Start: insn.Offset}
+			blockAt[insn.Offset] = current.Id
+			cfg.Blocks = append(cfg.Blocks, current)
+		}
+		current.Insns = append(current.Insns, insn)
+		current.End = insn.Offset + insn.Size
+	}
+	if len(cfg.Blocks) == 0 {
+		return cfg, nil
+	}
+	for _, try := range code.Tries {
+		for _, handler := range try.Handlers {
+			cfg.Blocks[blockAt[handler.Addr]].Handler = true
+		}
+		if try.CatchAllAddr >= 0 {
+			cfg.Blocks[blockAt[uint32(try.CatchAllAddr)]].Handler = true
+		}
+	}
+
+	// 连接基本块
+	for i, block := range cfg.Blocks {
+		last := block.Insns[len(block.Insns)-1]
+		addEdge := func(addr int64, edge entity.CFGEdge) {
+			edge.To = blockAt[uint32(addr)]
+			block.Succs = append(block.Succs, edge)
+		}
+		fallThrough := func() {
+			if i+1 < len(cfg.Blocks) && cfg.Blocks[i+1].Start == block.End {
+				addEdge(int64(block.End), entity.CFGEdge{Kind: entity.EDGE_FALLTHROUGH})
+			}
+		}
+		switch {
+		case isReturnOp(last.Opcode) || last.Opcode == 0x27:
+			block.Exit = true
+		case last.Opcode >= 0x28 && last.Opcode <= 0x2a:
+			addEdge(int64(last.Offset)+int64(last.Branch), entity.CFGEdge{Kind: entity.EDGE_BRANCH})
+		case isBranchOp(last.Opcode):
+			addEdge(int64(last.Offset)+int64(last.Branch), entity.CFGEdge{Kind: entity.EDGE_BRANCH})
+			fallThrough()
+		case isSwitchOp(last.Opcode):
+			payload, _ := switchPayload(byOffset, last)
+			for k, target := range payload.Targets {
+				key := payload.FirstKey + int32(k)
+				if payload.Ident == entity.SparseSwitchPayload {
+					key = payload.Keys[k]
+				}
+				addEdge(int64(last.Offset)+int64(target), entity.CFGEdge{Kind: entity.EDGE_SWITCH, Key: key})
+			}
+			fallThrough()
+		default:
+			fallThrough()
+		}
+		throws := false
+		for _, insn := range block.Insns {
+			if InsnCanThrow(insn.Opcode) {
+				throws = true
+			}
+		}
+		if try := findTry(code.Tries, block.Start); throws && try != nil {
+			for _, handler := range try.Handlers {
+				addEdge(int64(handler.Addr), entity.CFGEdge{Kind: entity.EDGE_EXCEPTION, CatchType: handler.TypeIdx})
+			}
+			if try.CatchAllAddr >= 0 {
+				addEdge(try.CatchAllAddr, entity.CFGEdge{Kind: entity.EDGE_EXCEPTION, CatchType: entity.NO_INDEX})
+			}
+		}
+	}
+	for _, block := range cfg.Blocks {
+		for _, edge := range block.Succs {
+			preds := &cfg.Blocks[edge.To].Preds
+			if len(*preds) == 0 || (*preds)[len(*preds)-1] != block.Id {
+				*preds = append(*preds, block.Id)
+			}
+		}
+	}
+	cfg.Idom = computeDominators(cfg)
+	cfg.Loops = findLoops(cfg)
+	return cfg, nil
+}
+
+// 从入口开始的逆后序
+func reversePostOrder(cfg *entity.MethodCFG) []int {
+	visited := make([]bool, len(cfg.Blocks))
+	var order []int
+	var visit func(id int)
+	visit = func(id int) {
+		visited[id] = true
+		for _, edge := range cfg.Blocks[id].Succs {
+			if !visited[edge.To] {
+				visit(edge.To)
+			}
+		}
+		order = append(order, id)
+	}
+	visit(0)
+	for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// Cooper-Harvey-Kennedy 迭代算法计算直接支配者
+func computeDominators(cfg *entity.MethodCFG) []int {
+	order := reversePostOrder(cfg)
+	rpo := make([]int, len(cfg.Blocks))
+	for i := range rpo {
+		rpo[i] = -1
+	}
+	for i, id := range order {
+		rpo[id] = i
+	}
+	idom := make([]int, len(cfg.Blocks))
+	for i := range idom {
+		idom[i] = -1
+	}
+	idom[0] = 0
+	intersect := func(a, b int) int {
+		for a != b {
+			for rpo[a] > rpo[b] {
+				a = idom[a]
+			}
+			for rpo[b] > rpo[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, id := range order[1:] {
+			newIdom := -1
+			for _, pred := range cfg.Blocks[id].Preds {
+				if idom[pred] < 0 {
+					continue
+				}
+				if newIdom < 0 {
+					newIdom = pred
+				} else {
+					newIdom = intersect(pred, newIdom)
+				}
+			}
+			if newIdom != idom[id] {
+				idom[id] = newIdom
+				changed = true
+			}
+		}
+	}
+	idom[0] = -1
+	return idom
+}
+
+// Dominates 基本块 a 是否支配基本块 b，每个可达的块都支配自身
+func Dominates(cfg *entity.MethodCFG, a int, b int) bool {
+	if b != 0 && cfg.Idom[b] < 0 {
+		return false
+	}
+	for ; b >= 0; b = cfg.Idom[b] {
+		if a == b {
+			return true
+		}
+	}
+	return false
+}
+
+// DominatorTree 返回支配树中每个基本块的直接子节点
+func DominatorTree(cfg *entity.MethodCFG) [][]int {
+	children := make([][]int, len(cfg.Blocks))
+	for id, parent := range cfg.Idom {
+		if parent >= 0 {
+			children[parent] = append(children[parent], id)
+		}
+	}
+	return children
+}
+
+// 通过回边（目标支配起点的边）找出自然循环，同一个头的循环合并
+func findLoops(cfg *entity.MethodCFG) []entity.Loop {
+	byHeader := make(map[int]*entity.Loop)
+	var headers []int
+	for _, block := range cfg.Blocks {
+		for _, edge := range block.Succs {
+			if !Dominates(cfg, edge.To, block.Id) {
+				continue
+			}
+			loop := byHeader[edge.To]
+			if loop == nil {
+				loop = &entity.Loop{Header: edge.To}
+				byHeader[edge.To] = loop
+				headers = append(headers, edge.To)
+			}
+			loop.Latches = append(loop.Latches, block.Id)
+		}
+	}
+	sort.Ints(headers)
+	var loops []entity.Loop
+	for _, header := range headers {
+		loop := byHeader[header]
+		body := map[int]bool{header: true}
+		stack := append([]int{}, loop.Latches...)
+		for len(stack) > 0 {
+			id := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if body[id] {
+				continue
+			}
+			body[id] = true
+			for _, pred := range cfg.Blocks[id].Preds {
+				// 不可达的块不属于循环
+				if pred == 0 || cfg.Idom[pred] >= 0 {
+					stack = append(stack, pred)
+				}
+			}
+		}
+		for id := range body {
+			loop.Blocks = append(loop.Blocks, id)
+		}
+		sort.Ints(loop.Blocks)
+		loops = append(loops, *loop)
+	}
+	return loops
+}
+
+// ExportCFGDOT 以 Graphviz DOT 格式导出控制流图，异常边为虚线
+func ExportCFGDOT(cfg *entity.MethodCFG, w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph cfg {\n  node [shape=box, fontname=monospace];\n")
+	for _, block := range cfg.Blocks {
+		// 每行指令以 \l 结束，左对齐
+		label := ""
+		for _, insn := range block.Insns {
+			label += fmt.Sprintf("%04x: %s\\l", insn.Offset, OpcodeName(insn))
+		}
+		fmt.Fprintf(&sb, "  b%d [label=\"%s\"];\n", block.Id, label)
+		for _, edge := range block.Succs {
+			attrs := []string{"label=" + dotQuote(edge.Kind)}
+			if edge.Kind == entity.EDGE_SWITCH {
+				attrs[0] = fmt.Sprintf("label=\"case %d\"", edge.Key)
+			}
+			if edge.Kind == entity.EDGE_EXCEPTION {
+				attrs = append(attrs, "style=dashed")
+			}
+			fmt.Fprintf(&sb, "  b%d -> b%d [%s];\n", block.Id, edge.To, strings.Join(attrs, ", "))
+		}
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
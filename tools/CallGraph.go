@@ -0,0 +1,307 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// 组件被框架调用的生命周期方法
+var (
+	applicationEntryMethods = []string{
+		"attachBaseContext(Landroid/content/Context;)V",
+		"onCreate()V",
+		"onConfigurationChanged(Landroid/content/res/Configuration;)V",
+		"onLowMemory()V",
+		"onTrimMemory(I)V",
+		"onTerminate()V",
+	}
+	activityEntryMethods = []string{
+		"attachBaseContext(Landroid/content/Context;)V",
+		"onCreate(Landroid/os/Bundle;)V",
+		"onStart()V",
+		"onRestart()V",
+		"onResume()V",
+		"onPostResume()V",
+		"onPause()V",
+		"onStop()V",
+		"onDestroy()V",
+		"onNewIntent(Landroid/content/Intent;)V",
+		"onActivityResult(IILandroid/content/Intent;)V",
+		"onSaveInstanceState(Landroid/os/Bundle;)V",
+		"onRestoreInstanceState(Landroid/os/Bundle;)V",
+		"onBackPressed()V",
+		"onCreateOptionsMenu(Landroid/view/Menu;)Z",
+		"onOptionsItemSelected(Landroid/view/MenuItem;)Z",
+		"onRequestPermissionsResult(I[Ljava/lang/String;[I)V",
+		"onWindowFocusChanged(Z)V",
+	}
+)
+
+type callGraphBuilder struct {
+	cp        *ClassPath
+	hierarchy *ClassHierarchy
+	graph     *entity.CallGraph
+	ids       map[string]int
+}
+
+func (b *callGraphBuilder) node(method string, external bool) int {
+	if id, ok := b.ids[method]; ok {
+		return id
+	}
+	id := len(b.graph.Nodes)
+	b.ids[method] = id
+	b.graph.Nodes = append(b.graph.Nodes, entity.CallGraphNode{Id: id, Method: method, External: external})
+	return id
+}
+
+// BuildCallGraph 建立全部方法的静态调用图。调用目标按类路径解析到定义方法的类，
+// 无法解析的方法（例如框架方法）作为外部节点。invoke-virtual 和 invoke-interface 按类层次分析（CHA）
+// 另外连到子类和实现类中覆盖的方法。invoke-custom 没有静态目标，不产生边
+func BuildCallGraph(cp *ClassPath) (*entity.CallGraph, error) {
+	b := &callGraphBuilder{cp: cp, hierarchy: BuildClassHierarchy(cp), graph: &entity.CallGraph{}, ids: make(map[string]int)}
+	for _, class := range cp.Order {
+		methods, err := cp.Methods(class)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", class.Name, err)
+		}
+		for _, method := range methods {
+			from := b.node(GetMethodSignature(&method.Ref), false)
+			if method.CodeOff == 0 {
+				continue
+			}
+			if err := b.addCalls(from, method); err != nil {
+				return nil, fmt.Errorf("%s: %v", GetMethodSignature(&method.Ref), err)
+			}
+		}
+	}
+	return b.graph, nil
+}
+
+func (b *callGraphBuilder) addCalls(from int, method *entity.ClassMethod) error {
+	dex := method.Class.Dex
+	code, err := ReadCodeItem(dex, method.CodeOff)
+	if err != nil {
+		return err
+	}
+	insns, err := DecodeInstructions(code.Insns)
+	if err != nil {
+		return err
+	}
+	for _, insn := range insns {
+		index := Opcodes[insn.Opcode].Index
+		if insn.Payload != nil || (index != entity.IndexMethod && index != entity.IndexMethodAndProto) {
+			continue
+		}
+		ref, err := GetMethodRef(dex, insn.Index)
+		if err != nil {
+			return fmt.Errorf("offset %d: %v", insn.Offset, err)
+		}
+		var to int
+		if target, err := b.cp.ResolveMethod(ref); err == nil {
+			to = b.node(GetMethodSignature(&target.Ref), false)
+		} else {
+			to = b.node(GetMethodSignature(ref), true)
+		}
+		edge := entity.CallGraphEdge{
+			From:   from,
+			To:     to,
+			Kind:   strings.TrimSuffix(OpcodeName(insn), "/range"),
+			Offset: insn.Offset,
+		}
+		b.graph.Edges = append(b.graph.Edges, edge)
+		switch insn.Opcode {
+		case 0x6e, 0x72, 0x74, 0x78: // invoke-virtual, invoke-interface
+			edge.Override = true
+			for _, target := range b.overrides(ref) {
+				if id := b.node(target, false); id != to {
+					edge.To = id
+					b.graph.Edges = append(b.graph.Edges, edge)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 虚方法调用在运行时可能到达的方法：引用的类的全部子类和实现类中，
+// 不是抽象类的类按虚方法规则解析得到的方法，按签名排序
+func (b *callGraphBuilder) overrides(ref *entity.DexMethodRef) []string {
+	targets := make(map[string]bool)
+	for _, names := range [][]string{b.hierarchy.SubClasses(ref.Class), b.hierarchy.Implementers(ref.Class)} {
+		for _, name := range names {
+			class := b.cp.Classes[name]
+			if class == nil || class.Def.Access_flags_&(entity.ACC_ABSTRACT|entity.ACC_INTERFACE) != 0 {
+				continue
+			}
+			method, err := b.cp.ResolveVirtual(name, ref)
+			if err != nil || method == nil || method.AccessFlags&entity.ACC_ABSTRACT != 0 {
+				continue
+			}
+			targets[GetMethodSignature(&method.Ref)] = true
+		}
+	}
+	return sortedKeys(targets)
+}
+
+// 清单中的类名可能以 . 开头，表示相对于包名
+func componentClassName(pkg string, name string) string {
+	if strings.HasPrefix(name, ".") {
+		return pkg + name
+	}
+	if !strings.Contains(name, ".") && pkg != "" {
+		return pkg + "." + name
+	}
+	return name
+}
+
+// ManifestEntryPoints 返回清单中 Application 和 Activity 的入口方法签名：
+// 静态初始化、构造方法和生命周期方法，生命周期方法可以定义在应用内的父类中
+func ManifestEntryPoints(cp *ClassPath, manifest *entity.ManifestData) []string {
+	var entries []string
+	seen := make(map[string]bool)
+	add := func(className string, methods []string) {
+		class := cp.FindClass(className)
+		if class == nil {
+			return
+		}
+		for _, desc := range append([]string{"<clinit>()V", "<init>()V"}, methods...) {
+			ref, err := ParseMethodSignature(class.Name + "->" + desc)
+			if err != nil {
+				continue
+			}
+			var method *entity.ClassMethod
+			if ref.Name == "<clinit>" || ref.Name == "<init>" {
+				method, _ = cp.FindMethod(class, ref.Name, GetProtoDescriptor(ref.Proto))
+			} else {
+				method, _ = cp.ResolveVirtual(class.Name, ref)
+			}
+			if method == nil {
+				continue
+			}
+			sig := GetMethodSignature(&method.Ref)
+			if !seen[sig] {
+				seen[sig] = true
+				entries = append(entries, sig)
+			}
+		}
+	}
+	if manifest.Application != "" {
+		add(componentClassName(manifest.PackageName, manifest.Application), applicationEntryMethods)
+	}
+	// 按名称排序，保证每次的结果相同
+	for _, activity := range sortedKeys(manifest.Activity) {
+		add(componentClassName(manifest.PackageName, activity), activityEntryMethods)
+	}
+	return entries
+}
+
+// ReachableCallGraph 返回从入口方法出发可达的子图，入口方法标记为 Entry
+func ReachableCallGraph(graph *entity.CallGraph, entries []string) *entity.CallGraph {
+	byMethod := make(map[string]int)
+	for _, node := range graph.Nodes {
+		byMethod[node.Method] = node.Id
+	}
+	out := make(map[int][]int)
+	for i, edge := range graph.Edges {
+		out[edge.From] = append(out[edge.From], i)
+	}
+	newIds := make(map[int]int)
+	sub := &entity.CallGraph{}
+	visit := func(id int) int {
+		if newId, ok := newIds[id]; ok {
+			return newId
+		}
+		node := graph.Nodes[id]
+		node.Id = len(sub.Nodes)
+		newIds[id] = node.Id
+		sub.Nodes = append(sub.Nodes, node)
+		return node.Id
+	}
+	var queue []int
+	for _, entry := range entries {
+		id, ok := byMethod[entry]
+		if !ok {
+			continue
+		}
+		if _, ok := newIds[id]; !ok {
+			queue = append(queue, id)
+		}
+		sub.Nodes[visit(id)].Entry = true
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, i := range out[id] {
+			edge := graph.Edges[i]
+			if _, ok := newIds[edge.To]; !ok {
+				queue = append(queue, edge.To)
+			}
+			edge.From = newIds[id]
+			edge.To = visit(edge.To)
+			sub.Edges = append(sub.Edges, edge)
+		}
+	}
+	return sub
+}
+
+// ExportCallGraphJSON 以 JSON 格式导出调用图
+func ExportCallGraphJSON(graph *entity.CallGraph, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(graph)
+}
+
+// ExportCallGraphDOT 以 Graphviz DOT 格式导出调用图，边上标注调用类型
+func ExportCallGraphDOT(graph *entity.CallGraph, w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph callgraph {\n  node [shape=box];\n")
+	for _, node := range graph.Nodes {
+		attrs := []string{"label=" + dotQuote(node.Method)}
+		if node.External {
+			attrs = append(attrs, "style=dashed", "color=gray")
+		}
+		if node.Entry {
+			attrs = append(attrs, "penwidth=2", "color=red")
+		}
+		fmt.Fprintf(&sb, "  n%d [%s];\n", node.Id, strings.Join(attrs, ", "))
+	}
+	for _, edge := range graph.Edges {
+		fmt.Fprintf(&sb, "  n%d -> n%d [label=%s];\n", edge.From, edge.To, dotQuote(edge.Kind))
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ExportCallGraphGraphML 以 GraphML 格式导出调用图
+func ExportCallGraphGraphML(graph *entity.CallGraph, w io.Writer) error {
+	var sb strings.Builder
+	escape := func(s string) string {
+		var buf strings.Builder
+		xml.EscapeText(&buf, []byte(s))
+		return buf.String()
+	}
+	sb.WriteString(xml.Header)
+	sb.WriteString("<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	sb.WriteString("  <key id=\"method\" for=\"node\" attr.name=\"method\" attr.type=\"string\"/>\n")
+	sb.WriteString("  <key id=\"external\" for=\"node\" attr.name=\"external\" attr.type=\"boolean\"/>\n")
+	sb.WriteString("  <key id=\"entry\" for=\"node\" attr.name=\"entry\" attr.type=\"boolean\"/>\n")
+	sb.WriteString("  <key id=\"kind\" for=\"edge\" attr.name=\"kind\" attr.type=\"string\"/>\n")
+	sb.WriteString("  <key id=\"offset\" for=\"edge\" attr.name=\"offset\" attr.type=\"int\"/>\n")
+	sb.WriteString("  <graph id=\"callgraph\" edgedefault=\"directed\">\n")
+	for _, node := range graph.Nodes {
+		fmt.Fprintf(&sb, "    <node id=\"n%d\"><data key=\"method\">%s</data><data key=\"external\">%t</data><data key=\"entry\">%t</data></node>\n",
+			node.Id, escape(node.Method), node.External, node.Entry)
+	}
+	for i, edge := range graph.Edges {
+		fmt.Fprintf(&sb, "    <edge id=\"e%d\" source=\"n%d\" target=\"n%d\"><data key=\"kind\">%s</data><data key=\"offset\">%d</data></edge>\n",
+			i, edge.From, edge.To, escape(edge.Kind), edge.Offset)
+	}
+	sb.WriteString("  </graph>\n</graphml>\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
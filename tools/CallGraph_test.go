@@ -0,0 +1,164 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"sort"
+	"testing"
+)
+
+var testCallGraphSmali = []string{`.class public interface abstract LShape;
+.super Ljava/lang/Object;
+
+.method public abstract area()I
+.end method
+`, `.class public LBase;
+.super Ljava/lang/Object;
+.implements LShape;
+
+.method public area()I
+    .registers 2
+    const/4 v0, 0x0
+    return v0
+.end method
+`, `.class public LSquare;
+.super LBase;
+
+.method public area()I
+    .registers 2
+    const/4 v0, 0x4
+    return v0
+.end method
+`, `.class public abstract LShadow;
+.super LBase;
+`, `.class public LCircle;
+.super Ljava/lang/Object;
+.implements LShape;
+
+.method public area()I
+    .registers 2
+    const/4 v0, 0x3
+    return v0
+.end method
+`, `.class public LMain;
+.super Ljava/lang/Object;
+
+.method public static run(LShape;LBase;)V
+    .registers 2
+    invoke-interface {p0}, LShape;->area()I
+    invoke-virtual {p1}, LBase;->area()I
+    return-void
+.end method
+`}
+
+// 汇编多个类到一个 dex 中
+func assembleTestClassPath(t *testing.T, sources []string) *ClassPath {
+	t.Helper()
+	model := &entity.DexModel{}
+	for _, src := range sources {
+		class, err := ParseSmali("test.smali", src)
+		if err != nil {
+			t.Fatalf("ParseSmali: %v", err)
+		}
+		model.Classes = append(model.Classes, class)
+	}
+	data, err := WriteDex(model)
+	if err != nil {
+		t.Fatalf("WriteDex: %v", err)
+	}
+	dexs, err := ParseDex(data, "classes.dex")
+	if err != nil {
+		t.Fatalf("ParseDex: %v", err)
+	}
+	if !Verify(dexs[0]) {
+		t.Fatalf("assembled dex does not verify")
+	}
+	cp, err := NewClassPath(dexs)
+	if err != nil {
+		t.Fatalf("NewClassPath: %v", err)
+	}
+	return cp
+}
+
+func TestCallGraphOverrides(t *testing.T) {
+	cp := assembleTestClassPath(t, testCallGraphSmali)
+	graph, err := BuildCallGraph(cp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 静态解析的目标不标记 Override
+	resolved := map[string]string{
+		"invoke-interface": "LShape;->area()I",
+		"invoke-virtual":   "LBase;->area()I",
+	}
+	calls := make(map[string][]string)
+	for _, edge := range graph.Edges {
+		if graph.Nodes[edge.From].Method != "LMain;->run(LShape;LBase;)V" {
+			continue
+		}
+		to := graph.Nodes[edge.To].Method
+		calls[edge.Kind] = append(calls[edge.Kind], to)
+		if edge.Override != (to != resolved[edge.Kind]) {
+			t.Errorf("%s edge to %s has override %v", edge.Kind, to, edge.Override)
+		}
+	}
+	want := map[string][]string{
+		"invoke-interface": {"LBase;->area()I", "LCircle;->area()I", "LShape;->area()I", "LSquare;->area()I"},
+		"invoke-virtual":   {"LBase;->area()I", "LSquare;->area()I"},
+	}
+	for kind, targets := range want {
+		got := calls[kind]
+		sort.Strings(got)
+		if len(got) != len(targets) {
+			t.Errorf("%s targets %v, want %v", kind, got, targets)
+			continue
+		}
+		for i := range got {
+			if got[i] != targets[i] {
+				t.Errorf("%s targets %v, want %v", kind, got, targets)
+				break
+			}
+		}
+	}
+}
+
+func TestManifestEntryPointsOrder(t *testing.T) {
+	sources := []string{`.class public Lcom/test/App;
+.super Landroid/app/Application;
+
+.method public onCreate()V
+    .registers 1
+    return-void
+.end method
+`}
+	names := []string{"B", "C", "A", "E", "D"}
+	for _, name := range names {
+		sources = append(sources, `.class public Lcom/test/`+name+`;
+.super Landroid/app/Activity;
+
+.method public onCreate(Landroid/os/Bundle;)V
+    .registers 2
+    return-void
+.end method
+`)
+	}
+	cp := assembleTestClassPath(t, sources)
+	manifest := &entity.ManifestData{PackageName: "com.test", Application: ".App", Activity: make(map[string]bool)}
+	for _, name := range names {
+		manifest.Activity["."+name] = true
+	}
+	want := []string{"Lcom/test/App;->onCreate()V"}
+	for _, name := range []string{"A", "B", "C", "D", "E"} {
+		want = append(want, "Lcom/test/"+name+";->onCreate(Landroid/os/Bundle;)V")
+	}
+	for i := 0; i < 5; i++ {
+		entries := ManifestEntryPoints(cp, manifest)
+		if len(entries) != len(want) {
+			t.Fatalf("entries %v, want %v", entries, want)
+		}
+		for j := range entries {
+			if entries[j] != want[j] {
+				t.Fatalf("entries %v, want %v", entries, want)
+			}
+		}
+	}
+}
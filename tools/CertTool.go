@@ -0,0 +1,139 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Android SDK 生成的调试证书的 CN
+const androidDebugCN = "Android Debug"
+
+// InspectCertificate 读取证书的主体、颁发者、有效期、序列号、密钥和指纹，检查调试证书和弱密钥
+func InspectCertificate(cert *x509.Certificate) entity.CertInfo {
+	md5Sum := md5.Sum(cert.Raw)
+	sha1Sum := sha1.Sum(cert.Raw)
+	sha256Sum := sha256.Sum256(cert.Raw)
+	info := entity.CertInfo{
+		Subject:   cert.Subject.String(),
+		Issuer:    cert.Issuer.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+		Serial:    cert.SerialNumber.Text(16),
+		MD5:       md5Sum[:],
+		SHA1:      sha1Sum[:],
+		SHA256:    sha256Sum[:],
+	}
+	minSize := 2048
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		info.KeyAlgorithm, info.KeySize = "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		info.KeyAlgorithm, info.KeySize = "EC", key.Curve.Params().BitSize
+		minSize = 256
+	case *dsa.PublicKey:
+		info.KeyAlgorithm, info.KeySize = "DSA", key.P.BitLen()
+	case ed25519.PublicKey:
+		info.KeyAlgorithm, info.KeySize = "Ed25519", 256
+		minSize = 256
+	default:
+		info.KeyAlgorithm = cert.PublicKeyAlgorithm.String()
+	}
+	if cert.Subject.CommonName == androidDebugCN {
+		info.Debug = true
+		info.Warnings = append(info.Warnings, "debug certificate")
+	}
+	if info.KeySize > 0 && info.KeySize < minSize {
+		info.WeakKey = true
+		info.Warnings = append(info.Warnings, fmt.Sprintf("weak %s key of %d bits", info.KeyAlgorithm, info.KeySize))
+	}
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		info.Warnings = append(info.Warnings, "weak signature algorithm "+cert.SignatureAlgorithm.String())
+	}
+	if time.Now().After(cert.NotAfter) {
+		info.Warnings = append(info.Warnings, "expired")
+	}
+	return info
+}
+
+// SignerCertificates 返回各签名方案的签名证书，同一证书只出现一次，不包括证书轮换记录中以前的证书
+func SignerCertificates(report *entity.SignatureReport) []entity.CertInfo {
+	var result []entity.CertInfo
+	index := make(map[string]int)
+	for _, scheme := range report.Schemes {
+		for _, signer := range scheme.Signers {
+			if len(signer.Certificates) == 0 {
+				continue
+			}
+			cert := signer.Certificates[0]
+			i, ok := index[string(cert.Raw)]
+			if !ok {
+				i = len(result)
+				index[string(cert.Raw)] = i
+				result = append(result, InspectCertificate(cert))
+			}
+			if !containsInt(result[i].Schemes, scheme.Version) {
+				result[i].Schemes = append(result[i].Schemes, scheme.Version)
+			}
+		}
+	}
+	return result
+}
+
+// FormatFingerprint 按 keytool 的格式输出指纹，如 AB:CD:...
+func FormatFingerprint(fingerprint []byte) string {
+	parts := make([]string, len(fingerprint))
+	for i, b := range fingerprint {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// CheckCertFingerprints 检查 APK 的签名通过验证，并且每个签名方案的签名证书都是 expected 之一。
+// 指纹可以是 MD5、SHA-1 或 SHA-256，按长度区分，可以带冒号，不区分大小写
+func CheckCertFingerprints(report *entity.SignatureReport, expected ...string) error {
+	if !report.Verified {
+		return fmt.Errorf("signatures not verified")
+	}
+	var wanted [][]byte
+	for _, fp := range expected {
+		raw, err := hex.DecodeString(strings.NewReplacer(":", "", " ", "").Replace(fp))
+		if err != nil {
+			return fmt.Errorf("fingerprint %q: %v", fp, err)
+		}
+		switch len(raw) {
+		case md5.Size, sha1.Size, sha256.Size:
+		default:
+			return fmt.Errorf("fingerprint %q: unknown length %d", fp, len(raw))
+		}
+		wanted = append(wanted, raw)
+	}
+	certs := SignerCertificates(report)
+	if len(certs) == 0 {
+		return fmt.Errorf("no signing certificates")
+	}
+	for _, cert := range certs {
+		match := false
+		for _, raw := range wanted {
+			if bytes.Equal(raw, cert.MD5) || bytes.Equal(raw, cert.SHA1) || bytes.Equal(raw, cert.SHA256) {
+				match = true
+			}
+		}
+		if !match {
+			return fmt.Errorf("certificate %s with SHA-256 %s is not expected", cert.Subject, FormatFingerprint(cert.SHA256))
+		}
+	}
+	return nil
+}
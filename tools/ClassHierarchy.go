@@ -0,0 +1,237 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// 框架组件基类
+var frameworkBases = map[string]string{
+	entity.FRAMEWORK_ACTIVITY_CLASS:    entity.FRAMEWORK_ACTIVITY,
+	entity.FRAMEWORK_SERVICE_CLASS:     entity.FRAMEWORK_SERVICE,
+	entity.FRAMEWORK_RECEIVER_CLASS:    entity.FRAMEWORK_RECEIVER,
+	entity.FRAMEWORK_PROVIDER_CLASS:    entity.FRAMEWORK_PROVIDER,
+	entity.FRAMEWORK_APPLICATION_CLASS: entity.FRAMEWORK_APPLICATION,
+	// APK 中不包含的框架子类，无法继续沿父类查找
+	"Landroid/app/ListActivity;":                                 entity.FRAMEWORK_ACTIVITY,
+	"Landroid/app/ActivityGroup;":                                entity.FRAMEWORK_ACTIVITY,
+	"Landroid/app/TabActivity;":                                  entity.FRAMEWORK_ACTIVITY,
+	"Landroid/app/NativeActivity;":                               entity.FRAMEWORK_ACTIVITY,
+	"Landroid/app/AliasActivity;":                                entity.FRAMEWORK_ACTIVITY,
+	"Landroid/app/LauncherActivity;":                             entity.FRAMEWORK_ACTIVITY,
+	"Landroid/app/ExpandableListActivity;":                       entity.FRAMEWORK_ACTIVITY,
+	"Landroid/preference/PreferenceActivity;":                    entity.FRAMEWORK_ACTIVITY,
+	"Landroid/accounts/AccountAuthenticatorActivity;":            entity.FRAMEWORK_ACTIVITY,
+	"Landroid/app/IntentService;":                                entity.FRAMEWORK_SERVICE,
+	"Landroid/app/job/JobService;":                               entity.FRAMEWORK_SERVICE,
+	"Landroid/accessibilityservice/AccessibilityService;":        entity.FRAMEWORK_SERVICE,
+	"Landroid/inputmethodservice/InputMethodService;":            entity.FRAMEWORK_SERVICE,
+	"Landroid/inputmethodservice/AbstractInputMethodService;":    entity.FRAMEWORK_SERVICE,
+	"Landroid/service/notification/NotificationListenerService;": entity.FRAMEWORK_SERVICE,
+	"Landroid/service/wallpaper/WallpaperService;":               entity.FRAMEWORK_SERVICE,
+	"Landroid/service/dreams/DreamService;":                      entity.FRAMEWORK_SERVICE,
+	"Landroid/widget/RemoteViewsService;":                        entity.FRAMEWORK_SERVICE,
+	"Landroid/net/VpnService;":                                   entity.FRAMEWORK_SERVICE,
+	"Landroid/appwidget/AppWidgetProvider;":                      entity.FRAMEWORK_RECEIVER,
+	"Landroid/app/admin/DeviceAdminReceiver;":                    entity.FRAMEWORK_RECEIVER,
+	"Landroid/content/SearchRecentSuggestionsProvider;":          entity.FRAMEWORK_PROVIDER,
+	"Landroid/provider/DocumentsProvider;":                       entity.FRAMEWORK_PROVIDER,
+}
+
+// ClassHierarchy 类路径中全部类的继承和接口实现关系
+type ClassHierarchy struct {
+	cp           *ClassPath
+	subclasses   map[string][]string // 直接子类
+	implementers map[string][]string // 直接实现接口的类，以及直接继承接口的接口
+}
+
+// BuildClassHierarchy 根据类路径建立继承关系
+func BuildClassHierarchy(cp *ClassPath) *ClassHierarchy {
+	h := &ClassHierarchy{
+		cp:           cp,
+		subclasses:   make(map[string][]string),
+		implementers: make(map[string][]string),
+	}
+	for _, class := range cp.Order {
+		if class.SuperClass != "" {
+			h.subclasses[class.SuperClass] = append(h.subclasses[class.SuperClass], class.Name)
+		}
+		for _, iface := range class.Interfaces {
+			h.implementers[iface] = append(h.implementers[iface], class.Name)
+		}
+	}
+	return h
+}
+
+func (h *ClassHierarchy) isInterface(name string) bool {
+	class := h.cp.Classes[name]
+	return class != nil && class.Def.Access_flags_&entity.ACC_INTERFACE != 0
+}
+
+func sortedKeys(set map[string]bool) []string {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// SubClasses 返回 name 的全部子类（包括间接子类），name 可以是框架类
+func (h *ClassHierarchy) SubClasses(name string) []string {
+	found := make(map[string]bool)
+	h.collectSubclasses(name, found)
+	return sortedKeys(found)
+}
+
+func (h *ClassHierarchy) collectSubclasses(name string, found map[string]bool) {
+	for _, sub := range h.subclasses[name] {
+		if !found[sub] {
+			found[sub] = true
+			h.collectSubclasses(sub, found)
+		}
+	}
+}
+
+// Implementers 返回实现了接口 iface 的全部类，包括通过父类或子接口间接实现的类
+func (h *ClassHierarchy) Implementers(iface string) []string {
+	ifaces := map[string]bool{iface: true}
+	queue := []string{iface}
+	classes := make(map[string]bool)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, impl := range h.implementers[name] {
+			if h.isInterface(impl) {
+				if !ifaces[impl] {
+					ifaces[impl] = true
+					queue = append(queue, impl)
+				}
+				continue
+			}
+			if !classes[impl] {
+				classes[impl] = true
+				h.collectSubclasses(impl, classes)
+			}
+		}
+	}
+	return sortedKeys(classes)
+}
+
+// Ancestors 返回父类链，从直接父类开始，到类路径之外的第一个类为止（通常是框架类）
+func (h *ClassHierarchy) Ancestors(name string) []string {
+	var chain []string
+	visited := map[string]bool{name: true}
+	for class := h.cp.Classes[name]; class != nil && class.SuperClass != ""; class = h.cp.Classes[class.SuperClass] {
+		if visited[class.SuperClass] {
+			break
+		}
+		visited[class.SuperClass] = true
+		chain = append(chain, class.SuperClass)
+	}
+	return chain
+}
+
+// AllInterfaces 返回类直接或间接实现的全部接口
+func (h *ClassHierarchy) AllInterfaces(name string) []string {
+	found := make(map[string]bool)
+	var collect func(name string)
+	collect = func(name string) {
+		class := h.cp.Classes[name]
+		if class == nil {
+			return
+		}
+		for _, iface := range class.Interfaces {
+			if !found[iface] {
+				found[iface] = true
+				collect(iface)
+			}
+		}
+	}
+	collect(name)
+	for _, ancestor := range h.Ancestors(name) {
+		collect(ancestor)
+	}
+	return sortedKeys(found)
+}
+
+// FrameworkBase 返回类最终继承的 Android 组件类型（entity.FRAMEWORK_*），不是组件返回空串
+func (h *ClassHierarchy) FrameworkBase(name string) string {
+	if kind, ok := frameworkBases[name]; ok {
+		return kind
+	}
+	for _, ancestor := range h.Ancestors(name) {
+		if kind, ok := frameworkBases[ancestor]; ok {
+			return kind
+		}
+	}
+	return ""
+}
+
+// Nodes 返回继承关系图中的全部节点，包括被引用的外部类
+func (h *ClassHierarchy) Nodes() []entity.HierarchyNode {
+	var nodes []entity.HierarchyNode
+	external := make(map[string]bool)
+	for _, class := range h.cp.Order {
+		nodes = append(nodes, entity.HierarchyNode{
+			Name:       class.Name,
+			SuperClass: class.SuperClass,
+			Interfaces: class.Interfaces,
+			Interface:  class.Def.Access_flags_&entity.ACC_INTERFACE != 0,
+			Framework:  h.FrameworkBase(class.Name),
+			Dex:        class.Dex.FileName,
+		})
+		for _, name := range append([]string{class.SuperClass}, class.Interfaces...) {
+			if name != "" && h.cp.Classes[name] == nil {
+				external[name] = true
+			}
+		}
+	}
+	for _, name := range sortedKeys(external) {
+		nodes = append(nodes, entity.HierarchyNode{Name: name, External: true, Framework: frameworkBases[name]})
+	}
+	return nodes
+}
+
+// ExportHierarchyJSON 以 JSON 格式导出继承关系
+func ExportHierarchyJSON(h *ClassHierarchy, w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(h.Nodes())
+}
+
+// ExportHierarchyDOT 以 Graphviz DOT 格式导出继承关系，实线指向父类，虚线指向接口
+func ExportHierarchyDOT(h *ClassHierarchy, w io.Writer) error {
+	var sb strings.Builder
+	sb.WriteString("digraph hierarchy {\n")
+	sb.WriteString("  rankdir=BT;\n  node [shape=box];\n")
+	for _, node := range h.Nodes() {
+		var attrs []string
+		attrs = append(attrs, "label="+dotQuote(convertToClassName(node.Name)))
+		if node.Interface {
+			attrs = append(attrs, "shape=ellipse")
+		}
+		if node.External {
+			attrs = append(attrs, "style=dashed", "color=gray")
+		}
+		fmt.Fprintf(&sb, "  %s [%s];\n", dotQuote(node.Name), strings.Join(attrs, ", "))
+		if node.SuperClass != "" {
+			fmt.Fprintf(&sb, "  %s -> %s;\n", dotQuote(node.Name), dotQuote(node.SuperClass))
+		}
+		for _, iface := range node.Interfaces {
+			fmt.Fprintf(&sb, "  %s -> %s [style=dashed];\n", dotQuote(node.Name), dotQuote(iface))
+		}
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// DOT 中的字符串需要加引号并转义
+func dotQuote(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(s) + "\""
+}
@@ -0,0 +1,341 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ClassPath APK 内全部 dex 的统一视图，按 classes.dex、classes2.dex ... 的顺序加载
+type ClassPath struct {
+	Dexes      []*entity.DexFile
+	Classes    map[string]*entity.DexClass
+	Order      []*entity.DexClass // 按加载顺序排列的类
+	Duplicates []entity.DuplicateClass
+
+	methods map[*entity.DexClass][]*entity.ClassMethod
+	fields  map[*entity.DexClass][]*entity.ClassField
+}
+
+// dex 文件在 APK 中的加载序号，classes.dex 为 1，classesN.dex 为 N，其他文件返回 0
+func dexLoadIndex(path string) int {
+	name := filepath.Base(path)
+	if !strings.HasPrefix(name, "classes") || !strings.HasSuffix(name, ".dex") {
+		return 0
+	}
+	num := strings.TrimSuffix(strings.TrimPrefix(name, "classes"), ".dex")
+	if num == "" {
+		return 1
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 2 {
+		return 0
+	}
+	return n
+}
+
+// SortDexPaths 按 APK 加载顺序排列 dex 文件，不符合 classesN.dex 命名的排在最后
+func SortDexPaths(paths []string) {
+	sort.SliceStable(paths, func(i, j int) bool {
+		a, b := dexLoadIndex(paths[i]), dexLoadIndex(paths[j])
+		if a == 0 || b == 0 {
+			if a != b {
+				return b == 0
+			}
+			return paths[i] < paths[j]
+		}
+		return a < b
+	})
+}
+
+// LoadClassPath 加载并校验全部 dex 文件后建立类路径
+func LoadClassPath(paths []string) (*ClassPath, error) {
+	sorted := append([]string{}, paths...)
+	SortDexPaths(sorted)
+	var dexes []*entity.DexFile
+	for _, path := range sorted {
+		files, err := LoadDexContainer(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, dex := range files {
+			if !Verify(dex) {
+				return nil, fmt.Errorf("%s: not a valid dex", dex.FileName)
+			}
+			dexes = append(dexes, dex)
+		}
+	}
+	return NewClassPath(dexes)
+}
+
+// NewClassPath 用已经校验过的 dex 建立类路径，dexes 需按加载顺序排列
+func NewClassPath(dexes []*entity.DexFile) (*ClassPath, error) {
+	cp := &ClassPath{
+		Dexes:   dexes,
+		Classes: make(map[string]*entity.DexClass),
+		methods: make(map[*entity.DexClass][]*entity.ClassMethod),
+		fields:  make(map[*entity.DexClass][]*entity.ClassField),
+	}
+	duplicates := make(map[string]int)
+	for i, dex := range dexes {
+		if !dex.ValidDex {
+			return nil, fmt.Errorf("%s: not a vaild dex", dex.FileName)
+		}
+		for _, def := range dex.ClassDef {
+			class, err := readDexClass(dex, def)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", dex.FileName, err)
+			}
+			class.DexIndex = i
+			if first, ok := cp.Classes[class.Name]; ok {
+				n, ok := duplicates[class.Name]
+				if !ok {
+					n = len(cp.Duplicates)
+					duplicates[class.Name] = n
+					cp.Duplicates = append(cp.Duplicates, entity.DuplicateClass{Name: class.Name, Dexes: []string{first.Dex.FileName}})
+				}
+				cp.Duplicates[n].Dexes = append(cp.Duplicates[n].Dexes, dex.FileName)
+				continue
+			}
+			cp.Classes[class.Name] = class
+			cp.Order = append(cp.Order, class)
+		}
+	}
+	return cp, nil
+}
+
+func readDexClass(dex *entity.DexFile, def entity.ClassDef) (*entity.DexClass, error) {
+	name, err := GetTypeName(dex, uint32(def.Class_idx_))
+	if err != nil {
+		return nil, err
+	}
+	class := &entity.DexClass{Name: name, Dex: dex, Def: def}
+	if def.Superclass_idx_ != 0xffff {
+		if class.SuperClass, err = GetTypeName(dex, uint32(def.Superclass_idx_)); err != nil {
+			return nil, err
+		}
+	}
+	if class.Interfaces, err = ReadTypeList(dex, def.Interfaces_off_); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if class.Data, err = ReadClassData(dex, def); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return class, nil
+}
+
+// FindClass 按类型描述符或 Java 类名查找类，找不到返回 nil
+func (cp *ClassPath) FindClass(name string) *entity.DexClass {
+	if !strings.HasSuffix(name, ";") {
+		name = convertToDexClassName(name)
+	}
+	return cp.Classes[name]
+}
+
+// ResolveType 把 dex 中的 type 索引解析为类路径中的类
+func (cp *ClassPath) ResolveType(dex *entity.DexFile, typeIdx uint32) (*entity.DexClass, error) {
+	name, err := GetTypeName(dex, typeIdx)
+	if err != nil {
+		return nil, err
+	}
+	class := cp.Classes[name]
+	if class == nil {
+		return nil, fmt.Errorf("class %s not found", name)
+	}
+	return class, nil
+}
+
+// Methods 返回类中定义的全部方法，direct 方法在前
+func (cp *ClassPath) Methods(class *entity.DexClass) ([]*entity.ClassMethod, error) {
+	if methods, ok := cp.methods[class]; ok {
+		return methods, nil
+	}
+	var methods []*entity.ClassMethod
+	add := func(defs []entity.MethodDef, direct bool) error {
+		for _, def := range defs {
+			ref, err := GetMethodRef(class.Dex, def.MethodIdx)
+			if err != nil {
+				return err
+			}
+			methods = append(methods, &entity.ClassMethod{
+				Class:       class,
+				Ref:         *ref,
+				MethodIdx:   def.MethodIdx,
+				AccessFlags: def.AccessFlags,
+				CodeOff:     def.CodeOff,
+				Direct:      direct,
+			})
+		}
+		return nil
+	}
+	if err := add(class.Data.DirectMethods, true); err != nil {
+		return nil, err
+	}
+	if err := add(class.Data.VirtualMethods, false); err != nil {
+		return nil, err
+	}
+	cp.methods[class] = methods
+	return methods, nil
+}
+
+// Fields 返回类中定义的全部字段，静态字段在前
+func (cp *ClassPath) Fields(class *entity.DexClass) ([]*entity.ClassField, error) {
+	if fields, ok := cp.fields[class]; ok {
+		return fields, nil
+	}
+	var fields []*entity.ClassField
+	for _, def := range append(append([]entity.DexField{}, class.Data.StaticFields...), class.Data.InstanceFields...) {
+		ref, err := GetFieldRef(class.Dex, def.FieldIdx)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, &entity.ClassField{Class: class, Ref: *ref, FieldIdx: def.FieldIdx, AccessFlags: def.AccessFlags})
+	}
+	cp.fields[class] = fields
+	return fields, nil
+}
+
+// FindMethod 只在 class 自身中查找方法，desc 为原型描述符，例如 (I)V
+func (cp *ClassPath) FindMethod(class *entity.DexClass, name string, desc string) (*entity.ClassMethod, error) {
+	methods, err := cp.Methods(class)
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range methods {
+		if method.Ref.Name == name && GetProtoDescriptor(method.Ref.Proto) == desc {
+			return method, nil
+		}
+	}
+	return nil, nil
+}
+
+// FindField 只在 class 自身中查找字段
+func (cp *ClassPath) FindField(class *entity.DexClass, name string, typ string) (*entity.ClassField, error) {
+	fields, err := cp.Fields(class)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range fields {
+		if field.Ref.Name == name && field.Ref.Type == typ {
+			return field, nil
+		}
+	}
+	return nil, nil
+}
+
+// ResolveMethod 解析方法引用：先在类及其父类中查找，再查找全部父接口。
+// 引用的类不在类路径中（例如 Android 框架类）时返回错误
+func (cp *ClassPath) ResolveMethod(ref *entity.DexMethodRef) (*entity.ClassMethod, error) {
+	class := cp.Classes[ref.Class]
+	if class == nil {
+		return nil, fmt.Errorf("class %s not found", ref.Class)
+	}
+	desc := GetProtoDescriptor(ref.Proto)
+	visited := make(map[string]bool)
+	for c := class; c != nil && !visited[c.Name]; c = cp.Classes[c.SuperClass] {
+		visited[c.Name] = true
+		method, err := cp.FindMethod(c, ref.Name, desc)
+		if err != nil || method != nil {
+			return method, err
+		}
+	}
+	visited = make(map[string]bool)
+	var search func(c *entity.DexClass) (*entity.ClassMethod, error)
+	search = func(c *entity.DexClass) (*entity.ClassMethod, error) {
+		for _, name := range c.Interfaces {
+			iface := cp.Classes[name]
+			if iface == nil || visited[name] {
+				continue
+			}
+			visited[name] = true
+			method, err := cp.FindMethod(iface, ref.Name, desc)
+			if err != nil || method != nil {
+				return method, err
+			}
+			if method, err = search(iface); err != nil || method != nil {
+				return method, err
+			}
+		}
+		return nil, nil
+	}
+	chain := make(map[string]bool)
+	for c := class; c != nil && !chain[c.Name]; c = cp.Classes[c.SuperClass] {
+		chain[c.Name] = true
+		method, err := search(c)
+		if err != nil || method != nil {
+			return method, err
+		}
+	}
+	return nil, fmt.Errorf("method %s not found", GetMethodSignature(ref))
+}
+
+// ResolveMethodIdx 解析 dex 中 method_ids 索引指向的方法
+func (cp *ClassPath) ResolveMethodIdx(dex *entity.DexFile, methodIdx uint32) (*entity.ClassMethod, error) {
+	ref, err := GetMethodRef(dex, methodIdx)
+	if err != nil {
+		return nil, err
+	}
+	return cp.ResolveMethod(ref)
+}
+
+// ResolveVirtual 按接收者的实际类型查找虚方法的实现
+func (cp *ClassPath) ResolveVirtual(receiver string, ref *entity.DexMethodRef) (*entity.ClassMethod, error) {
+	desc := GetProtoDescriptor(ref.Proto)
+	visited := make(map[string]bool)
+	for c := cp.Classes[receiver]; c != nil && !visited[c.Name]; c = cp.Classes[c.SuperClass] {
+		visited[c.Name] = true
+		method, err := cp.FindMethod(c, ref.Name, desc)
+		if err != nil {
+			return nil, err
+		}
+		if method != nil && method.AccessFlags&(entity.ACC_STATIC|entity.ACC_PRIVATE) == 0 &&
+			method.AccessFlags&entity.ACC_ABSTRACT == 0 {
+			return method, nil
+		}
+	}
+	// 接口的默认方法
+	return cp.ResolveMethod(&entity.DexMethodRef{Class: receiver, Name: ref.Name, Proto: ref.Proto})
+}
+
+// ResolveField 解析字段引用：先查找类自身，再查找父接口，最后查找父类
+func (cp *ClassPath) ResolveField(ref *entity.DexFieldRef) (*entity.ClassField, error) {
+	visited := make(map[string]bool)
+	var resolve func(name string) (*entity.ClassField, error)
+	resolve = func(name string) (*entity.ClassField, error) {
+		class := cp.Classes[name]
+		if class == nil || visited[name] {
+			return nil, nil
+		}
+		visited[name] = true
+		field, err := cp.FindField(class, ref.Name, ref.Type)
+		if err != nil || field != nil {
+			return field, err
+		}
+		for _, iface := range class.Interfaces {
+			if field, err = resolve(iface); err != nil || field != nil {
+				return field, err
+			}
+		}
+		return resolve(class.SuperClass)
+	}
+	if cp.Classes[ref.Class] == nil {
+		return nil, fmt.Errorf("class %s not found", ref.Class)
+	}
+	field, err := resolve(ref.Class)
+	if err == nil && field == nil {
+		err = fmt.Errorf("field %s not found", GetFieldSignature(ref))
+	}
+	return field, err
+}
+
+// ResolveFieldIdx 解析 dex 中 field_ids 索引指向的字段
+func (cp *ClassPath) ResolveFieldIdx(dex *entity.DexFile, fieldIdx uint32) (*entity.ClassField, error) {
+	ref, err := GetFieldRef(dex, fieldIdx)
+	if err != nil {
+		return nil, err
+	}
+	return cp.ResolveField(ref)
+}
@@ -0,0 +1,300 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"sort"
+)
+
+// 寄存器到达的定义
+type defState map[uint32][]uint32
+
+func (s defState) copy() defState {
+	result := make(defState, len(s))
+	for r, defs := range s {
+		result[r] = defs
+	}
+	return result
+}
+
+// 把 src 合并到 s，返回 s 是否变化
+func (s defState) merge(src defState) bool {
+	changed := false
+	for r, defs := range src {
+		merged := mergeOffsets(s[r], defs)
+		if len(merged) != len(s[r]) {
+			s[r] = merged
+			changed = true
+		}
+	}
+	return changed
+}
+
+// 合并两个升序的偏移列表
+func mergeOffsets(a []uint32, b []uint32) []uint32 {
+	if len(a) == 0 {
+		return b
+	}
+	result := append([]uint32(nil), a...)
+	for _, off := range b {
+		i := sort.Search(len(result), func(i int) bool { return result[i] >= off })
+		if i < len(result) && result[i] == off {
+			continue
+		}
+		result = append(result, 0)
+		copy(result[i+1:], result[i:])
+		result[i] = off
+	}
+	return result
+}
+
+// ReachingDefinitions 计算到达定义：每条指令执行之前，每个寄存器的值可能来自哪些指令。
+// 参数寄存器在入口处定义为 entity.DEF_ENTRY，不可达的指令没有结果
+func ReachingDefinitions(code entity.MethodCodeItem, cfg *entity.MethodCFG) map[uint32]map[uint32][]uint32 {
+	result := make(map[uint32]map[uint32][]uint32)
+	if len(cfg.Blocks) == 0 {
+		return result
+	}
+	in := make([]defState, len(cfg.Blocks))
+	in[0] = make(defState)
+	for r := uint32(code.RegistersSize - code.InsSize); r < uint32(code.RegistersSize); r++ {
+		in[0][r] = []uint32{entity.DEF_ENTRY}
+	}
+	work := newBlockQueue(0)
+	for !work.empty() {
+		block := cfg.Blocks[work.pop()]
+		state := in[block.Id].copy()
+		var before defState
+		for _, insn := range block.Insns {
+			before = state.copy()
+			result[insn.Offset] = before
+			for _, r := range InsnDefs(insn) {
+				state[r] = []uint32{insn.Offset}
+			}
+		}
+		for _, edge := range block.Succs {
+			changed := false
+			if in[edge.To] == nil {
+				in[edge.To] = make(defState)
+				changed = true
+			}
+			if in[edge.To].merge(state) {
+				changed = true
+			}
+			// 最后一条指令抛出异常时它的定义不会发生
+			if edge.Kind == entity.EDGE_EXCEPTION && in[edge.To].merge(before) {
+				changed = true
+			}
+			if changed {
+				work.push(edge.To)
+			}
+		}
+	}
+	return result
+}
+
+// 寄存器的常量值，不在表中的寄存器还没有赋值
+type constState map[uint32]entity.ConstValue
+
+func (s constState) copy() constState {
+	result := make(constState, len(s))
+	for r, v := range s {
+		result[r] = v
+	}
+	return result
+}
+
+func (s constState) merge(src constState) bool {
+	changed := false
+	for r, v := range src {
+		old, ok := s[r]
+		if !ok {
+			s[r] = v
+			changed = true
+		} else if old != v && old.Kind != entity.CONST_VARYING {
+			s[r] = entity.ConstValue{Kind: entity.CONST_VARYING}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// PropagateConstants 常量传播：每条指令执行之前值为常量的寄存器。
+// const、move、算术运算和 const-string 的结果按模拟器的语义计算，
+// 参数、方法返回值和从内存读取的值都不是常量，不可达的指令没有结果
+func PropagateConstants(dex *entity.DexFile, code entity.MethodCodeItem, cfg *entity.MethodCFG) map[uint32]map[uint32]entity.ConstValue {
+	result := make(map[uint32]map[uint32]entity.ConstValue)
+	if len(cfg.Blocks) == 0 {
+		return result
+	}
+	eval := newConstEvaluator(dex, code)
+	in := make([]constState, len(cfg.Blocks))
+	in[0] = make(constState)
+	for r := uint32(code.RegistersSize - code.InsSize); r < uint32(code.RegistersSize); r++ {
+		in[0][r] = entity.ConstValue{Kind: entity.CONST_VARYING}
+	}
+	work := newBlockQueue(0)
+	for !work.empty() {
+		block := cfg.Blocks[work.pop()]
+		state := in[block.Id].copy()
+		var before constState
+		for _, insn := range block.Insns {
+			before = state.copy()
+			consts := make(map[uint32]entity.ConstValue)
+			for r, v := range before {
+				if v.Kind != entity.CONST_VARYING {
+					consts[r] = v
+				}
+			}
+			result[insn.Offset] = consts
+			eval.apply(insn, state)
+		}
+		for _, edge := range block.Succs {
+			changed := false
+			if in[edge.To] == nil {
+				in[edge.To] = make(constState)
+				changed = true
+			}
+			if in[edge.To].merge(state) {
+				changed = true
+			}
+			if edge.Kind == entity.EDGE_EXCEPTION && in[edge.To].merge(before) {
+				changed = true
+			}
+			if changed {
+				work.push(edge.To)
+			}
+		}
+	}
+	return result
+}
+
+// AnalyzeDataFlow 对方法做到达定义和常量传播
+func AnalyzeDataFlow(dex *entity.DexFile, code entity.MethodCodeItem) (*entity.MethodDataFlow, error) {
+	cfg, err := BuildCFG(code)
+	if err != nil {
+		return nil, err
+	}
+	return &entity.MethodDataFlow{
+		Reaching:  ReachingDefinitions(code, cfg),
+		Constants: PropagateConstants(dex, code, cfg),
+	}, nil
+}
+
+// 用模拟器在临时栈帧上执行没有副作用的指令，计算常量的结果
+type constEvaluator struct {
+	vm    *VM
+	frame *VMFrame
+}
+
+func newConstEvaluator(dex *entity.DexFile, code entity.MethodCodeItem) *constEvaluator {
+	return &constEvaluator{
+		vm: NewVM(nil),
+		frame: &VMFrame{
+			dex:  dex,
+			code: code,
+			regs: make([]uint32, code.RegistersSize),
+			refs: make([]interface{}, code.RegistersSize),
+		},
+	}
+}
+
+// 没有副作用、结果只取决于寄存器和指令本身的指令
+func isPureOp(op uint8) bool {
+	switch {
+	case op >= 0x01 && op <= 0x09: // move
+		return true
+	case op >= 0x12 && op <= 0x1b: // const, const-string
+		return true
+	case op >= 0x2d && op <= 0x31: // cmp
+		return true
+	case op >= 0x7b && op <= 0xe2: // 一元、二元运算
+		return true
+	}
+	return false
+}
+
+// 执行一条指令后更新 state
+func (e *constEvaluator) apply(insn *entity.Instruction, state constState) {
+	defs := InsnDefs(insn)
+	if defs == nil {
+		return
+	}
+	varying := func() {
+		for _, r := range defs {
+			state[r] = entity.ConstValue{Kind: entity.CONST_VARYING}
+		}
+	}
+	if !isPureOp(insn.Opcode) {
+		varying()
+		return
+	}
+	f := e.frame
+	for _, r := range InsnUses(insn) {
+		v, ok := state[r]
+		if !ok {
+			// 读取还没有赋值的寄存器，等其他路径传来的值
+			for _, r := range defs {
+				delete(state, r)
+			}
+			return
+		}
+		switch v.Kind {
+		case entity.CONST_VARYING:
+			varying()
+			return
+		case entity.CONST_STRING:
+			f.setRef(r, NewStringObject(v.Str))
+		default:
+			f.regs[r] = v.Value
+			f.refs[r] = nil
+		}
+	}
+	if _, err := e.vm.step(f, insn); err != nil {
+		// 除以零等
+		varying()
+		return
+	}
+	for _, r := range defs {
+		if ref := f.refs[r]; ref != nil {
+			if s, ok := GoString(ref); ok {
+				state[r] = entity.ConstValue{Kind: entity.CONST_STRING, Str: s}
+			} else {
+				state[r] = entity.ConstValue{Kind: entity.CONST_VARYING}
+			}
+			continue
+		}
+		state[r] = entity.ConstValue{Kind: entity.CONST_NUMBER, Value: f.regs[r]}
+	}
+}
+
+// 基本块的工作队列，已经在队列中的块不重复加入
+type blockQueue struct {
+	ids    []int
+	queued map[int]bool
+}
+
+func newBlockQueue(ids ...int) *blockQueue {
+	q := &blockQueue{queued: make(map[int]bool)}
+	for _, id := range ids {
+		q.push(id)
+	}
+	return q
+}
+
+func (q *blockQueue) push(id int) {
+	if !q.queued[id] {
+		q.queued[id] = true
+		q.ids = append(q.ids, id)
+	}
+}
+
+func (q *blockQueue) pop() int {
+	id := q.ids[0]
+	q.ids = q.ids[1:]
+	q.queued[id] = false
+	return id
+}
+
+func (q *blockQueue) empty() bool {
+	return len(q.ids) == 0
+}
@@ -0,0 +1,240 @@
+package tools
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+var errTruncated = errors.New("unexpected end of data")
+
+// dexReader 带越界检查的顺序读取器，出错后后续读取都返回 0
+type dexReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func newDexReader(data []byte) *dexReader {
+	return &dexReader{data: data}
+}
+
+func (r *dexReader) fail() {
+	if r.err == nil {
+		r.err = errTruncated
+	}
+}
+
+func (r *dexReader) u8() uint8 {
+	if r.err != nil || r.pos+1 > len(r.data) {
+		r.fail()
+		return 0
+	}
+	v := r.data[r.pos]
+	r.pos++
+	return v
+}
+
+func (r *dexReader) u16() uint16 {
+	if r.err != nil || r.pos+2 > len(r.data) {
+		r.fail()
+		return 0
+	}
+	v := binary.LittleEndian.Uint16(r.data[r.pos:])
+	r.pos += 2
+	return v
+}
+
+func (r *dexReader) u32() uint32 {
+	if r.err != nil || r.pos+4 > len(r.data) {
+		r.fail()
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return v
+}
+
+func (r *dexReader) bytes(n int) []byte {
+	if r.err != nil || n < 0 || r.pos+n > len(r.data) {
+		r.fail()
+		return nil
+	}
+	v := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return v
+}
+
+func (r *dexReader) uleb() uint32 {
+	var result uint32
+	for i := 0; i < 5; i++ {
+		b := r.u8()
+		result |= uint32(b&0x7f) << (7 * uint(i))
+		if b&0x80 == 0 {
+			return result
+		}
+	}
+	if r.err == nil {
+		r.err = errors.New("invalid uleb128")
+	}
+	return 0
+}
+
+// uleb128p1，返回值减 1，NO_INDEX 表示 -1
+func (r *dexReader) ulebp1() uint32 {
+	return r.uleb() - 1
+}
+
+func (r *dexReader) sleb() int32 {
+	var result int32
+	var shift uint
+	for i := 0; i < 5; i++ {
+		b := r.u8()
+		result |= int32(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 32 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			return result
+		}
+	}
+	if r.err == nil {
+		r.err = errors.New("invalid sleb128")
+	}
+	return 0
+}
+
+func appendU16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v), byte(v>>8))
+}
+
+func appendU32(buf []byte, v uint32) []byte {
+	return append(buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendULEB128(buf []byte, v uint32) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func appendSLEB128(buf []byte, v int32) []byte {
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+// MUTF-8 解码，成对的代理项合成一个字符，单独的代理项按 3 字节原样保留以便写回
+func decodeMUTF8(data []byte) (string, error) {
+	units := make([]uint16, 0, len(data))
+	for i := 0; i < len(data); {
+		b := data[i]
+		switch {
+		case b < 0x80:
+			units = append(units, uint16(b))
+			i++
+		case b&0xe0 == 0xc0:
+			if i+1 >= len(data) {
+				return "", errors.New("invalid mutf-8")
+			}
+			units = append(units, uint16(b&0x1f)<<6|uint16(data[i+1]&0x3f))
+			i += 2
+		case b&0xf0 == 0xe0:
+			if i+2 >= len(data) {
+				return "", errors.New("invalid mutf-8")
+			}
+			units = append(units, uint16(b&0x0f)<<12|uint16(data[i+1]&0x3f)<<6|uint16(data[i+2]&0x3f))
+			i += 3
+		default:
+			return "", errors.New("invalid mutf-8")
+		}
+	}
+	return utf16UnitsToString(units), nil
+}
+
+func utf16UnitsToString(units []uint16) string {
+	buf := make([]byte, 0, len(units))
+	for i := 0; i < len(units); i++ {
+		u := units[i]
+		if utf16.IsSurrogate(rune(u)) {
+			if u < 0xdc00 && i+1 < len(units) && units[i+1] >= 0xdc00 && units[i+1] <= 0xdfff {
+				buf = utf8.AppendRune(buf, utf16.DecodeRune(rune(u), rune(units[i+1])))
+				i++
+				continue
+			}
+			buf = append(buf, 0xe0|byte(u>>12), 0x80|byte(u>>6)&0x3f, 0x80|byte(u)&0x3f)
+			continue
+		}
+		buf = utf8.AppendRune(buf, rune(u))
+	}
+	return string(buf)
+}
+
+// 把字符串拆成 UTF-16 编码单元，能处理 decodeMUTF8 保留下来的单独代理项
+func stringToUTF16Units(s string) []uint16 {
+	units := make([]uint16, 0, len(s))
+	for i := 0; i < len(s); {
+		b := s[i]
+		if b&0xf0 == 0xe0 && i+2 < len(s) {
+			u := uint16(b&0x0f)<<12 | uint16(s[i+1]&0x3f)<<6 | uint16(s[i+2]&0x3f)
+			if utf16.IsSurrogate(rune(u)) {
+				units = append(units, u)
+				i += 3
+				continue
+			}
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+		if r >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(r)
+			units = append(units, uint16(r1), uint16(r2))
+		} else {
+			units = append(units, uint16(r))
+		}
+	}
+	return units
+}
+
+// MUTF-8 编码，返回编码后的数据和 UTF-16 长度
+func encodeMUTF8(s string) ([]byte, uint32) {
+	units := stringToUTF16Units(s)
+	buf := make([]byte, 0, len(s)+1)
+	for _, u := range units {
+		switch {
+		case u != 0 && u < 0x80:
+			buf = append(buf, byte(u))
+		case u < 0x800:
+			buf = append(buf, 0xc0|byte(u>>6), 0x80|byte(u)&0x3f)
+		default:
+			buf = append(buf, 0xe0|byte(u>>12), 0x80|byte(u>>6)&0x3f, 0x80|byte(u)&0x3f)
+		}
+	}
+	return buf, uint32(len(units))
+}
+
+// 按 UTF-16 编码单元比较字符串，dex 中 string_ids 按此顺序排列
+func compareDexStrings(a, b string) int {
+	ua := stringToUTF16Units(a)
+	ub := stringToUTF16Units(b)
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			if ua[i] < ub[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(ua) - len(ub)
+}
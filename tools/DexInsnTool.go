@@ -0,0 +1,634 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Opcodes dalvik 操作码表，Name 为空的是未使用的操作码
+var Opcodes [256]entity.OpcodeInfo
+
+func init() {
+	set := func(op int, name string, format entity.InsnFormat, index entity.InsnIndexType) {
+		Opcodes[op] = entity.OpcodeInfo{Name: name, Format: format, Index: index}
+	}
+	set(0x00, "nop", entity.Format10x, entity.IndexNone)
+	set(0x01, "move", entity.Format12x, entity.IndexNone)
+	set(0x02, "move/from16", entity.Format22x, entity.IndexNone)
+	set(0x03, "move/16", entity.Format32x, entity.IndexNone)
+	set(0x04, "move-wide", entity.Format12x, entity.IndexNone)
+	set(0x05, "move-wide/from16", entity.Format22x, entity.IndexNone)
+	set(0x06, "move-wide/16", entity.Format32x, entity.IndexNone)
+	set(0x07, "move-object", entity.Format12x, entity.IndexNone)
+	set(0x08, "move-object/from16", entity.Format22x, entity.IndexNone)
+	set(0x09, "move-object/16", entity.Format32x, entity.IndexNone)
+	set(0x0a, "move-result", entity.Format11x, entity.IndexNone)
+	set(0x0b, "move-result-wide", entity.Format11x, entity.IndexNone)
+	set(0x0c, "move-result-object", entity.Format11x, entity.IndexNone)
+	set(0x0d, "move-exception", entity.Format11x, entity.IndexNone)
+	set(0x0e, "return-void", entity.Format10x, entity.IndexNone)
+	set(0x0f, "return", entity.Format11x, entity.IndexNone)
+	set(0x10, "return-wide", entity.Format11x, entity.IndexNone)
+	set(0x11, "return-object", entity.Format11x, entity.IndexNone)
+	set(0x12, "const/4", entity.Format11n, entity.IndexNone)
+	set(0x13, "const/16", entity.Format21s, entity.IndexNone)
+	set(0x14, "const", entity.Format31i, entity.IndexNone)
+	set(0x15, "const/high16", entity.Format21h, entity.IndexNone)
+	set(0x16, "const-wide/16", entity.Format21s, entity.IndexNone)
+	set(0x17, "const-wide/32", entity.Format31i, entity.IndexNone)
+	set(0x18, "const-wide", entity.Format51l, entity.IndexNone)
+	set(0x19, "const-wide/high16", entity.Format21h, entity.IndexNone)
+	set(0x1a, "const-string", entity.Format21c, entity.IndexString)
+	set(0x1b, "const-string/jumbo", entity.Format31c, entity.IndexString)
+	set(0x1c, "const-class", entity.Format21c, entity.IndexType)
+	set(0x1d, "monitor-enter", entity.Format11x, entity.IndexNone)
+	set(0x1e, "monitor-exit", entity.Format11x, entity.IndexNone)
+	set(0x1f, "check-cast", entity.Format21c, entity.IndexType)
+	set(0x20, "instance-of", entity.Format22c, entity.IndexType)
+	set(0x21, "array-length", entity.Format12x, entity.IndexNone)
+	set(0x22, "new-instance", entity.Format21c, entity.IndexType)
+	set(0x23, "new-array", entity.Format22c, entity.IndexType)
+	set(0x24, "filled-new-array", entity.Format35c, entity.IndexType)
+	set(0x25, "filled-new-array/range", entity.Format3rc, entity.IndexType)
+	set(0x26, "fill-array-data", entity.Format31t, entity.IndexNone)
+	set(0x27, "throw", entity.Format11x, entity.IndexNone)
+	set(0x28, "goto", entity.Format10t, entity.IndexNone)
+	set(0x29, "goto/16", entity.Format20t, entity.IndexNone)
+	set(0x2a, "goto/32", entity.Format30t, entity.IndexNone)
+	set(0x2b, "packed-switch", entity.Format31t, entity.IndexNone)
+	set(0x2c, "sparse-switch", entity.Format31t, entity.IndexNone)
+	for i, name := range []string{"cmpl-float", "cmpg-float", "cmpl-double", "cmpg-double", "cmp-long"} {
+		set(0x2d+i, name, entity.Format23x, entity.IndexNone)
+	}
+	for i, name := range []string{"if-eq", "if-ne", "if-lt", "if-ge", "if-gt", "if-le"} {
+		set(0x32+i, name, entity.Format22t, entity.IndexNone)
+		set(0x38+i, name+"z", entity.Format21t, entity.IndexNone)
+	}
+	suffixes := []string{"", "-wide", "-object", "-boolean", "-byte", "-char", "-short"}
+	for i, suffix := range suffixes {
+		set(0x44+i, "aget"+suffix, entity.Format23x, entity.IndexNone)
+		set(0x4b+i, "aput"+suffix, entity.Format23x, entity.IndexNone)
+		set(0x52+i, "iget"+suffix, entity.Format22c, entity.IndexField)
+		set(0x59+i, "iput"+suffix, entity.Format22c, entity.IndexField)
+		set(0x60+i, "sget"+suffix, entity.Format21c, entity.IndexField)
+		set(0x67+i, "sput"+suffix, entity.Format21c, entity.IndexField)
+	}
+	for i, name := range []string{"invoke-virtual", "invoke-super", "invoke-direct", "invoke-static", "invoke-interface"} {
+		set(0x6e+i, name, entity.Format35c, entity.IndexMethod)
+		set(0x74+i, name+"/range", entity.Format3rc, entity.IndexMethod)
+	}
+	unops := []string{"neg-int", "not-int", "neg-long", "not-long", "neg-float", "neg-double",
+		"int-to-long", "int-to-float", "int-to-double", "long-to-int", "long-to-float", "long-to-double",
+		"float-to-int", "float-to-long", "float-to-double", "double-to-int", "double-to-long", "double-to-float",
+		"int-to-byte", "int-to-char", "int-to-short"}
+	for i, name := range unops {
+		set(0x7b+i, name, entity.Format12x, entity.IndexNone)
+	}
+	binops := []string{"add-int", "sub-int", "mul-int", "div-int", "rem-int", "and-int", "or-int", "xor-int", "shl-int", "shr-int", "ushr-int",
+		"add-long", "sub-long", "mul-long", "div-long", "rem-long", "and-long", "or-long", "xor-long", "shl-long", "shr-long", "ushr-long",
+		"add-float", "sub-float", "mul-float", "div-float", "rem-float",
+		"add-double", "sub-double", "mul-double", "div-double", "rem-double"}
+	for i, name := range binops {
+		set(0x90+i, name, entity.Format23x, entity.IndexNone)
+		set(0xb0+i, name+"/2addr", entity.Format12x, entity.IndexNone)
+	}
+	for i, name := range []string{"add-int", "rsub-int", "mul-int", "div-int", "rem-int", "and-int", "or-int", "xor-int"} {
+		if i == 1 {
+			set(0xd0+i, name, entity.Format22s, entity.IndexNone)
+		} else {
+			set(0xd0+i, name+"/lit16", entity.Format22s, entity.IndexNone)
+		}
+	}
+	for i, name := range []string{"add-int", "rsub-int", "mul-int", "div-int", "rem-int", "and-int", "or-int", "xor-int", "shl-int", "shr-int", "ushr-int"} {
+		set(0xd8+i, name+"/lit8", entity.Format22b, entity.IndexNone)
+	}
+	set(0xfa, "invoke-polymorphic", entity.Format45cc, entity.IndexMethodAndProto)
+	set(0xfb, "invoke-polymorphic/range", entity.Format4rcc, entity.IndexMethodAndProto)
+	set(0xfc, "invoke-custom", entity.Format35c, entity.IndexCallSite)
+	set(0xfd, "invoke-custom/range", entity.Format3rc, entity.IndexCallSite)
+	set(0xfe, "const-method-handle", entity.Format21c, entity.IndexMethodHandle)
+	set(0xff, "const-method-type", entity.Format21c, entity.IndexProto)
+}
+
+// 各格式的指令长度，以 2 字节为单位
+func formatSize(format entity.InsnFormat) uint32 {
+	switch format {
+	case entity.Format10x, entity.Format12x, entity.Format11n, entity.Format11x, entity.Format10t:
+		return 1
+	case entity.Format20t, entity.Format22x, entity.Format21t, entity.Format21s, entity.Format21h, entity.Format21c,
+		entity.Format23x, entity.Format22b, entity.Format22t, entity.Format22s, entity.Format22c:
+		return 2
+	case entity.Format30t, entity.Format32x, entity.Format31i, entity.Format31t, entity.Format31c, entity.Format35c, entity.Format3rc:
+		return 3
+	case entity.Format45cc, entity.Format4rcc:
+		return 4
+	case entity.Format51l:
+		return 5
+	}
+	return 0
+}
+
+// 根据标识计算 payload 的长度
+func payloadSize(insns []uint16) (uint32, error) {
+	if len(insns) < 2 {
+		return 0, errors.New("truncated payload")
+	}
+	switch insns[0] {
+	case entity.PackedSwitchPayload:
+		return uint32(insns[1])*2 + 4, nil
+	case entity.SparseSwitchPayload:
+		return uint32(insns[1])*4 + 2, nil
+	case entity.FillArrayDataPayload:
+		if len(insns) < 4 {
+			return 0, errors.New("truncated payload")
+		}
+		size := uint64(insns[2]) | uint64(insns[3])<<16
+		return uint32((size*uint64(insns[1])+1)/2 + 4), nil
+	}
+	return 0, fmt.Errorf("unknown payload 0x%x", insns[0])
+}
+
+// InsnWidth 返回位于 insns 开头的指令的长度
+func InsnWidth(insns []uint16) (uint32, error) {
+	if len(insns) == 0 {
+		return 0, errors.New("empty insns")
+	}
+	op := uint8(insns[0])
+	if op == 0x00 && insns[0] != 0 {
+		return payloadSize(insns)
+	}
+	if Opcodes[op].Name == "" {
+		return 0, fmt.Errorf("unused opcode 0x%x", op)
+	}
+	return formatSize(Opcodes[op].Format), nil
+}
+
+// OpcodeName 返回指令的助记符，payload 返回伪指令名
+func OpcodeName(insn *entity.Instruction) string {
+	if insn.Payload != nil {
+		switch insn.Payload.Ident {
+		case entity.PackedSwitchPayload:
+			return "packed-switch-payload"
+		case entity.SparseSwitchPayload:
+			return "sparse-switch-payload"
+		case entity.FillArrayDataPayload:
+			return "array-payload"
+		}
+	}
+	return Opcodes[insn.Opcode].Name
+}
+
+// InsnFormatOf 返回指令的格式
+func InsnFormatOf(insn *entity.Instruction) entity.InsnFormat {
+	if insn.Payload != nil {
+		return entity.FormatPayload
+	}
+	return Opcodes[insn.Opcode].Format
+}
+
+// DecodeInstructions 解码整个 insns 数组
+func DecodeInstructions(insns []uint16) ([]*entity.Instruction, error) {
+	var result []*entity.Instruction
+	pc := uint32(0)
+	for pc < uint32(len(insns)) {
+		insn, err := DecodeInstruction(insns, pc)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, insn)
+		pc += insn.Size
+	}
+	return result, nil
+}
+
+// DecodeInstruction 解码 pc 处的一条指令
+func DecodeInstruction(insns []uint16, pc uint32) (*entity.Instruction, error) {
+	if pc >= uint32(len(insns)) {
+		return nil, fmt.Errorf("pc %d out of range", pc)
+	}
+	code := insns[pc:]
+	size, err := InsnWidth(code)
+	if err != nil {
+		return nil, fmt.Errorf("pc %d: %v", pc, err)
+	}
+	if uint32(len(code)) < size {
+		return nil, fmt.Errorf("pc %d: truncated instruction", pc)
+	}
+	insn := &entity.Instruction{Opcode: uint8(code[0]), Offset: pc, Size: size}
+	if insn.Opcode == 0x00 && code[0] != 0 {
+		insn.Payload = decodePayload(code[:size])
+		return insn, nil
+	}
+	aa := uint32(code[0] >> 8)
+	a := uint32(code[0]>>8) & 0x0f
+	b := uint32(code[0] >> 12)
+	u32 := func(i int) uint32 {
+		return uint32(code[i]) | uint32(code[i+1])<<16
+	}
+	switch Opcodes[insn.Opcode].Format {
+	case entity.Format10x:
+	case entity.Format12x:
+		insn.Regs = []uint32{a, b}
+	case entity.Format11n:
+		insn.Regs = []uint32{a}
+		insn.Literal = int64(int8(b<<4) >> 4)
+	case entity.Format11x:
+		insn.Regs = []uint32{aa}
+	case entity.Format10t:
+		insn.Branch = int32(int8(aa))
+	case entity.Format20t:
+		insn.Branch = int32(int16(code[1]))
+	case entity.Format22x:
+		insn.Regs = []uint32{aa, uint32(code[1])}
+	case entity.Format21t:
+		insn.Regs = []uint32{aa}
+		insn.Branch = int32(int16(code[1]))
+	case entity.Format21s:
+		insn.Regs = []uint32{aa}
+		insn.Literal = int64(int16(code[1]))
+	case entity.Format21h:
+		insn.Regs = []uint32{aa}
+		if insn.Opcode == 0x19 {
+			insn.Literal = int64(uint64(code[1]) << 48)
+		} else {
+			insn.Literal = int64(int32(uint32(code[1]) << 16))
+		}
+	case entity.Format21c:
+		insn.Regs = []uint32{aa}
+		insn.Index = uint32(code[1])
+	case entity.Format23x:
+		insn.Regs = []uint32{aa, uint32(code[1] & 0xff), uint32(code[1] >> 8)}
+	case entity.Format22b:
+		insn.Regs = []uint32{aa, uint32(code[1] & 0xff)}
+		insn.Literal = int64(int8(code[1] >> 8))
+	case entity.Format22t:
+		insn.Regs = []uint32{a, b}
+		insn.Branch = int32(int16(code[1]))
+	case entity.Format22s:
+		insn.Regs = []uint32{a, b}
+		insn.Literal = int64(int16(code[1]))
+	case entity.Format22c:
+		insn.Regs = []uint32{a, b}
+		insn.Index = uint32(code[1])
+	case entity.Format30t:
+		insn.Branch = int32(u32(1))
+	case entity.Format32x:
+		insn.Regs = []uint32{uint32(code[1]), uint32(code[2])}
+	case entity.Format31i:
+		insn.Regs = []uint32{aa}
+		insn.Literal = int64(int32(u32(1)))
+	case entity.Format31t:
+		insn.Regs = []uint32{aa}
+		insn.Branch = int32(u32(1))
+	case entity.Format31c:
+		insn.Regs = []uint32{aa}
+		insn.Index = u32(1)
+	case entity.Format35c, entity.Format45cc:
+		count := b
+		if count > 5 {
+			return nil, fmt.Errorf("pc %d: invalid register count %d", pc, count)
+		}
+		all := []uint32{uint32(code[2] & 0xf), uint32(code[2]>>4) & 0xf, uint32(code[2]>>8) & 0xf, uint32(code[2] >> 12), a}
+		insn.Regs = append([]uint32{}, all[:count]...)
+		insn.Index = uint32(code[1])
+		if insn.Opcode == 0xfa {
+			insn.Index2 = uint32(code[3])
+		}
+	case entity.Format3rc, entity.Format4rcc:
+		insn.Regs = make([]uint32, aa)
+		for i := range insn.Regs {
+			insn.Regs[i] = uint32(code[2]) + uint32(i)
+		}
+		insn.Index = uint32(code[1])
+		if insn.Opcode == 0xfb {
+			insn.Index2 = uint32(code[3])
+		}
+	case entity.Format51l:
+		insn.Regs = []uint32{aa}
+		insn.Literal = int64(uint64(u32(1)) | uint64(u32(3))<<32)
+	}
+	return insn, nil
+}
+
+func decodePayload(code []uint16) *entity.InsnPayload {
+	payload := &entity.InsnPayload{Ident: code[0]}
+	i32 := func(i int) int32 {
+		return int32(uint32(code[i]) | uint32(code[i+1])<<16)
+	}
+	switch code[0] {
+	case entity.PackedSwitchPayload:
+		size := int(code[1])
+		payload.FirstKey = i32(2)
+		payload.Targets = make([]int32, size)
+		for i := 0; i < size; i++ {
+			payload.Targets[i] = i32(4 + i*2)
+		}
+	case entity.SparseSwitchPayload:
+		size := int(code[1])
+		payload.Keys = make([]int32, size)
+		payload.Targets = make([]int32, size)
+		for i := 0; i < size; i++ {
+			payload.Keys[i] = i32(2 + i*2)
+			payload.Targets[i] = i32(2 + size*2 + i*2)
+		}
+	case entity.FillArrayDataPayload:
+		payload.ElementWidth = code[1]
+		size := uint32(code[2]) | uint32(code[3])<<16
+		raw := make([]byte, len(code[4:])*2)
+		for i, unit := range code[4:] {
+			binary.LittleEndian.PutUint16(raw[i*2:], unit)
+		}
+		payload.Data = raw[:size*uint32(payload.ElementWidth)]
+	}
+	return payload
+}
+
+// EncodeInstructions 编码指令序列，不检查 Offset 是否连续
+func EncodeInstructions(insns []*entity.Instruction) ([]uint16, error) {
+	var result []uint16
+	for _, insn := range insns {
+		units, err := EncodeInstruction(insn)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, units...)
+	}
+	return result, nil
+}
+
+// EncodeInstruction 编码一条指令，引用使用 Index/Index2
+func EncodeInstruction(insn *entity.Instruction) ([]uint16, error) {
+	if insn.Payload != nil {
+		return encodePayload(insn.Payload)
+	}
+	info := Opcodes[insn.Opcode]
+	if info.Name == "" {
+		return nil, fmt.Errorf("unused opcode 0x%x", insn.Opcode)
+	}
+	op := uint16(insn.Opcode)
+	reg := func(i int, limit uint32) (uint16, error) {
+		if i >= len(insn.Regs) {
+			return 0, fmt.Errorf("%s: missing register", info.Name)
+		}
+		if insn.Regs[i] > limit {
+			return 0, fmt.Errorf("%s: register v%d out of range", info.Name, insn.Regs[i])
+		}
+		return uint16(insn.Regs[i]), nil
+	}
+	lo := func(v uint32) uint16 { return uint16(v) }
+	hi := func(v uint32) uint16 { return uint16(v >> 16) }
+	var err error
+	var ra, rb, rc uint16
+	switch info.Format {
+	case entity.Format10x:
+		return []uint16{op}, nil
+	case entity.Format12x:
+		if ra, err = reg(0, 0xf); err != nil {
+			return nil, err
+		}
+		if rb, err = reg(1, 0xf); err != nil {
+			return nil, err
+		}
+		return []uint16{op | ra<<8 | rb<<12}, nil
+	case entity.Format11n:
+		if ra, err = reg(0, 0xf); err != nil {
+			return nil, err
+		}
+		if insn.Literal < -8 || insn.Literal > 7 {
+			return nil, fmt.Errorf("%s: literal %d out of range", info.Name, insn.Literal)
+		}
+		return []uint16{op | ra<<8 | uint16(insn.Literal&0xf)<<12}, nil
+	case entity.Format11x:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		return []uint16{op | ra<<8}, nil
+	case entity.Format10t:
+		if insn.Branch < -128 || insn.Branch > 127 {
+			return nil, fmt.Errorf("%s: branch %d out of range", info.Name, insn.Branch)
+		}
+		return []uint16{op | uint16(uint8(int8(insn.Branch)))<<8}, nil
+	case entity.Format20t:
+		if insn.Branch < -32768 || insn.Branch > 32767 {
+			return nil, fmt.Errorf("%s: branch %d out of range", info.Name, insn.Branch)
+		}
+		return []uint16{op, uint16(int16(insn.Branch))}, nil
+	case entity.Format22x:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		if rb, err = reg(1, 0xffff); err != nil {
+			return nil, err
+		}
+		return []uint16{op | ra<<8, rb}, nil
+	case entity.Format21t:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		if insn.Branch < -32768 || insn.Branch > 32767 {
+			return nil, fmt.Errorf("%s: branch %d out of range", info.Name, insn.Branch)
+		}
+		return []uint16{op | ra<<8, uint16(int16(insn.Branch))}, nil
+	case entity.Format21s:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		if insn.Literal < -32768 || insn.Literal > 32767 {
+			return nil, fmt.Errorf("%s: literal %d out of range", info.Name, insn.Literal)
+		}
+		return []uint16{op | ra<<8, uint16(int16(insn.Literal))}, nil
+	case entity.Format21h:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		if insn.Opcode == 0x19 {
+			if uint64(insn.Literal)&0x0000ffffffffffff != 0 {
+				return nil, fmt.Errorf("%s: literal 0x%x out of range", info.Name, insn.Literal)
+			}
+			return []uint16{op | ra<<8, uint16(uint64(insn.Literal) >> 48)}, nil
+		}
+		if insn.Literal != int64(int32(insn.Literal)) || insn.Literal&0xffff != 0 {
+			return nil, fmt.Errorf("%s: literal 0x%x out of range", info.Name, insn.Literal)
+		}
+		return []uint16{op | ra<<8, uint16(uint32(insn.Literal) >> 16)}, nil
+	case entity.Format21c:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		if insn.Index > 0xffff {
+			return nil, fmt.Errorf("%s: index %d out of range", info.Name, insn.Index)
+		}
+		return []uint16{op | ra<<8, uint16(insn.Index)}, nil
+	case entity.Format23x:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		if rb, err = reg(1, 0xff); err != nil {
+			return nil, err
+		}
+		if rc, err = reg(2, 0xff); err != nil {
+			return nil, err
+		}
+		return []uint16{op | ra<<8, rb | rc<<8}, nil
+	case entity.Format22b:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		if rb, err = reg(1, 0xff); err != nil {
+			return nil, err
+		}
+		if insn.Literal < -128 || insn.Literal > 127 {
+			return nil, fmt.Errorf("%s: literal %d out of range", info.Name, insn.Literal)
+		}
+		return []uint16{op | ra<<8, rb | uint16(uint8(int8(insn.Literal)))<<8}, nil
+	case entity.Format22t, entity.Format22s, entity.Format22c:
+		if ra, err = reg(0, 0xf); err != nil {
+			return nil, err
+		}
+		if rb, err = reg(1, 0xf); err != nil {
+			return nil, err
+		}
+		var second uint16
+		switch info.Format {
+		case entity.Format22t:
+			if insn.Branch < -32768 || insn.Branch > 32767 {
+				return nil, fmt.Errorf("%s: branch %d out of range", info.Name, insn.Branch)
+			}
+			second = uint16(int16(insn.Branch))
+		case entity.Format22s:
+			if insn.Literal < -32768 || insn.Literal > 32767 {
+				return nil, fmt.Errorf("%s: literal %d out of range", info.Name, insn.Literal)
+			}
+			second = uint16(int16(insn.Literal))
+		default:
+			if insn.Index > 0xffff {
+				return nil, fmt.Errorf("%s: index %d out of range", info.Name, insn.Index)
+			}
+			second = uint16(insn.Index)
+		}
+		return []uint16{op | ra<<8 | rb<<12, second}, nil
+	case entity.Format30t:
+		return []uint16{op, lo(uint32(insn.Branch)), hi(uint32(insn.Branch))}, nil
+	case entity.Format32x:
+		if ra, err = reg(0, 0xffff); err != nil {
+			return nil, err
+		}
+		if rb, err = reg(1, 0xffff); err != nil {
+			return nil, err
+		}
+		return []uint16{op, ra, rb}, nil
+	case entity.Format31i:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		if insn.Literal != int64(int32(insn.Literal)) {
+			return nil, fmt.Errorf("%s: literal %d out of range", info.Name, insn.Literal)
+		}
+		v := uint32(insn.Literal)
+		return []uint16{op | ra<<8, lo(v), hi(v)}, nil
+	case entity.Format31t:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		return []uint16{op | ra<<8, lo(uint32(insn.Branch)), hi(uint32(insn.Branch))}, nil
+	case entity.Format31c:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		return []uint16{op | ra<<8, lo(insn.Index), hi(insn.Index)}, nil
+	case entity.Format35c, entity.Format45cc:
+		if len(insn.Regs) > 5 {
+			return nil, fmt.Errorf("%s: too many registers", info.Name)
+		}
+		var regs [5]uint16
+		for i := range insn.Regs {
+			if regs[i], err = reg(i, 0xf); err != nil {
+				return nil, err
+			}
+		}
+		if insn.Index > 0xffff || insn.Index2 > 0xffff {
+			return nil, fmt.Errorf("%s: index out of range", info.Name)
+		}
+		units := []uint16{op | regs[4]<<8 | uint16(len(insn.Regs))<<12, uint16(insn.Index),
+			regs[0] | regs[1]<<4 | regs[2]<<8 | regs[3]<<12}
+		if info.Format == entity.Format45cc {
+			units = append(units, uint16(insn.Index2))
+		}
+		return units, nil
+	case entity.Format3rc, entity.Format4rcc:
+		if len(insn.Regs) > 0xff {
+			return nil, fmt.Errorf("%s: too many registers", info.Name)
+		}
+		first := uint32(0)
+		if len(insn.Regs) > 0 {
+			first = insn.Regs[0]
+		}
+		for i, r := range insn.Regs {
+			if r != first+uint32(i) {
+				return nil, fmt.Errorf("%s: registers are not contiguous", info.Name)
+			}
+		}
+		if first+uint32(len(insn.Regs)) > 0x10000 || insn.Index > 0xffff || insn.Index2 > 0xffff {
+			return nil, fmt.Errorf("%s: operand out of range", info.Name)
+		}
+		units := []uint16{op | uint16(len(insn.Regs))<<8, uint16(insn.Index), uint16(first)}
+		if info.Format == entity.Format4rcc {
+			units = append(units, uint16(insn.Index2))
+		}
+		return units, nil
+	case entity.Format51l:
+		if ra, err = reg(0, 0xff); err != nil {
+			return nil, err
+		}
+		v := uint64(insn.Literal)
+		return []uint16{op | ra<<8, uint16(v), uint16(v >> 16), uint16(v >> 32), uint16(v >> 48)}, nil
+	}
+	return nil, fmt.Errorf("unknown format of %s", info.Name)
+}
+
+func encodePayload(payload *entity.InsnPayload) ([]uint16, error) {
+	var units []uint16
+	put32 := func(v int32) {
+		units = append(units, uint16(uint32(v)), uint16(uint32(v)>>16))
+	}
+	switch payload.Ident {
+	case entity.PackedSwitchPayload:
+		if len(payload.Targets) > 0xffff {
+			return nil, errors.New("packed-switch too large")
+		}
+		units = append(units, payload.Ident, uint16(len(payload.Targets)))
+		put32(payload.FirstKey)
+		for _, target := range payload.Targets {
+			put32(target)
+		}
+	case entity.SparseSwitchPayload:
+		if len(payload.Targets) > 0xffff || len(payload.Keys) != len(payload.Targets) {
+			return nil, errors.New("invalid sparse-switch")
+		}
+		units = append(units, payload.Ident, uint16(len(payload.Targets)))
+		for _, key := range payload.Keys {
+			put32(key)
+		}
+		for _, target := range payload.Targets {
+			put32(target)
+		}
+	case entity.FillArrayDataPayload:
+		if payload.ElementWidth == 0 || len(payload.Data)%int(payload.ElementWidth) != 0 {
+			return nil, errors.New("invalid array data")
+		}
+		size := uint32(len(payload.Data) / int(payload.ElementWidth))
+		units = append(units, payload.Ident, payload.ElementWidth, uint16(size), uint16(size>>16))
+		for i := 0; i < len(payload.Data); i += 2 {
+			unit := uint16(payload.Data[i])
+			if i+1 < len(payload.Data) {
+				unit |= uint16(payload.Data[i+1]) << 8
+			}
+			units = append(units, unit)
+		}
+	default:
+		return nil, fmt.Errorf("unknown payload 0x%x", payload.Ident)
+	}
+	return units, nil
+}
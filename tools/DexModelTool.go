@@ -0,0 +1,528 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ReadDexModel 把解析过的 dex 转换为可修改、可重新写出的模型，dex 需要先通过 Verify
+func ReadDexModel(dex *entity.DexFile) (*entity.DexModel, error) {
+	if !dex.ValidDex {
+		return nil, fmt.Errorf("not a vaild dex")
+	}
+	mr := &modelReader{dex: dex}
+	if err := mr.readMethodHandles(); err != nil {
+		return nil, err
+	}
+	if err := mr.readCallSites(); err != nil {
+		return nil, err
+	}
+	model := &entity.DexModel{Version: string(dex.Header.Version[:3])}
+	for _, classdef := range dex.ClassDef {
+		class, err := mr.readClass(classdef)
+		if err != nil {
+			return nil, err
+		}
+		model.Classes = append(model.Classes, class)
+	}
+	return model, nil
+}
+
+type modelReader struct {
+	dex           *entity.DexFile
+	methodHandles []*entity.DexMethodHandle
+	callSites     []*entity.DexCallSite
+}
+
+func (mr *modelReader) mapItem(itemType entity.MapItemType) (entity.MapItem, bool) {
+	if mr.dex.MapList == nil {
+		return entity.MapItem{}, false
+	}
+	for _, item := range mr.dex.MapList.List_ {
+		if entity.MapItemType(item.Type) == itemType {
+			return item, true
+		}
+	}
+	return entity.MapItem{}, false
+}
+
+func (mr *modelReader) readMethodHandles() error {
+	item, ok := mr.mapItem(entity.KDexTypeMethodHandleItem)
+	if !ok {
+		return nil
+	}
+	r := newDexReader(sectionData(mr.dex, item.Offset))
+	for i := uint32(0); i < item.Size; i++ {
+		handle := &entity.DexMethodHandle{Type: r.u16()}
+		r.u16()
+		idx := uint32(r.u16())
+		r.u16()
+		if r.err != nil {
+			return r.err
+		}
+		var err error
+		if handle.Type <= entity.METHOD_HANDLE_INSTANCE_GET {
+			handle.Field, err = GetFieldRef(mr.dex, idx)
+		} else {
+			handle.Method, err = GetMethodRef(mr.dex, idx)
+		}
+		if err != nil {
+			return err
+		}
+		mr.methodHandles = append(mr.methodHandles, handle)
+	}
+	return nil
+}
+
+func (mr *modelReader) readCallSites() error {
+	item, ok := mr.mapItem(entity.KDexTypeCallSiteIdItem)
+	if !ok {
+		return nil
+	}
+	r := newDexReader(sectionData(mr.dex, item.Offset))
+	for i := uint32(0); i < item.Size; i++ {
+		off := r.u32()
+		if r.err != nil {
+			return r.err
+		}
+		values, err := mr.readEncodedArrayAt(off)
+		if err != nil {
+			return err
+		}
+		mr.callSites = append(mr.callSites, &entity.DexCallSite{Values: values})
+	}
+	return nil
+}
+
+func (mr *modelReader) readClass(classdef entity.ClassDef) (*entity.DexClassModel, error) {
+	dex := mr.dex
+	name, err := GetTypeName(dex, uint32(classdef.Class_idx_))
+	if err != nil {
+		return nil, err
+	}
+	class := &entity.DexClassModel{Name: name, AccessFlags: classdef.Access_flags_}
+	if uint32(classdef.Superclass_idx_) != 0xffff {
+		if class.SuperClass, err = GetTypeName(dex, uint32(classdef.Superclass_idx_)); err != nil {
+			return nil, err
+		}
+	}
+	if class.Interfaces, err = ReadTypeList(dex, classdef.Interfaces_off_); err != nil {
+		return nil, err
+	}
+	if classdef.Source_file_idx_ != entity.NO_INDEX {
+		if class.SourceFile, err = GetStringById(dex, classdef.Source_file_idx_); err != nil {
+			return nil, err
+		}
+	}
+	classData, err := ReadClassData(dex, classdef)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	annotations, err := mr.readAnnotationsDirectory(classdef.Annotations_off_)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	class.Annotations = annotations.class
+
+	var staticValues []entity.EncodedValue
+	if classdef.Static_values_off_ != 0 {
+		if staticValues, err = mr.readEncodedArrayAt(classdef.Static_values_off_); err != nil {
+			return nil, err
+		}
+	}
+	readFields := func(fields []entity.DexField, static bool) ([]*entity.DexFieldModel, error) {
+		var result []*entity.DexFieldModel
+		for i, field := range fields {
+			ref, err := GetFieldRef(dex, field.FieldIdx)
+			if err != nil {
+				return nil, err
+			}
+			model := &entity.DexFieldModel{Ref: *ref, AccessFlags: field.AccessFlags, Annotations: annotations.fields[field.FieldIdx]}
+			if static && i < len(staticValues) {
+				value := staticValues[i]
+				model.StaticValue = &value
+			}
+			result = append(result, model)
+		}
+		return result, nil
+	}
+	if class.StaticFields, err = readFields(classData.StaticFields, true); err != nil {
+		return nil, err
+	}
+	if class.InstanceFields, err = readFields(classData.InstanceFields, false); err != nil {
+		return nil, err
+	}
+	readMethods := func(methods []entity.MethodDef) ([]*entity.DexMethodModel, error) {
+		var result []*entity.DexMethodModel
+		for _, method := range methods {
+			ref, err := GetMethodRef(dex, method.MethodIdx)
+			if err != nil {
+				return nil, err
+			}
+			model := &entity.DexMethodModel{
+				Ref:              *ref,
+				AccessFlags:      method.AccessFlags,
+				Annotations:      annotations.methods[method.MethodIdx],
+				ParamAnnotations: annotations.params[method.MethodIdx],
+			}
+			if method.CodeOff != 0 {
+				if model.Code, err = mr.readCode(method.CodeOff); err != nil {
+					return nil, fmt.Errorf("%s->%s: %v", ref.Class, ref.Name, err)
+				}
+			}
+			result = append(result, model)
+		}
+		return result, nil
+	}
+	if class.DirectMethods, err = readMethods(classData.DirectMethods); err != nil {
+		return nil, err
+	}
+	if class.VirtualMethods, err = readMethods(classData.VirtualMethods); err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+func (mr *modelReader) readCode(codeOff uint32) (*entity.DexCodeModel, error) {
+	item, err := ReadCodeItem(mr.dex, codeOff)
+	if err != nil {
+		return nil, err
+	}
+	insns, err := DecodeInstructions(item.Insns)
+	if err != nil {
+		return nil, err
+	}
+	for _, insn := range insns {
+		if err := mr.resolveInsnRefs(insn); err != nil {
+			return nil, fmt.Errorf("pc %d: %v", insn.Offset, err)
+		}
+	}
+	code := &entity.DexCodeModel{
+		RegistersSize: item.RegistersSize,
+		InsSize:       item.InsSize,
+		OutsSize:      item.OutsSize,
+		Insns:         insns,
+	}
+	for _, try := range item.Tries {
+		model := entity.DexTryModel{StartAddr: try.StartAddr, InsnCount: try.InsnCount, CatchAllAddr: try.CatchAllAddr}
+		for _, handler := range try.Handlers {
+			typ, err := GetTypeName(mr.dex, handler.TypeIdx)
+			if err != nil {
+				return nil, err
+			}
+			model.Handlers = append(model.Handlers, entity.DexCatchModel{Type: typ, Addr: handler.Addr})
+		}
+		code.Tries = append(code.Tries, model)
+	}
+	if item.DebbugInfoOff != 0 {
+		if code.Debug, err = ReadDebugInfo(mr.dex, item.DebbugInfoOff); err != nil {
+			return nil, err
+		}
+	}
+	return code, nil
+}
+
+// 把指令中的索引转换为符号引用
+func (mr *modelReader) resolveInsnRefs(insn *entity.Instruction) error {
+	if insn.Payload != nil {
+		return nil
+	}
+	var err error
+	switch Opcodes[insn.Opcode].Index {
+	case entity.IndexString:
+		insn.Ref, err = GetStringById(mr.dex, insn.Index)
+	case entity.IndexType:
+		insn.Ref, err = GetTypeName(mr.dex, insn.Index)
+	case entity.IndexField:
+		insn.Ref, err = GetFieldRef(mr.dex, insn.Index)
+	case entity.IndexMethod:
+		insn.Ref, err = GetMethodRef(mr.dex, insn.Index)
+	case entity.IndexMethodAndProto:
+		if insn.Ref, err = GetMethodRef(mr.dex, insn.Index); err == nil {
+			var proto entity.DexProtoRef
+			proto, err = GetProtoRef(mr.dex, insn.Index2)
+			insn.Ref2 = &proto
+		}
+	case entity.IndexProto:
+		var proto entity.DexProtoRef
+		proto, err = GetProtoRef(mr.dex, insn.Index)
+		insn.Ref = &proto
+	case entity.IndexCallSite:
+		if insn.Index >= uint32(len(mr.callSites)) {
+			return fmt.Errorf("call site index %d out of range", insn.Index)
+		}
+		insn.Ref = mr.callSites[insn.Index]
+	case entity.IndexMethodHandle:
+		if insn.Index >= uint32(len(mr.methodHandles)) {
+			return fmt.Errorf("method handle index %d out of range", insn.Index)
+		}
+		insn.Ref = mr.methodHandles[insn.Index]
+	}
+	return err
+}
+
+// ReadDebugInfo 读取 debug_info_item
+func ReadDebugInfo(dex *entity.DexFile, off uint32) (*entity.DexDebugInfo, error) {
+	data := sectionData(dex, off)
+	if data == nil {
+		return nil, fmt.Errorf("invalid debug info offset %d", off)
+	}
+	r := newDexReader(data)
+	info := &entity.DexDebugInfo{LineStart: r.uleb()}
+	optString := func(idx uint32) (string, error) {
+		if idx == entity.NO_INDEX {
+			return "", nil
+		}
+		return GetStringById(dex, idx)
+	}
+	optType := func(idx uint32) (string, error) {
+		if idx == entity.NO_INDEX {
+			return "", nil
+		}
+		return GetTypeName(dex, idx)
+	}
+	paramsSize := r.uleb()
+	if uint64(paramsSize) > uint64(len(data)) {
+		return nil, errors.New("invalid debug info")
+	}
+	for i := uint32(0); i < paramsSize; i++ {
+		name, err := optString(r.ulebp1())
+		if err != nil {
+			return nil, err
+		}
+		info.ParamNames = append(info.ParamNames, name)
+	}
+	for r.err == nil {
+		event := entity.DexDebugEvent{Op: r.u8()}
+		var err error
+		switch event.Op {
+		case entity.DBG_END_SEQUENCE:
+			return info, r.err
+		case entity.DBG_ADVANCE_PC:
+			event.AddrDiff = r.uleb()
+		case entity.DBG_ADVANCE_LINE:
+			event.LineDiff = r.sleb()
+		case entity.DBG_START_LOCAL, entity.DBG_START_LOCAL_EXTENDED:
+			event.Reg = r.uleb()
+			if event.Name, err = optString(r.ulebp1()); err != nil {
+				return nil, err
+			}
+			if event.Type, err = optType(r.ulebp1()); err != nil {
+				return nil, err
+			}
+			if event.Op == entity.DBG_START_LOCAL_EXTENDED {
+				if event.Sig, err = optString(r.ulebp1()); err != nil {
+					return nil, err
+				}
+			}
+		case entity.DBG_END_LOCAL, entity.DBG_RESTART_LOCAL:
+			event.Reg = r.uleb()
+		case entity.DBG_SET_FILE:
+			if event.Name, err = optString(r.ulebp1()); err != nil {
+				return nil, err
+			}
+		}
+		info.Events = append(info.Events, event)
+	}
+	return nil, r.err
+}
+
+func (mr *modelReader) readEncodedArrayAt(off uint32) ([]entity.EncodedValue, error) {
+	data := sectionData(mr.dex, off)
+	if data == nil {
+		return nil, fmt.Errorf("invalid encoded array offset %d", off)
+	}
+	r := newDexReader(data)
+	values, err := mr.readEncodedArray(r)
+	if err != nil {
+		return nil, err
+	}
+	return values, r.err
+}
+
+func (mr *modelReader) readEncodedArray(r *dexReader) ([]entity.EncodedValue, error) {
+	size := r.uleb()
+	if uint64(size) > uint64(len(r.data)) {
+		return nil, errors.New("invalid encoded array size")
+	}
+	values := make([]entity.EncodedValue, 0, size)
+	for i := uint32(0); i < size && r.err == nil; i++ {
+		value, err := mr.readEncodedValue(r)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, r.err
+}
+
+func (mr *modelReader) readEncodedAnnotation(r *dexReader) (*entity.EncodedAnnotation, error) {
+	typ, err := GetTypeName(mr.dex, r.uleb())
+	if err != nil {
+		return nil, err
+	}
+	annotation := &entity.EncodedAnnotation{Type: typ}
+	size := r.uleb()
+	if uint64(size) > uint64(len(r.data)) {
+		return nil, errors.New("invalid annotation size")
+	}
+	for i := uint32(0); i < size && r.err == nil; i++ {
+		name, err := GetStringById(mr.dex, r.uleb())
+		if err != nil {
+			return nil, err
+		}
+		value, err := mr.readEncodedValue(r)
+		if err != nil {
+			return nil, err
+		}
+		annotation.Elements = append(annotation.Elements, entity.AnnotationElement{Name: name, Value: value})
+	}
+	return annotation, r.err
+}
+
+func (mr *modelReader) readEncodedValue(r *dexReader) (entity.EncodedValue, error) {
+	header := r.u8()
+	typ := header & 0x1f
+	arg := int(header >> 5)
+	value := entity.EncodedValue{Type: typ}
+	readUnsigned := func() uint64 {
+		raw := r.bytes(arg + 1)
+		var v uint64
+		for i := len(raw) - 1; i >= 0; i-- {
+			v = v<<8 | uint64(raw[i])
+		}
+		return v
+	}
+	readSigned := func() int64 {
+		v := readUnsigned()
+		shift := uint(64 - 8*(arg+1))
+		return int64(v<<shift) >> shift
+	}
+	var err error
+	switch typ {
+	case entity.VALUE_BYTE, entity.VALUE_SHORT, entity.VALUE_INT, entity.VALUE_LONG:
+		value.Value = readSigned()
+	case entity.VALUE_CHAR:
+		value.Value = int64(readUnsigned())
+	case entity.VALUE_FLOAT:
+		// 右侧补零
+		bits := readUnsigned() << uint(8*(3-arg))
+		value.Value = math.Float32frombits(uint32(bits))
+	case entity.VALUE_DOUBLE:
+		bits := readUnsigned() << uint(8*(7-arg))
+		value.Value = math.Float64frombits(bits)
+	case entity.VALUE_METHOD_TYPE:
+		var proto entity.DexProtoRef
+		proto, err = GetProtoRef(mr.dex, uint32(readUnsigned()))
+		value.Value = &proto
+	case entity.VALUE_METHOD_HANDLE:
+		idx := readUnsigned()
+		if idx >= uint64(len(mr.methodHandles)) {
+			return value, fmt.Errorf("method handle index %d out of range", idx)
+		}
+		value.Value = mr.methodHandles[idx]
+	case entity.VALUE_STRING:
+		value.Value, err = GetStringById(mr.dex, uint32(readUnsigned()))
+	case entity.VALUE_TYPE:
+		value.Value, err = GetTypeName(mr.dex, uint32(readUnsigned()))
+	case entity.VALUE_FIELD, entity.VALUE_ENUM:
+		value.Value, err = GetFieldRef(mr.dex, uint32(readUnsigned()))
+	case entity.VALUE_METHOD:
+		value.Value, err = GetMethodRef(mr.dex, uint32(readUnsigned()))
+	case entity.VALUE_ARRAY:
+		value.Value, err = mr.readEncodedArray(r)
+	case entity.VALUE_ANNOTATION:
+		value.Value, err = mr.readEncodedAnnotation(r)
+	case entity.VALUE_NULL:
+	case entity.VALUE_BOOLEAN:
+		value.Value = arg != 0
+	default:
+		return value, fmt.Errorf("unknown encoded value type 0x%x", typ)
+	}
+	if err != nil {
+		return value, err
+	}
+	return value, r.err
+}
+
+type classAnnotations struct {
+	class   []entity.DexAnnotation
+	fields  map[uint32][]entity.DexAnnotation
+	methods map[uint32][]entity.DexAnnotation
+	params  map[uint32][][]entity.DexAnnotation
+}
+
+func (mr *modelReader) readAnnotationsDirectory(off uint32) (classAnnotations, error) {
+	result := classAnnotations{
+		fields:  make(map[uint32][]entity.DexAnnotation),
+		methods: make(map[uint32][]entity.DexAnnotation),
+		params:  make(map[uint32][][]entity.DexAnnotation),
+	}
+	if off == 0 {
+		return result, nil
+	}
+	r := newDexReader(sectionData(mr.dex, off))
+	classOff := r.u32()
+	fieldsSize := r.u32()
+	methodsSize := r.u32()
+	paramsSize := r.u32()
+	if uint64(fieldsSize+methodsSize+paramsSize)*8 > uint64(len(r.data)) {
+		return result, errors.New("invalid annotations directory")
+	}
+	var err error
+	if result.class, err = mr.readAnnotationSet(classOff); err != nil {
+		return result, err
+	}
+	for i := uint32(0); i < fieldsSize; i++ {
+		idx, setOff := r.u32(), r.u32()
+		if result.fields[idx], err = mr.readAnnotationSet(setOff); err != nil {
+			return result, err
+		}
+	}
+	for i := uint32(0); i < methodsSize; i++ {
+		idx, setOff := r.u32(), r.u32()
+		if result.methods[idx], err = mr.readAnnotationSet(setOff); err != nil {
+			return result, err
+		}
+	}
+	for i := uint32(0); i < paramsSize; i++ {
+		idx, listOff := r.u32(), r.u32()
+		lr := newDexReader(sectionData(mr.dex, listOff))
+		size := lr.u32()
+		if uint64(size)*4 > uint64(len(lr.data)) {
+			return result, errors.New("invalid annotation set ref list")
+		}
+		sets := make([][]entity.DexAnnotation, size)
+		for j := range sets {
+			if sets[j], err = mr.readAnnotationSet(lr.u32()); err != nil {
+				return result, err
+			}
+		}
+		result.params[idx] = sets
+	}
+	return result, r.err
+}
+
+func (mr *modelReader) readAnnotationSet(off uint32) ([]entity.DexAnnotation, error) {
+	if off == 0 {
+		return nil, nil
+	}
+	r := newDexReader(sectionData(mr.dex, off))
+	size := r.u32()
+	if uint64(size)*4 > uint64(len(r.data)) {
+		return nil, errors.New("invalid annotation set")
+	}
+	annotations := make([]entity.DexAnnotation, 0, size)
+	for i := uint32(0); i < size; i++ {
+		ar := newDexReader(sectionData(mr.dex, r.u32()))
+		visibility := ar.u8()
+		annotation, err := mr.readEncodedAnnotation(ar)
+		if err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, entity.DexAnnotation{Visibility: visibility, Annotation: *annotation})
+	}
+	return annotations, r.err
+}
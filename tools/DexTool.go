@@ -7,32 +7,107 @@ import (
 	"errors"
 	"fmt"
 	"hash/adler32"
+	"io"
 	"os"
 	"strings"
 )
 
+// LoadDex 读取 dex 文件，041 容器格式只返回其中第一个 dex
 func LoadDex(filepath string) (*entity.DexFile, error) {
-	file, err := os.Open(filepath)
+	dexs, err := LoadDexContainer(filepath)
+	if err != nil {
+		return nil, err
+	}
+	return dexs[0], nil
+}
+
+// LoadDexContainer 读取 dex 文件，041 容器格式中的每个 dex 都作为单独的 DexFile 返回
+func LoadDexContainer(filepath string) ([]*entity.DexFile, error) {
+	raw, err := os.ReadFile(filepath)
 	if err != nil {
 		debugPrint("Error opening dex:%s", err)
 		return nil, fmt.Errorf("opening can not open")
 	}
-	defer file.Close()
+	return parseDexContainer(raw, filepath)
+}
+
+// ReadDexFrom 从 r 中读取 dex，size 为数据的长度，name 作为 DexFile.FileName。
+// 041 容器格式中的每个 dex 都作为单独的 DexFile 返回
+func ReadDexFrom(r io.ReaderAt, size int64, name string) ([]*entity.DexFile, error) {
+	raw := make([]byte, size)
+	if _, err := r.ReadAt(raw, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return parseDexContainer(raw, name)
+}
+
+// ParseDex 解析内存中的 dex，name 作为 DexFile.FileName，返回的 DexFile 直接引用 raw
+func ParseDex(raw []byte, name string) ([]*entity.DexFile, error) {
+	return parseDexContainer(raw, name)
+}
+
+func parseDexContainer(raw []byte, filepath string) ([]*entity.DexFile, error) {
+	var dexs []*entity.DexFile
+	offset := uint32(0)
+	for {
+		data, err := parseDexAt(raw, offset)
+		if err != nil {
+			return nil, err
+		}
+		data.FileName = filepath
+		if len(dexs) > 0 {
+			data.FileName = fmt.Sprintf("%s#%d", filepath, len(dexs))
+		}
+		dexs = append(dexs, data)
+		if !isContainerVersion(data.Header.Version[:]) {
+			return dexs, nil
+		}
+		// file_size 是单个 dex 的大小，可以用来遍历容器中的所有 dex
+		offset += data.Header.FileSize
+		if data.Header.FileSize == 0 || offset >= data.Container.ContainerSize || offset >= uint32(len(raw)) {
+			return dexs, nil
+		}
+	}
+}
+
+func parseDexAt(raw []byte, offset uint32) (*entity.DexFile, error) {
 	data := &entity.DexFile{
 		Strings: make(map[uint32]string),
 	}
-
-	err = binary.Read(file, binary.LittleEndian, &data.Header)
+	if uint64(offset)+entity.KDexHeaderSize > uint64(len(raw)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	reader := bytes.NewReader(raw[offset:])
+	err := binary.Read(reader, binary.LittleEndian, &data.Header)
 	if err != nil {
 		return nil, err
 	}
 	debugPrint("filesize %d headersize %d\n", data.Header.FileSize, data.Header.HeaderSize)
-	data.Oridata = make([]byte, data.Header.FileSize-data.Header.HeaderSize) // 根据 Size 分配空间
-	err = binary.Read(file, binary.LittleEndian, &data.Oridata)
-	if err != nil {
-		return nil, err
+	if data.Header.HeaderSize < entity.KDexHeaderSize || data.Header.FileSize < data.Header.HeaderSize {
+		return nil, fmt.Errorf("invalid header size %d", data.Header.HeaderSize)
 	}
-	data.FileName = filepath
+	if isContainerVersion(data.Header.Version[:]) {
+		if data.Header.HeaderSize < entity.KDexContainerHeaderSize {
+			return nil, fmt.Errorf("invalid header size %d", data.Header.HeaderSize)
+		}
+		err = binary.Read(reader, binary.LittleEndian, &data.Container)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(data.Container.ContainerSize) > uint64(len(raw)) || data.Container.ContainerSize < data.Header.HeaderSize {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if data.Container.HeaderOffset != offset {
+			return nil, fmt.Errorf("header offset %d mismatch %d", data.Container.HeaderOffset, offset)
+		}
+		// 容器内所有偏移都相对于整个文件，Oridata 从文件起始处减去头部大小，保持 off-HeaderSize 的寻址方式
+		data.Oridata = raw[data.Header.HeaderSize:data.Container.ContainerSize]
+		return data, nil
+	}
+	if uint64(offset)+uint64(data.Header.FileSize) > uint64(len(raw)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	data.Oridata = raw[offset+data.Header.HeaderSize : offset+data.Header.FileSize]
 	return data, nil
 }
 
@@ -63,8 +138,37 @@ func isVersionValid(version []byte) bool {
 	if bytes.Equal(version, entity.VERSION_039) {
 		return true
 	}
+	if bytes.Equal(version, entity.VERSION_040) {
+		return true
+	}
+	if bytes.Equal(version, entity.VERSION_041) {
+		return true
+	}
 	return false
 }
+
+// 041 及以后的版本使用容器格式
+func isContainerVersion(version []byte) bool {
+	return bytes.Compare(version, entity.VERSION_041) >= 0
+}
+
+// 当前 dex 的数据在 Oridata 中的范围，容器格式下只取属于自己的那一段
+func logicalData(d *entity.DexFile) []byte {
+	start := d.Container.HeaderOffset
+	end := uint64(start) + uint64(d.Header.FileSize) - uint64(d.Header.HeaderSize)
+	if end > uint64(len(d.Oridata)) || uint64(start) > end {
+		return nil
+	}
+	return d.Oridata[start:end]
+}
+
+// 偏移允许的上限，容器格式中的 dex 可以引用容器中后面的数据
+func dataLimit(d *entity.DexFile) uint32 {
+	if isContainerVersion(d.Header.Version[:]) {
+		return d.Container.ContainerSize
+	}
+	return d.Header.FileSize
+}
 func calculateChecksum(d *entity.DexFile) uint32 {
 	// 初始化 Adler-32 checksum
 	checksum := adler32.New()
@@ -91,9 +195,12 @@ func calculateChecksum(d *entity.DexFile) uint32 {
 	binary.Write(checksum, binary.LittleEndian, d.Header.ClassDefsOff)
 	binary.Write(checksum, binary.LittleEndian, d.Header.DataSize)
 	binary.Write(checksum, binary.LittleEndian, d.Header.DataOff)
+	if isContainerVersion(d.Header.Version[:]) {
+		binary.Write(checksum, binary.LittleEndian, d.Container)
+	}
 
 	// 写入 Oridata 数据
-	checksum.Write(d.Oridata)
+	checksum.Write(logicalData(d))
 
 	// 返回计算的 checksum
 	return checksum.Sum32()
@@ -135,12 +242,27 @@ func checkHeader(dex *entity.DexFile) bool {
 		return false
 	}
 
+	if isContainerVersion(dex.Header.Version[:]) {
+		if dex.Header.HeaderSize != entity.KDexContainerHeaderSize {
+			debugPrint("header size %x\n", dex.Header.HeaderSize)
+			return false
+		}
+		if uint64(dex.Container.HeaderOffset)+uint64(dex.Header.FileSize) > uint64(dex.Container.ContainerSize) {
+			debugPrint("header offset %x container size %x\n", dex.Container.HeaderOffset, dex.Container.ContainerSize)
+			return false
+		}
+	} else if string(dex.Header.Magic[:]) == entity.STAND_DEX_MAGIC && dex.Header.HeaderSize != entity.KDexHeaderSize {
+		debugPrint("header size %x\n", dex.Header.HeaderSize)
+		return false
+	}
+	fileSize := dataLimit(dex)
+
 	offset := dex.Header.LinkOffset
 	size := dex.Header.LinkSize
 	var alignment uint32 = 0
 	label := "link"
 
-	if !checkValidOffsetAndSize(size, dex.Header.FileSize, offset, alignment, label) {
+	if !checkValidOffsetAndSize(size, fileSize, offset, alignment, label) {
 		return false
 	}
 
@@ -149,7 +271,7 @@ func checkHeader(dex *entity.DexFile) bool {
 	alignment = 4
 	label = "map"
 
-	if !checkValidOffsetAndSize(size, dex.Header.FileSize, offset, alignment, label) {
+	if !checkValidOffsetAndSize(size, fileSize, offset, alignment, label) {
 		return false
 	}
 
@@ -158,7 +280,7 @@ func checkHeader(dex *entity.DexFile) bool {
 	alignment = 4
 	label = "string-ids"
 
-	if !checkValidOffsetAndSize(size, dex.Header.FileSize, offset, alignment, label) {
+	if !checkValidOffsetAndSize(size, fileSize, offset, alignment, label) {
 		return false
 	}
 
@@ -167,7 +289,7 @@ func checkHeader(dex *entity.DexFile) bool {
 	alignment = 4
 	label = "type-ids"
 
-	if !checkValidOffsetAndSize(size, dex.Header.FileSize, offset, alignment, label) {
+	if !checkValidOffsetAndSize(size, fileSize, offset, alignment, label) {
 		return false
 	}
 	offset = dex.Header.DataOff
@@ -175,7 +297,7 @@ func checkHeader(dex *entity.DexFile) bool {
 	alignment = 0
 	label = "data"
 
-	return checkValidOffsetAndSize(size, dex.Header.FileSize, offset, alignment, label)
+	return checkValidOffsetAndSize(size, fileSize, offset, alignment, label)
 }
 func mapTypeToBitMask(mapItemType entity.MapItemType) uint32 {
 	switch mapItemType {
@@ -352,45 +474,283 @@ func readClassDataItem(data []byte) (entity.ClassDataItem, error) {
 func ReadMethodCode(dex *entity.DexFile, methodId uint32, classdef entity.ClassDef) (byteCodeItem entity.MethodCodeItem, err error) {
 	for methodIdex := range classdef.ClassDataItem.DirectMethods {
 		if classdef.ClassDataItem.DirectMethods[methodIdex].MethodIdx == methodId {
-			offset := classdef.ClassDataItem.DirectMethods[methodIdex].CodeOff - dex.Header.HeaderSize
-			data := dex.Oridata[offset:]
-			item := entity.MethodCodeItem{
-				RegistersSize: binary.LittleEndian.Uint16(data[0:2]),
-				InsSize:       binary.LittleEndian.Uint16(data[2:4]),
-				OutsSize:      binary.LittleEndian.Uint16(data[4:6]),
-				TriesSize:     binary.LittleEndian.Uint16(data[6:8]),
-				DebbugInfoOff: binary.LittleEndian.Uint32(data[8:12]),
-				InsnsSize:     binary.LittleEndian.Uint32(data[12:16]),
-			}
-			item.Insns = make([]uint16, item.InsnsSize)
-			for i := 0; i < int(item.InsnsSize); i++ {
-				item.Insns[i] = binary.LittleEndian.Uint16(data[16+i*2 : 18+i*2])
-			}
-			return item, nil
+			return ReadCodeItem(dex, classdef.ClassDataItem.DirectMethods[methodIdex].CodeOff)
 		}
 	}
 	for methodIdex := range classdef.ClassDataItem.VirtualMethods {
 		if classdef.ClassDataItem.VirtualMethods[methodIdex].MethodIdx == methodId {
-			offset := classdef.ClassDataItem.VirtualMethods[methodIdex].CodeOff - dex.Header.HeaderSize
-			data := dex.Oridata[offset:]
-			item := entity.MethodCodeItem{
-				RegistersSize: binary.LittleEndian.Uint16(data[0:2]),
-				InsSize:       binary.LittleEndian.Uint16(data[2:4]),
-				OutsSize:      binary.LittleEndian.Uint16(data[4:6]),
-				TriesSize:     binary.LittleEndian.Uint16(data[6:8]),
-				DebbugInfoOff: binary.LittleEndian.Uint32(data[8:12]),
-				InsnsSize:     binary.LittleEndian.Uint32(data[12:16]),
-			}
-			item.Insns = make([]uint16, item.InsnsSize)
-			for i := 0; i < int(item.InsnsSize); i++ {
-				item.Insns[i] = binary.LittleEndian.Uint16(data[16+i*2 : 18+i*2])
-			}
-			return item, nil
+			return ReadCodeItem(dex, classdef.ClassDataItem.VirtualMethods[methodIdex].CodeOff)
 		}
 	}
 	return entity.MethodCodeItem{}, errors.New("not found")
 }
 
+// ReadCodeItem 读取 code item，包括 try/catch 信息
+func ReadCodeItem(dex *entity.DexFile, codeOff uint32) (entity.MethodCodeItem, error) {
+	data := sectionData(dex, codeOff)
+	if data == nil {
+		return entity.MethodCodeItem{}, errors.New("no code")
+	}
+	r := newDexReader(data)
+	item := entity.MethodCodeItem{
+		RegistersSize: r.u16(),
+		InsSize:       r.u16(),
+		OutsSize:      r.u16(),
+		TriesSize:     r.u16(),
+		DebbugInfoOff: r.u32(),
+		InsnsSize:     r.u32(),
+		CodeOff:       codeOff,
+	}
+	if r.err != nil || uint64(item.InsnsSize)*2 > uint64(len(data)) {
+		return entity.MethodCodeItem{}, errors.New("invalid code item")
+	}
+	item.Insns = make([]uint16, item.InsnsSize)
+	for i := range item.Insns {
+		item.Insns[i] = r.u16()
+	}
+	if item.TriesSize == 0 {
+		return item, r.err
+	}
+	if item.InsnsSize%2 == 1 {
+		r.u16() // padding
+	}
+	tries := make([]entity.TryItem, item.TriesSize)
+	handlerOffs := make([]uint16, item.TriesSize)
+	for i := range tries {
+		tries[i].StartAddr = r.u32()
+		tries[i].InsnCount = r.u16()
+		handlerOffs[i] = r.u16()
+	}
+	handlersBase := r.pos
+	for i := range tries {
+		hr := newDexReader(data)
+		hr.pos = handlersBase + int(handlerOffs[i])
+		size := hr.sleb()
+		count := size
+		if count < 0 {
+			count = -count
+		}
+		if count > 0xffff {
+			return entity.MethodCodeItem{}, errors.New("invalid catch handler")
+		}
+		for j := int32(0); j < count; j++ {
+			typeIdx := hr.uleb()
+			addr := hr.uleb()
+			tries[i].Handlers = append(tries[i].Handlers, entity.CatchHandler{TypeIdx: typeIdx, Addr: addr})
+		}
+		tries[i].CatchAllAddr = -1
+		if size <= 0 {
+			tries[i].CatchAllAddr = int64(hr.uleb())
+		}
+		if hr.err != nil {
+			return entity.MethodCodeItem{}, hr.err
+		}
+	}
+	item.Tries = tries
+	return item, r.err
+}
+
+// ReadClassData 读取类的 class data，没有 class data 的类返回空结构
+func ReadClassData(dex *entity.DexFile, classdef entity.ClassDef) (entity.ClassDataItem, error) {
+	if classdef.Class_data_off_ == 0 {
+		return entity.ClassDataItem{}, nil
+	}
+	data := sectionData(dex, classdef.Class_data_off_)
+	if data == nil {
+		return entity.ClassDataItem{}, errors.New("invalid class data offset")
+	}
+	r := newDexReader(data)
+	item := entity.ClassDataItem{
+		StaticFieldsSize:   r.uleb(),
+		InstanceFieldsSize: r.uleb(),
+		DirectMethodsSize:  r.uleb(),
+		VirtualMethodsSize: r.uleb(),
+	}
+	// 每个字段至少 2 字节，每个方法至少 3 字节
+	if uint64(item.StaticFieldsSize+item.InstanceFieldsSize)*2+uint64(item.DirectMethodsSize+item.VirtualMethodsSize)*3 > uint64(len(data)) {
+		return entity.ClassDataItem{}, errors.New("invalid size")
+	}
+	readFields := func(size uint32) []entity.DexField {
+		fields := make([]entity.DexField, size)
+		idx := uint32(0)
+		for i := range fields {
+			idx += r.uleb()
+			fields[i] = entity.DexField{FieldIdx: idx, AccessFlags: r.uleb()}
+		}
+		return fields
+	}
+	readMethods := func(size uint32) []entity.MethodDef {
+		methods := make([]entity.MethodDef, size)
+		idx := uint32(0)
+		for i := range methods {
+			idx += r.uleb()
+			methods[i] = entity.MethodDef{MethodIdx: idx, AccessFlags: r.uleb(), CodeOff: r.uleb()}
+		}
+		return methods
+	}
+	item.StaticFields = readFields(item.StaticFieldsSize)
+	item.InstanceFields = readFields(item.InstanceFieldsSize)
+	item.DirectMethods = readMethods(item.DirectMethodsSize)
+	item.VirtualMethods = readMethods(item.VirtualMethodsSize)
+	return item, r.err
+}
+
+// 读取 proto ID
+func readProtoIds(data []byte, size uint32) ([]entity.ProtoIdDef, error) {
+	if uint64(size)*12 > uint64(len(data)) {
+		return nil, errors.New("invalid proto offset")
+	}
+	protos := make([]entity.ProtoIdDef, size)
+	for i := range protos {
+		offset := i * 12
+		protos[i] = entity.ProtoIdDef{
+			Shorty_idx_:      binary.LittleEndian.Uint32(data[offset : offset+4]),
+			Return_type_idx_: binary.LittleEndian.Uint16(data[offset+4 : offset+6]),
+			Pad_:             binary.LittleEndian.Uint16(data[offset+6 : offset+8]),
+			Parameters_off_:  binary.LittleEndian.Uint32(data[offset+8 : offset+12]),
+		}
+	}
+	return protos, nil
+}
+
+// 读取字段 ID
+func readFieldIds(data []byte, size uint32) ([]entity.FieldIdDef, error) {
+	if uint64(size)*8 > uint64(len(data)) {
+		return nil, errors.New("invalid field offset")
+	}
+	fields := make([]entity.FieldIdDef, size)
+	for i := range fields {
+		offset := i * 8
+		fields[i] = entity.FieldIdDef{
+			Class_idx_: binary.LittleEndian.Uint16(data[offset : offset+2]),
+			Type_idx_:  binary.LittleEndian.Uint16(data[offset+2 : offset+4]),
+			Name_idx_:  binary.LittleEndian.Uint32(data[offset+4 : offset+8]),
+		}
+	}
+	return fields, nil
+}
+
+// GetStringById 根据 string_ids 索引取字符串，结果缓存在 dex.Strings 中
+func GetStringById(dex *entity.DexFile, idx uint32) (string, error) {
+	if str, ok := dex.Strings[idx]; ok {
+		return str, nil
+	}
+	if idx >= uint32(len(dex.StringIds)) {
+		return "", fmt.Errorf("string index %d out of range", idx)
+	}
+	data := sectionData(dex, dex.StringIds[idx])
+	if data == nil {
+		return "", fmt.Errorf("invalid string offset %d", dex.StringIds[idx])
+	}
+	r := newDexReader(data)
+	r.uleb() // utf16 长度
+	end := r.pos
+	for end < len(data) && data[end] != 0 {
+		end++
+	}
+	str, err := decodeMUTF8(data[r.pos:end])
+	if err != nil {
+		return "", err
+	}
+	if dex.Strings == nil {
+		dex.Strings = make(map[uint32]string)
+	}
+	dex.Strings[idx] = str
+	return str, nil
+}
+
+// GetTypeName 根据 type_ids 索引取类型描述符，例如 Ljava/lang/String;
+func GetTypeName(dex *entity.DexFile, typeIdx uint32) (string, error) {
+	if typeIdx >= uint32(len(dex.Typeids)) {
+		return "", fmt.Errorf("type index %d out of range", typeIdx)
+	}
+	return GetStringById(dex, dex.Typeids[typeIdx])
+}
+
+// ReadTypeList 读取 type_list，偏移为 0 时返回空
+func ReadTypeList(dex *entity.DexFile, off uint32) ([]string, error) {
+	if off == 0 {
+		return nil, nil
+	}
+	data := sectionData(dex, off)
+	if data == nil {
+		return nil, fmt.Errorf("invalid type list offset %d", off)
+	}
+	r := newDexReader(data)
+	size := r.u32()
+	if uint64(size)*2 > uint64(len(data)) {
+		return nil, errors.New("invalid type list size")
+	}
+	types := make([]string, size)
+	for i := range types {
+		name, err := GetTypeName(dex, uint32(r.u16()))
+		if err != nil {
+			return nil, err
+		}
+		types[i] = name
+	}
+	return types, r.err
+}
+
+// GetProtoRef 根据 proto_ids 索引取方法原型
+func GetProtoRef(dex *entity.DexFile, protoIdx uint32) (entity.DexProtoRef, error) {
+	if protoIdx >= uint32(len(dex.ProtoIds)) {
+		return entity.DexProtoRef{}, fmt.Errorf("proto index %d out of range", protoIdx)
+	}
+	proto := dex.ProtoIds[protoIdx]
+	ret, err := GetTypeName(dex, uint32(proto.Return_type_idx_))
+	if err != nil {
+		return entity.DexProtoRef{}, err
+	}
+	params, err := ReadTypeList(dex, proto.Parameters_off_)
+	if err != nil {
+		return entity.DexProtoRef{}, err
+	}
+	return entity.DexProtoRef{ReturnType: ret, Params: params}, nil
+}
+
+// GetFieldRef 根据 field_ids 索引取字段引用
+func GetFieldRef(dex *entity.DexFile, fieldIdx uint32) (*entity.DexFieldRef, error) {
+	if fieldIdx >= uint32(len(dex.FieldIds)) {
+		return nil, fmt.Errorf("field index %d out of range", fieldIdx)
+	}
+	field := dex.FieldIds[fieldIdx]
+	class, err := GetTypeName(dex, uint32(field.Class_idx_))
+	if err != nil {
+		return nil, err
+	}
+	name, err := GetStringById(dex, field.Name_idx_)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := GetTypeName(dex, uint32(field.Type_idx_))
+	if err != nil {
+		return nil, err
+	}
+	return &entity.DexFieldRef{Class: class, Name: name, Type: typ}, nil
+}
+
+// GetMethodRef 根据 method_ids 索引取方法引用
+func GetMethodRef(dex *entity.DexFile, methodIdx uint32) (*entity.DexMethodRef, error) {
+	if methodIdx >= uint32(len(dex.MethodIds)) {
+		return nil, fmt.Errorf("method index %d out of range", methodIdx)
+	}
+	method := dex.MethodIds[methodIdx]
+	class, err := GetTypeName(dex, uint32(method.Class_idx_))
+	if err != nil {
+		return nil, err
+	}
+	name, err := GetStringById(dex, method.Name_idx_)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := GetProtoRef(dex, uint32(method.Proto_idx_))
+	if err != nil {
+		return nil, err
+	}
+	return &entity.DexMethodRef{Class: class, Name: name, Proto: proto}, nil
+}
+
 // 读取type ID
 func readTypeIds(data []byte, size uint32) ([]uint32, error) {
 	typeIds := make([]uint32, size)
@@ -492,6 +852,15 @@ func checkMap(dex *entity.DexFile) bool {
 	dex.MapList = mapList
 	return true
 }
+
+// 根据文件偏移取出数据，偏移为 0（对应的段为空）或越界时返回 nil
+func sectionData(dex *entity.DexFile, off uint32) []byte {
+	if off < dex.Header.HeaderSize || off-dex.Header.HeaderSize > uint32(len(dex.Oridata)) {
+		return nil
+	}
+	return dex.Oridata[off-dex.Header.HeaderSize:]
+}
+
 func Verify(dex *entity.DexFile) bool {
 	if !isMagicValid(dex.Header.Magic[:]) {
 		return false
@@ -505,38 +874,48 @@ func Verify(dex *entity.DexFile) bool {
 	if !checkMap((dex)) {
 		return false
 	}
-	stringOff := dex.Header.StringIdsOff - dex.Header.HeaderSize
-	data := dex.Oridata[stringOff:]
+	data := sectionData(dex, dex.Header.StringIdsOff)
 	ids, err := readStringIds(data, dex.Header.StringIdsSize)
 	if err != nil {
 		return false
 	}
 	dex.StringIds = ids
 
-	typeOff := dex.Header.TypeIdsOff - dex.Header.HeaderSize
-	data = dex.Oridata[typeOff:]
+	data = sectionData(dex, dex.Header.TypeIdsOff)
 	ids, err = readTypeIds(data, dex.Header.TypeIdsSize)
 	if err != nil {
 		return false
 	}
 	dex.Typeids = ids
 
-	classOff := dex.Header.ClassDefsOff - dex.Header.HeaderSize
-	data = dex.Oridata[classOff:]
+	data = sectionData(dex, dex.Header.ClassDefsOff)
 	classes, err := readClassDef(data, dex.Header.ClassDefsSize)
 	if err != nil {
 		return false
 	}
 	dex.ClassDef = classes
 
-	methodOff := dex.Header.MethodIdsOff - dex.Header.HeaderSize
-	data = dex.Oridata[methodOff:]
+	data = sectionData(dex, dex.Header.MethodIdsOff)
 	methods, err := readMethodIds(data, dex.Header.MethodIdsSize)
 	if err != nil {
 		return false
 	}
 	dex.MethodIds = methods
 
+	data = sectionData(dex, dex.Header.ProtoIdsOff)
+	protos, err := readProtoIds(data, dex.Header.ProtoIdsSize)
+	if err != nil {
+		return false
+	}
+	dex.ProtoIds = protos
+
+	data = sectionData(dex, dex.Header.FieldIdsOff)
+	fields, err := readFieldIds(data, dex.Header.FieldIdsSize)
+	if err != nil {
+		return false
+	}
+	dex.FieldIds = fields
+
 	dex.ValidDex = true
 	return true
 }
@@ -0,0 +1,1484 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/adler32"
+	"math"
+	"os"
+	"sort"
+	"strings"
+)
+
+// WriteDexFile 把模型写成 dex 文件
+func WriteDexFile(path string, model *entity.DexModel) error {
+	data, err := WriteDex(model)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// WriteDex 把模型按规范布局为 dex：ID 段排序、数据段对齐、重新生成 map_list，并计算 checksum 和 signature
+func WriteDex(model *entity.DexModel) ([]byte, error) {
+	w := &dexWriter{
+		model:        model,
+		strings:      make(map[string]uint32),
+		types:        make(map[string]uint32),
+		protos:       make(map[string]uint32),
+		fields:       make(map[string]uint32),
+		methods:      make(map[string]uint32),
+		handles:      make(map[string]uint32),
+		callSites:    make(map[*entity.DexCallSite]uint32),
+		typeListOffs: make(map[string]uint32),
+		dataOffs:     make(map[string]uint32),
+	}
+	if err := w.collect(); err != nil {
+		return nil, err
+	}
+	w.sortIds()
+	if err := w.layout(); err != nil {
+		return nil, err
+	}
+	return w.out, nil
+}
+
+// GetProtoDescriptor 返回方法原型的描述符，例如 (ILjava/lang/String;)V
+func GetProtoDescriptor(proto entity.DexProtoRef) string {
+	return "(" + strings.Join(proto.Params, "") + ")" + proto.ReturnType
+}
+
+// GetMethodSignature 返回 smali 风格的方法签名，例如 Lcom/a/B;->foo(I)V
+func GetMethodSignature(method *entity.DexMethodRef) string {
+	return method.Class + "->" + method.Name + GetProtoDescriptor(method.Proto)
+}
+
+// GetFieldSignature 返回 smali 风格的字段签名，例如 Lcom/a/B;->count:I
+func GetFieldSignature(field *entity.DexFieldRef) string {
+	return field.Class + "->" + field.Name + ":" + field.Type
+}
+
+// 方法原型的简写形式，引用类型统一写成 L
+func protoShorty(proto entity.DexProtoRef) string {
+	shorty := func(typ string) byte {
+		if typ[0] == '[' {
+			return 'L'
+		}
+		return typ[0]
+	}
+	buf := []byte{shorty(proto.ReturnType)}
+	for _, param := range proto.Params {
+		buf = append(buf, shorty(param))
+	}
+	return string(buf)
+}
+
+func methodHandleKey(handle *entity.DexMethodHandle) string {
+	if handle.Field != nil {
+		return fmt.Sprintf("%d:%s", handle.Type, GetFieldSignature(handle.Field))
+	}
+	if handle.Method != nil {
+		return fmt.Sprintf("%d:%s", handle.Type, GetMethodSignature(handle.Method))
+	}
+	return fmt.Sprintf("%d:", handle.Type)
+}
+
+type dexWriter struct {
+	model *entity.DexModel
+
+	strings    map[string]uint32
+	stringList []string
+	types      map[string]uint32
+	typeList   []string
+	protos     map[string]uint32
+	protoList  []entity.DexProtoRef
+	fields     map[string]uint32
+	fieldList  []entity.DexFieldRef
+	methods    map[string]uint32
+	methodList []entity.DexMethodRef
+	handles    map[string]uint32
+	handleList []*entity.DexMethodHandle
+	callSites  map[*entity.DexCallSite]uint32
+	siteList   []*entity.DexCallSite
+	classes    []*entity.DexClassModel
+	version    string
+
+	out          []byte
+	mapItems     []entity.MapItem
+	typeListOffs map[string]uint32
+	dataOffs     map[string]uint32 // 用于去重的数据项，key 为类型和内容
+}
+
+// 收集阶段：登记所有用到的字符串、类型、原型、字段和方法
+
+func (w *dexWriter) addString(s string) {
+	if _, ok := w.strings[s]; !ok {
+		w.strings[s] = 0
+		w.stringList = append(w.stringList, s)
+	}
+}
+
+func (w *dexWriter) addType(typ string) error {
+	if typ == "" {
+		return errors.New("empty type descriptor")
+	}
+	if _, ok := w.types[typ]; !ok {
+		w.types[typ] = 0
+		w.typeList = append(w.typeList, typ)
+		w.addString(typ)
+	}
+	return nil
+}
+
+func (w *dexWriter) addProto(proto entity.DexProtoRef) error {
+	key := GetProtoDescriptor(proto)
+	if _, ok := w.protos[key]; ok {
+		return nil
+	}
+	if err := w.addType(proto.ReturnType); err != nil {
+		return err
+	}
+	for _, param := range proto.Params {
+		if err := w.addType(param); err != nil {
+			return err
+		}
+	}
+	w.addString(protoShorty(proto))
+	w.protos[key] = 0
+	w.protoList = append(w.protoList, proto)
+	return nil
+}
+
+func (w *dexWriter) addField(field *entity.DexFieldRef) error {
+	key := GetFieldSignature(field)
+	if _, ok := w.fields[key]; ok {
+		return nil
+	}
+	if err := w.addType(field.Class); err != nil {
+		return err
+	}
+	if err := w.addType(field.Type); err != nil {
+		return err
+	}
+	w.addString(field.Name)
+	w.fields[key] = 0
+	w.fieldList = append(w.fieldList, *field)
+	return nil
+}
+
+func (w *dexWriter) addMethod(method *entity.DexMethodRef) error {
+	key := GetMethodSignature(method)
+	if _, ok := w.methods[key]; ok {
+		return nil
+	}
+	if err := w.addType(method.Class); err != nil {
+		return err
+	}
+	if err := w.addProto(method.Proto); err != nil {
+		return err
+	}
+	w.addString(method.Name)
+	w.methods[key] = 0
+	w.methodList = append(w.methodList, *method)
+	return nil
+}
+
+func (w *dexWriter) addMethodHandle(handle *entity.DexMethodHandle) error {
+	key := methodHandleKey(handle)
+	if _, ok := w.handles[key]; ok {
+		return nil
+	}
+	var err error
+	if handle.Type <= entity.METHOD_HANDLE_INSTANCE_GET {
+		if handle.Field == nil {
+			return errors.New("method handle without field")
+		}
+		err = w.addField(handle.Field)
+	} else {
+		if handle.Method == nil {
+			return errors.New("method handle without method")
+		}
+		err = w.addMethod(handle.Method)
+	}
+	if err != nil {
+		return err
+	}
+	w.handles[key] = 0
+	w.handleList = append(w.handleList, handle)
+	return nil
+}
+
+func (w *dexWriter) addCallSite(site *entity.DexCallSite) error {
+	if _, ok := w.callSites[site]; ok {
+		return nil
+	}
+	for _, value := range site.Values {
+		if err := w.addValue(value); err != nil {
+			return err
+		}
+	}
+	w.callSites[site] = uint32(len(w.siteList))
+	w.siteList = append(w.siteList, site)
+	return nil
+}
+
+func (w *dexWriter) addValue(value entity.EncodedValue) error {
+	switch value.Type {
+	case entity.VALUE_STRING:
+		s, ok := value.Value.(string)
+		if !ok {
+			return errors.New("string value expected")
+		}
+		w.addString(s)
+	case entity.VALUE_TYPE:
+		s, ok := value.Value.(string)
+		if !ok {
+			return errors.New("type value expected")
+		}
+		return w.addType(s)
+	case entity.VALUE_FIELD, entity.VALUE_ENUM:
+		field, ok := value.Value.(*entity.DexFieldRef)
+		if !ok {
+			return errors.New("field value expected")
+		}
+		return w.addField(field)
+	case entity.VALUE_METHOD:
+		method, ok := value.Value.(*entity.DexMethodRef)
+		if !ok {
+			return errors.New("method value expected")
+		}
+		return w.addMethod(method)
+	case entity.VALUE_METHOD_TYPE:
+		proto, ok := value.Value.(*entity.DexProtoRef)
+		if !ok {
+			return errors.New("method type value expected")
+		}
+		return w.addProto(*proto)
+	case entity.VALUE_METHOD_HANDLE:
+		handle, ok := value.Value.(*entity.DexMethodHandle)
+		if !ok {
+			return errors.New("method handle value expected")
+		}
+		return w.addMethodHandle(handle)
+	case entity.VALUE_ARRAY:
+		values, ok := value.Value.([]entity.EncodedValue)
+		if !ok {
+			return errors.New("array value expected")
+		}
+		for _, v := range values {
+			if err := w.addValue(v); err != nil {
+				return err
+			}
+		}
+	case entity.VALUE_ANNOTATION:
+		annotation, ok := value.Value.(*entity.EncodedAnnotation)
+		if !ok {
+			return errors.New("annotation value expected")
+		}
+		return w.addEncodedAnnotation(annotation)
+	}
+	return nil
+}
+
+func (w *dexWriter) addEncodedAnnotation(annotation *entity.EncodedAnnotation) error {
+	if err := w.addType(annotation.Type); err != nil {
+		return err
+	}
+	for _, element := range annotation.Elements {
+		w.addString(element.Name)
+		if err := w.addValue(element.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *dexWriter) addAnnotations(annotations []entity.DexAnnotation) error {
+	for i := range annotations {
+		if err := w.addEncodedAnnotation(&annotations[i].Annotation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *dexWriter) requireVersion(version string) {
+	if w.version < version {
+		w.version = version
+	}
+}
+
+func (w *dexWriter) addInsnRef(insn *entity.Instruction) error {
+	if insn.Payload != nil {
+		return nil
+	}
+	info := Opcodes[insn.Opcode]
+	if info.Name == "" {
+		return fmt.Errorf("unused opcode 0x%x", insn.Opcode)
+	}
+	switch {
+	case insn.Opcode >= 0xfe:
+		w.requireVersion("039")
+	case insn.Opcode >= 0xfa:
+		w.requireVersion("038")
+	}
+	if info.Index == entity.IndexNone {
+		return nil
+	}
+	if insn.Ref == nil {
+		return fmt.Errorf("%s without reference", info.Name)
+	}
+	ok := true
+	switch info.Index {
+	case entity.IndexString:
+		var s string
+		if s, ok = insn.Ref.(string); ok {
+			w.addString(s)
+		}
+	case entity.IndexType:
+		var s string
+		if s, ok = insn.Ref.(string); ok {
+			return w.addType(s)
+		}
+	case entity.IndexField:
+		var field *entity.DexFieldRef
+		if field, ok = insn.Ref.(*entity.DexFieldRef); ok {
+			return w.addField(field)
+		}
+	case entity.IndexMethod:
+		var method *entity.DexMethodRef
+		if method, ok = insn.Ref.(*entity.DexMethodRef); ok {
+			return w.addMethod(method)
+		}
+	case entity.IndexMethodAndProto:
+		var method *entity.DexMethodRef
+		var proto *entity.DexProtoRef
+		if method, ok = insn.Ref.(*entity.DexMethodRef); ok {
+			if proto, ok = insn.Ref2.(*entity.DexProtoRef); ok {
+				if err := w.addMethod(method); err != nil {
+					return err
+				}
+				return w.addProto(*proto)
+			}
+		}
+	case entity.IndexProto:
+		var proto *entity.DexProtoRef
+		if proto, ok = insn.Ref.(*entity.DexProtoRef); ok {
+			return w.addProto(*proto)
+		}
+	case entity.IndexCallSite:
+		var site *entity.DexCallSite
+		if site, ok = insn.Ref.(*entity.DexCallSite); ok {
+			return w.addCallSite(site)
+		}
+	case entity.IndexMethodHandle:
+		var handle *entity.DexMethodHandle
+		if handle, ok = insn.Ref.(*entity.DexMethodHandle); ok {
+			return w.addMethodHandle(handle)
+		}
+	}
+	if !ok {
+		return fmt.Errorf("%s: unexpected reference %T", info.Name, insn.Ref)
+	}
+	return nil
+}
+
+func (w *dexWriter) collect() error {
+	w.version = "035"
+	if w.model.Version != "" {
+		w.version = w.model.Version
+	}
+	seen := make(map[string]bool)
+	for _, class := range w.model.Classes {
+		if seen[class.Name] {
+			return fmt.Errorf("duplicate class %s", class.Name)
+		}
+		seen[class.Name] = true
+		if err := w.addType(class.Name); err != nil {
+			return err
+		}
+		if class.SuperClass != "" {
+			if err := w.addType(class.SuperClass); err != nil {
+				return err
+			}
+		}
+		for _, iface := range class.Interfaces {
+			if err := w.addType(iface); err != nil {
+				return err
+			}
+		}
+		if class.SourceFile != "" {
+			w.addString(class.SourceFile)
+		}
+		if err := w.addAnnotations(class.Annotations); err != nil {
+			return err
+		}
+		for _, fields := range [][]*entity.DexFieldModel{class.StaticFields, class.InstanceFields} {
+			for _, field := range fields {
+				if err := w.addField(&field.Ref); err != nil {
+					return err
+				}
+				if field.StaticValue != nil {
+					if err := w.addValue(*field.StaticValue); err != nil {
+						return err
+					}
+				}
+				if err := w.addAnnotations(field.Annotations); err != nil {
+					return err
+				}
+			}
+		}
+		for _, methods := range [][]*entity.DexMethodModel{class.DirectMethods, class.VirtualMethods} {
+			for _, method := range methods {
+				if err := w.addMethod(&method.Ref); err != nil {
+					return err
+				}
+				if err := w.addAnnotations(method.Annotations); err != nil {
+					return err
+				}
+				for _, set := range method.ParamAnnotations {
+					if err := w.addAnnotations(set); err != nil {
+						return err
+					}
+				}
+				if method.Code != nil {
+					if err := w.collectCode(method.Code); err != nil {
+						return fmt.Errorf("%s: %v", GetMethodSignature(&method.Ref), err)
+					}
+				}
+			}
+		}
+	}
+	// 默认值会用到 0 和 null 等，但不会引入新的索引；默认值需要的类型已经在字段中登记
+	if len(w.siteList) > 0 || len(w.handleList) > 0 {
+		w.requireVersion("038")
+	}
+	if w.version > "041" {
+		return fmt.Errorf("unsupported dex version %s", w.version)
+	}
+	return nil
+}
+
+func (w *dexWriter) collectCode(code *entity.DexCodeModel) error {
+	for _, insn := range code.Insns {
+		if err := w.addInsnRef(insn); err != nil {
+			return err
+		}
+	}
+	for _, try := range code.Tries {
+		for _, handler := range try.Handlers {
+			if err := w.addType(handler.Type); err != nil {
+				return err
+			}
+		}
+	}
+	if code.Debug != nil {
+		for _, name := range code.Debug.ParamNames {
+			if name != "" {
+				w.addString(name)
+			}
+		}
+		for _, event := range code.Debug.Events {
+			if event.Name != "" {
+				w.addString(event.Name)
+			}
+			if event.Type != "" {
+				if err := w.addType(event.Type); err != nil {
+					return err
+				}
+			}
+			if event.Sig != "" {
+				w.addString(event.Sig)
+			}
+		}
+	}
+	return nil
+}
+
+// 排序阶段：按规范要求的顺序排列各个 ID 段并分配索引
+func (w *dexWriter) sortIds() {
+	sort.Slice(w.stringList, func(i, j int) bool {
+		return compareDexStrings(w.stringList[i], w.stringList[j]) < 0
+	})
+	for i, s := range w.stringList {
+		w.strings[s] = uint32(i)
+	}
+	// type_ids 按字符串索引排序
+	sort.Slice(w.typeList, func(i, j int) bool {
+		return w.strings[w.typeList[i]] < w.strings[w.typeList[j]]
+	})
+	for i, typ := range w.typeList {
+		w.types[typ] = uint32(i)
+	}
+	sort.Slice(w.protoList, func(i, j int) bool {
+		a, b := w.protoList[i], w.protoList[j]
+		if a.ReturnType != b.ReturnType {
+			return w.types[a.ReturnType] < w.types[b.ReturnType]
+		}
+		for k := 0; k < len(a.Params) && k < len(b.Params); k++ {
+			if a.Params[k] != b.Params[k] {
+				return w.types[a.Params[k]] < w.types[b.Params[k]]
+			}
+		}
+		return len(a.Params) < len(b.Params)
+	})
+	for i, proto := range w.protoList {
+		w.protos[GetProtoDescriptor(proto)] = uint32(i)
+	}
+	sort.Slice(w.fieldList, func(i, j int) bool {
+		a, b := w.fieldList[i], w.fieldList[j]
+		if a.Class != b.Class {
+			return w.types[a.Class] < w.types[b.Class]
+		}
+		if a.Name != b.Name {
+			return w.strings[a.Name] < w.strings[b.Name]
+		}
+		return w.types[a.Type] < w.types[b.Type]
+	})
+	for i := range w.fieldList {
+		w.fields[GetFieldSignature(&w.fieldList[i])] = uint32(i)
+	}
+	sort.Slice(w.methodList, func(i, j int) bool {
+		a, b := w.methodList[i], w.methodList[j]
+		if a.Class != b.Class {
+			return w.types[a.Class] < w.types[b.Class]
+		}
+		if a.Name != b.Name {
+			return w.strings[a.Name] < w.strings[b.Name]
+		}
+		return w.protos[GetProtoDescriptor(a.Proto)] < w.protos[GetProtoDescriptor(b.Proto)]
+	})
+	for i := range w.methodList {
+		w.methods[GetMethodSignature(&w.methodList[i])] = uint32(i)
+	}
+	sort.SliceStable(w.handleList, func(i, j int) bool {
+		return w.handleList[i].Type < w.handleList[j].Type
+	})
+	for i, handle := range w.handleList {
+		w.handles[methodHandleKey(handle)] = uint32(i)
+	}
+	// class_defs 中父类和接口需要排在子类前面
+	index := make(map[string]*entity.DexClassModel)
+	for _, class := range w.model.Classes {
+		index[class.Name] = class
+	}
+	visited := make(map[string]bool)
+	var visit func(name string)
+	visit = func(name string) {
+		class, ok := index[name]
+		if !ok || visited[name] {
+			return
+		}
+		visited[name] = true
+		if class.SuperClass != "" {
+			visit(class.SuperClass)
+		}
+		for _, iface := range class.Interfaces {
+			visit(iface)
+		}
+		w.classes = append(w.classes, class)
+	}
+	for _, class := range w.model.Classes {
+		visit(class.Name)
+	}
+}
+
+func (w *dexWriter) fieldIdx(field *entity.DexFieldRef) uint32 {
+	return w.fields[GetFieldSignature(field)]
+}
+
+func (w *dexWriter) methodIdx(method *entity.DexMethodRef) uint32 {
+	return w.methods[GetMethodSignature(method)]
+}
+
+// 写出阶段
+
+func (w *dexWriter) align(n int) {
+	for len(w.out)%n != 0 {
+		w.out = append(w.out, 0)
+	}
+}
+
+func (w *dexWriter) putU16(v uint16) {
+	w.out = appendU16(w.out, v)
+}
+
+func (w *dexWriter) putU32(v uint32) {
+	w.out = appendU32(w.out, v)
+}
+
+func (w *dexWriter) setU32(off int, v uint32) {
+	binary.LittleEndian.PutUint32(w.out[off:], v)
+}
+
+func (w *dexWriter) addMapItem(itemType entity.MapItemType, size int, offset int) {
+	if size == 0 {
+		return
+	}
+	w.mapItems = append(w.mapItems, entity.MapItem{Type: uint16(itemType), Size: uint32(size), Offset: uint32(offset)})
+}
+
+// 写入可去重的数据项，返回其偏移
+func (w *dexWriter) putDataItem(kind string, alignment int, data []byte, counter *int) uint32 {
+	key := kind + "\x00" + string(data)
+	if off, ok := w.dataOffs[key]; ok {
+		return off
+	}
+	w.align(alignment)
+	off := uint32(len(w.out))
+	w.out = append(w.out, data...)
+	w.dataOffs[key] = off
+	*counter++
+	return off
+}
+
+func (w *dexWriter) layout() error {
+	headerSize := entity.KDexHeaderSize
+	if w.version >= "041" {
+		headerSize = entity.KDexContainerHeaderSize
+	}
+	w.out = make([]byte, headerSize)
+	w.addMapItem(entity.KDexTypeHeaderItem, 1, 0)
+
+	reserve := func(itemType entity.MapItemType, count int, size int) int {
+		if count == 0 {
+			return 0
+		}
+		off := len(w.out)
+		w.out = append(w.out, make([]byte, count*size)...)
+		w.addMapItem(itemType, count, off)
+		return off
+	}
+	stringIdsOff := reserve(entity.KDexTypeStringIdItem, len(w.stringList), 4)
+	typeIdsOff := reserve(entity.KDexTypeTypeIdItem, len(w.typeList), 4)
+	protoIdsOff := reserve(entity.KDexTypeProtoIdItem, len(w.protoList), 12)
+	fieldIdsOff := reserve(entity.KDexTypeFieldIdItem, len(w.fieldList), 8)
+	methodIdsOff := reserve(entity.KDexTypeMethodIdItem, len(w.methodList), 8)
+	classDefsOff := reserve(entity.KDexTypeClassDefItem, len(w.classes), 32)
+	callSiteIdsOff := reserve(entity.KDexTypeCallSiteIdItem, len(w.siteList), 4)
+	handlesOff := reserve(entity.KDexTypeMethodHandleItem, len(w.handleList), 8)
+	dataOff := len(w.out)
+
+	// string_data_item
+	start := len(w.out)
+	for i, s := range w.stringList {
+		w.setU32(stringIdsOff+i*4, uint32(len(w.out)))
+		data, length := encodeMUTF8(s)
+		w.out = appendULEB128(w.out, length)
+		w.out = append(w.out, data...)
+		w.out = append(w.out, 0)
+	}
+	w.addMapItem(entity.KDexTypeStringDataItem, len(w.stringList), start)
+
+	for i, typ := range w.typeList {
+		w.setU32(typeIdsOff+i*4, w.strings[typ])
+	}
+
+	// type_list
+	typeListCount := 0
+	w.align(4)
+	start = len(w.out)
+	putTypeList := func(types []string) uint32 {
+		if len(types) == 0 {
+			return 0
+		}
+		buf := appendU32(nil, uint32(len(types)))
+		for _, typ := range types {
+			buf = appendU16(buf, uint16(w.types[typ]))
+		}
+		return w.putDataItem("type_list", 4, buf, &typeListCount)
+	}
+	for i, proto := range w.protoList {
+		off := protoIdsOff + i*12
+		w.setU32(off, w.strings[protoShorty(proto)])
+		binary.LittleEndian.PutUint16(w.out[off+4:], uint16(w.types[proto.ReturnType]))
+		w.setU32(off+8, putTypeList(proto.Params))
+	}
+	for _, class := range w.classes {
+		w.typeListOffs[class.Name] = putTypeList(class.Interfaces)
+	}
+	w.addMapItem(entity.KDexTypeTypeList, typeListCount, start)
+
+	for i, field := range w.fieldList {
+		off := fieldIdsOff + i*8
+		binary.LittleEndian.PutUint16(w.out[off:], uint16(w.types[field.Class]))
+		binary.LittleEndian.PutUint16(w.out[off+2:], uint16(w.types[field.Type]))
+		w.setU32(off+4, w.strings[field.Name])
+	}
+	for i, method := range w.methodList {
+		off := methodIdsOff + i*8
+		binary.LittleEndian.PutUint16(w.out[off:], uint16(w.types[method.Class]))
+		binary.LittleEndian.PutUint16(w.out[off+2:], uint16(w.protos[GetProtoDescriptor(method.Proto)]))
+		w.setU32(off+4, w.strings[method.Name])
+	}
+	for i, handle := range w.handleList {
+		off := handlesOff + i*8
+		binary.LittleEndian.PutUint16(w.out[off:], handle.Type)
+		idx := uint32(0)
+		if handle.Field != nil {
+			idx = w.fieldIdx(handle.Field)
+		} else {
+			idx = w.methodIdx(handle.Method)
+		}
+		binary.LittleEndian.PutUint16(w.out[off+4:], uint16(idx))
+	}
+	if len(w.typeList) > 0xffff || len(w.protoList) > 0xffff || len(w.fieldList) > 0xffff || len(w.methodList) > 0xffff {
+		return errors.New("too many ids for a single dex")
+	}
+
+	// encoded_array_item：调用点按偏移递增排列，之后是静态字段初始值
+	arrayCount := 0
+	start = len(w.out)
+	for i, site := range w.siteList {
+		buf, err := w.encodeArray(nil, site.Values)
+		if err != nil {
+			return err
+		}
+		// 调用点之间不去重，保证 call_site_ids 按偏移递增
+		w.setU32(callSiteIdsOff+i*4, uint32(len(w.out)))
+		w.out = append(w.out, buf...)
+		arrayCount++
+	}
+	staticValuesOffs := make(map[string]uint32)
+	for _, class := range w.classes {
+		values, err := w.staticValues(class)
+		if err != nil {
+			return fmt.Errorf("%s: %v", class.Name, err)
+		}
+		if values == nil {
+			continue
+		}
+		buf, err := w.encodeArray(nil, values)
+		if err != nil {
+			return fmt.Errorf("%s: %v", class.Name, err)
+		}
+		staticValuesOffs[class.Name] = w.putDataItem("encoded_array", 1, buf, &arrayCount)
+	}
+	w.addMapItem(entity.KDexTypeEncodedArrayItem, arrayCount, start)
+
+	annotationDirs, err := w.writeAnnotations()
+	if err != nil {
+		return err
+	}
+
+	debugOffs, err := w.writeDebugInfos()
+	if err != nil {
+		return err
+	}
+
+	codeOffs, err := w.writeCodeItems(debugOffs)
+	if err != nil {
+		return err
+	}
+
+	// class_data_item
+	classDataCount := 0
+	start = len(w.out)
+	classDataOffs := make(map[string]uint32)
+	for _, class := range w.classes {
+		buf, err := w.encodeClassData(class, codeOffs)
+		if err != nil {
+			return fmt.Errorf("%s: %v", class.Name, err)
+		}
+		if buf == nil {
+			continue
+		}
+		classDataOffs[class.Name] = uint32(len(w.out))
+		w.out = append(w.out, buf...)
+		classDataCount++
+	}
+	w.addMapItem(entity.KDexTypeClassDataItem, classDataCount, start)
+
+	for i, class := range w.classes {
+		off := classDefsOff + i*32
+		binary.LittleEndian.PutUint32(w.out[off:], w.types[class.Name])
+		w.setU32(off+4, class.AccessFlags)
+		superIdx := uint32(entity.NO_INDEX)
+		if class.SuperClass != "" {
+			superIdx = w.types[class.SuperClass]
+		}
+		w.setU32(off+8, superIdx)
+		w.setU32(off+12, w.typeListOffs[class.Name])
+		sourceIdx := uint32(entity.NO_INDEX)
+		if class.SourceFile != "" {
+			sourceIdx = w.strings[class.SourceFile]
+		}
+		w.setU32(off+16, sourceIdx)
+		w.setU32(off+20, annotationDirs[class.Name])
+		w.setU32(off+24, classDataOffs[class.Name])
+		w.setU32(off+28, staticValuesOffs[class.Name])
+	}
+
+	// map_list
+	w.align(4)
+	mapOff := len(w.out)
+	w.addMapItem(entity.KDexTypeMapList, 1, mapOff)
+	sort.SliceStable(w.mapItems, func(i, j int) bool {
+		return w.mapItems[i].Offset < w.mapItems[j].Offset
+	})
+	w.putU32(uint32(len(w.mapItems)))
+	for _, item := range w.mapItems {
+		w.putU16(item.Type)
+		w.putU16(0)
+		w.putU32(item.Size)
+		w.putU32(item.Offset)
+	}
+
+	// header
+	header := entity.DexHeader{
+		FileSize:      uint32(len(w.out)),
+		HeaderSize:    uint32(headerSize),
+		EndianTag:     entity.KDexEndianConstant,
+		MapOff:        uint32(mapOff),
+		StringIdsSize: uint32(len(w.stringList)),
+		StringIdsOff:  uint32(stringIdsOff),
+		TypeIdsSize:   uint32(len(w.typeList)),
+		TypeIdsOff:    uint32(typeIdsOff),
+		ProtoIdsSize:  uint32(len(w.protoList)),
+		ProtoIdsOff:   uint32(protoIdsOff),
+		FieldIdsSize:  uint32(len(w.fieldList)),
+		FieldIdsOff:   uint32(fieldIdsOff),
+		MethodIdsSize: uint32(len(w.methodList)),
+		MethodIdsOff:  uint32(methodIdsOff),
+		ClassDefsSize: uint32(len(w.classes)),
+		ClassDefsOff:  uint32(classDefsOff),
+		DataSize:      uint32(len(w.out) - dataOff),
+		DataOff:       uint32(dataOff),
+	}
+	copy(header.Magic[:], entity.STAND_DEX_MAGIC)
+	copy(header.Version[:], w.version)
+	if w.version >= "041" {
+		// 041 中 data_size 和 data_off 不再使用
+		header.DataSize = 0
+		header.DataOff = 0
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &header)
+	if w.version >= "041" {
+		binary.Write(&buf, binary.LittleEndian, entity.DexContainerHeader{ContainerSize: header.FileSize})
+	}
+	copy(w.out, buf.Bytes())
+	signature := sha1.Sum(w.out[32:])
+	copy(w.out[12:32], signature[:])
+	binary.LittleEndian.PutUint32(w.out[8:], adler32.Checksum(w.out[12:]))
+	return nil
+}
+
+// 静态字段的初始值，按字段索引排序，省略末尾的默认值
+func (w *dexWriter) staticValues(class *entity.DexClassModel) ([]entity.EncodedValue, error) {
+	fields := append([]*entity.DexFieldModel{}, class.StaticFields...)
+	sort.SliceStable(fields, func(i, j int) bool {
+		return w.fieldIdx(&fields[i].Ref) < w.fieldIdx(&fields[j].Ref)
+	})
+	last := -1
+	for i, field := range fields {
+		if field.StaticValue != nil {
+			last = i
+		}
+	}
+	if last < 0 {
+		return nil, nil
+	}
+	values := make([]entity.EncodedValue, last+1)
+	for i := 0; i <= last; i++ {
+		if fields[i].StaticValue != nil {
+			values[i] = *fields[i].StaticValue
+		} else {
+			values[i] = defaultValue(fields[i].Ref.Type)
+		}
+	}
+	return values, nil
+}
+
+func defaultValue(typ string) entity.EncodedValue {
+	switch typ[0] {
+	case 'Z':
+		return entity.EncodedValue{Type: entity.VALUE_BOOLEAN, Value: false}
+	case 'B':
+		return entity.EncodedValue{Type: entity.VALUE_BYTE, Value: int64(0)}
+	case 'S':
+		return entity.EncodedValue{Type: entity.VALUE_SHORT, Value: int64(0)}
+	case 'C':
+		return entity.EncodedValue{Type: entity.VALUE_CHAR, Value: int64(0)}
+	case 'I':
+		return entity.EncodedValue{Type: entity.VALUE_INT, Value: int64(0)}
+	case 'J':
+		return entity.EncodedValue{Type: entity.VALUE_LONG, Value: int64(0)}
+	case 'F':
+		return entity.EncodedValue{Type: entity.VALUE_FLOAT, Value: float32(0)}
+	case 'D':
+		return entity.EncodedValue{Type: entity.VALUE_DOUBLE, Value: float64(0)}
+	}
+	return entity.EncodedValue{Type: entity.VALUE_NULL}
+}
+
+func (w *dexWriter) encodeArray(buf []byte, values []entity.EncodedValue) ([]byte, error) {
+	buf = appendULEB128(buf, uint32(len(values)))
+	var err error
+	for _, value := range values {
+		if buf, err = w.encodeValue(buf, value); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func (w *dexWriter) encodeAnnotation(buf []byte, annotation *entity.EncodedAnnotation) ([]byte, error) {
+	elements := append([]entity.AnnotationElement{}, annotation.Elements...)
+	sort.SliceStable(elements, func(i, j int) bool {
+		return w.strings[elements[i].Name] < w.strings[elements[j].Name]
+	})
+	buf = appendULEB128(buf, w.types[annotation.Type])
+	buf = appendULEB128(buf, uint32(len(elements)))
+	var err error
+	for _, element := range elements {
+		buf = appendULEB128(buf, w.strings[element.Name])
+		if buf, err = w.encodeValue(buf, element.Value); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return n, true
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	}
+	return 0, false
+}
+
+// 有符号整数的最短编码
+func appendSignedValue(buf []byte, typ byte, v int64) []byte {
+	size := 1
+	for size < 8 && (v>>(uint(size)*8-1) != 0 && v>>(uint(size)*8-1) != -1) {
+		size++
+	}
+	buf = append(buf, byte(size-1)<<5|typ)
+	for i := 0; i < size; i++ {
+		buf = append(buf, byte(v>>(uint(i)*8)))
+	}
+	return buf
+}
+
+// 无符号整数的最短编码
+func appendUnsignedValue(buf []byte, typ byte, v uint64) []byte {
+	size := 1
+	for size < 8 && v>>(uint(size)*8) != 0 {
+		size++
+	}
+	buf = append(buf, byte(size-1)<<5|typ)
+	for i := 0; i < size; i++ {
+		buf = append(buf, byte(v>>(uint(i)*8)))
+	}
+	return buf
+}
+
+// 浮点数去掉低位的 0 字节，右侧补零
+func appendRightZeroExtended(buf []byte, typ byte, v uint64, width int) []byte {
+	size := width
+	for size > 1 && v&0xff == 0 {
+		v >>= 8
+		size--
+	}
+	buf = append(buf, byte(size-1)<<5|typ)
+	for i := 0; i < size; i++ {
+		buf = append(buf, byte(v>>(uint(i)*8)))
+	}
+	return buf
+}
+
+func (w *dexWriter) encodeValue(buf []byte, value entity.EncodedValue) ([]byte, error) {
+	typeError := fmt.Errorf("invalid value %v for encoded type 0x%x", value.Value, value.Type)
+	switch value.Type {
+	case entity.VALUE_BYTE:
+		n, ok := toInt64(value.Value)
+		if !ok {
+			return nil, typeError
+		}
+		return append(buf, entity.VALUE_BYTE, byte(n)), nil
+	case entity.VALUE_SHORT, entity.VALUE_INT, entity.VALUE_LONG:
+		n, ok := toInt64(value.Value)
+		if !ok {
+			return nil, typeError
+		}
+		return appendSignedValue(buf, value.Type, n), nil
+	case entity.VALUE_CHAR:
+		n, ok := toInt64(value.Value)
+		if !ok {
+			return nil, typeError
+		}
+		return appendUnsignedValue(buf, value.Type, uint64(uint16(n))), nil
+	case entity.VALUE_FLOAT:
+		f, ok := value.Value.(float32)
+		if !ok {
+			return nil, typeError
+		}
+		return appendRightZeroExtended(buf, value.Type, uint64(math.Float32bits(f)), 4), nil
+	case entity.VALUE_DOUBLE:
+		f, ok := value.Value.(float64)
+		if !ok {
+			return nil, typeError
+		}
+		return appendRightZeroExtended(buf, value.Type, math.Float64bits(f), 8), nil
+	case entity.VALUE_METHOD_TYPE:
+		proto, ok := value.Value.(*entity.DexProtoRef)
+		if !ok {
+			return nil, typeError
+		}
+		return appendUnsignedValue(buf, value.Type, uint64(w.protos[GetProtoDescriptor(*proto)])), nil
+	case entity.VALUE_METHOD_HANDLE:
+		handle, ok := value.Value.(*entity.DexMethodHandle)
+		if !ok {
+			return nil, typeError
+		}
+		return appendUnsignedValue(buf, value.Type, uint64(w.handles[methodHandleKey(handle)])), nil
+	case entity.VALUE_STRING:
+		s, ok := value.Value.(string)
+		if !ok {
+			return nil, typeError
+		}
+		return appendUnsignedValue(buf, value.Type, uint64(w.strings[s])), nil
+	case entity.VALUE_TYPE:
+		s, ok := value.Value.(string)
+		if !ok {
+			return nil, typeError
+		}
+		return appendUnsignedValue(buf, value.Type, uint64(w.types[s])), nil
+	case entity.VALUE_FIELD, entity.VALUE_ENUM:
+		field, ok := value.Value.(*entity.DexFieldRef)
+		if !ok {
+			return nil, typeError
+		}
+		return appendUnsignedValue(buf, value.Type, uint64(w.fieldIdx(field))), nil
+	case entity.VALUE_METHOD:
+		method, ok := value.Value.(*entity.DexMethodRef)
+		if !ok {
+			return nil, typeError
+		}
+		return appendUnsignedValue(buf, value.Type, uint64(w.methodIdx(method))), nil
+	case entity.VALUE_ARRAY:
+		values, ok := value.Value.([]entity.EncodedValue)
+		if !ok {
+			return nil, typeError
+		}
+		return w.encodeArray(append(buf, entity.VALUE_ARRAY), values)
+	case entity.VALUE_ANNOTATION:
+		annotation, ok := value.Value.(*entity.EncodedAnnotation)
+		if !ok {
+			return nil, typeError
+		}
+		return w.encodeAnnotation(append(buf, entity.VALUE_ANNOTATION), annotation)
+	case entity.VALUE_NULL:
+		return append(buf, entity.VALUE_NULL), nil
+	case entity.VALUE_BOOLEAN:
+		b, ok := value.Value.(bool)
+		if !ok {
+			return nil, typeError
+		}
+		if b {
+			return append(buf, 1<<5|entity.VALUE_BOOLEAN), nil
+		}
+		return append(buf, entity.VALUE_BOOLEAN), nil
+	}
+	return nil, fmt.Errorf("unknown encoded value type 0x%x", value.Type)
+}
+
+// 写出注解相关的数据项，返回每个类的 annotations_directory_item 偏移
+func (w *dexWriter) writeAnnotations() (map[string]uint32, error) {
+	itemCount, setCount, refListCount, dirCount := 0, 0, 0, 0
+
+	// annotation_item
+	start := len(w.out)
+	itemOffs := make(map[*entity.DexAnnotation]uint32)
+	var walkErr error
+	eachSet := func(fn func([]entity.DexAnnotation)) {
+		for _, class := range w.classes {
+			fn(class.Annotations)
+			for _, fields := range [][]*entity.DexFieldModel{class.StaticFields, class.InstanceFields} {
+				for _, field := range fields {
+					fn(field.Annotations)
+				}
+			}
+			for _, methods := range [][]*entity.DexMethodModel{class.DirectMethods, class.VirtualMethods} {
+				for _, method := range methods {
+					fn(method.Annotations)
+					for _, set := range method.ParamAnnotations {
+						fn(set)
+					}
+				}
+			}
+		}
+	}
+	eachSet(func(set []entity.DexAnnotation) {
+		for i := range set {
+			if walkErr != nil {
+				return
+			}
+			buf, err := w.encodeAnnotation([]byte{set[i].Visibility}, &set[i].Annotation)
+			if err != nil {
+				walkErr = err
+				return
+			}
+			itemOffs[&set[i]] = w.putDataItem("annotation", 1, buf, &itemCount)
+		}
+	})
+	if walkErr != nil {
+		return nil, walkErr
+	}
+	w.addMapItem(entity.KDexTypeAnnotationItem, itemCount, start)
+
+	// annotation_set_item，按注解类型排序
+	w.align(4)
+	start = len(w.out)
+	setKey := func(set []entity.DexAnnotation) string {
+		return fmt.Sprintf("%p/%d", set, len(set))
+	}
+	setOffs := make(map[string]uint32)
+	eachSet(func(set []entity.DexAnnotation) {
+		if len(set) == 0 {
+			return
+		}
+		if _, ok := setOffs[setKey(set)]; ok {
+			return
+		}
+		sorted := make([]*entity.DexAnnotation, len(set))
+		for i := range set {
+			sorted[i] = &set[i]
+		}
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return w.types[sorted[i].Annotation.Type] < w.types[sorted[j].Annotation.Type]
+		})
+		buf := appendU32(nil, uint32(len(sorted)))
+		for _, annotation := range sorted {
+			buf = appendU32(buf, itemOffs[annotation])
+		}
+		setOffs[setKey(set)] = w.putDataItem("annotation_set", 4, buf, &setCount)
+	})
+	w.addMapItem(entity.KDexTypeAnnotationSetItem, setCount, start)
+	setOff := func(set []entity.DexAnnotation) uint32 {
+		if len(set) == 0 {
+			return 0
+		}
+		return setOffs[setKey(set)]
+	}
+
+	// annotation_set_ref_list
+	w.align(4)
+	start = len(w.out)
+	refListOffs := make(map[*entity.DexMethodModel]uint32)
+	for _, class := range w.classes {
+		for _, methods := range [][]*entity.DexMethodModel{class.DirectMethods, class.VirtualMethods} {
+			for _, method := range methods {
+				if len(method.ParamAnnotations) == 0 {
+					continue
+				}
+				buf := appendU32(nil, uint32(len(method.ParamAnnotations)))
+				for _, set := range method.ParamAnnotations {
+					buf = appendU32(buf, setOff(set))
+				}
+				refListOffs[method] = w.putDataItem("annotation_set_ref_list", 4, buf, &refListCount)
+			}
+		}
+	}
+	w.addMapItem(entity.KDexTypeAnnotationSetRefList, refListCount, start)
+
+	// annotations_directory_item
+	type entry struct{ idx, off uint32 }
+	w.align(4)
+	start = len(w.out)
+	dirs := make(map[string]uint32)
+	for _, class := range w.classes {
+		var fieldEntries, methodEntries, paramEntries []entry
+		for _, fields := range [][]*entity.DexFieldModel{class.StaticFields, class.InstanceFields} {
+			for _, field := range fields {
+				if len(field.Annotations) > 0 {
+					fieldEntries = append(fieldEntries, entry{w.fieldIdx(&field.Ref), setOff(field.Annotations)})
+				}
+			}
+		}
+		for _, methods := range [][]*entity.DexMethodModel{class.DirectMethods, class.VirtualMethods} {
+			for _, method := range methods {
+				idx := w.methodIdx(&method.Ref)
+				if len(method.Annotations) > 0 {
+					methodEntries = append(methodEntries, entry{idx, setOff(method.Annotations)})
+				}
+				if off, ok := refListOffs[method]; ok {
+					paramEntries = append(paramEntries, entry{idx, off})
+				}
+			}
+		}
+		classSet := setOff(class.Annotations)
+		if classSet == 0 && len(fieldEntries) == 0 && len(methodEntries) == 0 && len(paramEntries) == 0 {
+			continue
+		}
+		w.align(4)
+		dirs[class.Name] = uint32(len(w.out))
+		dirCount++
+		w.putU32(classSet)
+		w.putU32(uint32(len(fieldEntries)))
+		w.putU32(uint32(len(methodEntries)))
+		w.putU32(uint32(len(paramEntries)))
+		for _, entries := range [][]entry{fieldEntries, methodEntries, paramEntries} {
+			sort.Slice(entries, func(i, j int) bool { return entries[i].idx < entries[j].idx })
+			for _, e := range entries {
+				w.putU32(e.idx)
+				w.putU32(e.off)
+			}
+		}
+	}
+	w.addMapItem(entity.KDexTypeAnnotationsDirectoryItem, dirCount, start)
+	return dirs, nil
+}
+
+func (w *dexWriter) writeDebugInfos() (map[*entity.DexCodeModel]uint32, error) {
+	offs := make(map[*entity.DexCodeModel]uint32)
+	count := 0
+	start := len(w.out)
+	optString := func(buf []byte, s string) []byte {
+		if s == "" {
+			return appendULEB128(buf, 0)
+		}
+		return appendULEB128(buf, w.strings[s]+1)
+	}
+	optType := func(buf []byte, s string) []byte {
+		if s == "" {
+			return appendULEB128(buf, 0)
+		}
+		return appendULEB128(buf, w.types[s]+1)
+	}
+	for _, class := range w.classes {
+		for _, methods := range [][]*entity.DexMethodModel{class.DirectMethods, class.VirtualMethods} {
+			for _, method := range methods {
+				if method.Code == nil || method.Code.Debug == nil {
+					continue
+				}
+				debug := method.Code.Debug
+				buf := appendULEB128(nil, debug.LineStart)
+				buf = appendULEB128(buf, uint32(len(debug.ParamNames)))
+				for _, name := range debug.ParamNames {
+					buf = optString(buf, name)
+				}
+				for _, event := range debug.Events {
+					buf = append(buf, event.Op)
+					switch event.Op {
+					case entity.DBG_END_SEQUENCE:
+						return nil, errors.New("unexpected DBG_END_SEQUENCE")
+					case entity.DBG_ADVANCE_PC:
+						buf = appendULEB128(buf, event.AddrDiff)
+					case entity.DBG_ADVANCE_LINE:
+						buf = appendSLEB128(buf, event.LineDiff)
+					case entity.DBG_START_LOCAL, entity.DBG_START_LOCAL_EXTENDED:
+						buf = appendULEB128(buf, event.Reg)
+						buf = optString(buf, event.Name)
+						buf = optType(buf, event.Type)
+						if event.Op == entity.DBG_START_LOCAL_EXTENDED {
+							buf = optString(buf, event.Sig)
+						}
+					case entity.DBG_END_LOCAL, entity.DBG_RESTART_LOCAL:
+						buf = appendULEB128(buf, event.Reg)
+					case entity.DBG_SET_FILE:
+						buf = optString(buf, event.Name)
+					}
+				}
+				buf = append(buf, entity.DBG_END_SEQUENCE)
+				offs[method.Code] = uint32(len(w.out))
+				w.out = append(w.out, buf...)
+				count++
+			}
+		}
+	}
+	w.addMapItem(entity.KDexTypeDebugInfoItem, count, start)
+	return offs, nil
+}
+
+// 根据符号引用计算指令中的索引
+func (w *dexWriter) assignInsnIndex(insn *entity.Instruction) {
+	if insn.Payload != nil {
+		return
+	}
+	switch Opcodes[insn.Opcode].Index {
+	case entity.IndexString:
+		insn.Index = w.strings[insn.Ref.(string)]
+	case entity.IndexType:
+		insn.Index = w.types[insn.Ref.(string)]
+	case entity.IndexField:
+		insn.Index = w.fieldIdx(insn.Ref.(*entity.DexFieldRef))
+	case entity.IndexMethod:
+		insn.Index = w.methodIdx(insn.Ref.(*entity.DexMethodRef))
+	case entity.IndexMethodAndProto:
+		insn.Index = w.methodIdx(insn.Ref.(*entity.DexMethodRef))
+		insn.Index2 = w.protos[GetProtoDescriptor(*insn.Ref2.(*entity.DexProtoRef))]
+	case entity.IndexProto:
+		insn.Index = w.protos[GetProtoDescriptor(*insn.Ref.(*entity.DexProtoRef))]
+	case entity.IndexCallSite:
+		insn.Index = w.callSites[insn.Ref.(*entity.DexCallSite)]
+	case entity.IndexMethodHandle:
+		insn.Index = w.handles[methodHandleKey(insn.Ref.(*entity.DexMethodHandle))]
+	}
+}
+
+func (w *dexWriter) encodeCode(code *entity.DexCodeModel) ([]uint16, error) {
+	var units []uint16
+	for _, insn := range code.Insns {
+		if uint32(len(units)) != insn.Offset {
+			return nil, fmt.Errorf("instruction %s at %d moved to %d, branch targets would be invalid", OpcodeName(insn), insn.Offset, len(units))
+		}
+		if insn.Payload != nil && len(units)%2 != 0 {
+			return nil, fmt.Errorf("payload at %d is not 4-byte aligned", insn.Offset)
+		}
+		w.assignInsnIndex(insn)
+		encoded, err := EncodeInstruction(insn)
+		if err != nil {
+			return nil, fmt.Errorf("pc %d: %v", insn.Offset, err)
+		}
+		units = append(units, encoded...)
+	}
+	return units, nil
+}
+
+func (w *dexWriter) writeCodeItems(debugOffs map[*entity.DexCodeModel]uint32) (map[*entity.DexCodeModel]uint32, error) {
+	offs := make(map[*entity.DexCodeModel]uint32)
+	count := 0
+	w.align(4)
+	start := len(w.out)
+	for _, class := range w.classes {
+		for _, methods := range [][]*entity.DexMethodModel{class.DirectMethods, class.VirtualMethods} {
+			for _, method := range methods {
+				code := method.Code
+				if code == nil {
+					continue
+				}
+				insns, err := w.encodeCode(code)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", GetMethodSignature(&method.Ref), err)
+				}
+				if len(code.Tries) > 0xffff {
+					return nil, fmt.Errorf("%s: too many tries", GetMethodSignature(&method.Ref))
+				}
+				w.align(4)
+				offs[code] = uint32(len(w.out))
+				count++
+				w.putU16(code.RegistersSize)
+				w.putU16(code.InsSize)
+				w.putU16(code.OutsSize)
+				w.putU16(uint16(len(code.Tries)))
+				w.putU32(debugOffs[code])
+				w.putU32(uint32(len(insns)))
+				for _, unit := range insns {
+					w.putU16(unit)
+				}
+				if len(code.Tries) == 0 {
+					continue
+				}
+				if len(insns)%2 == 1 {
+					w.putU16(0)
+				}
+				tries := append([]entity.DexTryModel{}, code.Tries...)
+				sort.SliceStable(tries, func(i, j int) bool { return tries[i].StartAddr < tries[j].StartAddr })
+				// encoded_catch_handler_list，相同的 handler 只写一次
+				handlers := appendULEB128(nil, 0)
+				handlerOffs := make(map[string]uint16)
+				handlerCount := uint32(0)
+				var tryOffs []uint16
+				for _, try := range tries {
+					var handler []byte
+					size := int32(len(try.Handlers))
+					if try.CatchAllAddr >= 0 {
+						size = -size
+					}
+					handler = appendSLEB128(handler, size)
+					for _, catch := range try.Handlers {
+						handler = appendULEB128(handler, w.types[catch.Type])
+						handler = appendULEB128(handler, catch.Addr)
+					}
+					if try.CatchAllAddr >= 0 {
+						handler = appendULEB128(handler, uint32(try.CatchAllAddr))
+					}
+					off, ok := handlerOffs[string(handler)]
+					if !ok {
+						off = uint16(len(handlers))
+						handlerOffs[string(handler)] = off
+						handlers = append(handlers, handler...)
+						handlerCount++
+					}
+					tryOffs = append(tryOffs, off)
+				}
+				// 重新写入 handler 个数，长度可能变化，需要整体平移偏移
+				prefix := appendULEB128(nil, handlerCount)
+				shift := uint16(len(prefix) - 1)
+				handlers = append(prefix, handlers[1:]...)
+				for i, try := range tries {
+					w.putU32(try.StartAddr)
+					w.putU16(try.InsnCount)
+					w.putU16(tryOffs[i] + shift)
+				}
+				w.out = append(w.out, handlers...)
+			}
+		}
+	}
+	w.addMapItem(entity.KDexTypeCodeItem, count, start)
+	return offs, nil
+}
+
+func (w *dexWriter) encodeClassData(class *entity.DexClassModel, codeOffs map[*entity.DexCodeModel]uint32) ([]byte, error) {
+	if len(class.StaticFields)+len(class.InstanceFields)+len(class.DirectMethods)+len(class.VirtualMethods) == 0 {
+		return nil, nil
+	}
+	buf := appendULEB128(nil, uint32(len(class.StaticFields)))
+	buf = appendULEB128(buf, uint32(len(class.InstanceFields)))
+	buf = appendULEB128(buf, uint32(len(class.DirectMethods)))
+	buf = appendULEB128(buf, uint32(len(class.VirtualMethods)))
+	for _, list := range [][]*entity.DexFieldModel{class.StaticFields, class.InstanceFields} {
+		fields := append([]*entity.DexFieldModel{}, list...)
+		sort.SliceStable(fields, func(i, j int) bool {
+			return w.fieldIdx(&fields[i].Ref) < w.fieldIdx(&fields[j].Ref)
+		})
+		prev := uint32(0)
+		for i, field := range fields {
+			idx := w.fieldIdx(&field.Ref)
+			if i > 0 && idx == prev {
+				return nil, fmt.Errorf("duplicate field %s", GetFieldSignature(&field.Ref))
+			}
+			buf = appendULEB128(buf, idx-prev)
+			buf = appendULEB128(buf, field.AccessFlags)
+			prev = idx
+		}
+	}
+	for _, list := range [][]*entity.DexMethodModel{class.DirectMethods, class.VirtualMethods} {
+		methods := append([]*entity.DexMethodModel{}, list...)
+		sort.SliceStable(methods, func(i, j int) bool {
+			return w.methodIdx(&methods[i].Ref) < w.methodIdx(&methods[j].Ref)
+		})
+		prev := uint32(0)
+		for i, method := range methods {
+			idx := w.methodIdx(&method.Ref)
+			if i > 0 && idx == prev {
+				return nil, fmt.Errorf("duplicate method %s", GetMethodSignature(&method.Ref))
+			}
+			buf = appendULEB128(buf, idx-prev)
+			buf = appendULEB128(buf, method.AccessFlags)
+			codeOff := uint32(0)
+			if method.Code != nil {
+				codeOff = codeOffs[method.Code]
+			}
+			buf = appendULEB128(buf, codeOff)
+			prev = idx
+		}
+	}
+	return buf, nil
+}
@@ -0,0 +1,346 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"hash/adler32"
+	"testing"
+	"unicode/utf16"
+)
+
+// 按名字取操作码
+func testOpcode(t *testing.T, name string) uint8 {
+	t.Helper()
+	for op, info := range Opcodes {
+		if info.Name == name {
+			return uint8(op)
+		}
+	}
+	t.Fatalf("unknown opcode %s", name)
+	return 0
+}
+
+// 依次计算指令的位置和长度
+func testCode(t *testing.T, regs uint16, ins uint16, outs uint16, insns ...*entity.Instruction) *entity.DexCodeModel {
+	t.Helper()
+	pc := uint32(0)
+	for _, insn := range insns {
+		insn.Offset = pc
+		insn.Size = formatSize(Opcodes[insn.Opcode].Format)
+		pc += insn.Size
+	}
+	return &entity.DexCodeModel{RegistersSize: regs, InsSize: ins, OutsSize: outs, Insns: insns}
+}
+
+// 测试用的模型：类、接口、字段、静态初始值、注解、调试信息，以及需要按 UTF-16 排序的字符串
+func testDexModel(t *testing.T, version string) *entity.DexModel {
+	t.Helper()
+	op := func(name string) uint8 {
+		return testOpcode(t, name)
+	}
+	stringType := "Ljava/lang/String;"
+	greeting := &entity.DexFieldRef{Class: "Lcom/example/Main;", Name: "GREETING", Type: stringType}
+	count := &entity.DexFieldRef{Class: "Lcom/example/Main;", Name: "count", Type: "I"}
+	helper := &entity.DexMethodRef{Class: "Lcom/example/Helper;", Name: "join", Proto: entity.DexProtoRef{ReturnType: stringType, Params: []string{stringType, stringType}}}
+	objectInit := &entity.DexMethodRef{Class: "Ljava/lang/Object;", Name: "<init>", Proto: entity.DexProtoRef{ReturnType: "V"}}
+
+	iface := &entity.DexClassModel{
+		Name:        "Lcom/example/Api;",
+		AccessFlags: 0x0601, // public interface abstract
+		SuperClass:  "Ljava/lang/Object;",
+		VirtualMethods: []*entity.DexMethodModel{{
+			Ref:         entity.DexMethodRef{Class: "Lcom/example/Api;", Name: "run", Proto: entity.DexProtoRef{ReturnType: "I", Params: []string{"I"}}},
+			AccessFlags: 0x0401,
+		}},
+	}
+	helperClass := &entity.DexClassModel{
+		Name:        "Lcom/example/Helper;",
+		AccessFlags: 0x0011,
+		SuperClass:  "Ljava/lang/Object;",
+		DirectMethods: []*entity.DexMethodModel{{
+			Ref:         *helper,
+			AccessFlags: 0x0009,
+			Code: testCode(t, 2, 2, 0,
+				&entity.Instruction{Opcode: op("return-object"), Regs: []uint32{0}},
+			),
+		}},
+	}
+	main := &entity.DexClassModel{
+		Name:        "Lcom/example/Main;",
+		AccessFlags: 0x0001,
+		SuperClass:  "Ljava/lang/Object;",
+		Interfaces:  []string{"Lcom/example/Api;"},
+		SourceFile:  "Main.java",
+		Annotations: []entity.DexAnnotation{{
+			Visibility: entity.VISIBILITY_RUNTIME,
+			Annotation: entity.EncodedAnnotation{
+				Type:     "Lcom/example/Tag;",
+				Elements: []entity.AnnotationElement{{Name: "value", Value: entity.EncodedValue{Type: entity.VALUE_STRING, Value: "￮"}}},
+			},
+		}},
+		StaticFields: []*entity.DexFieldModel{{
+			Ref:         *greeting,
+			AccessFlags: 0x0019,
+			StaticValue: &entity.EncodedValue{Type: entity.VALUE_STRING, Value: "héllo \U0001F600"},
+		}},
+		InstanceFields: []*entity.DexFieldModel{{Ref: *count, AccessFlags: 0x0002}},
+		DirectMethods: []*entity.DexMethodModel{{
+			Ref:         entity.DexMethodRef{Class: "Lcom/example/Main;", Name: "<init>", Proto: entity.DexProtoRef{ReturnType: "V"}},
+			AccessFlags: 0x10001,
+			Code: testCode(t, 1, 1, 1,
+				&entity.Instruction{Opcode: op("invoke-direct"), Regs: []uint32{0}, Ref: objectInit},
+				&entity.Instruction{Opcode: op("return-void")},
+			),
+		}},
+		VirtualMethods: []*entity.DexMethodModel{{
+			Ref:         entity.DexMethodRef{Class: "Lcom/example/Main;", Name: "run", Proto: entity.DexProtoRef{ReturnType: "I", Params: []string{"I"}}},
+			AccessFlags: 0x0001,
+			Code: testCode(t, 4, 2, 2,
+				&entity.Instruction{Opcode: op("sget-object"), Regs: []uint32{0}, Ref: greeting},
+				&entity.Instruction{Opcode: op("const-string"), Regs: []uint32{1}, Ref: "\U0001F600"},
+				&entity.Instruction{Opcode: op("invoke-static"), Regs: []uint32{0, 1}, Ref: helper},
+				&entity.Instruction{Opcode: op("iget"), Regs: []uint32{0, 2}, Ref: count},
+				&entity.Instruction{Opcode: op("add-int"), Regs: []uint32{0, 0, 3}},
+				&entity.Instruction{Opcode: op("return"), Regs: []uint32{0}},
+			),
+		}},
+	}
+	main.VirtualMethods[0].Code.Debug = &entity.DexDebugInfo{
+		LineStart:  10,
+		ParamNames: []string{"delta"},
+		Events:     []entity.DexDebugEvent{{Op: entity.DBG_FIRST_SPECIAL + 5}},
+	}
+	// 子类在前，写出时应该排到父类和接口后面
+	return &entity.DexModel{Version: version, Classes: []*entity.DexClassModel{main, helperClass, iface}}
+}
+
+// 按 UTF-16 编码单元比较，与 dex 规范要求的 string_ids 顺序一致
+func utf16Less(a, b string) bool {
+	x, y := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			return x[i] < y[i]
+		}
+	}
+	return len(x) < len(y)
+}
+
+// 写出模型并检查校验和、签名、头部、map_list 和各个 ID 段的顺序，返回解析后的 dex
+func checkWrittenDex(t *testing.T, data []byte, version string) *entity.DexFile {
+	t.Helper()
+	if got := binary.LittleEndian.Uint32(data[8:]); got != adler32.Checksum(data[12:]) {
+		t.Errorf("checksum %08x, want %08x", got, adler32.Checksum(data[12:]))
+	}
+	if sum := sha1.Sum(data[32:]); !bytes.Equal(data[12:32], sum[:]) {
+		t.Errorf("signature %x, want %x", data[12:32], sum)
+	}
+	dexs, err := ParseDex(data, "test.dex")
+	if err != nil {
+		t.Fatalf("ParseDex: %v", err)
+	}
+	if len(dexs) != 1 {
+		t.Fatalf("%d dex files in container", len(dexs))
+	}
+	dex := dexs[0]
+	if !Verify(dex) {
+		t.Fatalf("written dex does not verify")
+	}
+	if string(dex.Header.Version[:3]) != version {
+		t.Errorf("version %q, want %q", dex.Header.Version[:3], version)
+	}
+	if dex.Header.FileSize != uint32(len(data)) {
+		t.Errorf("file size %d, want %d", dex.Header.FileSize, len(data))
+	}
+
+	// map_list：从头部开始按偏移递增，每种类型只出现一次，位置和数量与头部一致
+	sections := map[entity.MapItemType][2]uint32{
+		entity.KDexTypeHeaderItem:   {1, 0},
+		entity.KDexTypeStringIdItem: {dex.Header.StringIdsSize, dex.Header.StringIdsOff},
+		entity.KDexTypeTypeIdItem:   {dex.Header.TypeIdsSize, dex.Header.TypeIdsOff},
+		entity.KDexTypeProtoIdItem:  {dex.Header.ProtoIdsSize, dex.Header.ProtoIdsOff},
+		entity.KDexTypeFieldIdItem:  {dex.Header.FieldIdsSize, dex.Header.FieldIdsOff},
+		entity.KDexTypeMethodIdItem: {dex.Header.MethodIdsSize, dex.Header.MethodIdsOff},
+		entity.KDexTypeClassDefItem: {dex.Header.ClassDefsSize, dex.Header.ClassDefsOff},
+		entity.KDexTypeMapList:      {1, dex.Header.MapOff},
+	}
+	seen := make(map[entity.MapItemType]bool)
+	items := dex.MapList.List_
+	if len(items) == 0 || entity.MapItemType(items[0].Type) != entity.KDexTypeHeaderItem || items[0].Offset != 0 {
+		t.Fatalf("map_list does not start with the header: %+v", items)
+	}
+	for i, item := range items {
+		typ := entity.MapItemType(item.Type)
+		if seen[typ] {
+			t.Errorf("map item type %04x appears twice", item.Type)
+		}
+		seen[typ] = true
+		if i > 0 && item.Offset <= items[i-1].Offset {
+			t.Errorf("map item %04x at %d is not after %04x at %d", item.Type, item.Offset, items[i-1].Type, items[i-1].Offset)
+		}
+		if item.Size == 0 || item.Offset >= dex.Header.FileSize {
+			t.Errorf("map item %04x: size %d offset %d, file size %d", item.Type, item.Size, item.Offset, dex.Header.FileSize)
+		}
+		if typ != entity.KDexTypeHeaderItem && typ != entity.KDexTypeStringDataItem &&
+			typ != entity.KDexTypeClassDataItem && typ != entity.KDexTypeDebugInfoItem &&
+			typ != entity.KDexTypeAnnotationItem && typ != entity.KDexTypeEncodedArrayItem && item.Offset%4 != 0 {
+			t.Errorf("map item %04x at %d is not 4-byte aligned", item.Type, item.Offset)
+		}
+		if want, ok := sections[typ]; ok && (item.Size != want[0] || item.Offset != want[1]) {
+			t.Errorf("map item %04x: size %d offset %d, header says %d at %d", item.Type, item.Size, item.Offset, want[0], want[1])
+		}
+	}
+	for typ := range sections {
+		if !seen[typ] {
+			t.Errorf("map item %04x missing", typ)
+		}
+	}
+
+	// string_ids 按 UTF-16 排序，type_ids 按字符串索引排序
+	strs := make([]string, len(dex.StringIds))
+	for i := range strs {
+		if strs[i], err = GetStringById(dex, uint32(i)); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && !utf16Less(strs[i-1], strs[i]) {
+			t.Errorf("string_ids not sorted: %q before %q", strs[i-1], strs[i])
+		}
+	}
+	typeIdx := make(map[string]uint32)
+	for i, s := range dex.Typeids {
+		if i > 0 && s <= dex.Typeids[i-1] {
+			t.Errorf("type_ids not sorted at %d", i)
+		}
+		typeIdx[strs[s]] = uint32(i)
+	}
+	stringIdx := make(map[string]uint32)
+	for i, s := range strs {
+		stringIdx[s] = uint32(i)
+	}
+	// 按索引组成的序列比较
+	less := func(a, b []uint32) bool {
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if a[i] != b[i] {
+				return a[i] < b[i]
+			}
+		}
+		return len(a) < len(b)
+	}
+	protoKeys := make([][]uint32, len(dex.ProtoIds))
+	for i := range dex.ProtoIds {
+		proto, err := GetProtoRef(dex, uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := []uint32{typeIdx[proto.ReturnType]}
+		for _, param := range proto.Params {
+			key = append(key, typeIdx[param])
+		}
+		protoKeys[i] = key
+		if i > 0 && !less(protoKeys[i-1], key) {
+			t.Errorf("proto_ids not sorted at %d", i)
+		}
+	}
+	var prev []uint32
+	for i := range dex.FieldIds {
+		field, err := GetFieldRef(dex, uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := []uint32{typeIdx[field.Class], stringIdx[field.Name], typeIdx[field.Type]}
+		if i > 0 && !less(prev, key) {
+			t.Errorf("field_ids not sorted at %s", GetFieldSignature(field))
+		}
+		prev = key
+	}
+	for i, m := range dex.MethodIds {
+		method, err := GetMethodRef(dex, uint32(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := []uint32{typeIdx[method.Class], stringIdx[method.Name], uint32(m.Proto_idx_)}
+		if i > 0 && !less(prev, key) {
+			t.Errorf("method_ids not sorted at %s", GetMethodSignature(method))
+		}
+		prev = key
+	}
+	return dex
+}
+
+// 读回模型，检查类的顺序和内容，并且再次写出的结果与第一次相同
+func checkDexModel(t *testing.T, dex *entity.DexFile, data []byte) {
+	t.Helper()
+	model, err := ReadDexModel(dex)
+	if err != nil {
+		t.Fatalf("ReadDexModel: %v", err)
+	}
+	var names []string
+	for _, class := range model.Classes {
+		names = append(names, class.Name)
+	}
+	// 接口排在实现它的类前面
+	pos := make(map[string]int)
+	for i, name := range names {
+		pos[name] = i
+	}
+	if len(names) != 3 || pos["Lcom/example/Api;"] > pos["Lcom/example/Main;"] {
+		t.Errorf("class order %v", names)
+	}
+	main := model.Classes[pos["Lcom/example/Main;"]]
+	if main.SourceFile != "Main.java" || len(main.Interfaces) != 1 || len(main.Annotations) != 1 {
+		t.Errorf("Main: source %q, interfaces %v, %d annotations", main.SourceFile, main.Interfaces, len(main.Annotations))
+	}
+	if v := main.StaticFields[0].StaticValue; v == nil || v.Value != "héllo \U0001F600" {
+		t.Errorf("static value %+v", v)
+	}
+	run := main.VirtualMethods[0].Code
+	if len(run.Insns) != 6 || run.Insns[1].Ref != "\U0001F600" || run.Debug == nil || run.Debug.LineStart != 10 {
+		t.Errorf("run(): %d insns, debug %+v", len(run.Insns), run.Debug)
+	}
+	again, err := WriteDex(model)
+	if err != nil {
+		t.Fatalf("WriteDex again: %v", err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("rewriting the model changed the dex")
+	}
+}
+
+func TestWriteDex035(t *testing.T) {
+	// 没有指定版本时使用 035
+	data, err := WriteDex(testDexModel(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dex := checkWrittenDex(t, data, "035")
+	if dex.Header.HeaderSize != entity.KDexHeaderSize || dex.Header.DataOff == 0 || dex.Header.DataOff+dex.Header.DataSize != dex.Header.FileSize {
+		t.Errorf("header size %d, data %d+%d, file size %d", dex.Header.HeaderSize, dex.Header.DataOff, dex.Header.DataSize, dex.Header.FileSize)
+	}
+	checkDexModel(t, dex, data)
+}
+
+func TestWriteDex041Container(t *testing.T) {
+	data, err := WriteDex(testDexModel(t, "041"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dex := checkWrittenDex(t, data, "041")
+	if dex.Header.HeaderSize != entity.KDexContainerHeaderSize {
+		t.Errorf("header size %d", dex.Header.HeaderSize)
+	}
+	if dex.Container.ContainerSize != uint32(len(data)) || dex.Container.HeaderOffset != 0 {
+		t.Errorf("container size %d header offset %d, file size %d", dex.Container.ContainerSize, dex.Container.HeaderOffset, len(data))
+	}
+	// 041 不再使用 data_size 和 data_off
+	if dex.Header.DataSize != 0 || dex.Header.DataOff != 0 {
+		t.Errorf("data %d+%d", dex.Header.DataOff, dex.Header.DataSize)
+	}
+	checkDexModel(t, dex, data)
+}
+
+func TestWriteDexRejectsUnknownVersion(t *testing.T) {
+	if _, err := WriteDex(testDexModel(t, "042")); err == nil {
+		t.Errorf("version 042 accepted")
+	}
+}
@@ -0,0 +1,478 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// v1 签名中的摘要算法，按优先级排列
+var jarDigestNames = []string{"SHA-512", "SHA-384", "SHA-256", "SHA-1", "SHA1", "MD5"}
+
+var jarDigestHashes = map[string]crypto.Hash{
+	"SHA-512": crypto.SHA512,
+	"SHA-384": crypto.SHA384,
+	"SHA-256": crypto.SHA256,
+	"SHA-1":   crypto.SHA1,
+	"SHA1":    crypto.SHA1,
+	"MD5":     crypto.MD5,
+}
+
+// MANIFEST.MF 或 .SF 中的一段
+type jarSection struct {
+	raw   []byte            // 包括结尾空行的原始内容
+	attrs map[string]string // 属性名为小写
+}
+
+func (s *jarSection) get(name string) string {
+	return s.attrs[strings.ToLower(name)]
+}
+
+// 解析 MANIFEST.MF 或 .SF，返回主段和按 Name 索引的其他段
+func parseJarManifest(raw []byte) (*jarSection, map[string]*jarSection, error) {
+	var main *jarSection
+	sections := make(map[string]*jarSection)
+	cur := &jarSection{attrs: make(map[string]string)}
+	start := 0
+	key := ""
+	finish := func(end int) error {
+		cur.raw = raw[start:end]
+		if main == nil {
+			main = cur
+			return nil
+		}
+		name := cur.get("Name")
+		if name == "" {
+			return fmt.Errorf("section at %d has no Name", start)
+		}
+		if sections[name] != nil {
+			return fmt.Errorf("duplicate section %s", name)
+		}
+		sections[name] = cur
+		return nil
+	}
+	for pos := 0; pos < len(raw); {
+		end := pos
+		for end < len(raw) && raw[end] != '\r' && raw[end] != '\n' {
+			end++
+		}
+		next := end
+		if next < len(raw) && raw[next] == '\r' {
+			next++
+		}
+		if next < len(raw) && raw[next] == '\n' {
+			next++
+		}
+		line := raw[pos:end]
+		pos = next
+		switch {
+		case len(line) == 0:
+			// 连续的空行不作为新的段
+			if len(cur.attrs) > 0 || main == nil {
+				if err := finish(next); err != nil {
+					return nil, nil, err
+				}
+				cur = &jarSection{attrs: make(map[string]string)}
+			}
+			start = next
+			key = ""
+		case line[0] == ' ':
+			if key == "" {
+				return nil, nil, fmt.Errorf("continuation line at %d without attribute", end-len(line))
+			}
+			cur.attrs[key] += string(line[1:])
+		default:
+			i := bytes.Index(line, []byte(": "))
+			if i <= 0 {
+				return nil, nil, fmt.Errorf("bad line %q", line)
+			}
+			key = strings.ToLower(string(line[:i]))
+			cur.attrs[key] = string(line[i+2:])
+		}
+	}
+	if len(cur.attrs) > 0 || main == nil {
+		if err := finish(len(raw)); err != nil {
+			return nil, nil, err
+		}
+	}
+	return main, sections, nil
+}
+
+// 是否需要在 MANIFEST.MF 中记录摘要：目录和 META-INF 下的签名文件除外
+func jarEntryNeedsDigest(name string) bool {
+	if strings.HasSuffix(name, "/") {
+		return false
+	}
+	if !strings.HasPrefix(name, "META-INF/") || strings.Contains(name[len("META-INF/"):], "/") {
+		return true
+	}
+	lower := strings.ToLower(name[len("META-INF/"):])
+	for _, ext := range []string{".sf", ".rsa", ".dsa", ".ec"} {
+		if strings.HasSuffix(lower, ext) {
+			return false
+		}
+	}
+	return lower != "manifest.mf" && !strings.HasPrefix(lower, "sig-")
+}
+
+// 比较段中记录的摘要与 data 的摘要，返回是否有可识别的摘要以及是否全部一致
+func checkJarDigests(section *jarSection, suffix string, digest func(crypto.Hash) ([]byte, error)) (bool, bool, error) {
+	found, ok := false, true
+	for _, name := range jarDigestNames {
+		value := section.get(name + suffix)
+		if value == "" {
+			continue
+		}
+		found = true
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return found, false, nil
+		}
+		computed, err := digest(jarDigestHashes[name])
+		if err != nil {
+			return found, false, err
+		}
+		if !bytes.Equal(expected, computed) {
+			ok = false
+		}
+	}
+	return found, ok, nil
+}
+
+func hashBytes(hash crypto.Hash, data []byte) []byte {
+	h := hash.New()
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// 验证 v1 签名，没有 .SF 文件时返回 nil。同时返回 .SF 中 X-Android-APK-Signed 声明的更高版本的方案
+func verifyJarSignature(z *entity.ZipArchive) (*entity.SignatureScheme, []int, error) {
+	entries := make(map[string]*entity.ZipEntry)
+	var sfNames []string
+	var duplicates []string
+	for _, e := range z.Entries {
+		if entries[e.Name] != nil {
+			duplicates = append(duplicates, e.Name)
+			continue
+		}
+		entries[e.Name] = e
+		if rest := strings.TrimPrefix(e.Name, "META-INF/"); rest != e.Name && !strings.Contains(rest, "/") && strings.HasSuffix(strings.ToUpper(rest), ".SF") {
+			sfNames = append(sfNames, e.Name)
+		}
+	}
+	if len(sfNames) == 0 {
+		return nil, nil, nil
+	}
+	sort.Strings(sfNames)
+	scheme := &entity.SignatureScheme{Version: entity.SIG_SCHEME_V1}
+	fail := func(format string, args ...interface{}) {
+		scheme.Errors = append(scheme.Errors, fmt.Sprintf(format, args...))
+	}
+	for _, name := range duplicates {
+		fail("duplicate entry %s", name)
+	}
+	mf := entries["META-INF/MANIFEST.MF"]
+	if mf == nil {
+		fail("META-INF/MANIFEST.MF missing")
+		return scheme, nil, nil
+	}
+	manifest, err := ReadZipEntry(z, mf)
+	if err != nil {
+		fail("META-INF/MANIFEST.MF: %v", err)
+		return scheme, nil, nil
+	}
+	mainSection, sections, err := parseJarManifest(manifest)
+	if err != nil {
+		fail("META-INF/MANIFEST.MF: %v", err)
+		return scheme, nil, nil
+	}
+
+	var claims []int
+	var covered []map[string]bool
+	for _, sfName := range sfNames {
+		signer, cover, claim := verifyJarSigner(z, entries, sfName, manifest, mainSection, sections)
+		scheme.Signers = append(scheme.Signers, signer)
+		covered = append(covered, cover)
+		for _, v := range claim {
+			if !containsInt(claims, v) {
+				claims = append(claims, v)
+			}
+		}
+	}
+
+	// 每个文件的摘要与 MANIFEST.MF 一致，并且被每个签名者签署
+	for _, e := range z.Entries {
+		if !jarEntryNeedsDigest(e.Name) || entries[e.Name] != e {
+			continue
+		}
+		section := sections[e.Name]
+		if section == nil {
+			fail("%s is not in the manifest", e.Name)
+			continue
+		}
+		e := e
+		found, ok, err := checkJarDigests(section, "-Digest", func(hash crypto.Hash) ([]byte, error) {
+			return hashZipEntry(z, e, hash)
+		})
+		if err != nil {
+			fail("%s: %v", e.Name, err)
+		} else if !found {
+			fail("%s has no supported digest in the manifest", e.Name)
+		} else if !ok {
+			fail("%s digest does not match the manifest", e.Name)
+		}
+		for i, signer := range scheme.Signers {
+			if covered[i] != nil && !covered[i][e.Name] {
+				signer.Errors = append(signer.Errors, fmt.Sprintf("%s is not signed", e.Name))
+			}
+		}
+	}
+	var missing []string
+	for name := range sections {
+		if entries[name] == nil {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		fail("%s is in the manifest but not in the APK", name)
+	}
+
+	scheme.Verified = len(scheme.Errors) == 0
+	for _, signer := range scheme.Signers {
+		if len(signer.Errors) > 0 {
+			scheme.Verified = false
+		}
+	}
+	return scheme, claims, nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+// 计算压缩包中一个文件解压后的摘要
+func hashZipEntry(z *entity.ZipArchive, e *entity.ZipEntry, hash crypto.Hash) ([]byte, error) {
+	rc, err := OpenZipEntry(z, e)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	h := hash.New()
+	if _, err := io.Copy(h, rc); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// 验证一个 .SF 文件和对应的签名块，返回签名者、签署的 MANIFEST.MF 段 (整个清单都被签署时为 nil)
+// 和 X-Android-APK-Signed 声明的方案
+func verifyJarSigner(z *entity.ZipArchive, entries map[string]*entity.ZipEntry, sfName string, manifest []byte,
+	mainSection *jarSection, sections map[string]*jarSection) (*entity.ApkSigner, map[string]bool, []int) {
+	base := sfName[:len(sfName)-3]
+	signer := &entity.ApkSigner{Name: base}
+	covered := make(map[string]bool)
+	fail := func(format string, args ...interface{}) {
+		signer.Errors = append(signer.Errors, fmt.Sprintf(format, args...))
+	}
+	sf, err := ReadZipEntry(z, entries[sfName])
+	if err != nil {
+		fail("%s: %v", sfName, err)
+		return signer, covered, nil
+	}
+	var blockName string
+	for _, ext := range []string{".RSA", ".DSA", ".EC"} {
+		if entries[base+ext] != nil {
+			blockName = base + ext
+			break
+		}
+	}
+	if blockName == "" {
+		fail("no signature block for %s", sfName)
+	} else if block, err := ReadZipEntry(z, entries[blockName]); err != nil {
+		fail("%s: %v", blockName, err)
+	} else if certs, err := verifyPkcs7(block, sf); err != nil {
+		fail("%s: %v", blockName, err)
+		signer.Certificates = certs
+	} else {
+		signer.Certificates = certs
+	}
+
+	sfMain, sfSections, err := parseJarManifest(sf)
+	if err != nil {
+		fail("%s: %v", sfName, err)
+		return signer, covered, nil
+	}
+	var claims []int
+	for _, v := range strings.Split(sfMain.get("X-Android-APK-Signed"), ",") {
+		if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			claims = append(claims, n)
+		}
+	}
+
+	for _, name := range jarDigestNames {
+		if value := sfMain.get(name + "-Digest-Manifest"); value != "" {
+			decoded, _ := base64.StdEncoding.DecodeString(value)
+			signer.Digests = append(signer.Digests, entity.SignatureDigest{Name: name, Value: decoded})
+		}
+	}
+	// 整个清单的摘要一致时不再检查各段
+	found, ok, _ := checkJarDigests(sfMain, "-Digest-Manifest", func(hash crypto.Hash) ([]byte, error) {
+		return hashBytes(hash, manifest), nil
+	})
+	if found && ok {
+		return signer, nil, claims
+	}
+	if found, ok, _ := checkJarDigests(sfMain, "-Digest-Manifest-Main-Attributes", func(hash crypto.Hash) ([]byte, error) {
+		return hashBytes(hash, mainSection.raw), nil
+	}); found && !ok {
+		fail("main attributes digest does not match the manifest")
+	}
+	for name, section := range sfSections {
+		mfSection := sections[name]
+		if mfSection == nil {
+			fail("%s is signed but not in the manifest", name)
+			continue
+		}
+		found, ok, _ := checkJarDigests(section, "-Digest", func(hash crypto.Hash) ([]byte, error) {
+			return hashBytes(hash, mfSection.raw), nil
+		})
+		if !found || !ok {
+			fail("%s section digest does not match the manifest", name)
+			continue
+		}
+		covered[name] = true
+	}
+	return signer, covered, claims
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerial           asn1.RawValue
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+var (
+	oidPkcs7Data          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidPkcs7SignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidPkcs9ContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidPkcs9MessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// PKCS#7 中摘要算法的 OID
+var pkcs7DigestOids = map[string]crypto.Hash{
+	"1.2.840.113549.2.5":     crypto.MD5,
+	"1.3.14.3.2.26":          crypto.SHA1,
+	"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+	"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+	"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+}
+
+// 验证 PKCS#7 签名块对 content 的签名，返回的证书中签名证书在第一个
+func verifyPkcs7(block []byte, content []byte) ([]*x509.Certificate, error) {
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(block, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(oidPkcs7SignedData) {
+		return nil, fmt.Errorf("content type %v is not signed data", ci.ContentType)
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) == 0 {
+		return certs, fmt.Errorf("no signer info")
+	}
+	// 与 Android 一样只验证第一个签名者
+	si := sd.SignerInfos[0]
+	var ias pkcs7IssuerAndSerial
+	signing := -1
+	if _, err := asn1.Unmarshal(si.IssuerAndSerial.FullBytes, &ias); err == nil {
+		for i, cert := range certs {
+			if bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes) && cert.SerialNumber.Cmp(ias.Serial) == 0 {
+				signing = i
+				break
+			}
+		}
+	}
+	if signing < 0 {
+		return certs, fmt.Errorf("signer certificate not found")
+	}
+	certs[0], certs[signing] = certs[signing], certs[0]
+	hash, ok := pkcs7DigestOids[si.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return certs, fmt.Errorf("unsupported digest algorithm %v", si.DigestAlgorithm.Algorithm)
+	}
+	signed := content
+	if len(si.AuthenticatedAttributes.Bytes) > 0 {
+		var digest []byte
+		for rest := si.AuthenticatedAttributes.Bytes; len(rest) > 0; {
+			var attr pkcs7Attribute
+			if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+				return certs, err
+			}
+			if attr.Type.Equal(oidPkcs9MessageDigest) {
+				if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+					return certs, err
+				}
+			}
+		}
+		if !bytes.Equal(digest, hashBytes(hash, content)) {
+			return certs, fmt.Errorf("message digest does not match the signature file")
+		}
+		// 签署的是 SET 编码的属性
+		signed = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+	}
+	if err := verifyHashed(certs[0].PublicKey, hash, false, hashBytes(hash, signed), si.EncryptedDigest); err != nil {
+		return certs, err
+	}
+	return certs, nil
+}
@@ -0,0 +1,123 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+// LoadSigningKey 读取签名用的私钥和证书。私钥可以是 PEM 或 DER 格式的 PKCS#8 (包括加密的 PKCS#8)、
+// PKCS#1 RSA 或 SEC1 EC 私钥，证书可以是 PEM (可以包含证书链) 或 DER 格式
+func LoadSigningKey(keyPath string, certPath string, password string) (*entity.SigningKey, error) {
+	keyRaw, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(keyRaw, password)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", keyPath, err)
+	}
+	certRaw, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := parseCertificates(certRaw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", certPath, err)
+	}
+	return newSigningKey(key, certs)
+}
+
+// 检查私钥与第一个证书的公钥一致
+func newSigningKey(key crypto.Signer, certs []*x509.Certificate) (*entity.SigningKey, error) {
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate")
+	}
+	pub, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	if string(pub) != string(certs[0].RawSubjectPublicKeyInfo) {
+		return nil, fmt.Errorf("private key does not match certificate %s", certs[0].Subject)
+	}
+	return &entity.SigningKey{Key: key, Certificates: certs}, nil
+}
+
+func parsePrivateKey(raw []byte, password string) (crypto.Signer, error) {
+	der := raw
+	if block, _ := pem.Decode(raw); block != nil {
+		der = block.Bytes
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(der)
+		case "EC PRIVATE KEY":
+			return x509.ParseECPrivateKey(der)
+		case "ENCRYPTED PRIVATE KEY":
+			var err error
+			if der, err = decryptPkcs8(der, password); err != nil {
+				return nil, err
+			}
+		}
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		// 可能是 DER 格式的加密 PKCS#8
+		plain, decErr := decryptPkcs8(der, password)
+		if decErr != nil {
+			return nil, err
+		}
+		if key, err = x509.ParsePKCS8PrivateKey(plain); err != nil {
+			return nil, err
+		}
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key %T", key)
+	}
+	return signer, nil
+}
+
+// 加密的 PKCS#8 私钥
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+func decryptPkcs8(der []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if rest, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, fmt.Errorf("trailing data after encrypted private key")
+	}
+	return pbeDecrypt(info.Algorithm, info.Data, password)
+}
+
+func parseCertificates(raw []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := raw
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+	return x509.ParseCertificates(raw)
+}
@@ -0,0 +1,234 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+	"sort"
+)
+
+const (
+	contextClass        = "Landroid/content/Context;"
+	contextWrapperClass = "Landroid/content/ContextWrapper;"
+	contextImplClass    = "Landroid/app/ContextImpl;"
+	contextBaseField    = contextWrapperClass + "->mBase:Landroid/content/Context;"
+)
+
+// 记录应用代码对框架方法的调用
+type frameworkRecorder struct {
+	calls []entity.FrameworkCall
+}
+
+func (r *frameworkRecorder) BeforeInstruction(vm *VM, frame *VMFrame, insn *entity.Instruction) error {
+	return nil
+}
+
+func (r *frameworkRecorder) AfterInstruction(vm *VM, frame *VMFrame, insn *entity.Instruction) error {
+	return nil
+}
+
+func (r *frameworkRecorder) AfterFrameworkCall(vm *VM, ref *entity.DexMethodRef, args []entity.VMValue, ret entity.VMValue, err error) {
+	// 驱动直接调用的生命周期方法不算
+	if vm.Depth() == 0 {
+		return
+	}
+	caller := vm.frames[len(vm.frames)-1]
+	call := entity.FrameworkCall{
+		Caller: caller.Name(),
+		Offset: caller.pc,
+		Method: GetMethodSignature(ref),
+		Args:   make([]string, len(args)),
+	}
+	for i, arg := range args {
+		call.Args[i] = FormatValue(arg)
+	}
+	if exc, ok := err.(*JavaException); ok {
+		call.Error = throwableString(exc.Object)
+	} else if err != nil {
+		call.Error = err.Error()
+	} else if ref.Proto.ReturnType != "V" {
+		call.Result = FormatValue(ret)
+	}
+	r.calls = append(r.calls, call)
+}
+
+// Context 的默认实现：ContextWrapper 的 mBase，getApplicationContext 返回自身，
+// getPackageName 返回 vm.PackageName
+func addContextStubs(stubs map[string]NativeMethod) {
+	stubs[contextWrapperClass+"->attachBaseContext(Landroid/content/Context;)V"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		if obj, ok := args[0].Ref.(*entity.VMObject); ok {
+			if obj.Fields == nil {
+				obj.Fields = make(map[string]entity.VMValue)
+			}
+			obj.Fields[contextBaseField] = args[1]
+		}
+		return entity.VMValue{}, nil
+	}
+	stubs[contextWrapperClass+"->getBaseContext()Landroid/content/Context;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		if obj, ok := args[0].Ref.(*entity.VMObject); ok {
+			if base, ok := obj.Fields[contextBaseField]; ok {
+				return base, nil
+			}
+		}
+		return RefValue(nil), nil
+	}
+	stubs[contextClass+"->getApplicationContext()Landroid/content/Context;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return args[0], nil
+	}
+	stubs[contextClass+"->getPackageName()Ljava/lang/String;"] = func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return stringValue(vm.PackageName), nil
+	}
+}
+
+// 生命周期模拟需要的框架方法：getApplicationContext 返回模拟的 Application，
+// 以及应用通常会调用的父类生命周期方法
+func registerLifecycleStubs(vm *VM, app *entity.VMObject) {
+	noop := func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return entity.VMValue{}, nil
+	}
+	vm.RegisterStub(contextClass+"->getApplicationContext()Landroid/content/Context;", func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return RefValue(app), nil
+	})
+	vm.RegisterStub("Landroid/app/Activity;->getApplication()Landroid/app/Application;", func(vm *VM, args []entity.VMValue) (entity.VMValue, error) {
+		return RefValue(app), nil
+	})
+	vm.RegisterStub(entity.FRAMEWORK_APPLICATION_CLASS+"-><init>()V", noop)
+	vm.RegisterStub(entity.FRAMEWORK_APPLICATION_CLASS+"->onCreate()V", noop)
+	vm.RegisterStub(entity.FRAMEWORK_ACTIVITY_CLASS+"-><init>()V", noop)
+	vm.RegisterStub(entity.FRAMEWORK_ACTIVITY_CLASS+"->onCreate(Landroid/os/Bundle;)V", noop)
+	vm.RegisterStub(entity.FRAMEWORK_ACTIVITY_CLASS+"->setContentView(I)V", noop)
+}
+
+// LauncherActivity 返回清单中的启动 Activity，有多个时按名字取第一个，没有时返回空
+func LauncherActivity(manifest *entity.ManifestData) string {
+	var names []string
+	for name, main := range manifest.Activity {
+		if main {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	return componentClassName(manifest.PackageName, names[0])
+}
+
+// RunLifecycle 按应用启动的顺序模拟执行清单中的组件：创建 Application 并调用
+// <init>、attachBaseContext、onCreate，然后对启动 Activity 调用 <init>、attachBaseContext、onCreate。
+// 没有声明或不在类路径中的组件使用框架对象代替。应用没有重写的方法由框架桩函数处理，
+// 其他框架调用按 vm.UnknownPolicy 处理，通常使用 UNKNOWN_CALL_SYMBOLIC。
+// 某一步失败时停止，返回已经执行的结果和这一步的错误
+func RunLifecycle(vm *VM, manifest *entity.ManifestData) (*entity.LifecycleReport, error) {
+	if vm.ClassPath == nil {
+		return nil, fmt.Errorf("lifecycle simulation needs a class path")
+	}
+	// 所有步骤共用一次资源限制的计数
+	if err := vm.enter(); err != nil {
+		return nil, err
+	}
+	recorder := &frameworkRecorder{}
+	hooks := vm.Hooks
+	vm.Hooks = append(append([]VMHook(nil), hooks...), recorder)
+	defer func() {
+		vm.Hooks = hooks
+	}()
+
+	report := &entity.LifecycleReport{Activity: LauncherActivity(manifest)}
+	err := runLifecycleSteps(vm, manifest, report)
+	report.Calls = recorder.calls
+	report.UnknownCalls = append([]string(nil), vm.UnknownCalls...)
+	report.Logs = append([]string(nil), vm.Logs...)
+	return report, err
+}
+
+// 一个生命周期方法和除 this 以外的参数
+type lifecycleCall struct {
+	desc string
+	args []entity.VMValue
+}
+
+func runLifecycleSteps(vm *VM, manifest *entity.ManifestData, report *entity.LifecycleReport) error {
+	appClass := entity.FRAMEWORK_APPLICATION_CLASS
+	if manifest.Application != "" {
+		appClass = convertToDexClassName(componentClassName(manifest.PackageName, manifest.Application))
+	}
+	report.Application = convertToClassName(appClass)
+	app, err := vm.newComponent(appClass)
+	if err != nil {
+		return err
+	}
+	vm.PackageName = manifest.PackageName
+	registerLifecycleStubs(vm, app)
+	base := &entity.VMObject{Class: contextImplClass, Fields: make(map[string]entity.VMValue)}
+
+	appSteps := []lifecycleCall{
+		{"<init>()V", nil},
+		{"attachBaseContext(Landroid/content/Context;)V", []entity.VMValue{RefValue(base)}},
+		{"onCreate()V", nil},
+	}
+	for _, call := range appSteps {
+		if err := vm.lifecycleStep(report, app, call); err != nil {
+			return err
+		}
+	}
+	if report.Activity == "" {
+		return nil
+	}
+
+	activity, err := vm.newComponent(convertToDexClassName(report.Activity))
+	if err != nil {
+		return err
+	}
+	activitySteps := []lifecycleCall{
+		{"<init>()V", nil},
+		{"attachBaseContext(Landroid/content/Context;)V", []entity.VMValue{RefValue(base)}},
+		{"onCreate(Landroid/os/Bundle;)V", []entity.VMValue{RefValue(nil)}},
+	}
+	for _, call := range activitySteps {
+		if err := vm.lifecycleStep(report, activity, call); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 创建组件对象，类不在类路径中时创建框架对象
+func (vm *VM) newComponent(class string) (*entity.VMObject, error) {
+	if vm.ClassPath.Classes[class] == nil {
+		return &entity.VMObject{Class: class, Fields: make(map[string]entity.VMValue)}, nil
+	}
+	return vm.newObject(class)
+}
+
+// 在组件对象上调用一个生命周期方法，应用中有实现时执行应用的代码，否则交给框架
+func (vm *VM) lifecycleStep(report *entity.LifecycleReport, obj *entity.VMObject, call lifecycleCall) error {
+	ref, err := ParseMethodSignature(obj.Class + "->" + call.desc)
+	if err != nil {
+		return err
+	}
+	kind := uint8(0x6e) // invoke-virtual
+	var target *entity.ClassMethod
+	if ref.Name == "<init>" {
+		// 构造方法只在组件类自身中查找
+		kind = 0x70 // invoke-direct
+		if class := vm.ClassPath.Classes[obj.Class]; class != nil {
+			target, _ = vm.ClassPath.FindMethod(class, ref.Name, GetProtoDescriptor(ref.Proto))
+		}
+	} else {
+		target, _ = vm.ClassPath.ResolveVirtual(obj.Class, ref)
+	}
+	step := entity.LifecycleStep{Component: convertToClassName(obj.Class), Method: GetMethodSignature(ref)}
+	args := append([]entity.VMValue{RefValue(obj)}, call.args...)
+	if target != nil && target.CodeOff != 0 {
+		step.Method = GetMethodSignature(&target.Ref)
+		_, err = vm.invokeMethod(target, args)
+	} else {
+		step.Framework = true
+		_, err = vm.callMethod(kind, ref, args)
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	report.Steps = append(report.Steps, step)
+	return err
+}
@@ -4,6 +4,7 @@ package tools
 
 import (
 	"apkgo/entity"
+	"bytes"
 	"container/list"
 	"encoding/binary"
 	"errors"
@@ -32,13 +33,27 @@ func ReadManifest(mFile string) (*entity.ManifestData, error) {
 	}
 	defer file.Close()
 
+	return parseManifest(file)
+}
+
+// ReadManifestFrom 从 r 中读取二进制 AndroidManifest.xml，size 为数据的长度
+func ReadManifestFrom(r io.ReaderAt, size int64) (*entity.ManifestData, error) {
+	return parseManifest(io.NewSectionReader(r, 0, size))
+}
+
+// ParseManifest 解析内存中的二进制 AndroidManifest.xml
+func ParseManifest(raw []byte) (*entity.ManifestData, error) {
+	return parseManifest(bytes.NewReader(raw))
+}
+
+func parseManifest(r io.ReadSeeker) (*entity.ManifestData, error) {
 	data := &entity.ManifestData{
 		OtherChunks:    list.New(),
 		Activity:       make(map[string]bool),
 		UsesPermission: list.New(),
 	}
 
-	err = parseXML(file, data)
+	err := parseXML(r, data)
 
 	return data, err
 }
@@ -72,7 +87,7 @@ func getUtf8StringByIndex(index uint32, data *entity.ManifestData) string {
 	return string(utf16.Decode(utf16Chars))
 }
 
-func parseXML(file *os.File, data *entity.ManifestData) error {
+func parseXML(file io.ReadSeeker, data *entity.ManifestData) error {
 	const scStart = 0x8
 
 	// 读Header
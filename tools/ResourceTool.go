@@ -0,0 +1,461 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// ParseResources 解析 resources.arsc：全局字符串池、包和每个资源在各个配置下的值
+func ParseResources(raw []byte) (*entity.ResourceTable, error) {
+	typ, headerSize, size, err := chunkHeader(raw, 0)
+	if err != nil {
+		return nil, err
+	}
+	if typ != entity.RES_TABLE_TYPE {
+		return nil, fmt.Errorf("not a resource table: chunk type 0x%x", typ)
+	}
+	table := &entity.ResourceTable{
+		Packages: make(map[uint32]string),
+		Entries:  make(map[uint32]*entity.ResourceEntry),
+	}
+	for off := headerSize; off < size; {
+		typ, _, chunkSize, err := chunkHeader(raw, off)
+		if err != nil {
+			return nil, err
+		}
+		chunk := raw[off : off+chunkSize]
+		switch typ {
+		case entity.RES_STRING_POOL_TYPE:
+			if table.Strings, err = parseStringPool(chunk); err != nil {
+				return nil, fmt.Errorf("string pool: %v", err)
+			}
+		case entity.RES_TABLE_PACKAGE_TYPE:
+			if err := parseResPackage(chunk, table); err != nil {
+				return nil, fmt.Errorf("package at %d: %v", off, err)
+			}
+		}
+		off += chunkSize
+	}
+	return table, nil
+}
+
+// 读取 off 处的 ResChunk_header，检查块在数据范围内
+func chunkHeader(raw []byte, off uint32) (uint16, uint32, uint32, error) {
+	if uint64(off)+8 > uint64(len(raw)) {
+		return 0, 0, 0, io.ErrUnexpectedEOF
+	}
+	typ := binary.LittleEndian.Uint16(raw[off:])
+	headerSize := uint32(binary.LittleEndian.Uint16(raw[off+2:]))
+	size := binary.LittleEndian.Uint32(raw[off+4:])
+	if headerSize < 8 || size < headerSize || uint64(off)+uint64(size) > uint64(len(raw)) {
+		return 0, 0, 0, fmt.Errorf("invalid chunk 0x%x at %d", typ, off)
+	}
+	return typ, headerSize, size, nil
+}
+
+// 从 off 开始的完整的块
+func subChunk(raw []byte, off uint32) ([]byte, error) {
+	_, _, size, err := chunkHeader(raw, off)
+	if err != nil {
+		return nil, err
+	}
+	return raw[off : off+size], nil
+}
+
+func parseStringPool(chunk []byte) ([]string, error) {
+	_, headerSize, _, err := chunkHeader(chunk, 0)
+	if err != nil {
+		return nil, err
+	}
+	if headerSize < 28 {
+		return nil, fmt.Errorf("invalid string pool header size %d", headerSize)
+	}
+	count := binary.LittleEndian.Uint32(chunk[8:])
+	flags := binary.LittleEndian.Uint32(chunk[16:])
+	stringsStart := binary.LittleEndian.Uint32(chunk[20:])
+	if uint64(headerSize)+4*uint64(count) > uint64(len(chunk)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	utf8 := flags&0x100 != 0
+	result := make([]string, count)
+	for i := range result {
+		off := uint64(stringsStart) + uint64(binary.LittleEndian.Uint32(chunk[headerSize+4*uint32(i):]))
+		if off >= uint64(len(chunk)) {
+			return nil, fmt.Errorf("string %d: offset out of range", i)
+		}
+		if utf8 {
+			result[i], err = decodeUtf8PoolString(chunk[off:])
+		} else {
+			result[i], err = decodeUtf16PoolString(chunk[off:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("string %d: %v", i, err)
+		}
+	}
+	return result, nil
+}
+
+// UTF-8 字符串前面是 UTF-16 长度和字节长度，各占 1 或 2 个字节
+func decodeUtf8PoolString(data []byte) (string, error) {
+	readLen := func(data []byte) (int, int, error) {
+		if len(data) < 1 {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		if data[0]&0x80 == 0 {
+			return int(data[0]), 1, nil
+		}
+		if len(data) < 2 {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		return int(data[0]&0x7f)<<8 | int(data[1]), 2, nil
+	}
+	_, n, err := readLen(data)
+	if err != nil {
+		return "", err
+	}
+	size, m, err := readLen(data[n:])
+	if err != nil {
+		return "", err
+	}
+	start := n + m
+	if start+size > len(data) {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(data[start : start+size]), nil
+}
+
+// UTF-16 字符串前面是字符数，占 2 或 4 个字节
+func decodeUtf16PoolString(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", io.ErrUnexpectedEOF
+	}
+	size := int(binary.LittleEndian.Uint16(data))
+	start := 2
+	if size&0x8000 != 0 {
+		if len(data) < 4 {
+			return "", io.ErrUnexpectedEOF
+		}
+		size = (size&0x7fff)<<16 | int(binary.LittleEndian.Uint16(data[2:]))
+		start = 4
+	}
+	if start+2*size > len(data) {
+		return "", io.ErrUnexpectedEOF
+	}
+	chars := make([]uint16, size)
+	for i := range chars {
+		chars[i] = binary.LittleEndian.Uint16(data[start+2*i:])
+	}
+	return string(utf16.Decode(chars)), nil
+}
+
+func parseResPackage(chunk []byte, table *entity.ResourceTable) error {
+	_, headerSize, size, err := chunkHeader(chunk, 0)
+	if err != nil {
+		return err
+	}
+	if headerSize < 284 {
+		return fmt.Errorf("invalid package header size %d", headerSize)
+	}
+	id := binary.LittleEndian.Uint32(chunk[8:])
+	var name []uint16
+	for i := 0; i < 128; i++ {
+		c := binary.LittleEndian.Uint16(chunk[12+2*i:])
+		if c == 0 {
+			break
+		}
+		name = append(name, c)
+	}
+	table.Packages[id] = string(utf16.Decode(name))
+
+	typePool, err := subChunk(chunk, binary.LittleEndian.Uint32(chunk[268:]))
+	if err != nil {
+		return fmt.Errorf("type strings: %v", err)
+	}
+	typeNames, err := parseStringPool(typePool)
+	if err != nil {
+		return fmt.Errorf("type strings: %v", err)
+	}
+	keyPool, err := subChunk(chunk, binary.LittleEndian.Uint32(chunk[276:]))
+	if err != nil {
+		return fmt.Errorf("key strings: %v", err)
+	}
+	keyNames, err := parseStringPool(keyPool)
+	if err != nil {
+		return fmt.Errorf("key strings: %v", err)
+	}
+	for off := headerSize; off < size; {
+		typ, _, chunkSize, err := chunkHeader(chunk, off)
+		if err != nil {
+			return err
+		}
+		if typ == entity.RES_TABLE_TYPE_TYPE {
+			if err := parseResType(chunk[off:off+chunkSize], id, typeNames, keyNames, table); err != nil {
+				return fmt.Errorf("type chunk at %d: %v", off, err)
+			}
+		}
+		off += chunkSize
+	}
+	return nil
+}
+
+// ResTable_entry 的标志
+const (
+	resEntryComplex = 0x0001
+	resEntryCompact = 0x0008
+)
+
+// ResTable_type 的标志
+const (
+	resTypeSparse   = 0x01
+	resTypeOffset16 = 0x02
+)
+
+func parseResType(chunk []byte, pkg uint32, typeNames []string, keyNames []string, table *entity.ResourceTable) error {
+	_, headerSize, _, err := chunkHeader(chunk, 0)
+	if err != nil {
+		return err
+	}
+	if headerSize < 20 {
+		return fmt.Errorf("invalid type header size %d", headerSize)
+	}
+	typeId := uint32(chunk[8])
+	flags := chunk[9]
+	entryCount := binary.LittleEndian.Uint32(chunk[12:])
+	entriesStart := binary.LittleEndian.Uint32(chunk[16:])
+	if typeId == 0 || int(typeId) > len(typeNames) {
+		return fmt.Errorf("invalid type id %d", typeId)
+	}
+	config := resConfigString(chunk[20:headerSize])
+
+	// 每个条目的序号和相对 entriesStart 的偏移
+	type entryRef struct {
+		index  uint32
+		offset uint32
+	}
+	var refs []entryRef
+	width := uint64(4)
+	if flags&resTypeSparse == 0 && flags&resTypeOffset16 != 0 {
+		width = 2
+	}
+	if uint64(headerSize)+width*uint64(entryCount) > uint64(len(chunk)) {
+		return io.ErrUnexpectedEOF
+	}
+	for i := uint32(0); i < entryCount; i++ {
+		p := chunk[headerSize+uint32(width)*i:]
+		switch {
+		case flags&resTypeSparse != 0:
+			refs = append(refs, entryRef{uint32(binary.LittleEndian.Uint16(p)), 4 * uint32(binary.LittleEndian.Uint16(p[2:]))})
+		case flags&resTypeOffset16 != 0:
+			if off := binary.LittleEndian.Uint16(p); off != 0xffff {
+				refs = append(refs, entryRef{i, 4 * uint32(off)})
+			}
+		default:
+			if off := binary.LittleEndian.Uint32(p); off != entity.NO_INDEX {
+				refs = append(refs, entryRef{i, off})
+			}
+		}
+	}
+
+	for _, ref := range refs {
+		off := uint64(entriesStart) + uint64(ref.offset)
+		if off+8 > uint64(len(chunk)) {
+			return fmt.Errorf("entry %d: offset out of range", ref.index)
+		}
+		e := chunk[off:]
+		size := binary.LittleEndian.Uint16(e)
+		entryFlags := binary.LittleEndian.Uint16(e[2:])
+		key := binary.LittleEndian.Uint32(e[4:])
+		value := entity.ResourceValue{Config: config}
+		switch {
+		case entryFlags&resEntryCompact != 0:
+			// 紧凑格式：key 在 size 的位置，类型在标志的高 8 位
+			key = uint32(size)
+			value.Type = uint8(entryFlags >> 8)
+			value.Data = binary.LittleEndian.Uint32(e[4:])
+		case entryFlags&resEntryComplex != 0:
+			// style、array、plurals 等，只记录名字
+		default:
+			if off+uint64(size)+8 > uint64(len(chunk)) {
+				return fmt.Errorf("entry %d: value out of range", ref.index)
+			}
+			v := e[size:]
+			value.Type = v[3]
+			value.Data = binary.LittleEndian.Uint32(v[4:])
+		}
+		if int(key) >= len(keyNames) {
+			return fmt.Errorf("entry %d: invalid key %d", ref.index, key)
+		}
+		value.Text = resValueText(value.Type, value.Data, table.Strings)
+		id := pkg<<24 | typeId<<16 | ref.index
+		entry := table.Entries[id]
+		if entry == nil {
+			entry = &entity.ResourceEntry{Id: id, Type: typeNames[typeId-1], Name: keyNames[key]}
+			table.Entries[id] = entry
+		}
+		entry.Values = append(entry.Values, value)
+	}
+	return nil
+}
+
+// ResTable_config 转换为 aapt 的限定符，只包含常用的部分
+func resConfigString(c []byte) string {
+	u16 := func(off int) uint16 {
+		if off+2 > len(c) {
+			return 0
+		}
+		return binary.LittleEndian.Uint16(c[off:])
+	}
+	u8 := func(off int) uint8 {
+		if off >= len(c) {
+			return 0
+		}
+		return c[off]
+	}
+	var parts []string
+	if mcc := u16(4); mcc != 0 {
+		parts = append(parts, fmt.Sprintf("mcc%d", mcc))
+	}
+	if mnc := u16(6); mnc != 0 {
+		parts = append(parts, fmt.Sprintf("mnc%d", mnc))
+	}
+	if lang := unpackLocale(u8(8), u8(9), 'a'); lang != "" {
+		if region := unpackLocale(u8(10), u8(11), '0'); region != "" {
+			lang += "-r" + region
+		}
+		parts = append(parts, lang)
+	}
+	if sw := u16(30); sw != 0 {
+		parts = append(parts, fmt.Sprintf("sw%ddp", sw))
+	}
+	if w := u16(32); w != 0 {
+		parts = append(parts, fmt.Sprintf("w%ddp", w))
+	}
+	if h := u16(34); h != 0 {
+		parts = append(parts, fmt.Sprintf("h%ddp", h))
+	}
+	switch u8(12) {
+	case 1:
+		parts = append(parts, "port")
+	case 2:
+		parts = append(parts, "land")
+	}
+	switch u8(29) & 0x30 {
+	case 0x10:
+		parts = append(parts, "notnight")
+	case 0x20:
+		parts = append(parts, "night")
+	}
+	switch density := u16(14); density {
+	case 0:
+	case 120:
+		parts = append(parts, "ldpi")
+	case 160:
+		parts = append(parts, "mdpi")
+	case 213:
+		parts = append(parts, "tvdpi")
+	case 240:
+		parts = append(parts, "hdpi")
+	case 320:
+		parts = append(parts, "xhdpi")
+	case 480:
+		parts = append(parts, "xxhdpi")
+	case 640:
+		parts = append(parts, "xxxhdpi")
+	case 0xfffe:
+		parts = append(parts, "anydpi")
+	case 0xffff:
+		parts = append(parts, "nodpi")
+	default:
+		parts = append(parts, fmt.Sprintf("%ddpi", density))
+	}
+	if sdk := u16(24); sdk != 0 {
+		parts = append(parts, fmt.Sprintf("v%d", sdk))
+	}
+	return strings.Join(parts, "-")
+}
+
+// 语言和地区是两个字符，最高位为 1 时是压缩的三个字符
+func unpackLocale(b0 uint8, b1 uint8, base uint8) string {
+	if b0 == 0 {
+		return ""
+	}
+	if b0&0x80 == 0 {
+		return string([]byte{b0, b1})
+	}
+	return string([]byte{
+		base + b1&0x1f,
+		base + (b1&0xe0)>>5 + (b0&0x03)<<3,
+		base + (b0&0x7c)>>2,
+	})
+}
+
+// 复数类型的值：高 24 位为尾数，radix 决定小数位置，低 4 位为单位
+func complexValue(data uint32) float64 {
+	radix := []float64{1.0 / (1 << 8), 1.0 / (1 << 15), 1.0 / (1 << 23), 1.0 / (1 << 31)}
+	return float64(int32(data&0xffffff00)) * radix[(data>>4)&3]
+}
+
+// 资源值的文本形式
+func resValueText(typ uint8, data uint32, strs []string) string {
+	switch {
+	case typ == entity.RES_VALUE_NULL:
+		return ""
+	case typ == entity.RES_VALUE_REFERENCE:
+		return fmt.Sprintf("@0x%08x", data)
+	case typ == entity.RES_VALUE_ATTRIBUTE:
+		return fmt.Sprintf("?0x%08x", data)
+	case typ == entity.RES_VALUE_STRING:
+		if int(data) < len(strs) {
+			return strs[data]
+		}
+	case typ == entity.RES_VALUE_FLOAT:
+		return strconv.FormatFloat(float64(math.Float32frombits(data)), 'g', -1, 32)
+	case typ == entity.RES_VALUE_DIMENSION:
+		units := []string{"px", "dp", "sp", "pt", "in", "mm"}
+		if unit := data & 0xf; int(unit) < len(units) {
+			return strconv.FormatFloat(complexValue(data), 'g', -1, 32) + units[unit]
+		}
+	case typ == entity.RES_VALUE_FRACTION:
+		unit := "%"
+		if data&0xf == 1 {
+			unit = "%p"
+		}
+		return strconv.FormatFloat(complexValue(data)*100, 'g', -1, 32) + unit
+	case typ == entity.RES_VALUE_INT_DEC:
+		return strconv.Itoa(int(int32(data)))
+	case typ == entity.RES_VALUE_INT_HEX:
+		return fmt.Sprintf("0x%x", data)
+	case typ == entity.RES_VALUE_BOOLEAN:
+		return strconv.FormatBool(data != 0)
+	case typ >= entity.RES_VALUE_COLOR_MIN && typ <= entity.RES_VALUE_COLOR_MAX:
+		return fmt.Sprintf("#%08x", data)
+	}
+	return fmt.Sprintf("0x%08x", data)
+}
+
+// LookupResource 查找资源在 config 配置下的值，没有该配置时使用默认配置，都没有时取第一个
+func LookupResource(table *entity.ResourceTable, id uint32, config string) (entity.ResourceValue, bool) {
+	entry := table.Entries[id]
+	if entry == nil || len(entry.Values) == 0 {
+		return entity.ResourceValue{}, false
+	}
+	var fallback *entity.ResourceValue
+	for i, value := range entry.Values {
+		if value.Config == config {
+			return value, true
+		}
+		if value.Config == "" && fallback == nil {
+			fallback = &entry.Values[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return entry.Values[0], true
+}
@@ -0,0 +1,1887 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AssembleSmaliDir 汇编目录（包括子目录）下的全部 .smali 文件
+func AssembleSmaliDir(dir string) (*entity.DexModel, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), ".smali") {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no smali files in %s", dir)
+	}
+	return AssembleSmaliFiles(files)
+}
+
+// AssembleSmaliFiles 汇编多个 .smali 文件，每个文件对应一个类
+func AssembleSmaliFiles(files []string) (*entity.DexModel, error) {
+	model := &entity.DexModel{}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		class, err := ParseSmali(file, string(src))
+		if err != nil {
+			return nil, err
+		}
+		model.Classes = append(model.Classes, class)
+	}
+	return model, nil
+}
+
+// ParseSmali 解析一个 smali 源文件为类模型，name 仅用于错误信息
+func ParseSmali(name string, src string) (*entity.DexClassModel, error) {
+	p := &smaliParser{file: name, lines: strings.Split(src, "\n")}
+	toks, err := tokenizeSmali(src)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%v", name, err)
+	}
+	p.toks = toks
+	class, err := p.parseClass()
+	if err != nil {
+		return nil, err
+	}
+	return class, nil
+}
+
+const (
+	tokNewline = iota
+	tokWord
+	tokDirective
+	tokLabel
+	tokString
+	tokChar
+	tokPunct
+	tokEOF
+)
+
+type smaliToken struct {
+	kind int
+	text string
+	line int // 从 1 开始
+	col  int // 在行内的字节偏移
+}
+
+func isSmaliSeparator(c byte) bool {
+	switch c {
+	case ' ', '\t', '\r', '\n', ',', '{', '}', '"', '#', '=':
+		return true
+	}
+	return false
+}
+
+func tokenizeSmali(src string) ([]smaliToken, error) {
+	var toks []smaliToken
+	line, lineStart := 1, 0
+	for i := 0; i < len(src); {
+		c := src[i]
+		col := i - lineStart
+		switch {
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '\n':
+			toks = append(toks, smaliToken{kind: tokNewline, line: line, col: col})
+			i++
+			line++
+			lineStart = i
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(src) && src[j] != c {
+				if src[j] == '\\' {
+					j++
+				}
+				if j < len(src) && src[j] == '\n' {
+					break
+				}
+				j++
+			}
+			if j >= len(src) || src[j] != c {
+				return nil, fmt.Errorf("%d: unterminated literal", line)
+			}
+			text, err := unescapeSmali(src[i+1 : j])
+			if err != nil {
+				return nil, fmt.Errorf("%d: %v", line, err)
+			}
+			kind := tokString
+			if c == '\'' {
+				kind = tokChar
+			}
+			toks = append(toks, smaliToken{kind: kind, text: text, line: line, col: col})
+			i = j + 1
+		case c == ',' || c == '{' || c == '}' || c == '=':
+			toks = append(toks, smaliToken{kind: tokPunct, text: string(c), line: line, col: col})
+			i++
+		default:
+			j := i
+			for j < len(src) && !isSmaliSeparator(src[j]) {
+				j++
+			}
+			word := src[i:j]
+			kind := tokWord
+			if c == ':' && len(word) > 1 {
+				kind = tokLabel
+				word = word[1:]
+			} else if c == '.' && word != ".." && len(word) > 1 {
+				kind = tokDirective
+			}
+			toks = append(toks, smaliToken{kind: kind, text: word, line: line, col: col})
+			i = j
+		}
+	}
+	toks = append(toks, smaliToken{kind: tokEOF, line: line})
+	return toks, nil
+}
+
+// 处理字符串中的转义，\u 转义按 UTF-16 处理
+func unescapeSmali(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	units := make([]uint16, 0, len(s))
+	for i := 0; i < len(s); {
+		if s[i] != '\\' {
+			r := []rune(s[i:])[0]
+			units = append(units, stringToUTF16Units(string(r))...)
+			i += len(string(r))
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", fmt.Errorf("invalid escape")
+		}
+		switch s[i+1] {
+		case 'n':
+			units = append(units, '\n')
+		case 't':
+			units = append(units, '\t')
+		case 'r':
+			units = append(units, '\r')
+		case 'b':
+			units = append(units, '\b')
+		case 'f':
+			units = append(units, '\f')
+		case '0':
+			units = append(units, 0)
+		case '"', '\'', '\\':
+			units = append(units, uint16(s[i+1]))
+		case 'u':
+			if i+6 > len(s) {
+				return "", fmt.Errorf("invalid unicode escape")
+			}
+			v, err := strconv.ParseUint(s[i+2:i+6], 16, 16)
+			if err != nil {
+				return "", fmt.Errorf("invalid unicode escape")
+			}
+			units = append(units, uint16(v))
+			i += 6
+			continue
+		default:
+			return "", fmt.Errorf("invalid escape \\%c", s[i+1])
+		}
+		i += 2
+	}
+	return utf16UnitsToString(units), nil
+}
+
+type smaliParser struct {
+	file  string
+	lines []string
+	toks  []smaliToken
+	pos   int
+}
+
+func (p *smaliParser) peek() smaliToken {
+	return p.toks[p.pos]
+}
+
+func (p *smaliParser) next() smaliToken {
+	tok := p.toks[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *smaliParser) errorf(tok smaliToken, format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.file, tok.line, fmt.Sprintf(format, args...))
+}
+
+func (p *smaliParser) skipNewlines() {
+	for p.peek().kind == tokNewline {
+		p.next()
+	}
+}
+
+// 当前行是否结束
+func (p *smaliParser) atLineEnd() bool {
+	kind := p.peek().kind
+	return kind == tokNewline || kind == tokEOF
+}
+
+func (p *smaliParser) expectLineEnd() error {
+	if !p.atLineEnd() {
+		tok := p.peek()
+		return p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return nil
+}
+
+func (p *smaliParser) expectPunct(s string) error {
+	tok := p.next()
+	if tok.kind != tokPunct || tok.text != s {
+		return p.errorf(tok, "expected %q", s)
+	}
+	return nil
+}
+
+func (p *smaliParser) expectWord() (smaliToken, error) {
+	tok := p.next()
+	if tok.kind != tokWord {
+		return tok, p.errorf(tok, "unexpected %q", tok.text)
+	}
+	return tok, nil
+}
+
+// 读取 .end xxx
+func (p *smaliParser) expectEnd(what string) error {
+	tok := p.next()
+	if tok.kind != tokDirective || tok.text != ".end" {
+		return p.errorf(tok, "expected .end %s", what)
+	}
+	name := p.next()
+	if name.text != what {
+		return p.errorf(name, "expected .end %s", what)
+	}
+	return p.expectLineEnd()
+}
+
+func (p *smaliParser) isEnd(what string) bool {
+	tok := p.peek()
+	return tok.kind == tokDirective && tok.text == ".end" && p.toks[p.pos+1].text == what
+}
+
+var smaliAccessFlags = map[string]uint32{
+	"public":                entity.ACC_PUBLIC,
+	"private":               entity.ACC_PRIVATE,
+	"protected":             entity.ACC_PROTECTED,
+	"static":                entity.ACC_STATIC,
+	"final":                 entity.ACC_FINAL,
+	"synchronized":          entity.ACC_SYNCHRONIZED,
+	"volatile":              entity.ACC_VOLATILE,
+	"bridge":                entity.ACC_BRIDGE,
+	"transient":             entity.ACC_TRANSIENT,
+	"varargs":               entity.ACC_VARARGS,
+	"native":                entity.ACC_NATIVE,
+	"interface":             entity.ACC_INTERFACE,
+	"abstract":              entity.ACC_ABSTRACT,
+	"strict":                entity.ACC_STRICT,
+	"synthetic":             entity.ACC_SYNTHETIC,
+	"annotation":            entity.ACC_ANNOTATION,
+	"enum":                  entity.ACC_ENUM,
+	"constructor":           entity.ACC_CONSTRUCTOR,
+	"declared-synchronized": entity.ACC_DECLARED_SYNCHRONIZED,
+}
+
+// 读取访问标志，返回标志和之后的第一个非标志单词
+func (p *smaliParser) parseAccessFlags() (uint32, smaliToken, error) {
+	flags := uint32(0)
+	for {
+		tok, err := p.expectWord()
+		if err != nil {
+			return 0, tok, err
+		}
+		flag, ok := smaliAccessFlags[tok.text]
+		if !ok {
+			return flags, tok, nil
+		}
+		flags |= flag
+	}
+}
+
+func (p *smaliParser) parseClass() (*entity.DexClassModel, error) {
+	class := &entity.DexClassModel{}
+	p.skipNewlines()
+	tok := p.next()
+	if tok.kind != tokDirective || tok.text != ".class" {
+		return nil, p.errorf(tok, "expected .class")
+	}
+	flags, name, err := p.parseAccessFlags()
+	if err != nil {
+		return nil, err
+	}
+	if !isClassDescriptor(name.text) {
+		return nil, p.errorf(name, "invalid class name %q", name.text)
+	}
+	class.AccessFlags = flags
+	class.Name = name.text
+	if err := p.expectLineEnd(); err != nil {
+		return nil, err
+	}
+	for {
+		p.skipNewlines()
+		tok := p.next()
+		if tok.kind == tokEOF {
+			return class, nil
+		}
+		if tok.kind != tokDirective {
+			return nil, p.errorf(tok, "unexpected %q", tok.text)
+		}
+		switch tok.text {
+		case ".super":
+			word, err := p.expectWord()
+			if err != nil {
+				return nil, err
+			}
+			class.SuperClass = word.text
+		case ".implements":
+			word, err := p.expectWord()
+			if err != nil {
+				return nil, err
+			}
+			class.Interfaces = append(class.Interfaces, word.text)
+		case ".source":
+			str := p.next()
+			if str.kind != tokString {
+				return nil, p.errorf(str, "expected string")
+			}
+			class.SourceFile = str.text
+		case ".annotation":
+			annotation, err := p.parseAnnotation()
+			if err != nil {
+				return nil, err
+			}
+			class.Annotations = append(class.Annotations, annotation)
+			continue
+		case ".field":
+			field, err := p.parseField(class.Name)
+			if err != nil {
+				return nil, err
+			}
+			if field.AccessFlags&entity.ACC_STATIC != 0 {
+				class.StaticFields = append(class.StaticFields, field)
+			} else {
+				class.InstanceFields = append(class.InstanceFields, field)
+			}
+			continue
+		case ".method":
+			method, err := p.parseMethod(class.Name)
+			if err != nil {
+				return nil, err
+			}
+			if method.AccessFlags&(entity.ACC_STATIC|entity.ACC_PRIVATE|entity.ACC_CONSTRUCTOR) != 0 {
+				class.DirectMethods = append(class.DirectMethods, method)
+			} else {
+				class.VirtualMethods = append(class.VirtualMethods, method)
+			}
+			continue
+		default:
+			return nil, p.errorf(tok, "unexpected directive %s", tok.text)
+		}
+		if err := p.expectLineEnd(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func isClassDescriptor(s string) bool {
+	return len(s) > 2 && s[0] == 'L' && s[len(s)-1] == ';'
+}
+
+// 解析类型描述符列表，例如 ILjava/lang/String;[J
+func parseTypeList(s string) ([]string, error) {
+	var types []string
+	for i := 0; i < len(s); {
+		start := i
+		for i < len(s) && s[i] == '[' {
+			i++
+		}
+		if i >= len(s) {
+			return nil, fmt.Errorf("invalid type list %q", s)
+		}
+		switch s[i] {
+		case 'L':
+			end := strings.IndexByte(s[i:], ';')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid type list %q", s)
+			}
+			i += end + 1
+		case 'Z', 'B', 'S', 'C', 'I', 'J', 'F', 'D', 'V':
+			i++
+		default:
+			return nil, fmt.Errorf("invalid type %q", s[start:])
+		}
+		types = append(types, s[start:i])
+	}
+	return types, nil
+}
+
+// 解析方法原型，例如 (ILjava/lang/String;)V
+func parseProto(s string) (entity.DexProtoRef, error) {
+	if !strings.HasPrefix(s, "(") {
+		return entity.DexProtoRef{}, fmt.Errorf("invalid prototype %q", s)
+	}
+	end := strings.IndexByte(s, ')')
+	if end < 0 || end == len(s)-1 {
+		return entity.DexProtoRef{}, fmt.Errorf("invalid prototype %q", s)
+	}
+	params, err := parseTypeList(s[1:end])
+	if err != nil {
+		return entity.DexProtoRef{}, err
+	}
+	ret, err := parseTypeList(s[end+1:])
+	if err != nil || len(ret) != 1 {
+		return entity.DexProtoRef{}, fmt.Errorf("invalid prototype %q", s)
+	}
+	return entity.DexProtoRef{ReturnType: ret[0], Params: params}, nil
+}
+
+// ParseMethodSignature 解析 Lcom/a/B;->foo(I)V 形式的方法签名
+func ParseMethodSignature(s string) (*entity.DexMethodRef, error) {
+	arrow := strings.Index(s, "->")
+	paren := strings.IndexByte(s, '(')
+	if arrow <= 0 || paren < arrow {
+		return nil, fmt.Errorf("invalid method %q", s)
+	}
+	proto, err := parseProto(s[paren:])
+	if err != nil {
+		return nil, err
+	}
+	return &entity.DexMethodRef{Class: s[:arrow], Name: s[arrow+2 : paren], Proto: proto}, nil
+}
+
+// ParseFieldSignature 解析 Lcom/a/B;->count:I 形式的字段签名
+func ParseFieldSignature(s string) (*entity.DexFieldRef, error) {
+	arrow := strings.Index(s, "->")
+	if arrow <= 0 {
+		return nil, fmt.Errorf("invalid field %q", s)
+	}
+	colon := strings.IndexByte(s[arrow:], ':')
+	if colon < 0 {
+		return nil, fmt.Errorf("invalid field %q", s)
+	}
+	colon += arrow
+	types, err := parseTypeList(s[colon+1:])
+	if err != nil || len(types) != 1 {
+		return nil, fmt.Errorf("invalid field %q", s)
+	}
+	return &entity.DexFieldRef{Class: s[:arrow], Name: s[arrow+2 : colon], Type: types[0]}, nil
+}
+
+func (p *smaliParser) parseField(className string) (*entity.DexFieldModel, error) {
+	flags, word, err := p.parseAccessFlags()
+	if err != nil {
+		return nil, err
+	}
+	colon := strings.IndexByte(word.text, ':')
+	if colon <= 0 {
+		return nil, p.errorf(word, "invalid field %q", word.text)
+	}
+	field, err := ParseFieldSignature(className + "->" + word.text)
+	if err != nil {
+		return nil, p.errorf(word, "%v", err)
+	}
+	model := &entity.DexFieldModel{Ref: *field, AccessFlags: flags}
+	if tok := p.peek(); tok.kind == tokPunct && tok.text == "=" {
+		p.next()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		value, err = coerceValue(value, field.Type)
+		if err != nil {
+			return nil, p.errorf(tok, "%v", err)
+		}
+		model.StaticValue = &value
+	}
+	if err := p.expectLineEnd(); err != nil {
+		return nil, err
+	}
+	// 有注解时以 .end field 结束
+	save := p.pos
+	p.skipNewlines()
+	for p.peek().kind == tokDirective && p.peek().text == ".annotation" {
+		p.next()
+		annotation, err := p.parseAnnotation()
+		if err != nil {
+			return nil, err
+		}
+		model.Annotations = append(model.Annotations, annotation)
+		p.skipNewlines()
+	}
+	if p.isEnd("field") {
+		return model, p.expectEnd("field")
+	}
+	if len(model.Annotations) > 0 {
+		return nil, p.errorf(p.peek(), "expected .end field")
+	}
+	p.pos = save
+	return model, nil
+}
+
+// 按字段类型调整初始值的类型，例如 long 字段写成 0x1 时转为 VALUE_LONG
+func coerceValue(value entity.EncodedValue, typ string) (entity.EncodedValue, error) {
+	n, isInt := value.Value.(int64)
+	switch typ {
+	case "Z":
+		if value.Type != entity.VALUE_BOOLEAN {
+			return value, fmt.Errorf("boolean value expected")
+		}
+	case "B", "S", "C", "I", "J":
+		if !isInt {
+			return value, fmt.Errorf("integer value expected")
+		}
+		value.Type = map[string]byte{"B": entity.VALUE_BYTE, "S": entity.VALUE_SHORT, "C": entity.VALUE_CHAR, "I": entity.VALUE_INT, "J": entity.VALUE_LONG}[typ]
+		switch typ {
+		case "B":
+			n = int64(int8(n))
+		case "S":
+			n = int64(int16(n))
+		case "C":
+			n = int64(uint16(n))
+		case "I":
+			n = int64(int32(n))
+		}
+		value.Value = n
+	case "F":
+		switch v := value.Value.(type) {
+		case float64:
+			value.Value = float32(v)
+		case int64:
+			value.Value = math.Float32frombits(uint32(v))
+		}
+		value.Type = entity.VALUE_FLOAT
+	case "D":
+		switch v := value.Value.(type) {
+		case float32:
+			value.Value = float64(v)
+		case int64:
+			value.Value = math.Float64frombits(uint64(v))
+		}
+		value.Type = entity.VALUE_DOUBLE
+	}
+	return value, nil
+}
+
+var smaliVisibility = map[string]byte{
+	"build":   entity.VISIBILITY_BUILD,
+	"runtime": entity.VISIBILITY_RUNTIME,
+	"system":  entity.VISIBILITY_SYSTEM,
+}
+
+// 解析 .annotation 之后的内容，直到 .end annotation
+func (p *smaliParser) parseAnnotation() (entity.DexAnnotation, error) {
+	tok, err := p.expectWord()
+	if err != nil {
+		return entity.DexAnnotation{}, err
+	}
+	visibility, ok := smaliVisibility[tok.text]
+	if !ok {
+		return entity.DexAnnotation{}, p.errorf(tok, "invalid visibility %q", tok.text)
+	}
+	typ, err := p.expectWord()
+	if err != nil {
+		return entity.DexAnnotation{}, err
+	}
+	annotation, err := p.parseAnnotationBody(typ.text, "annotation")
+	if err != nil {
+		return entity.DexAnnotation{}, err
+	}
+	return entity.DexAnnotation{Visibility: visibility, Annotation: *annotation}, nil
+}
+
+func (p *smaliParser) parseAnnotationBody(typ string, end string) (*entity.EncodedAnnotation, error) {
+	annotation := &entity.EncodedAnnotation{Type: typ}
+	if err := p.expectLineEnd(); err != nil {
+		return nil, err
+	}
+	for {
+		p.skipNewlines()
+		if p.isEnd(end) {
+			p.next()
+			p.next()
+			return annotation, nil
+		}
+		name, err := p.expectWord()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectPunct("="); err != nil {
+			return nil, err
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		annotation.Elements = append(annotation.Elements, entity.AnnotationElement{Name: name.text, Value: value})
+	}
+}
+
+// 解析 encoded value
+func (p *smaliParser) parseValue() (entity.EncodedValue, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokString:
+		return entity.EncodedValue{Type: entity.VALUE_STRING, Value: tok.text}, nil
+	case tokChar:
+		units := stringToUTF16Units(tok.text)
+		if len(units) != 1 {
+			return entity.EncodedValue{}, p.errorf(tok, "invalid char literal")
+		}
+		return entity.EncodedValue{Type: entity.VALUE_CHAR, Value: int64(units[0])}, nil
+	case tokPunct:
+		if tok.text != "{" {
+			break
+		}
+		var values []entity.EncodedValue
+		for {
+			p.skipNewlines()
+			if next := p.peek(); next.kind == tokPunct && next.text == "}" {
+				p.next()
+				return entity.EncodedValue{Type: entity.VALUE_ARRAY, Value: values}, nil
+			}
+			value, err := p.parseValue()
+			if err != nil {
+				return entity.EncodedValue{}, err
+			}
+			values = append(values, value)
+			p.skipNewlines()
+			if next := p.peek(); next.kind == tokPunct && next.text == "," {
+				p.next()
+			}
+		}
+	case tokDirective:
+		switch tok.text {
+		case ".subannotation":
+			typ, err := p.expectWord()
+			if err != nil {
+				return entity.EncodedValue{}, err
+			}
+			annotation, err := p.parseAnnotationBody(typ.text, "subannotation")
+			if err != nil {
+				return entity.EncodedValue{}, err
+			}
+			return entity.EncodedValue{Type: entity.VALUE_ANNOTATION, Value: annotation}, nil
+		case ".enum", ".field", ".method":
+			word, err := p.expectWord()
+			if err != nil {
+				return entity.EncodedValue{}, err
+			}
+			value, err := parseSmaliLiteral(word.text)
+			if err != nil {
+				return entity.EncodedValue{}, p.errorf(word, "%v", err)
+			}
+			if tok.text == ".enum" {
+				if value.Type != entity.VALUE_FIELD {
+					return entity.EncodedValue{}, p.errorf(word, "field expected")
+				}
+				value.Type = entity.VALUE_ENUM
+			}
+			return value, nil
+		}
+	case tokWord:
+		value, err := parseSmaliLiteral(tok.text)
+		if err != nil {
+			return entity.EncodedValue{}, p.errorf(tok, "%v", err)
+		}
+		return value, nil
+	}
+	return entity.EncodedValue{}, p.errorf(tok, "unexpected %q", tok.text)
+}
+
+var smaliMethodHandleTypes = map[string]uint16{
+	"static-put":         entity.METHOD_HANDLE_STATIC_PUT,
+	"static-get":         entity.METHOD_HANDLE_STATIC_GET,
+	"instance-put":       entity.METHOD_HANDLE_INSTANCE_PUT,
+	"instance-get":       entity.METHOD_HANDLE_INSTANCE_GET,
+	"invoke-static":      entity.METHOD_HANDLE_INVOKE_STATIC,
+	"invoke-instance":    entity.METHOD_HANDLE_INVOKE_INSTANCE,
+	"invoke-constructor": entity.METHOD_HANDLE_INVOKE_CONSTRUCTOR,
+	"invoke-direct":      entity.METHOD_HANDLE_INVOKE_DIRECT,
+	"invoke-interface":   entity.METHOD_HANDLE_INVOKE_INTERFACE,
+}
+
+func parseMethodHandle(s string) (*entity.DexMethodHandle, error) {
+	at := strings.IndexByte(s, '@')
+	if at < 0 {
+		return nil, fmt.Errorf("invalid method handle %q", s)
+	}
+	typ, ok := smaliMethodHandleTypes[s[:at]]
+	if !ok {
+		return nil, fmt.Errorf("invalid method handle type %q", s[:at])
+	}
+	handle := &entity.DexMethodHandle{Type: typ}
+	var err error
+	if typ <= entity.METHOD_HANDLE_INSTANCE_GET {
+		handle.Field, err = ParseFieldSignature(s[at+1:])
+	} else {
+		handle.Method, err = ParseMethodSignature(s[at+1:])
+	}
+	return handle, err
+}
+
+// 解析不带引号的字面量：数字、布尔、null、类型、字段、方法、原型和 method handle
+func parseSmaliLiteral(s string) (entity.EncodedValue, error) {
+	switch s {
+	case "true", "false":
+		return entity.EncodedValue{Type: entity.VALUE_BOOLEAN, Value: s == "true"}, nil
+	case "null":
+		return entity.EncodedValue{Type: entity.VALUE_NULL}, nil
+	}
+	if strings.Contains(s, "@") {
+		handle, err := parseMethodHandle(s)
+		if err != nil {
+			return entity.EncodedValue{}, err
+		}
+		return entity.EncodedValue{Type: entity.VALUE_METHOD_HANDLE, Value: handle}, nil
+	}
+	if strings.Contains(s, "->") {
+		if strings.Contains(s, "(") {
+			method, err := ParseMethodSignature(s)
+			return entity.EncodedValue{Type: entity.VALUE_METHOD, Value: method}, err
+		}
+		field, err := ParseFieldSignature(s)
+		return entity.EncodedValue{Type: entity.VALUE_FIELD, Value: field}, err
+	}
+	if strings.HasPrefix(s, "(") {
+		proto, err := parseProto(s)
+		return entity.EncodedValue{Type: entity.VALUE_METHOD_TYPE, Value: &proto}, err
+	}
+	if s[0] == 'L' || s[0] == '[' || (len(s) == 1 && strings.ContainsRune("ZBSCIJFDV", rune(s[0]))) {
+		types, err := parseTypeList(s)
+		if err != nil || len(types) != 1 {
+			return entity.EncodedValue{}, fmt.Errorf("invalid type %q", s)
+		}
+		return entity.EncodedValue{Type: entity.VALUE_TYPE, Value: s}, nil
+	}
+	return parseSmaliNumber(s)
+}
+
+// 解析数字字面量，后缀 L/t/s/f/d 分别表示 long、byte、short、float、double
+func parseSmaliNumber(s string) (entity.EncodedValue, error) {
+	lower := strings.ToLower(s)
+	body := strings.TrimPrefix(lower, "-")
+	isHex := strings.HasPrefix(body, "0x")
+	if !isHex {
+		switch {
+		case strings.HasSuffix(lower, "f") && !strings.HasSuffix(lower, "inf"):
+			f, err := strconv.ParseFloat(parseFloatText(s[:len(s)-1]), 32)
+			return entity.EncodedValue{Type: entity.VALUE_FLOAT, Value: float32(f)}, err
+		case strings.HasSuffix(lower, "d"):
+			f, err := strconv.ParseFloat(parseFloatText(s[:len(s)-1]), 64)
+			return entity.EncodedValue{Type: entity.VALUE_DOUBLE, Value: f}, err
+		case strings.ContainsAny(lower, ".e") || strings.Contains(lower, "infinity") || strings.Contains(lower, "nan"):
+			f, err := strconv.ParseFloat(parseFloatText(s), 64)
+			return entity.EncodedValue{Type: entity.VALUE_DOUBLE, Value: f}, err
+		}
+	}
+	typ := byte(entity.VALUE_INT)
+	bits := 32
+	switch {
+	case strings.HasSuffix(lower, "l"):
+		typ, bits = entity.VALUE_LONG, 64
+		s = s[:len(s)-1]
+	case strings.HasSuffix(lower, "t"):
+		typ, bits = entity.VALUE_BYTE, 8
+		s = s[:len(s)-1]
+	case strings.HasSuffix(lower, "s"):
+		typ, bits = entity.VALUE_SHORT, 16
+		s = s[:len(s)-1]
+	}
+	n, err := parseSmaliInt(s, bits)
+	if err != nil {
+		return entity.EncodedValue{}, err
+	}
+	return entity.EncodedValue{Type: typ, Value: n}, nil
+}
+
+func parseFloatText(s string) string {
+	switch strings.ToLower(strings.TrimPrefix(s, "-")) {
+	case "infinity":
+		if strings.HasPrefix(s, "-") {
+			return "-Inf"
+		}
+		return "+Inf"
+	case "nan":
+		return "NaN"
+	}
+	return s
+}
+
+// 解析整数，允许按无符号形式书写的负数，例如 0xffffffff 表示 -1
+func parseSmaliInt(s string, bits int) (int64, error) {
+	n, err := strconv.ParseInt(s, 0, 64)
+	if err != nil {
+		u, uerr := strconv.ParseUint(s, 0, 64)
+		if uerr != nil {
+			return 0, fmt.Errorf("invalid number %q", s)
+		}
+		n = int64(u)
+	}
+	if bits < 64 {
+		min := -(int64(1) << uint(bits-1))
+		max := int64(1)<<uint(bits) - 1
+		if n < min || n > max {
+			return 0, fmt.Errorf("number %q out of range", s)
+		}
+		shift := uint(64 - bits)
+		n = n << shift >> shift
+	}
+	return n, nil
+}
+
+// 方法汇编过程中的状态
+type smaliMethodBuilder struct {
+	p          *smaliParser
+	method     *entity.DexMethodModel
+	registers  int
+	ins        int
+	insns      []*entity.Instruction
+	offset     uint32
+	labels     map[string]uint32
+	pending    []string // 还没有遇到下一条指令的标签
+	branches   []smaliFixup
+	payloads   map[*entity.Instruction][]string // payload 中引用的标签
+	catches    []smaliCatch
+	debug      []smaliDebugEntry
+	paramNames []string
+	paramAnnos [][]entity.DexAnnotation
+}
+
+type smaliFixup struct {
+	insn  *entity.Instruction
+	label string
+	tok   smaliToken
+}
+
+type smaliCatch struct {
+	typ                 string // 空串表示 catchall
+	start, end, handler string
+	tok                 smaliToken
+}
+
+type smaliDebugEntry struct {
+	addr  uint32
+	line  int // .line 的行号，其他事件为 -1
+	event entity.DexDebugEvent
+}
+
+func (p *smaliParser) parseMethod(className string) (*entity.DexMethodModel, error) {
+	flags, word, err := p.parseAccessFlags()
+	if err != nil {
+		return nil, err
+	}
+	method, err := ParseMethodSignature(className + "->" + word.text)
+	if err != nil {
+		return nil, p.errorf(word, "%v", err)
+	}
+	if method.Name == "<init>" || method.Name == "<clinit>" {
+		flags |= entity.ACC_CONSTRUCTOR
+	}
+	if err := p.expectLineEnd(); err != nil {
+		return nil, err
+	}
+	b := &smaliMethodBuilder{
+		p:         p,
+		method:    &entity.DexMethodModel{Ref: *method, AccessFlags: flags},
+		registers: -1,
+		labels:    make(map[string]uint32),
+		payloads:  make(map[*entity.Instruction][]string),
+	}
+	if flags&entity.ACC_STATIC == 0 {
+		b.ins = 1
+	}
+	for _, param := range method.Proto.Params {
+		b.ins++
+		if param == "J" || param == "D" {
+			b.ins++
+		}
+	}
+	b.paramNames = make([]string, len(method.Proto.Params))
+	b.paramAnnos = make([][]entity.DexAnnotation, len(method.Proto.Params))
+	for {
+		p.skipNewlines()
+		if p.isEnd("method") {
+			if err := p.expectEnd("method"); err != nil {
+				return nil, err
+			}
+			return b.finish()
+		}
+		if err := b.parseStatement(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (b *smaliMethodBuilder) parseRegister(tok smaliToken) (uint32, error) {
+	if tok.kind != tokWord || len(tok.text) < 2 {
+		return 0, b.p.errorf(tok, "register expected")
+	}
+	n, err := strconv.ParseUint(tok.text[1:], 10, 16)
+	if err != nil {
+		return 0, b.p.errorf(tok, "invalid register %q", tok.text)
+	}
+	switch tok.text[0] {
+	case 'v':
+		return uint32(n), nil
+	case 'p':
+		if b.registers < 0 {
+			return 0, b.p.errorf(tok, "parameter register used before .registers/.locals")
+		}
+		return uint32(b.registers-b.ins) + uint32(n), nil
+	}
+	return 0, b.p.errorf(tok, "invalid register %q", tok.text)
+}
+
+// 参数寄存器对应的参数序号，this 返回 -1
+func (b *smaliMethodBuilder) paramIndex(reg uint32) int {
+	pos := uint32(b.registers - b.ins)
+	if b.method.AccessFlags&entity.ACC_STATIC == 0 {
+		if reg == pos {
+			return -1
+		}
+		pos++
+	}
+	for i, param := range b.method.Ref.Proto.Params {
+		if reg == pos {
+			return i
+		}
+		pos++
+		if param == "J" || param == "D" {
+			pos++
+		}
+	}
+	return -2
+}
+
+// 读取 {v0, v1} 或 {v0 .. v3}
+func (b *smaliMethodBuilder) parseRegisterList() ([]uint32, error) {
+	p := b.p
+	if err := p.expectPunct("{"); err != nil {
+		return nil, err
+	}
+	var regs []uint32
+	for {
+		tok := p.next()
+		if tok.kind == tokPunct && tok.text == "}" {
+			return regs, nil
+		}
+		if tok.kind == tokPunct && tok.text == "," {
+			continue
+		}
+		if tok.kind == tokWord && tok.text == ".." {
+			if len(regs) != 1 {
+				return nil, p.errorf(tok, "invalid register range")
+			}
+			last, err := b.parseRegister(p.next())
+			if err != nil {
+				return nil, err
+			}
+			if last < regs[0] {
+				return nil, p.errorf(tok, "invalid register range")
+			}
+			for r := regs[0] + 1; r <= last; r++ {
+				regs = append(regs, r)
+			}
+			continue
+		}
+		reg, err := b.parseRegister(tok)
+		if err != nil {
+			return nil, err
+		}
+		regs = append(regs, reg)
+	}
+}
+
+func (b *smaliMethodBuilder) parseLabel() (smaliToken, error) {
+	tok := b.p.next()
+	if tok.kind != tokLabel {
+		return tok, b.p.errorf(tok, "label expected")
+	}
+	return tok, nil
+}
+
+// 追加一条指令，之前的标签都指向它
+func (b *smaliMethodBuilder) emit(insn *entity.Instruction) error {
+	if insn.Payload != nil && b.offset%2 != 0 {
+		// payload 需要 4 字节对齐，补一条 nop，标签指向对齐后的位置
+		nop := &entity.Instruction{Opcode: 0x00, Offset: b.offset, Size: 1}
+		b.insns = append(b.insns, nop)
+		b.offset++
+		for _, label := range b.pending {
+			b.labels[label] = b.offset
+		}
+	}
+	units, err := EncodeInstruction(&entity.Instruction{Opcode: insn.Opcode, Regs: insn.Regs, Literal: insn.Literal, Payload: insn.Payload})
+	if err != nil && insn.Payload == nil {
+		// 此时分支和索引还没有确定，只需要长度
+		units = make([]uint16, formatSize(Opcodes[insn.Opcode].Format))
+	} else if err != nil {
+		return err
+	}
+	insn.Offset = b.offset
+	insn.Size = uint32(len(units))
+	b.insns = append(b.insns, insn)
+	b.offset += insn.Size
+	b.pending = nil
+	return nil
+}
+
+func (b *smaliMethodBuilder) defineLabel(tok smaliToken) error {
+	if _, ok := b.labels[tok.text]; ok {
+		return b.p.errorf(tok, "duplicate label :%s", tok.text)
+	}
+	b.labels[tok.text] = b.offset
+	b.pending = append(b.pending, tok.text)
+	return nil
+}
+
+func (b *smaliMethodBuilder) parseStatement() error {
+	p := b.p
+	tok := p.next()
+	switch tok.kind {
+	case tokLabel:
+		if err := b.defineLabel(tok); err != nil {
+			return err
+		}
+		return p.expectLineEnd()
+	case tokWord:
+		return b.parseInstruction(tok)
+	case tokDirective:
+	default:
+		return p.errorf(tok, "unexpected %q", tok.text)
+	}
+	switch tok.text {
+	case ".registers", ".locals":
+		word, err := p.expectWord()
+		if err != nil {
+			return err
+		}
+		n, err := strconv.ParseUint(word.text, 0, 16)
+		if err != nil {
+			return p.errorf(word, "invalid register count")
+		}
+		b.registers = int(n)
+		if tok.text == ".locals" {
+			b.registers += b.ins
+		}
+		if b.registers < b.ins || b.registers > 0xffff {
+			return p.errorf(word, "invalid register count")
+		}
+	case ".annotation":
+		annotation, err := p.parseAnnotation()
+		if err != nil {
+			return err
+		}
+		b.method.Annotations = append(b.method.Annotations, annotation)
+		return nil
+	case ".param", ".parameter":
+		return b.parseParam(tok)
+	case ".line":
+		word, err := p.expectWord()
+		if err != nil {
+			return err
+		}
+		n, err := parseSmaliInt(word.text, 32)
+		if err != nil || n < 0 {
+			return p.errorf(word, "invalid line number")
+		}
+		b.debug = append(b.debug, smaliDebugEntry{addr: b.offset, line: int(n)})
+	case ".local":
+		reg, err := b.parseRegister(p.next())
+		if err != nil {
+			return err
+		}
+		event := entity.DexDebugEvent{Op: entity.DBG_START_LOCAL, Reg: reg}
+		if next := p.peek(); next.kind == tokPunct && next.text == "," {
+			p.next()
+			name := p.next()
+			switch {
+			case name.kind == tokString:
+				event.Name = name.text
+			case name.kind == tokWord && strings.HasPrefix(name.text, "null:"):
+				// null:Ltype; 没有名字
+				p.pos--
+				p.toks[p.pos].kind = tokLabel
+				p.toks[p.pos].text = strings.TrimPrefix(name.text, "null:")
+			case name.kind == tokWord && name.text == "null":
+			default:
+				return p.errorf(name, "local name expected")
+			}
+			typ := p.next()
+			if typ.kind != tokLabel {
+				return p.errorf(typ, "local type expected")
+			}
+			if typ.text != "V" {
+				event.Type = typ.text
+			}
+			if next := p.peek(); next.kind == tokPunct && next.text == "," {
+				p.next()
+				sig := p.next()
+				if sig.kind != tokString {
+					return p.errorf(sig, "signature expected")
+				}
+				event.Op = entity.DBG_START_LOCAL_EXTENDED
+				event.Sig = sig.text
+			}
+		}
+		b.debug = append(b.debug, smaliDebugEntry{addr: b.offset, line: -1, event: event})
+	case ".end", ".restart":
+		what := p.next()
+		if what.text != "local" {
+			return p.errorf(what, "unexpected %s %s", tok.text, what.text)
+		}
+		reg, err := b.parseRegister(p.next())
+		if err != nil {
+			return err
+		}
+		op := byte(entity.DBG_END_LOCAL)
+		if tok.text == ".restart" {
+			op = entity.DBG_RESTART_LOCAL
+		}
+		b.debug = append(b.debug, smaliDebugEntry{addr: b.offset, line: -1, event: entity.DexDebugEvent{Op: op, Reg: reg}})
+	case ".prologue":
+		b.debug = append(b.debug, smaliDebugEntry{addr: b.offset, line: -1, event: entity.DexDebugEvent{Op: entity.DBG_SET_PROLOGUE_END}})
+	case ".epilogue":
+		b.debug = append(b.debug, smaliDebugEntry{addr: b.offset, line: -1, event: entity.DexDebugEvent{Op: entity.DBG_SET_EPILOGUE_BEGIN}})
+	case ".source":
+		event := entity.DexDebugEvent{Op: entity.DBG_SET_FILE}
+		if next := p.peek(); next.kind == tokString {
+			event.Name = p.next().text
+		}
+		b.debug = append(b.debug, smaliDebugEntry{addr: b.offset, line: -1, event: event})
+	case ".catch", ".catchall":
+		c := smaliCatch{tok: tok}
+		if tok.text == ".catch" {
+			typ, err := p.expectWord()
+			if err != nil {
+				return err
+			}
+			c.typ = typ.text
+		}
+		if err := p.expectPunct("{"); err != nil {
+			return err
+		}
+		start, err := b.parseLabel()
+		if err != nil {
+			return err
+		}
+		if dots := p.next(); dots.text != ".." {
+			return p.errorf(dots, "expected ..")
+		}
+		end, err := b.parseLabel()
+		if err != nil {
+			return err
+		}
+		if err := p.expectPunct("}"); err != nil {
+			return err
+		}
+		handler, err := b.parseLabel()
+		if err != nil {
+			return err
+		}
+		c.start, c.end, c.handler = start.text, end.text, handler.text
+		b.catches = append(b.catches, c)
+	case ".packed-switch", ".sparse-switch", ".array-data":
+		return b.parsePayload(tok)
+	default:
+		return p.errorf(tok, "unexpected directive %s", tok.text)
+	}
+	return p.expectLineEnd()
+}
+
+func (b *smaliMethodBuilder) parseParam(tok smaliToken) error {
+	p := b.p
+	reg, err := b.parseRegister(p.next())
+	if err != nil {
+		return err
+	}
+	index := b.paramIndex(reg)
+	if index < 0 {
+		return p.errorf(tok, "register is not a parameter")
+	}
+	if next := p.peek(); next.kind == tokPunct && next.text == "," {
+		p.next()
+		name := p.next()
+		if name.kind != tokString {
+			return p.errorf(name, "parameter name expected")
+		}
+		b.paramNames[index] = name.text
+	}
+	if err := p.expectLineEnd(); err != nil {
+		return err
+	}
+	// 参数注解以 .end param 结束
+	save := p.pos
+	p.skipNewlines()
+	for p.peek().kind == tokDirective && p.peek().text == ".annotation" {
+		p.next()
+		annotation, err := p.parseAnnotation()
+		if err != nil {
+			return err
+		}
+		b.paramAnnos[index] = append(b.paramAnnos[index], annotation)
+		p.skipNewlines()
+	}
+	if p.isEnd("param") || p.isEnd("parameter") {
+		p.next()
+		p.next()
+		return p.expectLineEnd()
+	}
+	if len(b.paramAnnos[index]) > 0 {
+		return p.errorf(p.peek(), "expected .end param")
+	}
+	p.pos = save
+	return nil
+}
+
+func (b *smaliMethodBuilder) parsePayload(tok smaliToken) error {
+	p := b.p
+	payload := &entity.InsnPayload{}
+	var labels []string
+	switch tok.text {
+	case ".packed-switch":
+		payload.Ident = entity.PackedSwitchPayload
+		word, err := p.expectWord()
+		if err != nil {
+			return err
+		}
+		key, err := parseSmaliInt(word.text, 32)
+		if err != nil {
+			return p.errorf(word, "%v", err)
+		}
+		payload.FirstKey = int32(key)
+		if err := p.expectLineEnd(); err != nil {
+			return err
+		}
+		for {
+			p.skipNewlines()
+			if p.isEnd("packed-switch") {
+				break
+			}
+			label, err := b.parseLabel()
+			if err != nil {
+				return err
+			}
+			labels = append(labels, label.text)
+		}
+		payload.Targets = make([]int32, len(labels))
+	case ".sparse-switch":
+		payload.Ident = entity.SparseSwitchPayload
+		if err := p.expectLineEnd(); err != nil {
+			return err
+		}
+		for {
+			p.skipNewlines()
+			if p.isEnd("sparse-switch") {
+				break
+			}
+			word, err := p.expectWord()
+			if err != nil {
+				return err
+			}
+			key, err := parseSmaliInt(word.text, 32)
+			if err != nil {
+				return p.errorf(word, "%v", err)
+			}
+			if arrow := p.next(); arrow.text != "->" {
+				return p.errorf(arrow, "expected ->")
+			}
+			label, err := b.parseLabel()
+			if err != nil {
+				return err
+			}
+			payload.Keys = append(payload.Keys, int32(key))
+			labels = append(labels, label.text)
+		}
+		payload.Targets = make([]int32, len(labels))
+	case ".array-data":
+		payload.Ident = entity.FillArrayDataPayload
+		word, err := p.expectWord()
+		if err != nil {
+			return err
+		}
+		width, err := strconv.ParseUint(word.text, 0, 16)
+		if err != nil || (width != 1 && width != 2 && width != 4 && width != 8) {
+			return p.errorf(word, "invalid element width")
+		}
+		payload.ElementWidth = uint16(width)
+		if err := p.expectLineEnd(); err != nil {
+			return err
+		}
+		for {
+			p.skipNewlines()
+			if p.isEnd("array-data") {
+				break
+			}
+			elem := p.next()
+			bits, err := b.arrayElement(elem, int(width))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < int(width); i++ {
+				payload.Data = append(payload.Data, byte(bits>>(uint(i)*8)))
+			}
+		}
+	}
+	p.next()
+	p.next()
+	insn := &entity.Instruction{Opcode: 0x00, Payload: payload}
+	if err := b.emit(insn); err != nil {
+		return p.errorf(tok, "%v", err)
+	}
+	b.payloads[insn] = labels
+	return p.expectLineEnd()
+}
+
+func (b *smaliMethodBuilder) arrayElement(tok smaliToken, width int) (uint64, error) {
+	var value entity.EncodedValue
+	var err error
+	switch tok.kind {
+	case tokChar:
+		value, err = entity.EncodedValue{Type: entity.VALUE_CHAR, Value: int64(stringToUTF16Units(tok.text)[0])}, nil
+	case tokWord:
+		value, err = parseSmaliNumber(tok.text)
+		if tok.text == "true" || tok.text == "false" {
+			value, err = parseSmaliLiteral(tok.text)
+		}
+	default:
+		return 0, b.p.errorf(tok, "array element expected")
+	}
+	if err != nil {
+		return 0, b.p.errorf(tok, "%v", err)
+	}
+	switch v := value.Value.(type) {
+	case int64:
+		return uint64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case float32:
+		if width == 8 {
+			return math.Float64bits(float64(v)), nil
+		}
+		return uint64(math.Float32bits(v)), nil
+	case float64:
+		if width == 4 {
+			return uint64(math.Float32bits(float32(v))), nil
+		}
+		return math.Float64bits(v), nil
+	}
+	return 0, b.p.errorf(tok, "invalid array element")
+}
+
+var (
+	smaliOpcodes     map[string]uint8
+	smaliOpcodesOnce sync.Once
+)
+
+// 按名称查找操作码，Opcodes 在 init 中填充，所以这里延迟建表
+func lookupOpcode(name string) (uint8, bool) {
+	smaliOpcodesOnce.Do(func() {
+		smaliOpcodes = make(map[string]uint8)
+		for i, info := range Opcodes {
+			if info.Name != "" {
+				smaliOpcodes[info.Name] = uint8(i)
+			}
+		}
+	})
+	op, ok := smaliOpcodes[name]
+	return op, ok
+}
+
+// 解析指令字面量，浮点数转换为对应的位模式
+func (b *smaliMethodBuilder) parseInsnLiteral(tok smaliToken, wide bool) (int64, error) {
+	if tok.kind == tokChar {
+		units := stringToUTF16Units(tok.text)
+		if len(units) != 1 {
+			return 0, b.p.errorf(tok, "invalid char literal")
+		}
+		return int64(units[0]), nil
+	}
+	if tok.kind != tokWord {
+		return 0, b.p.errorf(tok, "literal expected")
+	}
+	value, err := parseSmaliLiteral(tok.text)
+	if err != nil {
+		return 0, b.p.errorf(tok, "%v", err)
+	}
+	switch v := value.Value.(type) {
+	case int64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case float32:
+		if wide {
+			return int64(math.Float64bits(float64(v))), nil
+		}
+		return int64(int32(math.Float32bits(v))), nil
+	case float64:
+		if wide {
+			return int64(math.Float64bits(v)), nil
+		}
+		return int64(int32(math.Float32bits(float32(v)))), nil
+	}
+	return 0, b.p.errorf(tok, "invalid literal %q", tok.text)
+}
+
+func (b *smaliMethodBuilder) expectComma() error {
+	return b.p.expectPunct(",")
+}
+
+func (b *smaliMethodBuilder) parseInstruction(name smaliToken) error {
+	p := b.p
+	op, ok := lookupOpcode(name.text)
+	if !ok {
+		return p.errorf(name, "unknown instruction %q", name.text)
+	}
+	info := Opcodes[op]
+	insn := &entity.Instruction{Opcode: op}
+	var label smaliToken
+	hasLabel := false
+	readRegs := func(n int) error {
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				if err := b.expectComma(); err != nil {
+					return err
+				}
+			}
+			reg, err := b.parseRegister(p.next())
+			if err != nil {
+				return err
+			}
+			insn.Regs = append(insn.Regs, reg)
+		}
+		return nil
+	}
+	readLiteral := func() error {
+		if err := b.expectComma(); err != nil {
+			return err
+		}
+		wide := op == 0x16 || op == 0x17 || op == 0x18 || op == 0x19
+		lit, err := b.parseInsnLiteral(p.next(), wide)
+		insn.Literal = lit
+		return err
+	}
+	readLabel := func(comma bool) error {
+		if comma {
+			if err := b.expectComma(); err != nil {
+				return err
+			}
+		}
+		var err error
+		label, err = b.parseLabel()
+		hasLabel = true
+		return err
+	}
+	var err error
+	switch info.Format {
+	case entity.Format10x:
+	case entity.Format12x, entity.Format22x, entity.Format32x:
+		err = readRegs(2)
+	case entity.Format11x:
+		err = readRegs(1)
+	case entity.Format11n, entity.Format21s, entity.Format31i, entity.Format51l, entity.Format21h:
+		if err = readRegs(1); err == nil {
+			err = readLiteral()
+		}
+	case entity.Format22b, entity.Format22s:
+		if err = readRegs(2); err == nil {
+			err = readLiteral()
+		}
+	case entity.Format23x:
+		err = readRegs(3)
+	case entity.Format10t, entity.Format20t, entity.Format30t:
+		err = readLabel(false)
+	case entity.Format21t, entity.Format31t:
+		if err = readRegs(1); err == nil {
+			err = readLabel(true)
+		}
+	case entity.Format22t:
+		if err = readRegs(2); err == nil {
+			err = readLabel(true)
+		}
+	case entity.Format21c, entity.Format31c:
+		if err = readRegs(1); err == nil {
+			if err = b.expectComma(); err == nil {
+				err = b.parseReference(insn, info.Index)
+			}
+		}
+	case entity.Format22c:
+		if err = readRegs(2); err == nil {
+			if err = b.expectComma(); err == nil {
+				err = b.parseReference(insn, info.Index)
+			}
+		}
+	case entity.Format35c, entity.Format3rc, entity.Format45cc, entity.Format4rcc:
+		if insn.Regs, err = b.parseRegisterList(); err != nil {
+			return err
+		}
+		if err = b.expectComma(); err != nil {
+			return err
+		}
+		if info.Index == entity.IndexCallSite {
+			err = b.parseCallSite(insn)
+			break
+		}
+		if err = b.parseReference(insn, info.Index); err == nil && info.Index == entity.IndexMethodAndProto {
+			if err = b.expectComma(); err == nil {
+				word, werr := p.expectWord()
+				if werr != nil {
+					return werr
+				}
+				proto, perr := parseProto(word.text)
+				if perr != nil {
+					return p.errorf(word, "%v", perr)
+				}
+				insn.Ref2 = &proto
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := p.expectLineEnd(); err != nil {
+		return err
+	}
+	if err := b.emit(insn); err != nil {
+		return p.errorf(name, "%v", err)
+	}
+	if hasLabel {
+		b.branches = append(b.branches, smaliFixup{insn: insn, label: label.text, tok: label})
+	}
+	return nil
+}
+
+func (b *smaliMethodBuilder) parseReference(insn *entity.Instruction, index entity.InsnIndexType) error {
+	p := b.p
+	tok := p.next()
+	if index == entity.IndexString {
+		if tok.kind != tokString {
+			return p.errorf(tok, "string expected")
+		}
+		insn.Ref = tok.text
+		return nil
+	}
+	if tok.kind != tokWord {
+		return p.errorf(tok, "reference expected")
+	}
+	var err error
+	switch index {
+	case entity.IndexType:
+		var types []string
+		if types, err = parseTypeList(tok.text); err == nil && len(types) != 1 {
+			err = fmt.Errorf("invalid type %q", tok.text)
+		}
+		insn.Ref = tok.text
+	case entity.IndexField:
+		insn.Ref, err = ParseFieldSignature(tok.text)
+	case entity.IndexMethod, entity.IndexMethodAndProto:
+		insn.Ref, err = ParseMethodSignature(tok.text)
+	case entity.IndexProto:
+		var proto entity.DexProtoRef
+		proto, err = parseProto(tok.text)
+		insn.Ref = &proto
+	case entity.IndexMethodHandle:
+		insn.Ref, err = parseMethodHandle(tok.text)
+	}
+	if err != nil {
+		return p.errorf(tok, "%v", err)
+	}
+	return nil
+}
+
+// 解析 call_site_0("name", (I)V, args...)@Lcom/a/B;->bootstrap(...)Ljava/lang/invoke/CallSite;
+// 调用点中包含空格和字符串，直接从源码行中截取
+func (b *smaliMethodBuilder) parseCallSite(insn *entity.Instruction) error {
+	p := b.p
+	tok := p.peek()
+	line := p.lines[tok.line-1]
+	text := strings.TrimSpace(stripSmaliComment(line[tok.col:]))
+	// 跳过这一行剩余的 token
+	for !p.atLineEnd() {
+		p.next()
+	}
+	open := strings.IndexByte(text, '(')
+	at := strings.LastIndex(text, ")@")
+	if open < 0 || at < open {
+		return p.errorf(tok, "invalid call site")
+	}
+	bootstrap, err := ParseMethodSignature(text[at+2:])
+	if err != nil {
+		return p.errorf(tok, "%v", err)
+	}
+	site := &entity.DexCallSite{Values: []entity.EncodedValue{{
+		Type:  entity.VALUE_METHOD_HANDLE,
+		Value: &entity.DexMethodHandle{Type: entity.METHOD_HANDLE_INVOKE_STATIC, Method: bootstrap},
+	}}}
+	argToks, err := tokenizeSmali(text[open+1 : at])
+	if err != nil {
+		return p.errorf(tok, "invalid call site")
+	}
+	sub := &smaliParser{file: p.file, toks: argToks}
+	for sub.peek().kind != tokEOF {
+		value, err := sub.parseValue()
+		if err != nil {
+			return p.errorf(tok, "invalid call site argument: %v", err)
+		}
+		site.Values = append(site.Values, value)
+		if next := sub.peek(); next.kind == tokPunct && next.text == "," {
+			sub.next()
+		}
+	}
+	if len(site.Values) < 3 || site.Values[1].Type != entity.VALUE_STRING || site.Values[2].Type != entity.VALUE_METHOD_TYPE {
+		return p.errorf(tok, "call site needs a name and a method type")
+	}
+	insn.Ref = site
+	return nil
+}
+
+func stripSmaliComment(line string) string {
+	inString := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			inString = !inString
+		case '#':
+			if !inString {
+				return line[:i]
+			}
+		}
+	}
+	return line
+}
+
+func (b *smaliMethodBuilder) labelAddr(label string, tok smaliToken) (uint32, error) {
+	addr, ok := b.labels[label]
+	if !ok {
+		return 0, b.p.errorf(tok, "undefined label :%s", label)
+	}
+	return addr, nil
+}
+
+func (b *smaliMethodBuilder) finish() (*entity.DexMethodModel, error) {
+	method := b.method
+	for _, annos := range b.paramAnnos {
+		if len(annos) > 0 {
+			method.ParamAnnotations = b.paramAnnos
+			break
+		}
+	}
+	// abstract 和 native 方法没有代码
+	if len(b.insns) == 0 {
+		return method, nil
+	}
+	if b.registers < 0 {
+		return nil, fmt.Errorf("%s: method %s has no .registers or .locals", b.p.file, method.Ref.Name)
+	}
+	code := &entity.DexCodeModel{RegistersSize: uint16(b.registers), InsSize: uint16(b.ins), Insns: b.insns}
+	byOffset := make(map[uint32]*entity.Instruction)
+	for _, insn := range b.insns {
+		byOffset[insn.Offset] = insn
+		if Opcodes[insn.Opcode].Index == entity.IndexMethod || Opcodes[insn.Opcode].Index == entity.IndexMethodAndProto ||
+			Opcodes[insn.Opcode].Index == entity.IndexCallSite {
+			if uint16(len(insn.Regs)) > code.OutsSize {
+				code.OutsSize = uint16(len(insn.Regs))
+			}
+		}
+	}
+	for _, fix := range b.branches {
+		addr, err := b.labelAddr(fix.label, fix.tok)
+		if err != nil {
+			return nil, err
+		}
+		fix.insn.Branch = int32(addr) - int32(fix.insn.Offset)
+		// switch 的目标相对于 switch 指令本身
+		if fix.insn.Opcode == 0x2b || fix.insn.Opcode == 0x2c {
+			payload, ok := byOffset[addr]
+			if !ok || payload.Payload == nil {
+				return nil, b.p.errorf(fix.tok, "label :%s is not a switch payload", fix.label)
+			}
+			for i, label := range b.payloads[payload] {
+				target, err := b.labelAddr(label, fix.tok)
+				if err != nil {
+					return nil, err
+				}
+				payload.Payload.Targets[i] = int32(target) - int32(fix.insn.Offset)
+			}
+		}
+	}
+	tries, err := b.buildTries()
+	if err != nil {
+		return nil, err
+	}
+	code.Tries = tries
+	code.Debug = b.buildDebugInfo()
+	method.Code = code
+	return method, nil
+}
+
+// 把 .catch 指令转换为互不重叠的 try 块
+func (b *smaliMethodBuilder) buildTries() ([]entity.DexTryModel, error) {
+	if len(b.catches) == 0 {
+		return nil, nil
+	}
+	type rangeCatch struct {
+		start, end, handler uint32
+		typ                 string
+	}
+	var ranges []rangeCatch
+	bounds := make(map[uint32]bool)
+	for _, c := range b.catches {
+		start, err := b.labelAddr(c.start, c.tok)
+		if err != nil {
+			return nil, err
+		}
+		end, err := b.labelAddr(c.end, c.tok)
+		if err != nil {
+			return nil, err
+		}
+		handler, err := b.labelAddr(c.handler, c.tok)
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			return nil, b.p.errorf(c.tok, "invalid try range")
+		}
+		ranges = append(ranges, rangeCatch{start, end, handler, c.typ})
+		bounds[start] = true
+		bounds[end] = true
+	}
+	var points []uint32
+	for point := range bounds {
+		points = append(points, point)
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i] < points[j] })
+	var tries []entity.DexTryModel
+	for i := 0; i+1 < len(points); i++ {
+		start, end := points[i], points[i+1]
+		try := entity.DexTryModel{StartAddr: start, CatchAllAddr: -1}
+		seen := make(map[string]bool)
+		for _, r := range ranges {
+			if r.start > start || r.end < end {
+				continue
+			}
+			if r.typ == "" {
+				if try.CatchAllAddr < 0 {
+					try.CatchAllAddr = int64(r.handler)
+				}
+				continue
+			}
+			if !seen[r.typ] {
+				seen[r.typ] = true
+				try.Handlers = append(try.Handlers, entity.DexCatchModel{Type: r.typ, Addr: r.handler})
+			}
+		}
+		if len(try.Handlers) == 0 && try.CatchAllAddr < 0 {
+			continue
+		}
+		// 与前一个相邻且 handler 相同的块合并
+		if n := len(tries); n > 0 {
+			prev := &tries[n-1]
+			if prev.StartAddr+uint32(prev.InsnCount) == start && sameHandlers(*prev, try) && end-prev.StartAddr <= 0xffff {
+				prev.InsnCount = uint16(end - prev.StartAddr)
+				continue
+			}
+		}
+		if end-start > 0xffff {
+			return nil, fmt.Errorf("%s: try block too large", b.p.file)
+		}
+		try.InsnCount = uint16(end - start)
+		tries = append(tries, try)
+	}
+	return tries, nil
+}
+
+func sameHandlers(a, b entity.DexTryModel) bool {
+	if a.CatchAllAddr != b.CatchAllAddr || len(a.Handlers) != len(b.Handlers) {
+		return false
+	}
+	for i := range a.Handlers {
+		if a.Handlers[i] != b.Handlers[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// 根据 .line/.local 等指令生成 debug_info
+func (b *smaliMethodBuilder) buildDebugInfo() *entity.DexDebugInfo {
+	hasNames := false
+	for _, name := range b.paramNames {
+		if name != "" {
+			hasNames = true
+		}
+	}
+	if len(b.debug) == 0 && !hasNames {
+		return nil
+	}
+	info := &entity.DexDebugInfo{ParamNames: b.paramNames}
+	line := -1
+	for _, entry := range b.debug {
+		if entry.line >= 0 {
+			info.LineStart = uint32(entry.line)
+			line = entry.line
+			break
+		}
+	}
+	if line < 0 {
+		line = 0
+	}
+	addr := uint32(0)
+	for _, entry := range b.debug {
+		addrDiff := entry.addr - addr
+		if entry.line < 0 {
+			if addrDiff > 0 {
+				info.Events = append(info.Events, entity.DexDebugEvent{Op: entity.DBG_ADVANCE_PC, AddrDiff: addrDiff})
+				addr = entry.addr
+			}
+			info.Events = append(info.Events, entry.event)
+			continue
+		}
+		lineDiff := entry.line - line
+		if lineDiff < entity.DBG_LINE_BASE || lineDiff >= entity.DBG_LINE_BASE+entity.DBG_LINE_RANGE {
+			info.Events = append(info.Events, entity.DexDebugEvent{Op: entity.DBG_ADVANCE_LINE, LineDiff: int32(lineDiff)})
+			lineDiff = 0
+		}
+		special := (lineDiff - entity.DBG_LINE_BASE) + int(addrDiff)*entity.DBG_LINE_RANGE + entity.DBG_FIRST_SPECIAL
+		if special > 0xff {
+			info.Events = append(info.Events, entity.DexDebugEvent{Op: entity.DBG_ADVANCE_PC, AddrDiff: addrDiff})
+			special = (lineDiff - entity.DBG_LINE_BASE) + entity.DBG_FIRST_SPECIAL
+		}
+		info.Events = append(info.Events, entity.DexDebugEvent{Op: byte(special)})
+		addr = entry.addr
+		line = entry.line
+	}
+	return info
+}
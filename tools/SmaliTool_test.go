@@ -0,0 +1,318 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"strings"
+	"testing"
+)
+
+// 覆盖分支、循环、try/catch、catchall、packed/sparse-switch 和各种宽度的数组数据
+const testSmali = `.class public LSmaliTest;
+.super Ljava/lang/Object;
+.source "SmaliTest.java"
+
+.method public static classify(I)I
+    .registers 2
+    .param p0, "n"    # I
+    .line 5
+    packed-switch p0, :pswitch_data_0
+    const/4 v0, -0x1
+    return v0
+
+    :pswitch_0
+    const/16 v0, 0xa
+    return v0
+
+    :pswitch_1
+    const/16 v0, 0x14
+    return v0
+
+    :pswitch_2
+    const/16 v0, 0x1e
+    return v0
+
+    :pswitch_data_0
+    .packed-switch 0x0
+        :pswitch_0
+        :pswitch_1
+        :pswitch_2
+    .end packed-switch
+.end method
+
+.method public static sparse(I)I
+    .registers 2
+    sparse-switch p0, :sswitch_data_0
+    const/4 v0, 0x0
+    return v0
+
+    :sswitch_0
+    const/4 v0, 0x1
+    return v0
+
+    :sswitch_1
+    const/4 v0, 0x2
+    return v0
+
+    :sswitch_2
+    const/4 v0, 0x3
+    return v0
+
+    :sswitch_data_0
+    .sparse-switch
+        -0x5 -> :sswitch_0
+        0x64 -> :sswitch_1
+        0x3e8 -> :sswitch_2
+    .end sparse-switch
+.end method
+
+.method public static sumArray()I
+    .registers 5
+    const/4 v0, 0x5
+    new-array v1, v0, [I
+    fill-array-data v1, :array_0
+    const/4 v2, 0x0
+    const/4 v3, 0x0
+
+    :goto_0
+    array-length v0, v1
+    if-ge v2, v0, :cond_0
+    aget v4, v1, v2
+    add-int/2addr v3, v4
+    add-int/lit8 v2, v2, 0x1
+    goto :goto_0
+
+    :cond_0
+    return v3
+
+    :array_0
+    .array-data 4
+        0x1
+        0x2
+        0x3
+        0x4
+        -0x64
+    .end array-data
+.end method
+
+.method public static byteAt(I)I
+    .registers 3
+    const/4 v0, 0x4
+    new-array v0, v0, [B
+    fill-array-data v0, :array_0
+    aget-byte v1, v0, p0
+    return v1
+
+    :array_0
+    .array-data 1
+        0x7ft
+        -0x2t
+        0x0t
+        -0x80t
+    .end array-data
+.end method
+
+.method public static longAt(I)J
+    .registers 4
+    const/4 v0, 0x2
+    new-array v0, v0, [J
+    fill-array-data v0, :array_0
+    aget-wide v1, v0, p0
+    return-wide v1
+
+    :array_0
+    .array-data 8
+        0x7fffffffffffffffL
+        -0x123456789L
+    .end array-data
+.end method
+
+.method public static safeDiv(II)I
+    .registers 3
+    :try_start_0
+    div-int v0, p0, p1
+    :try_end_0
+    .catch Ljava/lang/ArithmeticException; {:try_start_0 .. :try_end_0} :catch_0
+    return v0
+
+    :catch_0
+    move-exception v0
+    const/4 v0, -0x1
+    return v0
+.end method
+
+.method public static guard(I)I
+    .registers 4
+    const/4 v0, 0x1
+    :try_start_0
+    if-nez p0, :cond_0
+    new-instance v1, Ljava/lang/IllegalStateException;
+    const-string v2, "zero"
+    invoke-direct {v1, v2}, Ljava/lang/IllegalStateException;-><init>(Ljava/lang/String;)V
+    throw v1
+
+    :cond_0
+    add-int/lit8 v0, v0, 0x1
+    :try_end_0
+    .catchall {:try_start_0 .. :try_end_0} :catchall_0
+    return v0
+
+    :catchall_0
+    move-exception v1
+    invoke-virtual {v1}, Ljava/lang/Throwable;->getMessage()Ljava/lang/String;
+    move-result-object v1
+    invoke-virtual {v1}, Ljava/lang/String;->length()I
+    move-result v0
+    return v0
+.end method
+`
+
+// 汇编、写出 dex 并重新解析，返回模拟器和读回的模型
+func assembleTestSmali(t *testing.T, src string) (*VM, *ClassPath, *entity.DexModel) {
+	t.Helper()
+	class, err := ParseSmali("SmaliTest.smali", src)
+	if err != nil {
+		t.Fatalf("ParseSmali: %v", err)
+	}
+	data, err := WriteDex(&entity.DexModel{Classes: []*entity.DexClassModel{class}})
+	if err != nil {
+		t.Fatalf("WriteDex: %v", err)
+	}
+	dexs, err := ParseDex(data, "smali.dex")
+	if err != nil {
+		t.Fatalf("ParseDex: %v", err)
+	}
+	if !Verify(dexs[0]) {
+		t.Fatalf("assembled dex does not verify")
+	}
+	model, err := ReadDexModel(dexs[0])
+	if err != nil {
+		t.Fatalf("ReadDexModel: %v", err)
+	}
+	cp, err := NewClassPath(dexs)
+	if err != nil {
+		t.Fatalf("NewClassPath: %v", err)
+	}
+	vm := NewVM(cp)
+	vm.UnknownPolicy = entity.UNKNOWN_CALL_ABORT
+	return vm, cp, model
+}
+
+func invokeTestMethod(t *testing.T, vm *VM, cp *ClassPath, name string, desc string, args ...entity.VMValue) (entity.VMValue, error) {
+	t.Helper()
+	method, err := cp.FindMethod(cp.FindClass("LSmaliTest;"), name, desc)
+	if err != nil {
+		t.Fatalf("%s%s: %v", name, desc, err)
+	}
+	return vm.InvokeMethod(method, args)
+}
+
+func TestSmaliSwitches(t *testing.T) {
+	vm, cp, _ := assembleTestSmali(t, testSmali)
+	cases := []struct {
+		name string
+		arg  int32
+		want int32
+	}{
+		{"classify", 0, 10},
+		{"classify", 1, 20},
+		{"classify", 2, 30},
+		{"classify", 3, -1},
+		{"classify", -1, -1},
+		{"sparse", -5, 1},
+		{"sparse", 100, 2},
+		{"sparse", 1000, 3},
+		{"sparse", 7, 0},
+	}
+	for _, c := range cases {
+		ret, err := invokeTestMethod(t, vm, cp, c.name, "(I)I", IntValue(c.arg))
+		if err != nil {
+			t.Errorf("%s(%d): %v", c.name, c.arg, err)
+		} else if ret.Int() != c.want {
+			t.Errorf("%s(%d) = %d, want %d", c.name, c.arg, ret.Int(), c.want)
+		}
+	}
+}
+
+func TestSmaliArrayData(t *testing.T) {
+	vm, cp, _ := assembleTestSmali(t, testSmali)
+	ret, err := invokeTestMethod(t, vm, cp, "sumArray", "()I")
+	if err != nil || ret.Int() != -90 {
+		t.Errorf("sumArray() = %d, %v, want -90", ret.Int(), err)
+	}
+	for i, want := range []int32{127, -2, 0, -128} {
+		ret, err := invokeTestMethod(t, vm, cp, "byteAt", "(I)I", IntValue(int32(i)))
+		if err != nil || ret.Int() != want {
+			t.Errorf("byteAt(%d) = %d, %v, want %d", i, ret.Int(), err, want)
+		}
+	}
+	if _, err := invokeTestMethod(t, vm, cp, "byteAt", "(I)I", IntValue(4)); err == nil || !strings.Contains(err.Error(), "ArrayIndexOutOfBoundsException") {
+		t.Errorf("byteAt(4) error %v", err)
+	}
+	for i, want := range []int64{0x7fffffffffffffff, -0x123456789} {
+		ret, err := invokeTestMethod(t, vm, cp, "longAt", "(I)J", IntValue(int32(i)))
+		if err != nil || ret.Long() != want {
+			t.Errorf("longAt(%d) = %d, %v, want %d", i, ret.Long(), err, want)
+		}
+	}
+}
+
+func TestSmaliTryCatch(t *testing.T) {
+	vm, cp, _ := assembleTestSmali(t, testSmali)
+	ret, err := invokeTestMethod(t, vm, cp, "safeDiv", "(II)I", IntValue(7), IntValue(2))
+	if err != nil || ret.Int() != 3 {
+		t.Errorf("safeDiv(7, 2) = %d, %v", ret.Int(), err)
+	}
+	ret, err = invokeTestMethod(t, vm, cp, "safeDiv", "(II)I", IntValue(7), IntValue(0))
+	if err != nil || ret.Int() != -1 {
+		t.Errorf("safeDiv(7, 0) = %d, %v", ret.Int(), err)
+	}
+	ret, err = invokeTestMethod(t, vm, cp, "guard", "(I)I", IntValue(0))
+	if err != nil || int(ret.Int()) != len("zero") {
+		t.Errorf("guard(0) = %d, %v", ret.Int(), err)
+	}
+	ret, err = invokeTestMethod(t, vm, cp, "guard", "(I)I", IntValue(5))
+	if err != nil || ret.Int() != 2 {
+		t.Errorf("guard(5) = %d, %v", ret.Int(), err)
+	}
+}
+
+// 从 dex 读回的模型保留了 try 块、payload 和调试信息
+func TestSmaliDexStructure(t *testing.T) {
+	_, _, model := assembleTestSmali(t, testSmali)
+	methods := make(map[string]*entity.DexMethodModel)
+	for _, m := range model.Classes[0].DirectMethods {
+		methods[m.Ref.Name] = m
+	}
+	classify := methods["classify"].Code
+	if classify.Debug == nil || classify.Debug.LineStart != 5 || len(classify.Debug.ParamNames) != 1 || classify.Debug.ParamNames[0] != "n" {
+		t.Errorf("classify debug info %+v", classify.Debug)
+	}
+	var payload *entity.Instruction
+	for _, insn := range classify.Insns {
+		if insn.Payload != nil {
+			payload = insn
+		}
+	}
+	if payload == nil || payload.Offset%2 != 0 || payload.Payload.FirstKey != 0 || len(payload.Payload.Targets) != 3 {
+		t.Errorf("packed-switch payload %+v", payload)
+	}
+	tries := methods["safeDiv"].Code.Tries
+	if len(tries) != 1 || len(tries[0].Handlers) != 1 || tries[0].Handlers[0].Type != "Ljava/lang/ArithmeticException;" || tries[0].CatchAllAddr != -1 {
+		t.Errorf("safeDiv tries %+v", tries)
+	}
+	tries = methods["guard"].Code.Tries
+	if len(tries) != 1 || len(tries[0].Handlers) != 0 || tries[0].CatchAllAddr < 0 {
+		t.Errorf("guard tries %+v", tries)
+	}
+}
+
+func TestSmaliErrors(t *testing.T) {
+	src := strings.Replace(testSmali, "goto :goto_0", "goto :missing", 1)
+	if _, err := ParseSmali("SmaliTest.smali", src); err == nil || !strings.Contains(err.Error(), "SmaliTest.smali:") {
+		t.Errorf("undefined label: %v", err)
+	}
+	src = strings.Replace(testSmali, "aget v4, v1, v2", "aget v4, v1", 1)
+	if _, err := ParseSmali("SmaliTest.smali", src); err == nil {
+		t.Errorf("missing operand accepted")
+	}
+}
@@ -0,0 +1,365 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 单次解密调用的资源限制，解密方法通常很小，超出时认为不是解密方法
+var decryptLimits = entity.VMLimits{
+	MaxInstructions: 1000000,
+	MaxCallDepth:    64,
+	MaxHeapBytes:    16 << 20,
+	MaxArrayLength:  256 << 10,
+}
+
+// 单次解密调用的执行时间
+const decryptTimeout = time.Second
+
+// 一处参数都是常量的静态调用
+type decryptCall struct {
+	caller *entity.ClassMethod
+	offset uint32
+	target *entity.ClassMethod
+	args   []entity.VMValue
+}
+
+// DecryptStrings 查找把常量字符串或数组传给返回 String 的静态方法的调用，
+// 用模拟器单独执行这些方法，返回每个调用位置的解密结果
+func DecryptStrings(cp *ClassPath) ([]entity.DecryptedString, error) {
+	calls, err := findDecryptCalls(cp)
+	if err != nil {
+		return nil, err
+	}
+	vm := NewVM(cp)
+	// 解密方法不应该依赖未知的框架调用
+	vm.UnknownPolicy = entity.UNKNOWN_CALL_ABORT
+	vm.Limits = decryptLimits
+	cache := make(map[string]entity.DecryptedString)
+	var results []entity.DecryptedString
+	for _, call := range calls {
+		result := entity.DecryptedString{
+			Caller: GetMethodSignature(&call.caller.Ref),
+			Dex:    call.caller.Class.Dex.FileName,
+			Offset: call.offset,
+			Method: GetMethodSignature(&call.target.Ref),
+		}
+		for _, arg := range call.args {
+			result.Args = append(result.Args, constantText(arg))
+		}
+		key := result.Method + "(" + strings.Join(result.Args, ",") + ")"
+		if cached, ok := cache[key]; ok {
+			result.Value, result.Error = cached.Value, cached.Error
+		} else {
+			result.Value, result.Error = runDecryptor(vm, call)
+			cache[key] = result
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func runDecryptor(vm *VM, call *decryptCall) (string, string) {
+	// 数组参数可能被解密方法修改，每次调用使用副本
+	args := make([]entity.VMValue, len(call.args))
+	for i, arg := range call.args {
+		if arr, ok := arg.Ref.(*entity.VMArray); ok {
+			arg = RefValue(&entity.VMArray{Type: arr.Type, Data: append([]entity.VMValue{}, arr.Data...)})
+		}
+		args[i] = arg
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), decryptTimeout)
+	defer cancel()
+	vm.Context = ctx
+	ret, err := vm.InvokeMethod(call.target, args)
+	vm.Context = nil
+	if err != nil {
+		return "", err.Error()
+	}
+	if ret.Ref == nil {
+		return "", "returned null"
+	}
+	s, ok := GoString(ret.Ref)
+	if !ok {
+		return "", fmt.Sprintf("returned %s", runtimeType(ret.Ref))
+	}
+	return s, ""
+}
+
+// 常量参数的文本形式，字符串加引号，数组写成类型和十六进制内容
+func constantText(v entity.VMValue) string {
+	if s, ok := GoString(v.Ref); ok {
+		return strconv.Quote(s)
+	}
+	if arr, ok := v.Ref.(*entity.VMArray); ok {
+		if arr.Type == "[B" {
+			return "[B:" + hex.EncodeToString(ByteArrayData(arr))
+		}
+		var items []string
+		for _, item := range arr.Data {
+			items = append(items, strconv.FormatInt(int64(item.Int()), 10))
+		}
+		return arr.Type + ":{" + strings.Join(items, ",") + "}"
+	}
+	return strconv.FormatInt(int64(v.Int()), 10)
+}
+
+func findDecryptCalls(cp *ClassPath) ([]*decryptCall, error) {
+	var calls []*decryptCall
+	for _, class := range cp.Order {
+		methods, err := cp.Methods(class)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", class.Name, err)
+		}
+		for _, method := range methods {
+			if method.CodeOff == 0 {
+				continue
+			}
+			found, err := findDecryptCallsIn(cp, method)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", GetMethodSignature(&method.Ref), err)
+			}
+			calls = append(calls, found...)
+		}
+	}
+	return calls, nil
+}
+
+// 在每个基本块内跟踪寄存器中的常量：字符串、int 和内容已知的基本类型数组
+func findDecryptCallsIn(cp *ClassPath, method *entity.ClassMethod) ([]*decryptCall, error) {
+	dex := method.Class.Dex
+	code, err := ReadCodeItem(dex, method.CodeOff)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := BuildCFG(code)
+	if err != nil {
+		return nil, err
+	}
+	list, err := DecodeInstructions(code.Insns)
+	if err != nil {
+		return nil, err
+	}
+	byOffset := make(map[uint32]*entity.Instruction, len(list))
+	for _, insn := range list {
+		byOffset[insn.Offset] = insn
+	}
+	var calls []*decryptCall
+	states := make(map[int]map[uint32]entity.VMValue)
+	for _, block := range cfg.Blocks {
+		consts := make(map[uint32]entity.VMValue)
+		// 只有一个前驱时继承前驱结束时的常量，例如被 try 块边界分开的指令
+		if len(block.Preds) == 1 && !block.Handler {
+			if pred, ok := states[block.Preds[0]]; ok {
+				consts = copyConstants(pred)
+			}
+		}
+		for _, insn := range block.Insns {
+			if insn.Payload != nil {
+				continue
+			}
+			op := insn.Opcode
+			if op == 0x71 || op == 0x77 { // invoke-static
+				call, err := constantCall(cp, dex, insn, consts)
+				if err != nil {
+					return nil, err
+				}
+				if call != nil {
+					call.caller = method
+					calls = append(calls, call)
+				}
+			}
+			trackConstant(dex, insn, byOffset, consts)
+		}
+		states[block.Id] = consts
+	}
+	return calls, nil
+}
+
+// 复制常量表，数组也复制一份并保持寄存器之间的别名关系
+func copyConstants(consts map[uint32]entity.VMValue) map[uint32]entity.VMValue {
+	copied := make(map[uint32]entity.VMValue, len(consts))
+	arrays := make(map[*entity.VMArray]*entity.VMArray)
+	for reg, v := range consts {
+		if arr, ok := v.Ref.(*entity.VMArray); ok {
+			if arrays[arr] == nil {
+				arrays[arr] = &entity.VMArray{Type: arr.Type, Data: append([]entity.VMValue{}, arr.Data...)}
+			}
+			v = RefValue(arrays[arr])
+		}
+		copied[reg] = v
+	}
+	return copied
+}
+
+// 更新常量表，无法确定的值从表中删除
+func trackConstant(dex *entity.DexFile, insn *entity.Instruction, byOffset map[uint32]*entity.Instruction, consts map[uint32]entity.VMValue) {
+	op := insn.Opcode
+	regs := insn.Regs
+	var value *entity.VMValue
+	switch {
+	case op >= 0x12 && op <= 0x15: // const
+		v := IntValue(int32(insn.Literal))
+		value = &v
+	case op == 0x1a || op == 0x1b:
+		if s, err := GetStringById(dex, insn.Index); err == nil {
+			v := RefValue(NewStringObject(s))
+			value = &v
+		}
+	case op >= 0x07 && op <= 0x09, op >= 0x01 && op <= 0x03: // move, move-object
+		if v, ok := consts[regs[1]]; ok {
+			value = &v
+		}
+	case op == 0x23: // new-array
+		size, ok := consts[regs[1]]
+		typ, err := GetTypeName(dex, insn.Index)
+		if ok && err == nil && size.Kind == entity.VM_INT && size.Int() <= 0x10000 && kindOfType(typ[1:]) == entity.VM_INT {
+			if arr, err := NewArray(typ, size.Int()); err == nil {
+				v := RefValue(arr)
+				value = &v
+			}
+		}
+	case op == 0x26: // fill-array-data
+		if v, ok := consts[regs[0]]; ok {
+			payload := byOffset[uint32(int64(insn.Offset)+int64(insn.Branch))]
+			arr, isArray := v.Ref.(*entity.VMArray)
+			if !isArray || payload == nil || payload.Payload == nil || fillArray(arr, payload.Payload) != nil {
+				delete(consts, regs[0])
+			}
+		}
+		return
+	case op >= 0x4b && op <= 0x51: // aput
+		arr, isArray := consts[regs[1]].Ref.(*entity.VMArray)
+		if !isArray {
+			return
+		}
+		v, ok1 := consts[regs[0]]
+		index, ok2 := consts[regs[2]]
+		if !ok1 || !ok2 || v.Kind != entity.VM_INT || index.Int() < 0 || int(index.Int()) >= len(arr.Data) {
+			// 内容不再确定
+			for reg, other := range consts {
+				if other.Ref == arr {
+					delete(consts, reg)
+				}
+			}
+			return
+		}
+		arr.Data[index.Int()] = truncateValue(arr.Type[1:], v)
+		return
+	case (op >= 0x6e && op <= 0x72) || (op >= 0x74 && op <= 0x78):
+		// 传给其他方法的数组可能被修改
+		for _, reg := range regs {
+			if arr, isArray := consts[reg].Ref.(*entity.VMArray); isArray {
+				for other, v := range consts {
+					if v.Ref == arr {
+						delete(consts, other)
+					}
+				}
+			}
+		}
+		return
+	}
+	for _, reg := range InsnDefs(insn) {
+		delete(consts, reg)
+	}
+	if value != nil {
+		consts[regs[0]] = *value
+	}
+}
+
+// invoke-static 的参数都是常量、至少有一个字符串或数组参数、返回 String 且方法在类路径中有代码时
+// 返回调用，否则返回 nil
+func constantCall(cp *ClassPath, dex *entity.DexFile, insn *entity.Instruction, consts map[uint32]entity.VMValue) (*decryptCall, error) {
+	ref, err := GetMethodRef(dex, insn.Index)
+	if err != nil {
+		return nil, err
+	}
+	if ref.Proto.ReturnType != "Ljava/lang/String;" || len(insn.Regs) != len(ref.Proto.Params) {
+		// 有 long/double 参数时寄存器数与参数个数不同，不处理
+		return nil, nil
+	}
+	var args []entity.VMValue
+	hasData := false
+	for i, reg := range insn.Regs {
+		v, ok := consts[reg]
+		if !ok || kindOfType(ref.Proto.Params[i]) != v.Kind {
+			return nil, nil
+		}
+		if v.Kind == entity.VM_REF {
+			hasData = true
+		}
+		args = append(args, v)
+	}
+	if !hasData {
+		return nil, nil
+	}
+	target, err := cp.ResolveMethod(ref)
+	if err != nil || target.CodeOff == 0 || target.AccessFlags&entity.ACC_STATIC == 0 {
+		return nil, nil
+	}
+	return &decryptCall{offset: insn.Offset, target: target, args: args}, nil
+}
+
+// RewriteDecryptedStrings 把模型中解密成功的调用替换为 const-string/jumbo，返回替换的个数。
+// 替换后的指令用 nop 补齐原来的长度，其他指令的偏移不变；
+// 结果不使用或者寄存器超过 v255 时删除调用或跳过。model 需要读取自 results 所在的 dex
+func RewriteDecryptedStrings(model *entity.DexModel, results []entity.DecryptedString) int {
+	byCall := make(map[string]entity.DecryptedString)
+	for _, result := range results {
+		if result.Error == "" {
+			byCall[result.Caller+"@"+strconv.Itoa(int(result.Offset))] = result
+		}
+	}
+	count := 0
+	for _, class := range model.Classes {
+		for _, methods := range [][]*entity.DexMethodModel{class.DirectMethods, class.VirtualMethods} {
+			for _, method := range methods {
+				if method.Code == nil {
+					continue
+				}
+				caller := GetMethodSignature(&method.Ref)
+				insns := method.Code.Insns
+				for i := 0; i < len(insns); i++ {
+					insn := insns[i]
+					result, ok := byCall[caller+"@"+strconv.Itoa(int(insn.Offset))]
+					if !ok || (insn.Opcode != 0x71 && insn.Opcode != 0x77) {
+						continue
+					}
+					if ref, isMethod := insn.Ref.(*entity.DexMethodRef); !isMethod || GetMethodSignature(ref) != result.Method {
+						continue
+					}
+					var replaced []*entity.Instruction
+					if i+1 < len(insns) && insns[i+1].Opcode == 0x0c { // move-result-object
+						reg := insns[i+1].Regs[0]
+						if reg > 0xff {
+							continue
+						}
+						replaced = append(replaced, &entity.Instruction{Opcode: 0x1b, Offset: insn.Offset, Size: 3, Regs: []uint32{reg}, Ref: result.Value})
+						replaced = append(replaced, nops(insn.Offset+3, insn.Size+insns[i+1].Size-3)...)
+						insns = append(insns[:i], append(replaced, insns[i+2:]...)...)
+					} else {
+						replaced = nops(insn.Offset, insn.Size)
+						insns = append(insns[:i], append(replaced, insns[i+1:]...)...)
+					}
+					i += len(replaced) - 1
+					count++
+				}
+				method.Code.Insns = insns
+			}
+		}
+	}
+	return count
+}
+
+func nops(offset uint32, count uint32) []*entity.Instruction {
+	var insns []*entity.Instruction
+	for i := uint32(0); i < count; i++ {
+		insns = append(insns, &entity.Instruction{Opcode: 0x00, Offset: offset + i, Size: 1})
+	}
+	return insns
+}
@@ -0,0 +1,671 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// DefaultTaintRules 由 DefaultTaintSources 和 DefaultTaintSinks 组成的规则
+func DefaultTaintRules() entity.TaintRules {
+	var rules entity.TaintRules
+	for _, method := range DefaultTaintSources {
+		rules.Sources = append(rules.Sources, entity.TaintRule{Method: method})
+	}
+	for _, method := range DefaultTaintSinks {
+		rules.Sinks = append(rules.Sinks, entity.TaintRule{Method: method})
+	}
+	return rules
+}
+
+// LoadTaintRules 从文件读取静态污点分析的规则，格式见 ParseTaintRules
+func LoadTaintRules(path string) (entity.TaintRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return entity.TaintRules{}, err
+	}
+	defer file.Close()
+	return ParseTaintRules(file)
+}
+
+// ParseTaintRules 解析文本格式的规则，每行一条，# 开头的行和空行忽略：
+//
+//	source Landroid/telephony/TelephonyManager;->getDeviceId
+//	source Landroid/provider/Settings$Secure;->getString 1="android_id"
+//	sink Landroid/util/Log;->d(Ljava/lang/String;Ljava/lang/String;)I
+//
+// 方法签名后面可以要求参数为常量，序号=值，字符串加双引号，整数可以是十进制或 0x 开头
+func ParseTaintRules(r io.Reader) (entity.TaintRules, error) {
+	var rules entity.TaintRules
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		kind, rule, err := parseTaintRule(text)
+		if err != nil {
+			return rules, fmt.Errorf("line %d: %v", line, err)
+		}
+		switch kind {
+		case "source":
+			rules.Sources = append(rules.Sources, rule)
+		case "sink":
+			rules.Sinks = append(rules.Sinks, rule)
+		default:
+			return rules, fmt.Errorf("line %d: unknown rule type %q", line, kind)
+		}
+	}
+	return rules, scanner.Err()
+}
+
+func parseTaintRule(text string) (string, entity.TaintRule, error) {
+	kind, rest := cutField(text)
+	method, rest := cutField(rest)
+	if method == "" {
+		return kind, entity.TaintRule{}, fmt.Errorf("missing method")
+	}
+	rule := entity.TaintRule{Method: method}
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			return kind, rule, fmt.Errorf("invalid argument constraint %q", rest)
+		}
+		index, err := strconv.Atoi(strings.TrimSpace(rest[:eq]))
+		if err != nil || index < 0 {
+			return kind, rule, fmt.Errorf("invalid argument index %q", rest[:eq])
+		}
+		value := strings.TrimSpace(rest[eq+1:])
+		var c entity.ConstValue
+		if strings.HasPrefix(value, "\"") {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return kind, rule, fmt.Errorf("invalid string %s", value)
+			}
+			s, _ := strconv.Unquote(quoted)
+			c = entity.ConstValue{Kind: entity.CONST_STRING, Str: s}
+			rest = strings.TrimSpace(value[len(quoted):])
+		} else {
+			var token string
+			token, rest = cutField(value)
+			n, err := strconv.ParseInt(token, 0, 64)
+			if err != nil {
+				return kind, rule, fmt.Errorf("invalid constant %q", token)
+			}
+			c = entity.ConstValue{Kind: entity.CONST_NUMBER, Value: uint32(n)}
+		}
+		if rule.Args == nil {
+			rule.Args = make(map[int]entity.ConstValue)
+		}
+		rule.Args[index] = c
+	}
+	return kind, rule, nil
+}
+
+// 分出第一个空白分隔的字段
+func cutField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// 方法摘要：参数和方法内数据源的值流向返回值、sink 调用和字段。
+// 参数用占位节点表示，调用时替换为实参的污点
+type flowSummary struct {
+	returns taint
+	sinks   []flowSink
+	fields  map[string]taint
+	keys    map[flowKey]bool
+}
+
+// 到达 sink 调用的值，路径的最后一步为 sink 调用
+type flowSink struct {
+	sink string
+	site entity.TaintStep
+	node *taintNode
+}
+
+type flowKey struct {
+	kind   byte // r 返回值，s sink，f 字段
+	target string
+	origin *taintNode
+}
+
+// 记录一条结果，已经有同一来源的结果时返回 false
+func (s *flowSummary) add(kind byte, target string, n *taintNode) bool {
+	key := flowKey{kind, target, n.origin}
+	if s.keys[key] {
+		return false
+	}
+	s.keys[key] = true
+	return true
+}
+
+type flowAnalysis struct {
+	cp        *ClassPath
+	rules     entity.TaintRules
+	methods   map[string]*entity.ClassMethod
+	summaries map[string]*flowSummary
+	fields    map[string]taint           // 数据源的值写入的字段
+	callers   map[string]map[string]bool // 被调用方法 -> 调用方
+	readers   map[string]map[string]bool // 字段 -> 读取字段的方法
+	roots     map[string]*taintNode      // 数据源调用和参数的占位节点
+	work      []string
+	queued    map[string]bool
+	findings  []entity.TaintFinding
+	found     map[string]bool
+}
+
+// AnalyzeTaintFlows 不执行代码，静态分析数据源方法的返回值到 sink 方法参数的数据流。
+// 方法内按到达定义在寄存器之间传播污点，调用类路径中的方法时使用它的摘要，
+// 摘要变化时重新分析调用方，直到不再变化。字段不区分对象，数组和框架方法的 this
+// 在写入和调用之后带有值的污点，调用按方法引用解析，不考虑子类的重写。
+// 结果按 sink 调用的位置排序，同一个数据源调用到同一个 sink 调用只报告一次
+func AnalyzeTaintFlows(cp *ClassPath, rules entity.TaintRules) ([]entity.TaintFinding, error) {
+	a := &flowAnalysis{
+		cp:        cp,
+		rules:     rules,
+		methods:   make(map[string]*entity.ClassMethod),
+		summaries: make(map[string]*flowSummary),
+		fields:    make(map[string]taint),
+		callers:   make(map[string]map[string]bool),
+		readers:   make(map[string]map[string]bool),
+		roots:     make(map[string]*taintNode),
+		queued:    make(map[string]bool),
+		found:     make(map[string]bool),
+	}
+	for _, class := range cp.Order {
+		methods, err := cp.Methods(class)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", class.Name, err)
+		}
+		for _, method := range methods {
+			if method.CodeOff == 0 {
+				continue
+			}
+			signature := GetMethodSignature(&method.Ref)
+			a.methods[signature] = method
+			a.enqueue(signature)
+		}
+	}
+	for len(a.work) > 0 {
+		signature := a.work[0]
+		a.work = a.work[1:]
+		a.queued[signature] = false
+		if err := a.analyze(signature); err != nil {
+			return nil, fmt.Errorf("%s: %v", signature, err)
+		}
+	}
+	sort.SliceStable(a.findings, func(i, j int) bool {
+		x, y := a.findings[i], a.findings[j]
+		if x.Caller != y.Caller {
+			return x.Caller < y.Caller
+		}
+		return x.Offset < y.Offset
+	})
+	return a.findings, nil
+}
+
+func (a *flowAnalysis) enqueue(signature string) {
+	if !a.queued[signature] {
+		a.queued[signature] = true
+		a.work = append(a.work, signature)
+	}
+}
+
+func (a *flowAnalysis) summary(signature string) *flowSummary {
+	s := a.summaries[signature]
+	if s == nil {
+		s = &flowSummary{fields: make(map[string]taint), keys: make(map[flowKey]bool)}
+		a.summaries[signature] = s
+	}
+	return s
+}
+
+func addEdge(edges map[string]map[string]bool, from string, to string) {
+	if edges[from] == nil {
+		edges[from] = make(map[string]bool)
+	}
+	edges[from][to] = true
+}
+
+// 参数的占位节点
+func (a *flowAnalysis) paramRoot(method string, param int) *taintNode {
+	key := fmt.Sprintf("%s#%d", method, param)
+	n := a.roots[key]
+	if n == nil {
+		n = &taintNode{param: param}
+		n.origin = n
+		a.roots[key] = n
+	}
+	return n
+}
+
+// 数据源调用的节点，重新分析方法时使用同一个节点
+func (a *flowAnalysis) sourceRoot(source string, step entity.TaintStep) *taintNode {
+	key := fmt.Sprintf("%s+%x", step.Method, step.Offset)
+	n := a.roots[key]
+	if n == nil {
+		n = &taintNode{source: source, step: step}
+		n.origin = n
+		a.roots[key] = n
+	}
+	return n
+}
+
+// 数据源的值写入字段，读取该字段的方法需要重新分析
+func (a *flowAnalysis) storeField(field string, n *taintNode) bool {
+	old := a.fields[field]
+	merged := old.union(taint{n})
+	if len(merged) == len(old) {
+		return false
+	}
+	a.fields[field] = merged
+	for reader := range a.readers[field] {
+		a.enqueue(reader)
+	}
+	return true
+}
+
+func (a *flowAnalysis) report(n *taintNode, sink string, site entity.TaintStep) {
+	key := fmt.Sprintf("%s+%x %s+%x", n.origin.step.Method, n.origin.step.Offset, site.Method, site.Offset)
+	if a.found[key] {
+		return
+	}
+	a.found[key] = true
+	path := n.path()
+	if last := path[len(path)-1]; last.Method != site.Method || last.Offset != site.Offset {
+		path = append(path, site)
+	}
+	a.findings = append(a.findings, entity.TaintFinding{
+		Source: n.source,
+		Sink:   sink,
+		Caller: site.Method,
+		Offset: site.Offset,
+		Path:   path,
+	})
+}
+
+// 一个方法的分析状态
+type methodFlow struct {
+	a        *flowAnalysis
+	name     string
+	dex      *entity.DexFile
+	summary  *flowSummary
+	reaching map[uint32]map[uint32][]uint32
+	consts   map[uint32]map[uint32]entity.ConstValue
+	defs     map[defKey]taint
+	entry    map[uint32]taint // 参数寄存器在入口处的值
+	results  map[uint32]taint // invoke 和 filled-new-array 的结果，按指令偏移
+	locals   map[string]taint // 方法内写入字段的值
+	steps    map[uint32]entity.TaintStep
+	changed  bool // 这一轮有新的污点
+	updated  bool // 摘要有变化
+}
+
+// 一个定义：指令偏移和写入的寄存器
+type defKey struct {
+	offset uint32
+	reg    uint32
+}
+
+func (a *flowAnalysis) analyze(signature string) error {
+	method := a.methods[signature]
+	dex := method.Class.Dex
+	code, err := ReadCodeItem(dex, method.CodeOff)
+	if err != nil {
+		return err
+	}
+	cfg, err := BuildCFG(code)
+	if err != nil {
+		return err
+	}
+	m := &methodFlow{
+		a:        a,
+		name:     signature,
+		dex:      dex,
+		summary:  a.summary(signature),
+		reaching: ReachingDefinitions(code, cfg),
+		consts:   PropagateConstants(dex, code, cfg),
+		defs:     make(map[defKey]taint),
+		entry:    make(map[uint32]taint),
+		results:  make(map[uint32]taint),
+		locals:   make(map[string]taint),
+		steps:    make(map[uint32]entity.TaintStep),
+	}
+	base := uint32(code.RegistersSize - code.InsSize)
+	for r := base; r < uint32(code.RegistersSize); r++ {
+		m.entry[r] = taint{a.paramRoot(signature, int(r-base))}
+	}
+	// 基本块按代码顺序排列，move-result 紧跟在 invoke 之后
+	var insns []*entity.Instruction
+	for _, block := range cfg.Blocks {
+		insns = append(insns, block.Insns...)
+	}
+	for {
+		m.changed = false
+		for i, insn := range insns {
+			if m.reaching[insn.Offset] == nil {
+				continue
+			}
+			var prev uint32
+			if i > 0 {
+				prev = insns[i-1].Offset
+			}
+			if err := m.transfer(insn, prev); err != nil {
+				return fmt.Errorf("offset %d: %v", insn.Offset, err)
+			}
+		}
+		if !m.changed {
+			break
+		}
+	}
+	if m.updated {
+		for caller := range a.callers[signature] {
+			a.enqueue(caller)
+		}
+	}
+	return nil
+}
+
+func (m *methodFlow) step(insn *entity.Instruction) entity.TaintStep {
+	step, ok := m.steps[insn.Offset]
+	if !ok {
+		step = entity.TaintStep{Method: m.name, Offset: insn.Offset, Insn: FormatInstruction(m.dex, insn)}
+		m.steps[insn.Offset] = step
+	}
+	return step
+}
+
+// 指令执行之前寄存器的污点，来自所有到达的定义
+func (m *methodFlow) reg(insn *entity.Instruction, r uint32) taint {
+	var result taint
+	for _, def := range m.reaching[insn.Offset][r] {
+		if def == entity.DEF_ENTRY {
+			result = result.union(m.entry[r])
+		} else {
+			result = result.union(m.defs[defKey{def, r}])
+		}
+	}
+	return result
+}
+
+func (m *methodFlow) uses(insn *entity.Instruction, regs []uint32) taint {
+	var result taint
+	for _, r := range regs {
+		result = result.union(m.reg(insn, r))
+	}
+	return result
+}
+
+func (m *methodFlow) merge(dst *taint, value taint) {
+	merged := dst.union(value)
+	if len(merged) != len(*dst) {
+		*dst = merged
+		m.changed = true
+	}
+}
+
+func (m *methodFlow) define(insn *entity.Instruction, value taint) {
+	if len(value) == 0 {
+		return
+	}
+	for _, r := range InsnDefs(insn) {
+		key := defKey{insn.Offset, r}
+		t := m.defs[key]
+		m.merge(&t, value)
+		m.defs[key] = t
+	}
+}
+
+// 对象或数组的内容被修改，寄存器的所有到达定义都带上污点
+func (m *methodFlow) taintObject(insn *entity.Instruction, r uint32, value taint) {
+	if len(value) == 0 {
+		return
+	}
+	for _, def := range m.reaching[insn.Offset][r] {
+		if def == entity.DEF_ENTRY {
+			t := m.entry[r]
+			m.merge(&t, value)
+			m.entry[r] = t
+		} else {
+			key := defKey{def, r}
+			t := m.defs[key]
+			m.merge(&t, value)
+			m.defs[key] = t
+		}
+	}
+}
+
+func (m *methodFlow) transfer(insn *entity.Instruction, prev uint32) error {
+	op := insn.Opcode
+	switch {
+	case (op >= 0x6e && op <= 0x72) || (op >= 0x74 && op <= 0x78) || (op >= 0xfa && op <= 0xfd): // invoke
+		return m.invoke(insn)
+	case op >= 0x0a && op <= 0x0c: // move-result
+		m.define(insn, m.results[prev].extendStep(m.step(insn)))
+	case op == 0x24 || op == 0x25: // filled-new-array
+		t := m.results[insn.Offset]
+		m.merge(&t, m.uses(insn, insn.Regs).extendStep(m.step(insn)))
+		m.results[insn.Offset] = t
+	case op >= 0x44 && op <= 0x4a: // aget
+		m.define(insn, m.reg(insn, insn.Regs[1]).extendStep(m.step(insn)))
+	case op >= 0x4b && op <= 0x51: // aput
+		uses := InsnUses(insn)
+		m.taintObject(insn, insn.Regs[1], m.uses(insn, uses[:len(uses)-2]).extendStep(m.step(insn)))
+	case op >= 0x52 && op <= 0x6d: // iget, iput, sget, sput
+		return m.fieldAccess(insn)
+	case op >= 0x0f && op <= 0x11: // return
+		for _, n := range m.uses(insn, InsnUses(insn)).extendStep(m.step(insn)) {
+			if m.summary.add('r', "", n) {
+				m.summary.returns = m.summary.returns.union(taint{n})
+				m.updated = true
+			}
+		}
+	default:
+		if defs := InsnDefs(insn); defs != nil {
+			m.define(insn, m.uses(insn, InsnUses(insn)).extendStep(m.step(insn)))
+		}
+	}
+	return nil
+}
+
+func (m *methodFlow) fieldKey(index uint32) (string, error) {
+	ref, err := GetFieldRef(m.dex, index)
+	if err != nil {
+		return "", err
+	}
+	if m.a.cp.Classes[ref.Class] != nil {
+		if field, err := m.a.cp.ResolveField(ref); err == nil && field != nil {
+			return GetFieldSignature(&field.Ref), nil
+		}
+	}
+	return GetFieldSignature(ref), nil
+}
+
+func (m *methodFlow) fieldAccess(insn *entity.Instruction) error {
+	field, err := m.fieldKey(insn.Index)
+	if err != nil {
+		return err
+	}
+	op := insn.Opcode
+	if op <= 0x58 || (op >= 0x60 && op <= 0x66) { // iget, sget
+		addEdge(m.a.readers, field, m.name)
+		m.define(insn, m.a.fields[field].union(m.locals[field]).extendStep(m.step(insn)))
+		return nil
+	}
+	uses := InsnUses(insn)
+	if op <= 0x5f { // iput 去掉对象
+		uses = uses[:len(uses)-1]
+	}
+	m.storeField(field, m.uses(insn, uses).extendStep(m.step(insn)))
+	return nil
+}
+
+// 写入字段：数据源的值对所有方法可见，参数的值记录到摘要
+func (m *methodFlow) storeField(field string, value taint) {
+	if len(value) == 0 {
+		return
+	}
+	t := m.locals[field]
+	m.merge(&t, value)
+	m.locals[field] = t
+	for _, n := range value {
+		if n.origin.source != "" {
+			if m.a.storeField(field, n) {
+				m.changed = true
+			}
+		} else if m.summary.add('f', field, n) {
+			m.summary.fields[field] = m.summary.fields[field].union(taint{n})
+			m.updated = true
+		}
+	}
+}
+
+// 值到达 sink 调用：数据源的值报告为泄漏，参数的值记录到摘要
+func (m *methodFlow) reachSink(sink string, site entity.TaintStep, value taint) {
+	for _, n := range value {
+		if n.origin.source != "" {
+			m.a.report(n, sink, site)
+		} else if m.summary.add('s', fmt.Sprintf("%s+%x", site.Method, site.Offset), n) {
+			m.summary.sinks = append(m.summary.sinks, flowSink{sink: sink, site: site, node: n})
+			m.updated = true
+		}
+	}
+}
+
+// 在调用处使用被调用方法摘要中的值：参数占位节点替换为实参的污点，路径接上被调用方法内的部分
+func (m *methodFlow) apply(value taint, args []taint, step entity.TaintStep) taint {
+	var result taint
+	for _, n := range value {
+		if n.origin.source != "" {
+			result = result.union(taint{&taintNode{source: n.source, step: step, prev: n, origin: n.origin}})
+			continue
+		}
+		if n.origin.param >= len(args) {
+			continue
+		}
+		path := n.path()
+		for _, arg := range args[n.origin.param].extendStep(step) {
+			for _, s := range path {
+				arg = &taintNode{source: arg.source, param: arg.param, step: s, prev: arg, origin: arg.origin}
+			}
+			result = result.union(taint{arg})
+		}
+	}
+	return result
+}
+
+func (m *methodFlow) invoke(insn *entity.Instruction) error {
+	step := m.step(insn)
+	args := make([]taint, len(insn.Regs))
+	var all taint
+	for i, r := range insn.Regs {
+		args[i] = m.reg(insn, r)
+		all = all.union(args[i])
+	}
+	index := Opcodes[insn.Opcode].Index
+	if index != entity.IndexMethod && index != entity.IndexMethodAndProto {
+		// invoke-custom 没有静态目标
+		m.setResult(insn, all.extendStep(step))
+		return nil
+	}
+	ref, err := GetMethodRef(m.dex, insn.Index)
+	if err != nil {
+		return err
+	}
+	callee := GetMethodSignature(ref)
+	static := insn.Opcode == 0x71 || insn.Opcode == 0x77
+	if len(all) > 0 && m.matchRule(m.a.rules.Sinks, callee, insn, ref, static) {
+		m.reachSink(callee, step, all.extendStep(step))
+	}
+	var result taint
+	if target, err := m.a.cp.ResolveMethod(ref); err == nil && target != nil && target.CodeOff != 0 {
+		name := GetMethodSignature(&target.Ref)
+		addEdge(m.a.callers, name, m.name)
+		if s := m.a.summaries[name]; s != nil {
+			result = m.apply(s.returns, args, step)
+			for _, sink := range s.sinks {
+				m.reachSink(sink.sink, sink.site, m.apply(taint{sink.node}, args, step))
+			}
+			for field, value := range s.fields {
+				m.storeField(field, m.apply(value, args, step))
+			}
+		}
+	} else {
+		// 框架方法：返回值带有全部参数的污点，this 带有其他参数的污点
+		result = all.extendStep(step)
+		if !static && len(args) > 1 {
+			var others taint
+			for _, t := range args[1:] {
+				others = others.union(t)
+			}
+			m.taintObject(insn, insn.Regs[0], others.extendStep(step))
+		}
+	}
+	if m.matchRule(m.a.rules.Sources, callee, insn, ref, static) {
+		result = result.union(taint{m.a.sourceRoot(callee, step)})
+	}
+	m.setResult(insn, result)
+	return nil
+}
+
+func (m *methodFlow) setResult(insn *entity.Instruction, value taint) {
+	t := m.results[insn.Offset]
+	m.merge(&t, value)
+	m.results[insn.Offset] = t
+}
+
+// 方法签名和常量参数都满足时规则匹配
+func (m *methodFlow) matchRule(rules []entity.TaintRule, callee string, insn *entity.Instruction, ref *entity.DexMethodRef, static bool) bool {
+	for _, rule := range rules {
+		if !matchMethod(rule.Method, callee) {
+			continue
+		}
+		regs := argRegisters(insn.Regs, ref.Proto, static)
+		consts := m.consts[insn.Offset]
+		matched := true
+		for i, want := range rule.Args {
+			if i >= len(regs) || consts[regs[i]] != want {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// 每个参数的第一个寄存器，实例方法的 this 在最前面
+func argRegisters(regs []uint32, proto entity.DexProtoRef, static bool) []uint32 {
+	var result []uint32
+	i := 0
+	if !static && len(regs) > 0 {
+		result = append(result, regs[0])
+		i = 1
+	}
+	for _, param := range proto.Params {
+		if i >= len(regs) {
+			break
+		}
+		result = append(result, regs[i])
+		i++
+		if param == "J" || param == "D" {
+			i++
+		}
+	}
+	return result
+}
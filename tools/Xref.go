@@ -0,0 +1,166 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"fmt"
+	"strings"
+)
+
+// XrefIndex 全部 dex 中代码的交叉引用数据库
+type XrefIndex struct {
+	Entries  []entity.XrefEntry
+	byTarget map[string][]int
+}
+
+func xrefKey(kind string, target string) string {
+	return kind + "\x00" + target
+}
+
+// BuildXrefIndex 遍历类路径中全部方法的代码，记录方法调用、字段读写、字符串和类型的使用
+func BuildXrefIndex(cp *ClassPath) (*XrefIndex, error) {
+	x := &XrefIndex{byTarget: make(map[string][]int)}
+	for _, class := range cp.Order {
+		methods, err := cp.Methods(class)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", class.Name, err)
+		}
+		for _, method := range methods {
+			if method.CodeOff == 0 {
+				continue
+			}
+			if err := x.indexMethod(method); err != nil {
+				return nil, fmt.Errorf("%s: %v", GetMethodSignature(&method.Ref), err)
+			}
+		}
+	}
+	return x, nil
+}
+
+func (x *XrefIndex) indexMethod(method *entity.ClassMethod) error {
+	dex := method.Class.Dex
+	code, err := ReadCodeItem(dex, method.CodeOff)
+	if err != nil {
+		return err
+	}
+	insns, err := DecodeInstructions(code.Insns)
+	if err != nil {
+		return err
+	}
+	caller := GetMethodSignature(&method.Ref)
+	for _, insn := range insns {
+		if insn.Payload != nil {
+			continue
+		}
+		kind, target, err := xrefTarget(dex, insn)
+		if err != nil {
+			return fmt.Errorf("offset %d: %v", insn.Offset, err)
+		}
+		if kind == "" {
+			continue
+		}
+		x.add(entity.XrefEntry{
+			Kind:   kind,
+			Target: target,
+			Caller: caller,
+			Dex:    dex.FileName,
+			Offset: insn.Offset,
+			Opcode: OpcodeName(insn),
+		})
+	}
+	return nil
+}
+
+// 指令引用的对象，不产生引用的指令返回空的 kind
+func xrefTarget(dex *entity.DexFile, insn *entity.Instruction) (string, string, error) {
+	switch Opcodes[insn.Opcode].Index {
+	case entity.IndexMethod, entity.IndexMethodAndProto:
+		ref, err := GetMethodRef(dex, insn.Index)
+		if err != nil {
+			return "", "", err
+		}
+		return entity.XREF_INVOKE, GetMethodSignature(ref), nil
+	case entity.IndexField:
+		ref, err := GetFieldRef(dex, insn.Index)
+		if err != nil {
+			return "", "", err
+		}
+		kind := entity.XREF_FIELD_READ
+		// iput* 0x59-0x5f，sput* 0x67-0x6d
+		if (insn.Opcode >= 0x59 && insn.Opcode <= 0x5f) || (insn.Opcode >= 0x67 && insn.Opcode <= 0x6d) {
+			kind = entity.XREF_FIELD_WRITE
+		}
+		return kind, GetFieldSignature(ref), nil
+	case entity.IndexString:
+		str, err := GetStringById(dex, insn.Index)
+		return entity.XREF_STRING, str, err
+	case entity.IndexType:
+		typ, err := GetTypeName(dex, insn.Index)
+		return entity.XREF_TYPE, typ, err
+	}
+	return "", "", nil
+}
+
+func (x *XrefIndex) add(entry entity.XrefEntry) {
+	key := xrefKey(entry.Kind, entry.Target)
+	x.byTarget[key] = append(x.byTarget[key], len(x.Entries))
+	x.Entries = append(x.Entries, entry)
+}
+
+// Find 返回指定类型和目标的全部引用
+func (x *XrefIndex) Find(kind string, target string) []entity.XrefEntry {
+	var entries []entity.XrefEntry
+	for _, i := range x.byTarget[xrefKey(kind, target)] {
+		entries = append(entries, x.Entries[i])
+	}
+	return entries
+}
+
+// Callers 返回调用方法的位置，method 为 Lcom/a/B;->foo(I)V 形式的签名
+func (x *XrefIndex) Callers(method string) []entity.XrefEntry {
+	return x.Find(entity.XREF_INVOKE, method)
+}
+
+// FieldReaders 返回读取字段的位置，field 为 Lcom/a/B;->count:I 形式的签名
+func (x *XrefIndex) FieldReaders(field string) []entity.XrefEntry {
+	return x.Find(entity.XREF_FIELD_READ, field)
+}
+
+// FieldWriters 返回写入字段的位置
+func (x *XrefIndex) FieldWriters(field string) []entity.XrefEntry {
+	return x.Find(entity.XREF_FIELD_WRITE, field)
+}
+
+// StringUses 返回使用字符串常量的位置
+func (x *XrefIndex) StringUses(str string) []entity.XrefEntry {
+	return x.Find(entity.XREF_STRING, str)
+}
+
+// TypeUses 返回使用类型的位置
+func (x *XrefIndex) TypeUses(typ string) []entity.XrefEntry {
+	return x.Find(entity.XREF_TYPE, typ)
+}
+
+// SearchStrings 返回内容包含 substr 的字符串常量的使用位置
+func (x *XrefIndex) SearchStrings(substr string) []entity.XrefEntry {
+	var entries []entity.XrefEntry
+	for _, entry := range x.Entries {
+		if entry.Kind == entity.XREF_STRING && strings.Contains(entry.Target, substr) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Query 根据查询的形式自动选择：方法签名查调用者，字段签名查读写，类型描述符查类型使用，
+// 其他内容按子串搜索字符串常量
+func (x *XrefIndex) Query(query string) []entity.XrefEntry {
+	switch {
+	case strings.Contains(query, "->") && strings.Contains(query, "("):
+		return x.Callers(query)
+	case strings.Contains(query, "->") && strings.Contains(query, ":"):
+		return append(x.FieldReaders(query), x.FieldWriters(query)...)
+	case isClassDescriptor(query) || strings.HasPrefix(query, "["):
+		return x.TypeUses(query)
+	}
+	return x.SearchStrings(query)
+}
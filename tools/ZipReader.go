@@ -0,0 +1,363 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	zipLocalSignature        = 0x04034b50
+	zipCentralSignature      = 0x02014b50
+	zipEndSignature          = 0x06054b50
+	zipEnd64LocatorSignature = 0x07064b50
+	zipEnd64Signature        = 0x06064b50
+
+	zipLocalHeaderLen   = 30
+	zipCentralHeaderLen = 46
+	zipEndLen           = 22
+	zipEnd64LocatorLen  = 20
+	zipEnd64Len         = 56
+
+	zipMethodStore   = 0
+	zipMethodDeflate = 8
+)
+
+var (
+	ErrZipFormat   = errors.New("zip: not a valid zip file")
+	ErrZipChecksum = errors.New("zip: checksum error")
+)
+
+// OpenZip 按 Android 的规则读取压缩包，返回的 ZipArchive 直接读取文件，用完后需要关闭 file
+func OpenZip(file *os.File) (*entity.ZipArchive, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return ReadZip(file, info.Size())
+}
+
+// ReadZip 按 Android 安装器的规则解析压缩包：中央目录为准，忽略加密标志，
+// 未知的压缩方式按不压缩读取，本地文件头损坏、文件名不一致、数据重叠都不报错，
+// 只记录在 Issues 中。找不到中央目录时返回错误
+func ReadZip(r io.ReaderAt, size int64) (*entity.ZipArchive, error) {
+	z := &entity.ZipArchive{Reader: r, Size: size}
+	issue := func(kind string, name string, format string, args ...interface{}) {
+		z.Issues = append(z.Issues, entity.ArchiveIssue{Kind: kind, Entry: name, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	endPos, end, err := findZipEnd(r, size)
+	if err != nil {
+		return nil, err
+	}
+	count := uint64(binary.LittleEndian.Uint16(end[10:]))
+	dirSize := uint64(binary.LittleEndian.Uint32(end[12:]))
+	dirOffset := uint64(binary.LittleEndian.Uint32(end[16:]))
+	if commentLen := int64(binary.LittleEndian.Uint16(end[20:])); endPos+zipEndLen+commentLen != size {
+		issue(entity.ARCHIVE_END_RECORD, "", "comment length %d, %d bytes follow the record", commentLen, size-endPos-zipEndLen)
+	}
+	dirEnd := endPos
+	if count == 0xffff || dirSize == 0xffffffff || dirOffset == 0xffffffff {
+		if pos, c, s, o, ok := readZip64End(r, endPos); ok {
+			count, dirSize, dirOffset, dirEnd = c, s, o, pos
+		}
+	}
+	if dirOffset > uint64(dirEnd) || dirSize > uint64(dirEnd)-dirOffset {
+		return nil, fmt.Errorf("%w: central directory at %d size %d outside the file", ErrZipFormat, dirOffset, dirSize)
+	}
+	z.DirOffset, z.EndOffset = int64(dirOffset), endPos
+	dir := make([]byte, dirSize)
+	if _, err := r.ReadAt(dir, int64(dirOffset)); err != nil {
+		return nil, err
+	}
+
+	for pos := 0; pos+zipCentralHeaderLen <= len(dir); {
+		h := dir[pos:]
+		if binary.LittleEndian.Uint32(h) != zipCentralSignature {
+			break
+		}
+		nameLen := int(binary.LittleEndian.Uint16(h[28:]))
+		extraLen := int(binary.LittleEndian.Uint16(h[30:]))
+		commentLen := int(binary.LittleEndian.Uint16(h[32:]))
+		next := pos + zipCentralHeaderLen + nameLen + extraLen + commentLen
+		if next > len(dir) {
+			issue(entity.ARCHIVE_END_RECORD, "", "central directory entry at %d truncated", int(dirOffset)+pos)
+			break
+		}
+		e := &entity.ZipEntry{
+			Name:             string(h[zipCentralHeaderLen : zipCentralHeaderLen+nameLen]),
+			Flags:            binary.LittleEndian.Uint16(h[8:]),
+			Method:           binary.LittleEndian.Uint16(h[10:]),
+			CRC32:            binary.LittleEndian.Uint32(h[16:]),
+			CompressedSize:   uint64(binary.LittleEndian.Uint32(h[20:])),
+			UncompressedSize: uint64(binary.LittleEndian.Uint32(h[24:])),
+			Time:             binary.LittleEndian.Uint32(h[12:]),
+			Creator:          binary.LittleEndian.Uint16(h[4:]),
+			External:         binary.LittleEndian.Uint32(h[38:]),
+			HeaderOffset:     int64(binary.LittleEndian.Uint32(h[42:])),
+		}
+		e.Mode = zipEntryMode(e.Name, e.Creator, e.External)
+		readZip64Extra(e, h[zipCentralHeaderLen+nameLen:zipCentralHeaderLen+nameLen+extraLen])
+		readZipLocalHeader(r, size, e, nameLen+extraLen, issue)
+
+		if e.Flags&0x1 != 0 {
+			issue(entity.ARCHIVE_ENCRYPTED, e.Name, "encryption flag ignored")
+		}
+		if e.Method != zipMethodStore && e.Method != zipMethodDeflate {
+			issue(entity.ARCHIVE_UNKNOWN_METHOD, e.Name, "method %d read as stored", e.Method)
+		}
+		if e.Method != zipMethodDeflate && e.CompressedSize != e.UncompressedSize {
+			issue(entity.ARCHIVE_SIZE_MISMATCH, e.Name, "stored with compressed size %d, uncompressed size %d", e.CompressedSize, e.UncompressedSize)
+		}
+		z.Entries = append(z.Entries, e)
+		pos = next
+	}
+	if uint64(len(z.Entries)) != count {
+		issue(entity.ARCHIVE_END_RECORD, "", "%d entries recorded, %d in the central directory", count, len(z.Entries))
+	}
+	checkZipOverlap(z, int64(dirOffset), issue)
+	return z, nil
+}
+
+// 从文件末尾向前查找中央目录结束记录，返回位置和记录
+func findZipEnd(r io.ReaderAt, size int64) (int64, []byte, error) {
+	tailLen := int64(zipEndLen + 0xffff)
+	if tailLen > size {
+		tailLen = size
+	}
+	tail := make([]byte, tailLen)
+	if _, err := r.ReadAt(tail, size-tailLen); err != nil && err != io.EOF {
+		return 0, nil, err
+	}
+	for i := len(tail) - zipEndLen; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) == zipEndSignature {
+			return size - tailLen + int64(i), tail[i : i+zipEndLen], nil
+		}
+	}
+	return 0, nil, fmt.Errorf("%w: end of central directory not found", ErrZipFormat)
+}
+
+// 读取 zip64 中央目录结束记录，返回记录的位置、文件数、中央目录的大小和位置
+func readZip64End(r io.ReaderAt, endPos int64) (int64, uint64, uint64, uint64, bool) {
+	if endPos < zipEnd64LocatorLen {
+		return 0, 0, 0, 0, false
+	}
+	locator := make([]byte, zipEnd64LocatorLen)
+	if _, err := r.ReadAt(locator, endPos-zipEnd64LocatorLen); err != nil {
+		return 0, 0, 0, 0, false
+	}
+	if binary.LittleEndian.Uint32(locator) != zipEnd64LocatorSignature {
+		return 0, 0, 0, 0, false
+	}
+	pos := binary.LittleEndian.Uint64(locator[8:])
+	if pos > uint64(endPos-zipEnd64LocatorLen) {
+		return 0, 0, 0, 0, false
+	}
+	end := make([]byte, zipEnd64Len)
+	if _, err := r.ReadAt(end, int64(pos)); err != nil {
+		return 0, 0, 0, 0, false
+	}
+	if binary.LittleEndian.Uint32(end) != zipEnd64Signature {
+		return 0, 0, 0, 0, false
+	}
+	return int64(pos), binary.LittleEndian.Uint64(end[32:]), binary.LittleEndian.Uint64(end[40:]), binary.LittleEndian.Uint64(end[48:]), true
+}
+
+// 用 zip64 扩展字段替换中央目录中为 0xffffffff 的大小和位置
+func readZip64Extra(e *entity.ZipEntry, extra []byte) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		n := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+n > len(extra) {
+			return
+		}
+		field := extra[4 : 4+n]
+		extra = extra[4+n:]
+		if id != 0x0001 {
+			continue
+		}
+		next := func(v *uint64) {
+			if *v == 0xffffffff && len(field) >= 8 {
+				*v = binary.LittleEndian.Uint64(field)
+				field = field[8:]
+			}
+		}
+		next(&e.UncompressedSize)
+		next(&e.CompressedSize)
+		offset := uint64(e.HeaderOffset)
+		next(&offset)
+		e.HeaderOffset = int64(offset)
+		return
+	}
+}
+
+// 读取本地文件头计算数据的位置。本地文件头损坏时按中央目录中文件名和扩展字段的长度推算
+func readZipLocalHeader(r io.ReaderAt, size int64, e *entity.ZipEntry, centralLen int, issue func(string, string, string, ...interface{})) {
+	e.DataOffset = e.HeaderOffset + zipLocalHeaderLen + int64(centralLen)
+	h := make([]byte, zipLocalHeaderLen)
+	if e.HeaderOffset < 0 || e.HeaderOffset+zipLocalHeaderLen > size {
+		issue(entity.ARCHIVE_LOCAL_HEADER, e.Name, "offset %d outside the file", e.HeaderOffset)
+		return
+	}
+	if _, err := r.ReadAt(h, e.HeaderOffset); err != nil {
+		issue(entity.ARCHIVE_LOCAL_HEADER, e.Name, "%v", err)
+		return
+	}
+	if binary.LittleEndian.Uint32(h) != zipLocalSignature {
+		issue(entity.ARCHIVE_LOCAL_HEADER, e.Name, "bad signature %08x at %d", binary.LittleEndian.Uint32(h), e.HeaderOffset)
+		return
+	}
+	nameLen := int64(binary.LittleEndian.Uint16(h[26:]))
+	extraLen := int64(binary.LittleEndian.Uint16(h[28:]))
+	e.DataOffset = e.HeaderOffset + zipLocalHeaderLen + nameLen + extraLen
+	if e.DataOffset > size {
+		issue(entity.ARCHIVE_LOCAL_HEADER, e.Name, "name and extra field run past the end of the file")
+		return
+	}
+	name := make([]byte, nameLen)
+	if _, err := r.ReadAt(name, e.HeaderOffset+zipLocalHeaderLen); err != nil {
+		issue(entity.ARCHIVE_LOCAL_HEADER, e.Name, "%v", err)
+		return
+	}
+	if string(name) != e.Name {
+		issue(entity.ARCHIVE_NAME_MISMATCH, e.Name, "local header name %q", name)
+	}
+	if e.DataOffset+int64(e.CompressedSize) > size {
+		issue(entity.ARCHIVE_LOCAL_HEADER, e.Name, "data runs past the end of the file")
+	}
+}
+
+// 检查文件之间以及文件与中央目录之间的重叠
+func checkZipOverlap(z *entity.ZipArchive, dirOffset int64, issue func(string, string, string, ...interface{})) {
+	entries := append([]*entity.ZipEntry(nil), z.Entries...)
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].HeaderOffset < entries[j].HeaderOffset })
+	var last *entity.ZipEntry
+	var lastEnd int64
+	for _, e := range entries {
+		end := e.DataOffset + int64(e.CompressedSize)
+		if last != nil && e.HeaderOffset < lastEnd {
+			issue(entity.ARCHIVE_OVERLAP, e.Name, "overlaps %s", last.Name)
+		}
+		if end > dirOffset {
+			issue(entity.ARCHIVE_OVERLAP, e.Name, "overlaps the central directory")
+		}
+		if end > lastEnd {
+			last, lastEnd = e, end
+		}
+	}
+}
+
+// 根据创建系统和外部属性计算文件的权限和类型
+func zipEntryMode(name string, madeBy uint16, external uint32) os.FileMode {
+	var mode os.FileMode
+	switch madeBy >> 8 {
+	case 3, 19: // unix, macOS
+		unix := external >> 16
+		mode = os.FileMode(unix & 0777)
+		switch unix & 0xf000 {
+		case 0x4000:
+			mode |= os.ModeDir
+		case 0xa000:
+			mode |= os.ModeSymlink
+		}
+	default:
+		mode = 0666
+		if external&0x10 != 0 {
+			mode = os.ModeDir | 0777
+		}
+		if external&0x01 != 0 {
+			mode &^= 0222
+		}
+	}
+	if len(name) > 0 && name[len(name)-1] == '/' {
+		mode |= os.ModeDir
+	}
+	return mode
+}
+
+// 读取出的数据的大小，不压缩读取时为压缩后的大小
+func zipEntrySize(e *entity.ZipEntry) uint64 {
+	if e.Method == zipMethodDeflate {
+		return e.UncompressedSize
+	}
+	return e.CompressedSize
+}
+
+// OpenZipEntry 打开压缩包中的文件。deflate 之外的压缩方式都按不压缩读取，
+// 读完时检查大小和 CRC，不一致时返回 ErrZipChecksum
+func OpenZipEntry(z *entity.ZipArchive, e *entity.ZipEntry) (io.ReadCloser, error) {
+	if e.DataOffset < 0 || e.DataOffset+int64(e.CompressedSize) > z.Size {
+		return nil, fmt.Errorf("%w: %s data outside the file", ErrZipFormat, e.Name)
+	}
+	section := io.NewSectionReader(z.Reader, e.DataOffset, int64(e.CompressedSize))
+	cr := &zipChecksumReader{entry: e, hash: crc32.NewIEEE()}
+	cr.size = zipEntrySize(e)
+	switch e.Method {
+	case zipMethodDeflate:
+		cr.rc = flate.NewReader(section)
+	case zipMethodStore:
+		cr.rc = io.NopCloser(section)
+	default:
+		// 未知压缩方式的 CRC 是解压后数据的，原样读取时无法校验
+		cr.rc = io.NopCloser(section)
+		cr.hash = nil
+	}
+	return cr, nil
+}
+
+// 读取时计算大小和 CRC，读完时与中央目录中的记录比较
+type zipChecksumReader struct {
+	entry *entity.ZipEntry
+	rc    io.ReadCloser
+	hash  hash.Hash32
+	size  uint64
+	read  uint64
+	err   error
+}
+
+func (r *zipChecksumReader) Read(b []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.rc.Read(b)
+	r.read += uint64(n)
+	if r.hash != nil {
+		r.hash.Write(b[:n])
+	}
+	if r.read > r.size {
+		err = fmt.Errorf("%w: %s longer than %d bytes", ErrZipFormat, r.entry.Name, r.size)
+	} else if err == io.EOF {
+		if r.read != r.size {
+			err = fmt.Errorf("%w: %s is %d bytes, expected %d", ErrZipFormat, r.entry.Name, r.read, r.size)
+		} else if r.hash != nil && r.hash.Sum32() != r.entry.CRC32 {
+			err = ErrZipChecksum
+		}
+	}
+	r.err = err
+	return n, err
+}
+
+func (r *zipChecksumReader) Close() error {
+	return r.rc.Close()
+}
+
+// ReadZipEntry 读取压缩包中一个文件的内容，大小受 DefaultArchiveLimits 的单个文件限制
+func ReadZipEntry(z *entity.ZipArchive, e *entity.ZipEntry) ([]byte, error) {
+	limit := DefaultArchiveLimits().MaxEntrySize
+	if int64(zipEntrySize(e)) > limit {
+		return nil, fmt.Errorf("size %d exceeds limit %d", zipEntrySize(e), limit)
+	}
+	rc, err := OpenZipEntry(z, e)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
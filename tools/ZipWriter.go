@@ -0,0 +1,226 @@
+package tools
+
+import (
+	"apkgo/entity"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+const (
+	// 新文件使用的修改时间 2008-01-01 00:00
+	zipDefaultTime = 0x3821 << 16
+	// apksig 用来对齐数据的扩展字段
+	zipAlignmentExtraId = 0xd935
+	// 不压缩的 .so 按 16KB 页对齐，才能直接从 APK 中映射
+	zipPageAlignment = 16384
+)
+
+// ZipWriter 依次写入压缩包中的文件，不压缩的文件按 Align 对齐，最后写入中央目录
+type ZipWriter struct {
+	w      io.Writer
+	offset int64
+	dir    bytes.Buffer
+	count  int
+	names  map[string]bool
+	Align  func(name string) int64 // 不压缩的文件数据的对齐字节数，为 nil 时使用 ZipAlignment
+}
+
+// 写入一个文件需要的信息
+type zipHeader struct {
+	name     string
+	flags    uint16
+	method   uint16
+	time     uint32
+	crc      uint32
+	csize    uint64
+	usize    uint64
+	creator  uint16
+	external uint32
+}
+
+// NewZipWriter 创建向 w 写入的压缩包
+func NewZipWriter(w io.Writer) *ZipWriter {
+	return &ZipWriter{w: w, names: make(map[string]bool)}
+}
+
+// Offset 返回已经写入的字节数，写入中央目录前即为中央目录的位置
+func (zw *ZipWriter) Offset() int64 {
+	return zw.offset
+}
+
+func (zw *ZipWriter) write(b []byte) error {
+	n, err := zw.w.Write(b)
+	zw.offset += int64(n)
+	return err
+}
+
+func (zw *ZipWriter) writeEntry(h zipHeader, data io.Reader) error {
+	if zw.names[h.name] {
+		return fmt.Errorf("duplicate entry %s", h.name)
+	}
+	if h.csize >= 0xffffffff || h.usize >= 0xffffffff || zw.offset >= 0xffffffff || zw.count >= 0xffff {
+		return fmt.Errorf("%s: zip64 is not supported", h.name)
+	}
+	zw.names[h.name] = true
+	zw.count++
+	offset := zw.offset
+	// 不再使用数据描述符，大小写在文件头中
+	h.flags &^= 0x8
+
+	var extra []byte
+	if h.method == zipMethodStore {
+		align := ZipAlignment(h.name)
+		if zw.Align != nil {
+			align = zw.Align(h.name)
+		}
+		if align > 1 {
+			start := offset + zipLocalHeaderLen + int64(len(h.name)) + 6
+			pad := (align - start%align) % align
+			extra = make([]byte, 6+pad)
+			binary.LittleEndian.PutUint16(extra, zipAlignmentExtraId)
+			binary.LittleEndian.PutUint16(extra[2:], uint16(2+pad))
+			binary.LittleEndian.PutUint16(extra[4:], uint16(align))
+		}
+	}
+
+	local := make([]byte, zipLocalHeaderLen)
+	binary.LittleEndian.PutUint32(local, zipLocalSignature)
+	binary.LittleEndian.PutUint16(local[4:], 20)
+	binary.LittleEndian.PutUint16(local[6:], h.flags)
+	binary.LittleEndian.PutUint16(local[8:], h.method)
+	binary.LittleEndian.PutUint32(local[10:], h.time)
+	binary.LittleEndian.PutUint32(local[14:], h.crc)
+	binary.LittleEndian.PutUint32(local[18:], uint32(h.csize))
+	binary.LittleEndian.PutUint32(local[22:], uint32(h.usize))
+	binary.LittleEndian.PutUint16(local[26:], uint16(len(h.name)))
+	binary.LittleEndian.PutUint16(local[28:], uint16(len(extra)))
+	if err := zw.write(local); err != nil {
+		return err
+	}
+	if err := zw.write([]byte(h.name)); err != nil {
+		return err
+	}
+	if err := zw.write(extra); err != nil {
+		return err
+	}
+	n, err := io.Copy(zw.w, data)
+	zw.offset += n
+	if err != nil {
+		return err
+	}
+	if uint64(n) != h.csize {
+		return fmt.Errorf("%s: wrote %d bytes, expected %d", h.name, n, h.csize)
+	}
+
+	central := make([]byte, zipCentralHeaderLen)
+	binary.LittleEndian.PutUint32(central, zipCentralSignature)
+	creator := h.creator
+	if creator == 0 {
+		creator = 20
+	}
+	binary.LittleEndian.PutUint16(central[4:], creator)
+	binary.LittleEndian.PutUint16(central[6:], 20)
+	binary.LittleEndian.PutUint16(central[8:], h.flags)
+	binary.LittleEndian.PutUint16(central[10:], h.method)
+	binary.LittleEndian.PutUint32(central[12:], h.time)
+	binary.LittleEndian.PutUint32(central[16:], h.crc)
+	binary.LittleEndian.PutUint32(central[20:], uint32(h.csize))
+	binary.LittleEndian.PutUint32(central[24:], uint32(h.usize))
+	binary.LittleEndian.PutUint16(central[28:], uint16(len(h.name)))
+	binary.LittleEndian.PutUint32(central[38:], h.external)
+	binary.LittleEndian.PutUint32(central[42:], uint32(offset))
+	zw.dir.Write(central)
+	zw.dir.WriteString(h.name)
+	return nil
+}
+
+// CopyEntry 复制另一个压缩包中的文件，压缩后的数据原样复制
+func (zw *ZipWriter) CopyEntry(z *entity.ZipArchive, e *entity.ZipEntry) error {
+	if e.DataOffset < 0 || e.DataOffset+int64(e.CompressedSize) > z.Size {
+		return fmt.Errorf("%w: %s data outside the file", ErrZipFormat, e.Name)
+	}
+	h := zipHeader{
+		name:     e.Name,
+		flags:    e.Flags,
+		method:   e.Method,
+		time:     e.Time,
+		crc:      e.CRC32,
+		csize:    e.CompressedSize,
+		usize:    e.UncompressedSize,
+		creator:  e.Creator,
+		external: e.External,
+	}
+	return zw.writeEntry(h, io.NewSectionReader(z.Reader, e.DataOffset, int64(e.CompressedSize)))
+}
+
+// AddEntry 写入一个新文件，method 为 0 (不压缩) 或 8 (deflate)
+func (zw *ZipWriter) AddEntry(name string, data []byte, method uint16) error {
+	return zw.addData(zipHeader{name: name, flags: 0x800, method: method, time: zipDefaultTime}, data)
+}
+
+// ReplaceEntry 用 data 代替 e 的内容并按 method 重新压缩，保留文件名、时间和属性
+func (zw *ZipWriter) ReplaceEntry(e *entity.ZipEntry, data []byte, method uint16) error {
+	h := zipHeader{
+		name:     e.Name,
+		flags:    e.Flags & 0x800, // 只保留 UTF-8 标志
+		method:   method,
+		time:     e.Time,
+		creator:  e.Creator,
+		external: e.External,
+	}
+	return zw.addData(h, data)
+}
+
+// 按 h.method 压缩 data 并写入
+func (zw *ZipWriter) addData(h zipHeader, data []byte) error {
+	h.crc = crc32.ChecksumIEEE(data)
+	h.usize = uint64(len(data))
+	switch h.method {
+	case zipMethodStore:
+		h.csize = h.usize
+		return zw.writeEntry(h, bytes.NewReader(data))
+	case zipMethodDeflate:
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+		fw.Write(data)
+		if err := fw.Close(); err != nil {
+			return err
+		}
+		h.csize = uint64(buf.Len())
+		return zw.writeEntry(h, &buf)
+	}
+	return fmt.Errorf("%s: unsupported method %d", h.name, h.method)
+}
+
+// ZipAlignment 不压缩的文件数据的默认对齐：.so 按 16KB 页，其余按 4 字节
+func ZipAlignment(name string) int64 {
+	if strings.HasSuffix(name, ".so") {
+		return zipPageAlignment
+	}
+	return 4
+}
+
+// Finish 返回中央目录和中央目录结束记录，中央目录位于当前的位置
+func (zw *ZipWriter) Finish() ([]byte, []byte) {
+	end := make([]byte, zipEndLen)
+	binary.LittleEndian.PutUint32(end, zipEndSignature)
+	binary.LittleEndian.PutUint16(end[8:], uint16(zw.count))
+	binary.LittleEndian.PutUint16(end[10:], uint16(zw.count))
+	binary.LittleEndian.PutUint32(end[12:], uint32(zw.dir.Len()))
+	binary.LittleEndian.PutUint32(end[16:], uint32(zw.offset))
+	return zw.dir.Bytes(), end
+}
+
+// Close 写入中央目录和中央目录结束记录
+func (zw *ZipWriter) Close() error {
+	dir, end := zw.Finish()
+	if err := zw.write(dir); err != nil {
+		return err
+	}
+	return zw.write(end)
+}
@@ -1,53 +1,203 @@
 package tools
 
 import (
-	"archive/zip"
+	"apkgo/entity"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 )
-// Unzip 解压缩 APK 文件到指定目录
+
+// DefaultArchiveLimits 返回 Unzip 使用的默认限制
+func DefaultArchiveLimits() entity.ArchiveLimits {
+	return entity.ArchiveLimits{
+		MaxEntries:   100000,
+		MaxEntrySize: 1 << 30,
+		MaxTotalSize: 4 << 30,
+		MaxRatio:     1000,
+	}
+}
+
+// 检查压缩比的最小文件大小
+const ratioCheckSize = 1 << 20
+
+// Unzip 使用默认限制解压缩 APK 文件到指定目录，可疑的文件跳过不解压
 func Unzip(src string, dest string) error {
-	r, err := zip.OpenReader(src)
+	_, err := UnzipWithLimits(src, dest, DefaultArchiveLimits())
+	return err
+}
+
+// UnzipWithLimits 解压缩 APK 文件到指定目录。指向目录之外的文件、符号链接、重复的文件、
+// 不区分大小写的文件系统上只差大小写的文件、超出大小或压缩比限制的文件跳过不解压，文件数或总大小超出限制时停止解压，
+// 这些情况都记录在返回的报告中。只有读写出错时返回错误
+func UnzipWithLimits(src string, dest string, limits entity.ArchiveLimits) (*entity.ArchiveReport, error) {
+	file, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	z, err := OpenZip(file)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer r.Close()
 
-	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
-		if !strings.HasPrefix(fpath, filepath.Clean(dest)+string(os.PathSeparator)) {
-			return fmt.Errorf("illegal file path: %s", fpath)
+	// 解析时容忍的异常也记录在报告中
+	report := &entity.ArchiveReport{Issues: append([]entity.ArchiveIssue(nil), z.Issues...)}
+	issue := func(kind string, f *entity.ZipEntry, format string, args ...interface{}) {
+		report.Issues = append(report.Issues, entity.ArchiveIssue{Kind: kind, Entry: f.Name, Detail: fmt.Sprintf(format, args...)})
+	}
+	root := filepath.Clean(dest)
+	seen := make(map[string]string)
+	folded := make(map[string]string) // 小写的路径对应的第一个文件名
+	var foldCase *bool                // 第一次遇到大小写冲突时检查文件系统
+	var total int64
+	for i, f := range z.Entries {
+		if limits.MaxEntries > 0 && i >= limits.MaxEntries {
+			issue(entity.ARCHIVE_ENTRY_COUNT, f, "%d entries, limit %d", len(z.Entries), limits.MaxEntries)
+			report.Skipped += len(z.Entries) - i
+			break
+		}
+		fpath := filepath.Join(root, f.Name)
+		if !strings.HasPrefix(fpath, root+string(os.PathSeparator)) {
+			issue(entity.ARCHIVE_PATH_TRAVERSAL, f, "resolves to %s", fpath)
+			report.Skipped++
+			continue
+		}
+		if f.Mode&os.ModeSymlink != 0 {
+			issue(entity.ARCHIVE_SYMLINK, f, "symbolic link refused")
+			report.Skipped++
+			continue
+		}
+		if first, ok := seen[fpath]; ok {
+			issue(entity.ARCHIVE_DUPLICATE, f, "same path as %s, keeping the first", first)
+			report.Skipped++
+			continue
+		}
+		seen[fpath] = f.Name
+		// 混淆过的资源中常有只差大小写的文件名，只有文件系统不区分大小写时才会覆盖
+		key := strings.ToLower(fpath)
+		if first, ok := folded[key]; ok {
+			if foldCase == nil {
+				insensitive := caseInsensitiveDir(root)
+				foldCase = &insensitive
+			}
+			if *foldCase {
+				issue(entity.ARCHIVE_CASE_COLLISION, f, "same path as %s on this case-insensitive filesystem, keeping the first", first)
+				report.Skipped++
+				continue
+			}
+			issue(entity.ARCHIVE_CASE_COLLISION, f, "differs from %s only in case", first)
+		} else {
+			folded[key] = f.Name
 		}
 
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
+		if f.Mode.IsDir() {
+			if err := os.MkdirAll(fpath, os.ModePerm); err != nil {
+				return report, err
+			}
+			continue
+		}
+		size := int64(zipEntrySize(f))
+		if limits.MaxEntrySize > 0 && size > limits.MaxEntrySize {
+			issue(entity.ARCHIVE_ENTRY_SIZE, f, "declared size %d, limit %d", size, limits.MaxEntrySize)
+			report.Skipped++
+			continue
+		}
+		if limits.MaxRatio > 0 && size > ratioCheckSize && size/int64(f.CompressedSize+1) > limits.MaxRatio {
+			issue(entity.ARCHIVE_RATIO, f, "%d bytes compressed to %d", size, f.CompressedSize)
+			report.Skipped++
 			continue
 		}
 
 		if err = os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return err
+			return report, err
 		}
-
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			return err
+		// 实际解压的大小不能超过目录中记录的大小和剩余的总大小
+		limit := size
+		remaining := limits.MaxTotalSize - total
+		if limits.MaxTotalSize > 0 && remaining < limit {
+			limit = remaining
 		}
-		rc, err := f.Open()
+		written, corrupt, err := extractFile(z, f, fpath, limit)
 		if err != nil {
-			return err
+			return report, err
+		}
+		total += written
+		if corrupt != nil {
+			os.Remove(fpath)
+			issue(entity.ARCHIVE_CORRUPT, f, "%v", corrupt)
+			report.Skipped++
+			continue
 		}
+		if written > limit {
+			os.Remove(fpath)
+			report.Skipped++
+			if limit < size {
+				issue(entity.ARCHIVE_TOTAL_SIZE, f, "total size limit %d reached", limits.MaxTotalSize)
+				report.Skipped += len(z.Entries) - i - 1
+				break
+			}
+			issue(entity.ARCHIVE_SIZE_MISMATCH, f, "more than the declared %d bytes", size)
+			continue
+		}
+		report.Extracted++
+	}
+	return report, nil
+}
 
-		_, err = io.Copy(outFile, rc)
+// 在 dir 中创建临时文件，检查文件系统是否不区分大小写
+func caseInsensitiveDir(dir string) bool {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return false
+	}
+	f, err := os.CreateTemp(dir, ".apkgo-case-")
+	if err != nil {
+		return false
+	}
+	name := f.Name()
+	f.Close()
+	defer os.Remove(name)
+	upper := filepath.Join(filepath.Dir(name), strings.ToUpper(filepath.Base(name)))
+	_, err = os.Stat(upper)
+	return err == nil
+}
 
-		outFile.Close()
-		rc.Close()
+// 解压一个文件，最多写入 limit+1 个字节，返回写入的字节数。
+// 读取压缩数据出错时通过 corrupt 返回，写文件出错时通过 err 返回
+func extractFile(z *entity.ZipArchive, f *entity.ZipEntry, fpath string, limit int64) (written int64, corrupt error, err error) {
+	rc, corrupt := OpenZipEntry(z, f)
+	if corrupt != nil {
+		return 0, corrupt, nil
+	}
+	defer rc.Close()
+	outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode.Perm()|0600)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer outFile.Close()
+	src := &readErrRecorder{r: io.LimitReader(rc, limit+1)}
+	written, err = io.Copy(outFile, src)
+	if src.err != nil && written <= limit {
+		// 超出记录的大小时读取返回格式或校验和错误，由调用方按超出处理
+		return written, src.err, nil
+	}
+	if src.err != nil {
+		err = nil
+	}
+	return written, nil, err
+}
 
-		if err != nil {
-			return err
-		}
+// 记录读取时的错误，区分读和写的错误
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (r *readErrRecorder) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	if err != nil && err != io.EOF {
+		r.err = err
 	}
-	return nil
+	return n, err
 }